@@ -0,0 +1,55 @@
+// Lets the items of every [data-sortable] container be dragged into
+// a new order, the attribute naming the selector of the items. Each
+// item carries a hidden input belonging to a reorder form, so moving
+// the item moves its input too and saving the form posts them in
+// their new order. The edit gallery page uses it for images and the
+// collection pages for the collections in them.
+(function () {
+  'use strict';
+
+  function sortable(container) {
+    var selector = container.getAttribute('data-sortable');
+    var dragged = null;
+
+    container.addEventListener('dragstart', function (e) {
+      dragged = e.target.closest(selector);
+      if (!dragged) {
+        return;
+      }
+      dragged.classList.add('dragging');
+      e.dataTransfer.effectAllowed = 'move';
+      // Firefox won't start dragging without some data
+      e.dataTransfer.setData('text/plain', '');
+    });
+
+    container.addEventListener('dragover', function (e) {
+      if (!dragged) {
+        return;
+      }
+      e.preventDefault();
+      var target = e.target.closest(selector);
+      if (!target || target === dragged) {
+        return;
+      }
+      var rect = target.getBoundingClientRect();
+      var after = e.clientX > rect.left + rect.width / 2;
+      container.insertBefore(dragged, after ? target.nextSibling : target);
+    });
+
+    container.addEventListener('drop', function (e) {
+      e.preventDefault();
+    });
+
+    container.addEventListener('dragend', function () {
+      if (dragged) {
+        dragged.classList.remove('dragging');
+        dragged = null;
+      }
+    });
+  }
+
+  var containers = document.querySelectorAll('[data-sortable]');
+  for (var i = 0; i < containers.length; i++) {
+    sortable(containers[i]);
+  }
+})();
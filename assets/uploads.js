@@ -0,0 +1,200 @@
+// Uploads the images picked on the edit gallery page with the tus
+// resumable upload protocol. Files are sent one after another in
+// chunks, a chunk that fails is retried from wherever the server
+// says it got to, and an upload interrupted by closing the page
+// carries on when the same file is picked again. Without
+// JavaScript the form posts every image in one request instead.
+(function () {
+  'use strict';
+
+  var form = document.getElementById('upload-form');
+  if (!form || !window.XMLHttpRequest || !window.FileReader) {
+    return;
+  }
+  var input = form.querySelector('input[type="file"]');
+  var list = document.getElementById('upload-progress');
+  var endpoint = form.getAttribute('data-uploads');
+  var csrfToken = form.querySelector('input[name="gorilla.csrf.Token"]').value;
+
+  var chunkSize = 5 * 1024 * 1024;
+  var maxRetries = 5;
+
+  form.addEventListener('submit', function (e) {
+    e.preventDefault();
+    var files = Array.prototype.slice.call(input.files);
+    if (files.length === 0) {
+      return;
+    }
+    form.querySelector('button[type="submit"]').disabled = true;
+    var rows = files.map(addRow);
+    var uploaded = 0;
+    var next = function (i) {
+      if (i === files.length) {
+        if (uploaded > 0) {
+          window.location.reload();
+        }
+        form.querySelector('button[type="submit"]').disabled = false;
+        return;
+      }
+      upload(files[i], rows[i], function (ok) {
+        if (ok) {
+          uploaded++;
+        }
+        next(i + 1);
+      });
+    };
+    next(0);
+  });
+
+  // addRow shows a progress bar for file
+  function addRow(file) {
+    var item = document.createElement('li');
+    item.className = 'list-group-item';
+    var name = document.createElement('strong');
+    name.textContent = file.name;
+    var progress = document.createElement('div');
+    progress.className = 'progress';
+    var bar = document.createElement('div');
+    bar.className = 'progress-bar';
+    bar.setAttribute('role', 'progressbar');
+    bar.style.width = '0%';
+    progress.appendChild(bar);
+    var status = document.createElement('span');
+    status.className = 'help-block';
+    status.textContent = 'Waiting';
+    item.appendChild(name);
+    item.appendChild(progress);
+    item.appendChild(status);
+    list.appendChild(item);
+    return {
+      progress: function (sent) {
+        var pct = file.size === 0 ? 100 : Math.floor(sent / file.size * 100);
+        bar.style.width = pct + '%';
+        status.textContent = pct + '%';
+      },
+      done: function () {
+        bar.className = 'progress-bar progress-bar-success';
+        bar.style.width = '100%';
+        status.textContent = 'Uploaded';
+      },
+      fail: function (msg) {
+        bar.className = 'progress-bar progress-bar-danger';
+        status.textContent = msg;
+      }
+    };
+  }
+
+  // upload sends file, resuming an earlier upload of it if there is
+  // one, and calls cb with whether it made it
+  function upload(file, row, cb) {
+    var key = 'tus:' + endpoint + ':' + [file.name, file.size, file.lastModified].join(':');
+    var retries = 0;
+
+    var fail = function (xhr) {
+      localStorage.removeItem(key);
+      row.fail((xhr.responseText || 'Upload failed').trim());
+      cb(false);
+    };
+
+    // retry waits longer after every failure, then asks the
+    // server where to carry on from
+    var retry = function (xhr) {
+      if (retries === maxRetries) {
+        row.fail('Upload failed, please try again later');
+        cb(false);
+        return;
+      }
+      retries++;
+      row.fail('Connection lost, retrying…');
+      setTimeout(resume, 1000 * Math.pow(2, retries - 1));
+    };
+
+    var create = function () {
+      request('POST', endpoint, {
+        'Upload-Length': file.size,
+        'Upload-Metadata': 'filename ' + base64(file.name)
+      }, null, function (xhr) {
+        if (xhr.status !== 201) {
+          return transient(xhr) ? retry(xhr) : fail(xhr);
+        }
+        localStorage.setItem(key, xhr.getResponseHeader('Location'));
+        send(0);
+      });
+    };
+
+    var resume = function () {
+      var url = localStorage.getItem(key);
+      if (!url) {
+        create();
+        return;
+      }
+      request('HEAD', url, {}, null, function (xhr) {
+        if (xhr.status === 200) {
+          send(parseInt(xhr.getResponseHeader('Upload-Offset'), 10));
+        } else if (transient(xhr)) {
+          retry(xhr);
+        } else {
+          // It expired or was removed, start over
+          localStorage.removeItem(key);
+          create();
+        }
+      });
+    };
+
+    var send = function (offset) {
+      row.progress(offset);
+      var chunk = file.slice(offset, Math.min(offset + chunkSize, file.size));
+      var xhr = request('PATCH', localStorage.getItem(key), {
+        'Content-Type': 'application/offset+octet-stream',
+        'Upload-Offset': offset
+      }, chunk, function (xhr) {
+        if (xhr.status !== 204) {
+          return transient(xhr) || xhr.status === 409 ? retry(xhr) : fail(xhr);
+        }
+        retries = 0;
+        offset = parseInt(xhr.getResponseHeader('Upload-Offset'), 10);
+        if (offset < file.size) {
+          send(offset);
+          return;
+        }
+        localStorage.removeItem(key);
+        row.done();
+        cb(true);
+      });
+      xhr.upload.onprogress = function (e) {
+        row.progress(offset + e.loaded);
+      };
+    };
+
+    resume();
+  }
+
+  // transient reports whether a failed request is worth retrying
+  function transient(xhr) {
+    return xhr.status === 0 || xhr.status === 423 || xhr.status >= 500;
+  }
+
+  function request(method, url, headers, body, cb) {
+    var xhr = new XMLHttpRequest();
+    xhr.open(method, url);
+    xhr.setRequestHeader('Tus-Resumable', '1.0.0');
+    xhr.setRequestHeader('X-CSRF-Token', csrfToken);
+    Object.keys(headers).forEach(function (name) {
+      xhr.setRequestHeader(name, headers[name]);
+    });
+    xhr.onload = function () {
+      cb(xhr);
+    };
+    xhr.onerror = function () {
+      cb(xhr);
+    };
+    xhr.send(body);
+    return xhr;
+  }
+
+  // base64 encodes a string as UTF-8 first, like Upload-Metadata
+  // expects
+  function base64(s) {
+    return btoa(unescape(encodeURIComponent(s)));
+  }
+})();
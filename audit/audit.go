@@ -0,0 +1,111 @@
+// Package audit records who did what, and from where, through the
+// AuditService. It fills in everything an event can learn from the
+// request, so controllers only say what happened.
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+)
+
+// maxUserAgent is how much of the user agent header we keep
+const maxUserAgent = 255
+
+// Details are stored with an event as a JSON object
+type Details map[string]interface{}
+
+// Log records audit events and finds them again
+type Log struct {
+	models.AuditService
+}
+
+// New returns a Log that stores events with as
+func New(as models.AuditService) *Log {
+	return &Log{as}
+}
+
+// Record stores e along with details. The actor defaults to the
+// logged in user and the affected user to the actor, while the IP,
+// user agent and any admin impersonating the actor always come from
+// r. Whatever is being recorded has already happened, so errors are
+// logged rather than returned.
+func (l *Log) Record(r *http.Request, e models.AuditEvent, details Details) {
+	ctx := r.Context()
+	if user := context.User(ctx); user != nil && e.ActorID == 0 {
+		e.ActorID = user.ID
+	}
+	if admin := context.Impersonator(ctx); admin != nil && admin.ID != e.ActorID {
+		e.ImpersonatorID = admin.ID
+	}
+	if e.UserID == 0 {
+		e.UserID = e.ActorID
+	}
+	if token := context.APIToken(ctx); token != nil {
+		if details == nil {
+			details = Details{}
+		}
+		details["api_token_id"] = token.ID
+	}
+	e.IP = clientIP(r)
+	e.UserAgent = truncate(r.UserAgent(), maxUserAgent)
+	if details != nil {
+		b, err := json.Marshal(details)
+		if err != nil {
+			log.Printf("audit: encoding details of %s: %v", e.Action, err)
+		} else {
+			e.Details = string(b)
+		}
+	}
+	if err := l.Create(&e); err != nil {
+		log.Printf("audit: recording %s by user %d on user %d: %v", e.Action, e.ActorID, e.UserID, err)
+	}
+}
+
+// GalleryDeleted records that gallery was deleted, by its owner or
+// a moderator
+func (l *Log) GalleryDeleted(r *http.Request, gallery *models.Gallery) {
+	l.Record(r, models.AuditEvent{
+		Action:     models.AuditGalleryDeleted,
+		UserID:     gallery.UserID,
+		TargetType: "gallery",
+		TargetID:   strconv.Itoa(int(gallery.ID)),
+	}, Details{"title": gallery.Title})
+}
+
+// ImageDeleted records that filename was deleted from gallery
+func (l *Log) ImageDeleted(r *http.Request, gallery *models.Gallery, filename string) {
+	l.Record(r, models.AuditEvent{
+		Action:     models.AuditImageDeleted,
+		UserID:     gallery.UserID,
+		TargetType: "image",
+		TargetID:   strconv.Itoa(int(gallery.ID)) + "/" + filename,
+	}, Details{"gallery_id": gallery.ID, "filename": filename})
+}
+
+// clientIP returns the IP address r came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// truncate cuts s down to at most n bytes without splitting a
+// character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
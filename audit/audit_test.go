@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/models/memstore"
+)
+
+func TestRecordFillsRequestDetails(t *testing.T) {
+	l := New(memstore.NewAuditService())
+	r := httptest.NewRequest("POST", "/galleries/1/delete", nil)
+	r.RemoteAddr = "203.0.113.7:51234"
+	r.Header.Set("User-Agent", strings.Repeat("é", 200))
+	user := &models.User{Name: "Jon Snow"}
+	user.ID = 2
+	admin := &models.User{Name: "Gary Oldman"}
+	admin.ID = 1
+	ctx := context.WithImpersonator(context.WithUser(r.Context(), user), admin)
+	r = r.WithContext(ctx)
+
+	l.GalleryDeleted(r, &models.Gallery{UserID: 2, Title: "Winterfell"})
+	events, err := l.Find(models.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected one event, received %d", len(events))
+	}
+	e := events[0]
+	if e.ActorID != 2 || e.ImpersonatorID != 1 || e.UserID != 2 {
+		t.Errorf("Expected jon as the actor and gary as the impersonator, received %+v", e)
+	}
+	if e.IP != "203.0.113.7" {
+		t.Errorf("Expected the IP without the port, received %s", e.IP)
+	}
+	if len(e.UserAgent) != 254 || !strings.HasSuffix(e.UserAgent, "é") {
+		t.Errorf("Expected the user agent cut at a character boundary, received %d bytes", len(e.UserAgent))
+	}
+	if e.Details != `{"title":"Winterfell"}` {
+		t.Errorf("Expected the title in the details, received %s", e.Details)
+	}
+}
+
+func TestRecordAnonymous(t *testing.T) {
+	l := New(memstore.NewAuditService())
+	r := httptest.NewRequest("POST", "/login", nil)
+	l.Record(r, models.AuditEvent{Action: models.AuditLoginFailed}, Details{"email": "nobody@test.dev"})
+	events, _ := l.Find(models.AuditFilter{})
+	if len(events) != 1 || events[0].ActorID != 0 || events[0].UserID != 0 {
+		t.Errorf("Expected an event without an actor, received %+v", events)
+	}
+}
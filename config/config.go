@@ -0,0 +1,387 @@
+// Package config loads our application settings from the
+// environment, an optional .env file and an optional JSON or TOML
+// config file, and validates them before anything else starts up.
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+)
+
+// Profiles we know how to configure. Each one comes with its own
+// defaults and validation rules.
+const (
+	Development = "development"
+	Test        = "test"
+	Production  = "production"
+)
+
+// Config holds every setting the application needs
+type Config struct {
+	Env     string `json:"env" toml:"env"`
+	Port    string `json:"port" toml:"port"`
+	BaseURL string `json:"base_url" toml:"base_url"`
+	Pepper  string `json:"pepper" toml:"pepper"`
+	// HMACKey hashes remember tokens, every other secret is derived
+	// from it with DerivedKey
+	HMACKey string `json:"hmac_key" toml:"hmac_key"`
+	// CSRFKey is the base64 encoded 32 byte key used to sign csrf
+	// tokens. CSRFOldKeys are keys we rotated away from, which are
+	// still accepted until the forms signed with them expire.
+	CSRFKey     string         `json:"csrf_key" toml:"csrf_key"`
+	CSRFOldKeys []string       `json:"csrf_old_keys" toml:"csrf_old_keys"`
+	Database    DatabaseConfig `json:"database" toml:"database"`
+	Mailgun     MailgunConfig  `json:"mailgun" toml:"mailgun"`
+	// OIDCProviders are the external providers users can sign in
+	// with. They can only be set in the config file.
+	OIDCProviders []OIDCProviderConfig `json:"oidc_providers" toml:"oidc_providers"`
+	// AccountDeletionGraceDays is how many days users have to cancel
+	// deleting their account, the server default when zero. It can
+	// only be set in the config file.
+	AccountDeletionGraceDays int `json:"account_deletion_grace_days" toml:"account_deletion_grace_days"`
+	// ExportDir is where data exports are stored until they expire,
+	// ExportExpiryHours after they were built, or the job's default
+	// when zero. They can only be set in the config file.
+	ExportDir         string `json:"export_dir" toml:"export_dir"`
+	ExportExpiryHours int    `json:"export_expiry_hours" toml:"export_expiry_hours"`
+	// UploadDir is where chunked uploads are stored until they are
+	// complete, or UploadExpiryHours after their last chunk, the
+	// server default when zero. They can only be set in the config
+	// file.
+	UploadDir         string `json:"upload_dir" toml:"upload_dir"`
+	UploadExpiryHours int    `json:"upload_expiry_hours" toml:"upload_expiry_hours"`
+	// TrashRetentionDays is how long deleted galleries and images
+	// stay in the trash before they are purged, the job's default
+	// when zero. It can only be set in the config file.
+	TrashRetentionDays int `json:"trash_retention_days" toml:"trash_retention_days"`
+}
+
+// IsProd reports whether we are running with the production
+// profile
+func (c Config) IsProd() bool {
+	return c.Env == Production
+}
+
+// AccountDeletionGrace is AccountDeletionGraceDays as a duration
+func (c Config) AccountDeletionGrace() time.Duration {
+	return time.Duration(c.AccountDeletionGraceDays) * 24 * time.Hour
+}
+
+// ExportExpiry is ExportExpiryHours as a duration
+func (c Config) ExportExpiry() time.Duration {
+	return time.Duration(c.ExportExpiryHours) * time.Hour
+}
+
+// UploadExpiry is UploadExpiryHours as a duration
+func (c Config) UploadExpiry() time.Duration {
+	return time.Duration(c.UploadExpiryHours) * time.Hour
+}
+
+// TrashRetention is TrashRetentionDays as a duration
+func (c Config) TrashRetention() time.Duration {
+	return time.Duration(c.TrashRetentionDays) * 24 * time.Hour
+}
+
+// DerivedKey is the key to use for purpose, derived from HMACKey so
+// that every use gets its own and a key leaked from one can't be
+// used to forge anything for another
+func (c Config) DerivedKey(purpose string) string {
+	h := hmac.New(sha256.New, []byte(c.HMACKey))
+	h.Write([]byte(purpose))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CSRFKeys decodes the csrf key followed by every old key
+func (c Config) CSRFKeys() ([][]byte, error) {
+	encoded := append([]string{c.CSRFKey}, c.CSRFOldKeys...)
+	keys := make([][]byte, len(encoded))
+	for i, k := range encoded {
+		b, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(b) != csrfKeyBytes {
+			return nil, fmt.Errorf("config: csrf keys must be %d bytes encoded as base64, e.g. the output of `openssl rand -base64 %d`", csrfKeyBytes, csrfKeyBytes)
+		}
+		keys[i] = b
+	}
+	return keys, nil
+}
+
+const csrfKeyBytes = 32
+
+// devCSRFKey is only used by the development and test profiles so
+// that restarting the app doesn't invalidate open forms.
+const devCSRFKey = "ZGV2LWNzcmYta2V5LWRvLW5vdC11c2UtaW4tcHJvZCE="
+
+// DatabaseConfig holds the settings used to connect to our
+// database
+type DatabaseConfig struct {
+	Driver   string `json:"driver" toml:"driver"`
+	Host     string `json:"host" toml:"host"`
+	Port     string `json:"port" toml:"port"`
+	User     string `json:"user" toml:"user"`
+	Password string `json:"password" toml:"password"`
+	Name     string `json:"name" toml:"name"`
+}
+
+// ConnectionInfo builds the connection string expected by the
+// configured driver. sqlite3 only needs Name, which is the path
+// to the database file (or ":memory:"), everything else is
+// treated as postgres.
+func (c DatabaseConfig) ConnectionInfo() string {
+	switch c.Driver {
+	case "sqlite3":
+		return c.Name
+	default:
+		return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", c.Host, c.Port, c.User, c.Password, c.Name)
+	}
+}
+
+// MailgunConfig holds our mailgun credentials
+type MailgunConfig struct {
+	APIKey    string `json:"api_key" toml:"api_key"`
+	PublicKey string `json:"public_key" toml:"public_key"`
+	Domain    string `json:"domain" toml:"domain"`
+}
+
+// OIDCProviderConfig holds the settings of an OpenID Connect
+// provider. Name is used in our callback URL,
+// {base_url}/auth/{name}/callback, which has to be registered with
+// the provider.
+type OIDCProviderConfig struct {
+	Name         string `json:"name" toml:"name"`
+	DisplayName  string `json:"display_name" toml:"display_name"`
+	Issuer       string `json:"issuer" toml:"issuer"`
+	ClientID     string `json:"client_id" toml:"client_id"`
+	ClientSecret string `json:"client_secret" toml:"client_secret"`
+}
+
+// oidcProviderName is what provider names may look like, since
+// they end up in URLs and the database
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Default returns the defaults for the provided profile.
+// Development and test can run without any configuration at all
+// using sqlite, production has to be configured explicitly.
+func Default(env string) Config {
+	switch env {
+	case Production:
+		return Config{
+			Env:       Production,
+			Port:      "3000",
+			ExportDir: "exports",
+			UploadDir: "uploads",
+			Database: DatabaseConfig{
+				Driver: "postgres",
+				Port:   "5432",
+			},
+		}
+	case Test:
+		return Config{
+			Env:       Test,
+			Port:      "3000",
+			BaseURL:   "http://localhost:3000",
+			HMACKey:   "test-hmac-secret-key",
+			CSRFKey:   devCSRFKey,
+			ExportDir: "exports",
+			UploadDir: "uploads",
+			Database: DatabaseConfig{
+				Driver: "sqlite3",
+				Name:   ":memory:",
+			},
+		}
+	default:
+		return Config{
+			Env:       Development,
+			Port:      "3000",
+			BaseURL:   "http://localhost:3000",
+			Pepper:    "dev-pepper",
+			HMACKey:   "dev-hmac-secret-key",
+			CSRFKey:   devCSRFKey,
+			ExportDir: "exports",
+			UploadDir: "uploads",
+			Database: DatabaseConfig{
+				Driver: "sqlite3",
+				Name:   "gophotos.db",
+			},
+		}
+	}
+}
+
+// Load reads our configuration. Values are applied in order of
+// increasing precedence:
+//  1. the defaults for the profile picked by APP_ENV (or the
+//     "env" key of the config file), development if neither is set
+//  2. the config file at path, if path is not empty, read as TOML
+//     when it ends in .toml and as JSON otherwise
+//  3. environment variables, including those loaded from .env
+//
+// The result is validated before it is returned.
+func Load(path string) (Config, error) {
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return Config{}, fmt.Errorf("config: loading .env: %v", err)
+	}
+
+	var file []byte
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("config: %v", err)
+		}
+		file = b
+	}
+
+	env := os.Getenv("APP_ENV")
+	if env == "" && file != nil {
+		var peek struct {
+			Env string `json:"env" toml:"env"`
+		}
+		if err := decodeFile(path, file, &peek); err != nil {
+			return Config{}, fmt.Errorf("config: parsing %s: %v", path, err)
+		}
+		env = peek.Env
+	}
+	if env == "" {
+		env = Development
+	}
+
+	cfg := Default(env)
+	if file != nil {
+		if err := decodeFile(path, file, &cfg); err != nil {
+			return Config{}, fmt.Errorf("config: parsing %s: %v", path, err)
+		}
+	}
+	cfg.Env = env
+	applyEnv(&cfg)
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// decodeFile parses the contents of the config file at path into
+// v, picking the format from its extension
+func decodeFile(path string, file []byte, v interface{}) error {
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		_, err := toml.Decode(string(file), v)
+		return err
+	}
+	return json.Unmarshal(file, v)
+}
+
+// applyEnv overrides cfg with every environment variable that is
+// set
+func applyEnv(cfg *Config) {
+	vars := map[string]*string{
+		"APP_PORT":             &cfg.Port,
+		"APP_BASE_URL":         &cfg.BaseURL,
+		"USER_PASSWORD_PEPPER": &cfg.Pepper,
+		"HMAC_SECRET_KEY":      &cfg.HMACKey,
+		"CSRF_KEY":             &cfg.CSRFKey,
+		"DB_DRIVER":            &cfg.Database.Driver,
+		"DB_HOST":              &cfg.Database.Host,
+		"DB_PORT":              &cfg.Database.Port,
+		"DB_USER":              &cfg.Database.User,
+		"DB_PASSWORD":          &cfg.Database.Password,
+		"DB_NAME":              &cfg.Database.Name,
+		"MG_API_KEY":           &cfg.Mailgun.APIKey,
+		"MG_PUBLIC_KEY":        &cfg.Mailgun.PublicKey,
+		"MG_DOMAIN":            &cfg.Mailgun.Domain,
+	}
+	for name, dst := range vars {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*dst = v
+		}
+	}
+	if v := os.Getenv("CSRF_OLD_KEYS"); v != "" {
+		cfg.CSRFOldKeys = strings.Split(v, ",")
+	}
+}
+
+// Validate makes sure everything required by the profile is set,
+// reporting every missing setting at once.
+func (c Config) Validate() error {
+	var missing []string
+	require := func(name, value string) {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	switch c.Env {
+	case Development, Test, Production:
+	default:
+		return fmt.Errorf("config: unknown APP_ENV %q, expected one of %s, %s or %s", c.Env, Development, Test, Production)
+	}
+
+	require("APP_PORT", c.Port)
+	require("CSRF_KEY", c.CSRFKey)
+	require("DB_DRIVER", c.Database.Driver)
+	require("DB_NAME", c.Database.Name)
+	switch c.Database.Driver {
+	case "", "sqlite3":
+	case "postgres":
+		require("DB_HOST", c.Database.Host)
+		require("DB_PORT", c.Database.Port)
+		require("DB_USER", c.Database.User)
+	default:
+		return fmt.Errorf("config: unsupported DB_DRIVER %q, expected postgres or sqlite3", c.Database.Driver)
+	}
+
+	if c.IsProd() {
+		require("APP_BASE_URL", c.BaseURL)
+		require("USER_PASSWORD_PEPPER", c.Pepper)
+		require("HMAC_SECRET_KEY", c.HMACKey)
+		require("MG_DOMAIN", c.Mailgun.Domain)
+		require("MG_API_KEY", c.Mailgun.APIKey)
+		require("MG_PUBLIC_KEY", c.Mailgun.PublicKey)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("config: missing required settings for the %s profile: %s", c.Env, strings.Join(missing, ", "))
+	}
+	seen := make(map[string]bool)
+	for i, p := range c.OIDCProviders {
+		if !oidcProviderName.MatchString(p.Name) || seen[p.Name] {
+			return fmt.Errorf("config: oidc provider %d needs a unique name made of lowercase letters, digits and dashes, received %q", i+1, p.Name)
+		}
+		seen[p.Name] = true
+		if p.Issuer == "" || p.ClientID == "" {
+			return fmt.Errorf("config: oidc provider %q needs an issuer and a client_id", p.Name)
+		}
+	}
+	if c.ExportDir == "" {
+		return fmt.Errorf("config: export_dir can't be empty")
+	}
+	if c.ExportExpiryHours < 0 {
+		return fmt.Errorf("config: export_expiry_hours can't be negative, received %d", c.ExportExpiryHours)
+	}
+	if c.UploadDir == "" {
+		return fmt.Errorf("config: upload_dir can't be empty")
+	}
+	if c.UploadExpiryHours < 0 {
+		return fmt.Errorf("config: upload_expiry_hours can't be negative, received %d", c.UploadExpiryHours)
+	}
+	if c.AccountDeletionGraceDays < 0 {
+		return fmt.Errorf("config: account_deletion_grace_days can't be negative, received %d", c.AccountDeletionGraceDays)
+	}
+	if c.TrashRetentionDays < 0 {
+		return fmt.Errorf("config: trash_retention_days can't be negative, received %d", c.TrashRetentionDays)
+	}
+	if c.IsProd() && c.CSRFKey == devCSRFKey {
+		return fmt.Errorf("config: CSRF_KEY must not be the development key in production")
+	}
+	_, err := c.CSRFKeys()
+	return err
+}
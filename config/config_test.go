@@ -0,0 +1,277 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv blanks every variable we read so the tests don't pick
+// up whatever is set on the machine running them
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("APP_ENV", "")
+	names := []string{
+		"APP_PORT", "APP_BASE_URL", "USER_PASSWORD_PEPPER", "HMAC_SECRET_KEY",
+		"CSRF_KEY", "CSRF_OLD_KEYS", "DB_DRIVER", "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME",
+		"MG_API_KEY", "MG_PUBLIC_KEY", "MG_DOMAIN",
+	}
+	for _, name := range names {
+		t.Setenv(name, "")
+	}
+}
+
+func writeFile(t *testing.T, contents string) string {
+	t.Helper()
+	return writeNamedFile(t, "config.json", contents)
+}
+
+func writeNamedFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaultsToDevelopment(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Env != Development || cfg.IsProd() {
+		t.Errorf("Expected the development profile, received %s", cfg.Env)
+	}
+	if cfg.Database.Driver != "sqlite3" || cfg.Database.ConnectionInfo() != "gophotos.db" {
+		t.Errorf("Expected a sqlite database, received %+v", cfg.Database)
+	}
+}
+
+func TestLoadTestProfile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("APP_ENV", Test)
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Env != Test || cfg.HMACKey == "" || cfg.Database.ConnectionInfo() != ":memory:" {
+		t.Errorf("Expected the test profile to run without configuration, received %+v", cfg)
+	}
+}
+
+func TestLoadFileThenEnv(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, `{
+		"env": "production",
+		"base_url": "https://shutters.co",
+		"pepper": "file-pepper",
+		"hmac_key": "file-hmac",
+		"csrf_key": "bmV3LWNzcmYta2V5LXRoYXQtaXMtMzItYnl0ZXMtISE=",
+		"database": {"host": "db.internal", "user": "photos", "name": "photos"},
+		"mailgun": {"api_key": "key", "public_key": "pub", "domain": "mg.shutters.co"}
+	}`)
+	t.Setenv("DB_HOST", "replica.internal")
+	t.Setenv("USER_PASSWORD_PEPPER", "env-pepper")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.IsProd() {
+		t.Errorf("Expected the production profile from the file, received %s", cfg.Env)
+	}
+	if cfg.Pepper != "env-pepper" || cfg.HMACKey != "file-hmac" {
+		t.Errorf("Expected env to override the file, received pepper=%q hmac=%q", cfg.Pepper, cfg.HMACKey)
+	}
+	want := "host=replica.internal port=5432 user=photos password= dbname=photos sslmode=disable"
+	if got := cfg.Database.ConnectionInfo(); got != want {
+		t.Errorf("Expected %q, received %q", want, got)
+	}
+}
+
+func TestLoadTOMLFile(t *testing.T) {
+	clearEnv(t)
+	path := writeNamedFile(t, "config.toml", `
+env = "production"
+base_url = "https://shutters.co"
+pepper = "file-pepper"
+hmac_key = "file-hmac"
+csrf_key = "bmV3LWNzcmYta2V5LXRoYXQtaXMtMzItYnl0ZXMtISE="
+trash_retention_days = 7
+
+[database]
+host = "db.internal"
+user = "photos"
+name = "photos"
+
+[mailgun]
+api_key = "key"
+public_key = "pub"
+domain = "mg.shutters.co"
+
+[[oidc_providers]]
+name = "google"
+issuer = "https://accounts.google.com"
+client_id = "client"
+`)
+	t.Setenv("DB_HOST", "replica.internal")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.IsProd() || cfg.HMACKey != "file-hmac" || cfg.TrashRetentionDays != 7 {
+		t.Errorf("Expected the settings from the TOML file, received %+v", cfg)
+	}
+	want := "host=replica.internal port=5432 user=photos password= dbname=photos sslmode=disable"
+	if got := cfg.Database.ConnectionInfo(); got != want {
+		t.Errorf("Expected %q, received %q", want, got)
+	}
+	if len(cfg.OIDCProviders) != 1 || cfg.OIDCProviders[0].ClientID != "client" {
+		t.Errorf("Expected the oidc provider from the file, received %+v", cfg.OIDCProviders)
+	}
+
+	// a .json file is still read as JSON
+	path = writeNamedFile(t, "config.json", `env = "production"`)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "parsing") {
+		t.Errorf("Expected a parse error, received %v", err)
+	}
+}
+
+// TestExampleFiles checks the JSON and TOML examples stay in sync
+func TestExampleFiles(t *testing.T) {
+	var configs [2]Config
+	for i, path := range []string{"../config.example.json", "../config.example.toml"} {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decodeFile(path, b, &configs[i]); err != nil {
+			t.Fatalf("parsing %s: %v", path, err)
+		}
+	}
+	if !reflect.DeepEqual(configs[0], configs[1]) {
+		t.Errorf("Expected the examples to match, received %+v and %+v", configs[0], configs[1])
+	}
+}
+
+func TestLoadProductionRequiresSecrets(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("APP_ENV", Production)
+	t.Setenv("DB_HOST", "db.internal")
+	t.Setenv("DB_USER", "photos")
+	t.Setenv("DB_NAME", "photos")
+	_, err := Load("")
+	if err == nil {
+		t.Fatal("Expected an error for missing production secrets")
+	}
+	for _, name := range []string{"USER_PASSWORD_PEPPER", "HMAC_SECRET_KEY", "MG_API_KEY"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected %s in %q", name, err)
+		}
+	}
+}
+
+func TestLoadUnknownProfile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("APP_ENV", "staging")
+	if _, err := Load(""); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+func TestCSRFKeys(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CSRF_KEY", "bmV3LWNzcmYta2V5LXRoYXQtaXMtMzItYnl0ZXMtISE=")
+	t.Setenv("CSRF_OLD_KEYS", devCSRFKey)
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := cfg.CSRFKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || string(keys[0]) != "new-csrf-key-that-is-32-bytes-!!" || string(keys[1]) != "dev-csrf-key-do-not-use-in-prod!" {
+		t.Errorf("Expected the current key followed by the old key, received %q", keys)
+	}
+
+	t.Setenv("CSRF_KEY", "dG9vLXNob3J0")
+	if _, err := Load(""); err == nil {
+		t.Error("Expected an error for a csrf key that is too short")
+	}
+}
+
+func TestLoadOIDCProviders(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, `{"oidc_providers": [
+		{"name": "google", "display_name": "Google", "issuer": "https://accounts.google.com", "client_id": "id", "client_secret": "secret"}
+	]}`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.OIDCProviders) != 1 || cfg.OIDCProviders[0].Issuer != "https://accounts.google.com" {
+		t.Errorf("Expected the google provider, received %+v", cfg.OIDCProviders)
+	}
+
+	for name, providers := range map[string]string{
+		"bad name":       `[{"name": "Google Login", "issuer": "https://accounts.google.com", "client_id": "id"}]`,
+		"duplicate name": `[{"name": "a", "issuer": "https://a.test", "client_id": "id"}, {"name": "a", "issuer": "https://b.test", "client_id": "id"}]`,
+		"no issuer":      `[{"name": "a", "client_id": "id"}]`,
+	} {
+		path := writeFile(t, `{"oidc_providers": `+providers+`}`)
+		if _, err := Load(path); err == nil {
+			t.Errorf("Expected an error for a provider with a %s", name)
+		}
+	}
+}
+
+func TestLoadAccountDeletionGrace(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load(writeFile(t, `{"account_deletion_grace_days": 30}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AccountDeletionGrace() != 30*24*time.Hour {
+		t.Errorf("Expected a grace of 30 days, received %v", cfg.AccountDeletionGrace())
+	}
+
+	if _, err := Load(writeFile(t, `{"account_deletion_grace_days": -1}`)); err == nil {
+		t.Error("Expected an error for a negative grace period")
+	}
+}
+
+func TestLoadTrashRetention(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load(writeFile(t, `{"trash_retention_days": 7}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TrashRetention() != 7*24*time.Hour {
+		t.Errorf("Expected a retention of 7 days, received %v", cfg.TrashRetention())
+	}
+
+	if _, err := Load(writeFile(t, `{"trash_retention_days": -1}`)); err == nil {
+		t.Error("Expected an error for a negative retention")
+	}
+}
+
+func TestDerivedKey(t *testing.T) {
+	cfg := Config{HMACKey: "secret"}
+	flash, impersonation := cfg.DerivedKey("flash"), cfg.DerivedKey("impersonation")
+	if flash == impersonation || flash == cfg.HMACKey || len(flash) != 64 {
+		t.Errorf("Expected a different key for each purpose, received %q and %q", flash, impersonation)
+	}
+	if cfg.DerivedKey("flash") != flash {
+		t.Error("Expected the same key every time for the same purpose")
+	}
+	if (Config{HMACKey: "other"}).DerivedKey("flash") == flash {
+		t.Error("Expected the key to depend on HMACKey")
+	}
+}
@@ -9,7 +9,10 @@ import (
 // * we do not want another app to overwrite our user key in context
 // * context stores both the key and key type
 const (
-	userKey privateKey = "user"
+	userKey         privateKey = "user"
+	apiTokenKey     privateKey = "api_token"
+	impersonatorKey privateKey = "impersonator"
+	quotaKey        privateKey = "quota"
 )
 
 type privateKey string
@@ -28,3 +31,53 @@ func User(ctx context.Context) *models.User {
 	}
 	return nil
 }
+
+// WithAPIToken records the API token a request was authenticated
+// with
+func WithAPIToken(ctx context.Context, token *models.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey, token)
+}
+
+// APIToken returns the API token the request was authenticated
+// with, or nil if it was authenticated some other way
+func APIToken(ctx context.Context) *models.APIToken {
+	if temp := ctx.Value(apiTokenKey); temp != nil {
+		if token, ok := temp.(*models.APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// WithImpersonator records the admin who is acting as the user on
+// the context
+func WithImpersonator(ctx context.Context, admin *models.User) context.Context {
+	return context.WithValue(ctx, impersonatorKey, admin)
+}
+
+// Impersonator returns the admin acting as the current user, or nil
+// if the user is acting as themselves
+func Impersonator(ctx context.Context) *models.User {
+	if temp := ctx.Value(impersonatorKey); temp != nil {
+		if user, ok := temp.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// WithQuota records the current user's plan and what they use of it
+func WithQuota(ctx context.Context, quota *models.Quota) context.Context {
+	return context.WithValue(ctx, quotaKey, quota)
+}
+
+// Quota returns the current user's quota, or nil if it wasn't
+// looked up
+func Quota(ctx context.Context) *models.Quota {
+	if temp := ctx.Value(quotaKey); temp != nil {
+		if quota, ok := temp.(*models.Quota); ok {
+			return quota
+		}
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/audit"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/oidc"
+	"github.com/sajicode/go-photo/rand"
+	"github.com/sajicode/go-photo/views"
+)
+
+// activityLimit is how many events the security activity page shows
+const activityLimit = 100
+
+// deletionDateFormat is how we tell users when their account will
+// be deleted
+const deletionDateFormat = "January 2, 2006"
+
+// NewAccount is used to create the account settings controller.
+// Accounts users ask to delete are purged once grace has passed,
+// their API tokens and app authorizations are revoked right away.
+func NewAccount(us models.UserService, uis models.UserIdentityService, ads models.AccountDeletionService, gs models.GalleryService, usage models.UsageService, ts models.APITokenService, oas models.OAuthService, grace time.Duration, providers []*oidc.Provider, al *audit.Log) *Account {
+	return &Account{
+		AccountView:  views.NewView("bootstrap", "users/account"),
+		ActivityView: views.NewView("bootstrap", "users/activity"),
+		us:           us,
+		uis:          uis,
+		ads:          ads,
+		gs:           gs,
+		usage:        usage,
+		ts:           ts,
+		oas:          oas,
+		grace:        grace,
+		providers:    providers,
+		al:           al,
+	}
+}
+
+// Account lets users manage how they sign in: their password and
+// the external accounts connected to theirs. It is also where they
+// delete their account, and see how much storage they use.
+type Account struct {
+	AccountView  *views.View
+	ActivityView *views.View
+	us           models.UserService
+	uis          models.UserIdentityService
+	ads          models.AccountDeletionService
+	gs           models.GalleryService
+	usage        models.UsageService
+	ts           models.APITokenService
+	oas          models.OAuthService
+	grace        time.Duration
+	providers    []*oidc.Provider
+	al           *audit.Log
+}
+
+// PasswordForm is used to set or change a password. Current is not
+// needed by users who don't have a password yet.
+type PasswordForm struct {
+	Current  string `schema:"current"`
+	Password string `schema:"password"`
+}
+
+// DeleteAccountForm asks users for their password again before
+// deleting their account
+type DeleteAccountForm struct {
+	Password string `schema:"password"`
+}
+
+// AccountData is what the account page renders
+type AccountData struct {
+	NoPassword bool
+	Identities []IdentityData
+	// Connect are the providers the user can still connect
+	Connect []*oidc.Provider
+	// DeleteOn is when the account will be deleted, empty unless
+	// the user asked for that
+	DeleteOn string
+	// GraceDays is how long users have to cancel a deletion
+	GraceDays int
+	// Quota is the user's plan and what they use of it, broken
+	// down by gallery in Galleries
+	Quota     *models.Quota
+	Galleries []GalleryUsageData
+}
+
+// GalleryUsageData is the storage one of the user's galleries takes
+// up
+type GalleryUsageData struct {
+	models.Usage
+	ID      uint
+	Title   string
+	Trashed bool
+}
+
+// IdentityData is an external account connected to the user's
+type IdentityData struct {
+	ID       uint
+	Provider string
+	Email    string
+}
+
+// Show renders the account page
+// GET /settings/account
+func (a *Account) Show(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	a.render(w, r, vd)
+}
+
+// UpdatePassword sets the user's password, checking their current
+// one if they have one
+// POST /settings/password
+func (a *Account) UpdatePassword(w http.ResponseWriter, r *http.Request) {
+	if a.refuseImpersonation(w, r, "password") {
+		return
+	}
+	var vd views.Data
+	var form PasswordForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	if form.Password == "" {
+		vd.SetAlert(models.ErrPasswordRequired)
+		a.render(w, r, vd)
+		return
+	}
+	if !user.NoPassword {
+		if _, err := a.us.Authenticate(user.Email, form.Current); err != nil {
+			vd.SetAlert(err)
+			a.render(w, r, vd)
+			return
+		}
+	}
+	hadPassword := !user.NoPassword
+	user.Password = form.Password
+	if err := a.us.Update(user); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, vd)
+		return
+	}
+	a.al.Record(r, models.AuditEvent{Action: models.AuditPasswordChanged}, audit.Details{"had_password": hadPassword})
+	views.RedirectAlert(w, r, "/settings/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your password was saved.",
+	})
+}
+
+// Unlink disconnects an external account from the user's
+// POST /settings/identities/:id/delete
+func (a *Account) Unlink(w http.ResponseWriter, r *http.Request) {
+	if a.refuseImpersonation(w, r, "identity_unlink") {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Connected account not found", http.StatusNotFound)
+		return
+	}
+	user := context.User(r.Context())
+	switch err := a.uis.Unlink(user.ID, uint(id)); err {
+	case nil:
+	case models.ErrNotFound:
+		http.Error(w, "Connected account not found", http.StatusNotFound)
+		return
+	default:
+		var vd views.Data
+		vd.SetAlert(err)
+		a.render(w, r, vd)
+		return
+	}
+	a.al.Record(r, models.AuditEvent{
+		Action:     models.AuditIdentityUnlinked,
+		TargetType: "identity",
+		TargetID:   strconv.Itoa(id),
+	}, nil)
+	views.RedirectAlert(w, r, "/settings/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The account was disconnected.",
+	})
+}
+
+// Delete schedules the user's account to be deleted once the grace
+// period is over. Users have to enter their password again, and
+// are logged out everywhere so nobody else can keep using the
+// account in the meantime: their sessions end, and their API
+// tokens and the access they gave apps are revoked for good.
+// Logging back in lets them cancel.
+// POST /settings/delete
+func (a *Account) Delete(w http.ResponseWriter, r *http.Request) {
+	if a.refuseImpersonation(w, r, "account_deletion") {
+		return
+	}
+	var vd views.Data
+	var form DeleteAccountForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	if user.NoPassword {
+		vd.Alert = &views.Alert{
+			Level:   views.AlertLvlError,
+			Message: "Set a password before deleting your account, so we can be sure it's you.",
+		}
+		a.render(w, r, vd)
+		return
+	}
+	if _, err := a.us.Authenticate(user.Email, form.Password); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, vd)
+		return
+	}
+	deletion, err := a.ads.Schedule(user.ID, a.grace)
+	if err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, vd)
+		return
+	}
+	a.al.Record(r, models.AuditEvent{Action: models.AuditDeletionScheduled}, audit.Details{"purge_after": deletion.PurgeAfter})
+	token, err := rand.RememberToken()
+	if err == nil {
+		user.Remember = token
+		err = a.us.Update(user)
+	}
+	if err == nil {
+		err = a.ts.DeleteByUserID(user.ID)
+	}
+	if err == nil {
+		err = a.oas.DeleteGrantsByUserID(user.ID)
+	}
+	if err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, vd)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "remember_token",
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Now(),
+		HttpOnly: true,
+	})
+	views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your account will be deleted on " + deletion.PurgeAfter.Format(deletionDateFormat) + ". Log in before then if you change your mind.",
+	})
+}
+
+// CancelDelete keeps the user's account if it hasn't been deleted
+// yet
+// POST /settings/delete/cancel
+func (a *Account) CancelDelete(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	switch err := a.ads.Cancel(user.ID); err {
+	case nil:
+	case models.ErrNotFound:
+		http.Error(w, "Your account isn't scheduled for deletion", http.StatusNotFound)
+		return
+	default:
+		var vd views.Data
+		vd.SetAlert(err)
+		a.render(w, r, vd)
+		return
+	}
+	a.al.Record(r, models.AuditEvent{Action: models.AuditDeletionCancelled}, nil)
+	views.RedirectAlert(w, r, "/settings/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your account will not be deleted.",
+	})
+}
+
+// refuseImpersonation renders the account page with an error if
+// an admin is acting as the user, since the password, connected
+// accounts and deletion are the user's alone to change. It reports
+// whether a response was written.
+func (a *Account) refuseImpersonation(w http.ResponseWriter, r *http.Request, what string) bool {
+	if !impersonating(r, a.al, what) {
+		return false
+	}
+	var vd views.Data
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlError,
+		Message: "This can't be changed while acting as a user.",
+	}
+	a.render(w, r, vd)
+	return true
+}
+
+// Activity lists the recent security events on the user's account,
+// so they can spot anything they don't recognise
+// GET /settings/activity
+func (a *Account) Activity(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	events, err := a.al.Find(models.AuditFilter{
+		UserID: user.ID,
+		Limit:  activityLimit,
+	})
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = events
+	a.ActivityView.Render(w, r, vd)
+}
+
+// render loads the user's identities before rendering
+func (a *Account) render(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	user := context.User(r.Context())
+	identities, err := a.uis.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	names := make(map[string]string, len(a.providers))
+	for _, p := range a.providers {
+		names[p.Name()] = p.DisplayName()
+	}
+	data := AccountData{
+		NoPassword: user.NoPassword,
+		GraceDays:  int(a.grace.Hours() / 24),
+	}
+	switch deletion, err := a.ads.ByUserID(user.ID); err {
+	case nil:
+		data.DeleteOn = deletion.PurgeAfter.Format(deletionDateFormat)
+	case models.ErrNotFound:
+	default:
+		vd.SetAlert(err)
+	}
+	connected := make(map[string]bool)
+	for _, identity := range identities {
+		name, ok := names[identity.Provider]
+		if !ok {
+			name = identity.Provider
+		}
+		connected[identity.Provider] = true
+		data.Identities = append(data.Identities, IdentityData{
+			ID:       identity.ID,
+			Provider: name,
+			Email:    identity.Email,
+		})
+	}
+	for _, p := range a.providers {
+		if !connected[p.Name()] {
+			data.Connect = append(data.Connect, p)
+		}
+	}
+	if err := a.addUsage(&data, user); err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = data
+	a.AccountView.Render(w, r, vd)
+}
+
+// addUsage adds the user's quota and the usage of each of their
+// galleries, those in the trash included
+func (a *Account) addUsage(data *AccountData, user *models.User) error {
+	quota, err := a.usage.Quota(user)
+	if err != nil {
+		return err
+	}
+	data.Quota = quota
+	usage, err := a.usage.ByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	galleries, err := a.gs.ByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	trashed, err := a.gs.DeletedByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	titles := make(map[uint]string, len(galleries)+len(trashed))
+	for _, g := range append(galleries, trashed...) {
+		titles[g.ID] = g.Title
+	}
+	for _, u := range usage {
+		data.Galleries = append(data.Galleries, GalleryUsageData{
+			Usage:   u.Usage,
+			ID:      u.GalleryID,
+			Title:   titles[u.GalleryID],
+			Trashed: galleryIn(trashed, u.GalleryID),
+		})
+	}
+	return nil
+}
+
+// galleryIn reports whether the gallery with id is in galleries
+func galleryIn(galleries []models.Gallery, id uint) bool {
+	for _, g := range galleries {
+		if g.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,618 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/audit"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/email"
+	"github.com/sajicode/go-photo/middleware"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/views"
+)
+
+const (
+	// adminSearchLimit is how many users a search in the admin
+	// console returns, and how many events a user's page shows
+	adminSearchLimit = 50
+	// adminAuditLimit is how many events the audit log shows, the
+	// CSV export has all of them
+	adminAuditLimit = 200
+	// adminAuditDate is the format of the audit log date filters
+	adminAuditDate = "2006-01-02"
+)
+
+// NewAdmin is used to create the admin console controller
+func NewAdmin(us models.UserService, gs models.GalleryService, is models.ImageService, usage models.UsageService, al *audit.Log, emailer email.Client, im *middleware.Impersonation) *Admin {
+	return &Admin{
+		UsersView: views.NewView("bootstrap", "admin/users"),
+		UserView:  views.NewView("bootstrap", "admin/user", "admin/events"),
+		AuditView: views.NewView("bootstrap", "admin/audit", "admin/events"),
+		us:        us,
+		gs:        gs,
+		is:        is,
+		usage:     usage,
+		al:        al,
+		emailer:   emailer,
+		im:        im,
+	}
+}
+
+// Admin lets moderators find users and remove abusive content, and
+// admins manage accounts and review the audit log. Everything done
+// to a user is recorded in the audit log.
+type Admin struct {
+	UsersView *views.View
+	UserView  *views.View
+	AuditView *views.View
+	us        models.UserService
+	gs        models.GalleryService
+	is        models.ImageService
+	usage     models.UsageService
+	al        *audit.Log
+	emailer   email.Client
+	im        *middleware.Impersonation
+}
+
+// AdminSearchForm is used to search for users
+type AdminSearchForm struct {
+	Query string `schema:"q"`
+}
+
+// AdminUsersData is what the user search page renders
+type AdminUsersData struct {
+	Form  AdminSearchForm
+	Users []models.User
+	// CanAudit is set for admins, who can see the audit log
+	CanAudit bool
+}
+
+// AdminUserData is what a user's page in the admin console renders
+type AdminUserData struct {
+	User      *models.User
+	Galleries []models.Gallery
+	// Images and Bytes are totals across every gallery
+	Images int
+	Bytes  int64
+	// Events are the latest audit events of the user, only loaded
+	// for admins
+	Events []models.AuditEvent
+	Roles  []string
+	// Quota is the user's plan and what they use of it, the trash
+	// included
+	Quota *models.Quota
+	Plans []models.Plan
+	// CanManage is set for admins, who can do more than remove
+	// content
+	CanManage bool
+}
+
+// Storage is the space the user's images take up
+func (d AdminUserData) Storage() string {
+	return views.FormatBytes(d.Bytes)
+}
+
+// AdminRoleForm is used to change a user's role
+type AdminRoleForm struct {
+	Role string `schema:"role"`
+}
+
+// AdminPlanForm is used to move a user to another plan
+type AdminPlanForm struct {
+	Plan string `schema:"plan"`
+}
+
+// AdminAuditForm filters the audit log. User is an email address
+// or user ID, Since and Until are dates and both inclusive.
+type AdminAuditForm struct {
+	User   string `schema:"user"`
+	Action string `schema:"action"`
+	Since  string `schema:"since"`
+	Until  string `schema:"until"`
+}
+
+// AdminAuditData is what the audit log page renders
+type AdminAuditData struct {
+	Form      AdminAuditForm
+	Actions   []string
+	Events    []models.AuditEvent
+	ExportURL string
+}
+
+// Users searches users by name or email, listing the most recent
+// users when there is no query
+// GET /admin/users
+func (a *Admin) Users(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	data := AdminUsersData{
+		CanAudit: context.User(r.Context()).HasRole(models.RoleAdmin),
+	}
+	if err := parseURLParams(r, &data.Form); err != nil {
+		vd.SetAlert(err)
+	}
+	users, err := a.us.Search(data.Form.Query, adminSearchLimit)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	data.Users = users
+	vd.Yield = data
+	a.UsersView.Render(w, r, vd)
+}
+
+// User shows a user's galleries, storage use and the actions taken
+// on their account
+// GET /admin/users/:id
+func (a *Admin) User(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	a.renderUser(w, r, vd, user)
+}
+
+// Disable stops a user from logging in, ending every session and
+// token they have
+// POST /admin/users/:id/disable
+func (a *Admin) Disable(w http.ResponseWriter, r *http.Request) {
+	a.setDisabled(w, r, true)
+}
+
+// Enable lets a disabled user log in again
+// POST /admin/users/:id/enable
+func (a *Admin) Enable(w http.ResponseWriter, r *http.Request) {
+	a.setDisabled(w, r, false)
+}
+
+func (a *Admin) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if !a.canManage(w, r, user) {
+		return
+	}
+	user.Disabled = disabled
+	if err := a.us.Update(user); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		a.renderUser(w, r, vd, user)
+		return
+	}
+	action, msg := models.AuditUserEnabled, "enabled"
+	if disabled {
+		action, msg = models.AuditUserDisabled, "disabled"
+	}
+	a.record(r, user, action, nil)
+	a.redirectToUser(w, r, user, user.Email+" was "+msg+".")
+}
+
+// SetRole changes a user's role
+// POST /admin/users/:id/role
+func (a *Admin) SetRole(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if !a.canManage(w, r, user) {
+		return
+	}
+	var vd views.Data
+	var form AdminRoleForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.renderUser(w, r, vd, user)
+		return
+	}
+	previous := user.Role
+	user.Role = form.Role
+	if err := a.us.Update(user); err != nil {
+		user.Role = previous
+		vd.SetAlert(err)
+		a.renderUser(w, r, vd, user)
+		return
+	}
+	a.record(r, user, models.AuditRoleChanged, audit.Details{"from": previous, "to": user.Role})
+	a.redirectToUser(w, r, user, user.Email+" is now a "+user.Role+".")
+}
+
+// SetPlan moves a user to another plan. Users already over the new
+// plan keep their images, they just can't add more.
+// POST /admin/users/:id/plan
+func (a *Admin) SetPlan(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if !a.canManage(w, r, user) {
+		return
+	}
+	var vd views.Data
+	var form AdminPlanForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.renderUser(w, r, vd, user)
+		return
+	}
+	previous := user.Plan
+	user.Plan = form.Plan
+	if err := a.us.Update(user); err != nil {
+		user.Plan = previous
+		vd.SetAlert(err)
+		a.renderUser(w, r, vd, user)
+		return
+	}
+	a.record(r, user, models.AuditPlanChanged, audit.Details{"from": previous, "to": user.Plan})
+	a.redirectToUser(w, r, user, user.Email+" is now on the "+user.Plan+" plan.")
+}
+
+// ForceReset logs a user out everywhere and emails them a link to
+// choose a new password, which they have to do before logging in
+// again
+// POST /admin/users/:id/reset
+func (a *Admin) ForceReset(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if !a.canManage(w, r, user) {
+		return
+	}
+	var vd views.Data
+	token, err := a.us.ForcePasswordReset(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		a.renderUser(w, r, vd, user)
+		return
+	}
+	a.record(r, user, models.AuditPasswordResetForced, nil)
+	if err := a.emailer.ResetPw(user.Email, token); err != nil {
+		vd.SetAlert(err)
+		a.renderUser(w, r, vd, user)
+		return
+	}
+	a.redirectToUser(w, r, user, user.Email+" has to reset their password and was emailed instructions.")
+}
+
+// Impersonate lets an admin act as a user to help them, until they
+// stop or an hour has passed
+// POST /admin/users/:id/impersonate
+func (a *Admin) Impersonate(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if !a.canManage(w, r, user) {
+		return
+	}
+	if user.Disabled || user.HasRole(models.RoleAdmin) {
+		a.renderUserError(w, r, user, "Disabled users and admins can't be impersonated.")
+		return
+	}
+	admin := context.User(r.Context())
+	if err := a.im.Start(w, admin, user); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		a.renderUser(w, r, vd, user)
+		return
+	}
+	a.record(r, user, models.AuditImpersonationStarted, nil)
+	views.RedirectAlert(w, r, "/galleries", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlWarning,
+		Message: "You are now acting as " + user.Email + ".",
+	})
+}
+
+// StopImpersonating makes an admin act as themselves again. It is
+// reached while impersonating, so it can't require the admin role.
+// POST /admin/impersonate/stop
+func (a *Admin) StopImpersonating(w http.ResponseWriter, r *http.Request) {
+	admin := context.Impersonator(r.Context())
+	user := context.User(r.Context())
+	a.im.Stop(w)
+	if admin == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	a.al.Record(r, models.AuditEvent{
+		Action:     models.AuditImpersonationFinished,
+		ActorID:    admin.ID,
+		UserID:     user.ID,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(int(user.ID)),
+	}, nil)
+	a.redirectToUser(w, r, user, "You stopped acting as "+user.Email+".")
+}
+
+// DeleteGallery removes an abusive gallery and its images for
+// good, so the owner can't restore them from the trash
+// POST /admin/galleries/:id/delete
+func (a *Admin) DeleteGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	err = a.is.DeleteAll(gallery.ID)
+	if err == nil {
+		err = a.gs.Purge(gallery.ID)
+	}
+	if err != nil {
+		a.galleryOwnerError(w, r, gallery, err)
+		return
+	}
+	a.al.GalleryDeleted(r, gallery)
+	a.redirectToUserID(w, r, gallery.UserID, "Gallery "+gallery.Title+" was deleted.")
+}
+
+// DeleteImage removes an abusive image from a gallery for good
+// POST /admin/galleries/:id/images/:filename/delete
+func (a *Admin) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	image := models.Image{
+		GalleryID: gallery.ID,
+		Filename:  mux.Vars(r)["filename"],
+	}
+	if err := a.is.Purge(&image); err != nil {
+		a.galleryOwnerError(w, r, gallery, err)
+		return
+	}
+	a.al.ImageDeleted(r, gallery, image.Filename)
+	a.redirectToUserID(w, r, gallery.UserID, "Image "+image.Filename+" was deleted.")
+}
+
+// Audit shows the latest audit events matching the filters
+// GET /admin/audit
+func (a *Admin) Audit(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	data := AdminAuditData{
+		Actions:   models.AuditActions,
+		ExportURL: "/admin/audit.csv?" + r.URL.RawQuery,
+	}
+	filter, err := a.auditFilter(r, &data.Form)
+	if err != nil {
+		vd.Alert = &views.Alert{
+			Level:   views.AlertLvlError,
+			Message: err.Error(),
+		}
+	} else {
+		filter.Limit = adminAuditLimit
+		data.Events, err = a.al.Find(filter)
+		if err != nil {
+			vd.SetAlert(err)
+		}
+	}
+	vd.Yield = data
+	a.AuditView.Render(w, r, vd)
+}
+
+// AuditCSV exports every audit event matching the filters as CSV
+// GET /admin/audit.csv
+func (a *Admin) AuditCSV(w http.ResponseWriter, r *http.Request) {
+	var form AdminAuditForm
+	filter, err := a.auditFilter(r, &form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := a.al.Find(filter)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.csv"`, time.Now().Format("20060102")))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created_at", "action", "actor_id", "impersonator_id", "user_id", "target_type", "target_id", "ip", "user_agent", "details"})
+	for _, e := range events {
+		cw.Write([]string{
+			strconv.Itoa(int(e.ID)),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			csvCell(e.Action),
+			strconv.Itoa(int(e.ActorID)),
+			strconv.Itoa(int(e.ImpersonatorID)),
+			strconv.Itoa(int(e.UserID)),
+			csvCell(e.TargetType),
+			csvCell(e.TargetID),
+			csvCell(e.IP),
+			csvCell(e.UserAgent),
+			csvCell(e.Details),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Println(err)
+	}
+}
+
+// csvCell prefixes s with ' if a spreadsheet would otherwise run it
+// as a formula, since user agents and the like are whatever the
+// client sent
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// auditFilter parses the audit log filters in r into form and turns
+// them into an AuditFilter. Its errors are meant for the admin.
+func (a *Admin) auditFilter(r *http.Request, form *AdminAuditForm) (models.AuditFilter, error) {
+	var filter models.AuditFilter
+	if err := parseURLParams(r, form); err != nil {
+		return filter, err
+	}
+	filter.Action = form.Action
+	if form.User != "" {
+		if id, err := strconv.Atoi(form.User); err == nil && id > 0 {
+			filter.UserID = uint(id)
+		} else {
+			user, err := a.us.ByEmail(form.User)
+			if err != nil {
+				return filter, fmt.Errorf("No user found with the email address %s.", form.User)
+			}
+			filter.UserID = user.ID
+		}
+	}
+	var err error
+	if form.Since != "" {
+		if filter.Since, err = time.Parse(adminAuditDate, form.Since); err != nil {
+			return filter, fmt.Errorf("%s isn't a valid date.", form.Since)
+		}
+	}
+	if form.Until != "" {
+		if filter.Until, err = time.Parse(adminAuditDate, form.Until); err != nil {
+			return filter, fmt.Errorf("%s isn't a valid date.", form.Until)
+		}
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+// canManage keeps admins from disabling, demoting or impersonating
+// themselves, writing an error if user is the current user
+func (a *Admin) canManage(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	if user.ID != context.User(r.Context()).ID {
+		return true
+	}
+	a.renderUserError(w, r, user, "You can't do that to your own account.")
+	return false
+}
+
+// record adds action taken by the current user on user to the
+// audit log
+func (a *Admin) record(r *http.Request, user *models.User, action string, details audit.Details) {
+	a.al.Record(r, models.AuditEvent{
+		Action:     action,
+		UserID:     user.ID,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(int(user.ID)),
+	}, details)
+}
+
+func (a *Admin) redirectToUser(w http.ResponseWriter, r *http.Request, user *models.User, msg string) {
+	a.redirectToUserID(w, r, user.ID, msg)
+}
+
+func (a *Admin) redirectToUserID(w http.ResponseWriter, r *http.Request, userID uint, msg string) {
+	views.RedirectAlert(w, r, fmt.Sprintf("/admin/users/%d", userID), http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: msg,
+	})
+}
+
+// galleryOwnerError renders the page of the gallery's owner with
+// err
+func (a *Admin) galleryOwnerError(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, err error) {
+	var vd views.Data
+	vd.SetAlert(err)
+	user, uerr := a.us.ByID(gallery.UserID)
+	if uerr != nil {
+		log.Println(uerr)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	a.renderUser(w, r, vd, user)
+}
+
+func (a *Admin) renderUserError(w http.ResponseWriter, r *http.Request, user *models.User, msg string) {
+	vd := views.Data{
+		Alert: &views.Alert{
+			Level:   views.AlertLvlError,
+			Message: msg,
+		},
+	}
+	a.renderUser(w, r, vd, user)
+}
+
+func (a *Admin) renderUser(w http.ResponseWriter, r *http.Request, vd views.Data, user *models.User) {
+	data := AdminUserData{
+		User:      user,
+		Roles:     models.Roles,
+		Plans:     models.Plans,
+		CanManage: context.User(r.Context()).HasRole(models.RoleAdmin),
+	}
+	quota, err := a.usage.Quota(user)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	data.Quota = quota
+	galleries, err := a.gs.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	for i := range galleries {
+		images, err := a.is.ByGalleryID(galleries[i].ID)
+		if err != nil {
+			vd.SetAlert(err)
+			continue
+		}
+		galleries[i].Images = images
+		data.Images += len(images)
+		for _, image := range images {
+			data.Bytes += image.Size
+		}
+	}
+	data.Galleries = galleries
+	if data.CanManage {
+		data.Events, err = a.al.Find(models.AuditFilter{
+			UserID: user.ID,
+			Limit:  adminSearchLimit,
+		})
+		if err != nil {
+			vd.SetAlert(err)
+		}
+	}
+	vd.Yield = data
+	a.UserView.Render(w, r, vd)
+}
+
+func (a *Admin) userByID(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, err
+	}
+	user, err := a.us.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (a *Admin) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := a.gs.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return gallery, nil
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/views"
+)
+
+const (
+	// openAPIPath is the OpenAPI document describing the API. It
+	// is maintained by hand, so update it whenever an API handler
+	// or route changes.
+	openAPIPath = "controllers/openapi.json"
+
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// APIError is the body of every error returned by the JSON API
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+}
+
+// APIErrorBody describes what went wrong in a way that is safe to
+// show to users
+type APIErrorBody struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Pagination describes which slice of a list was returned. Lists
+// paged by number have a Page, lists paged by cursor have a
+// NextCursor until the last page.
+type Pagination struct {
+	Page       int    `json:"page,omitempty"`
+	PerPage    int    `json:"per_page"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// PageParams are the query params used to page through lists
+type PageParams struct {
+	Page    int `schema:"page"`
+	PerPage int `schema:"per_page"`
+}
+
+// parsePageParams reads ?page=&per_page= falling back to the first
+// page of defaultPerPage items.
+func parsePageParams(r *http.Request) PageParams {
+	var p PageParams
+	parseURLParams(r, &p)
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.PerPage < 1 {
+		p.PerPage = defaultPerPage
+	}
+	if p.PerPage > maxPerPage {
+		p.PerPage = maxPerPage
+	}
+	return p
+}
+
+// bounds returns the start and end indexes of the page within a
+// list of n items.
+func (p PageParams) bounds(n int) (int, int) {
+	start := (p.Page - 1) * p.PerPage
+	if start > n {
+		start = n
+	}
+	end := start + p.PerPage
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeAPIError responds with the given status and message
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, APIError{
+		Error: APIErrorBody{
+			Status:  status,
+			Message: msg,
+		},
+	})
+}
+
+// writeModelError maps an error from the models package onto an
+// API error. Public errors are shown as is, anything else is
+// logged and reported as a generic 500, just like Data.SetAlert.
+func writeModelError(w http.ResponseWriter, err error) {
+	if err == models.ErrNotFound || os.IsNotExist(err) {
+		writeAPIError(w, http.StatusNotFound, models.ErrNotFound.Public())
+		return
+	}
+	if pErr, ok := err.(views.PublicError); ok {
+		writeAPIError(w, http.StatusUnprocessableEntity, pErr.Public())
+		return
+	}
+	log.Println(err)
+	writeAPIError(w, http.StatusInternalServerError, views.AlertMsgGeneric)
+}
+
+// OpenAPI serves the OpenAPI document describing the JSON API
+// GET /api/v1/openapi.json
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFile(w, r, openAPIPath)
+}
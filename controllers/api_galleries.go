@@ -0,0 +1,422 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/audit"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/unpack"
+)
+
+// NewAPIGalleries is used to create the JSON API gallery
+// controller. should only be used at setup
+func NewAPIGalleries(gs models.GalleryService, is models.ImageService, al *audit.Log) *APIGalleries {
+	return &APIGalleries{
+		gs: gs,
+		is: is,
+		al: al,
+	}
+}
+
+// APIGalleries serves galleries and their images as JSON under
+// /api/v1. Every route expects a signed in user and only ever
+// exposes that user's galleries.
+type APIGalleries struct {
+	gs models.GalleryService
+	is models.ImageService
+	al *audit.Log
+}
+
+// APIGallery is the JSON representation of a gallery
+type APIGallery struct {
+	ID          uint      `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Cover       string    `json:"cover"`
+	EventDate   *string   `json:"event_date"`
+	Location    string    `json:"location"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// APIImage is the JSON representation of an image
+type APIImage struct {
+	GalleryID uint     `json:"gallery_id"`
+	Filename  string   `json:"filename"`
+	URL       string   `json:"url"`
+	Position  int      `json:"position"`
+	Caption   string   `json:"caption"`
+	AltText   string   `json:"alt_text"`
+	Tags      []string `json:"tags"`
+	// Camera and TakenAt are read from the image when it is
+	// uploaded
+	Camera  string     `json:"camera"`
+	TakenAt *time.Time `json:"taken_at"`
+}
+
+// APIGalleryForm is the body accepted when creating or updating a
+// gallery. The title is always required, the other fields are left
+// alone when they are missing.
+type APIGalleryForm struct {
+	Title       string  `json:"title"`
+	Description *string `json:"description"`
+	Cover       *string `json:"cover"`
+	EventDate   *string `json:"event_date"`
+	Location    *string `json:"location"`
+	// Tags replace the gallery's tags
+	Tags *[]string `json:"tags"`
+}
+
+// apply copies the fields that were sent onto gallery
+func (form *APIGalleryForm) apply(gallery *models.Gallery) error {
+	if form.EventDate != nil {
+		eventDate, err := parseEventDate(*form.EventDate)
+		if err != nil {
+			return err
+		}
+		gallery.EventDate = eventDate
+	}
+	gallery.Title = form.Title
+	if form.Description != nil {
+		gallery.Description = *form.Description
+	}
+	if form.Cover != nil {
+		gallery.CoverFilename = *form.Cover
+	}
+	if form.Location != nil {
+		gallery.Location = *form.Location
+	}
+	if form.Tags != nil {
+		gallery.Tags = models.JoinTags(*form.Tags)
+	}
+	return nil
+}
+
+func newAPIGallery(g *models.Gallery) APIGallery {
+	ret := APIGallery{
+		ID:          g.ID,
+		Title:       g.Title,
+		Description: g.Description,
+		Cover:       g.CoverFilename,
+		Location:    g.Location,
+		Tags:        apiTags(g.TagList()),
+		CreatedAt:   g.CreatedAt,
+		UpdatedAt:   g.UpdatedAt,
+	}
+	if g.EventDate != nil {
+		date := g.EventDate.Format(eventDateFormat)
+		ret.EventDate = &date
+	}
+	return ret
+}
+
+// apiTags makes sure tags are sent as an empty list rather than
+// null
+func apiTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+func newAPIImages(images []models.Image) []APIImage {
+	ret := make([]APIImage, len(images))
+	for i := range images {
+		ret[i] = APIImage{
+			GalleryID: images[i].GalleryID,
+			Filename:  images[i].Filename,
+			URL:       images[i].Path(),
+			Position:  images[i].Position,
+			Caption:   images[i].Caption,
+			AltText:   images[i].AltText,
+			Tags:      apiTags(images[i].TagList()),
+			Camera:    images[i].Camera,
+			TakenAt:   images[i].TakenAt,
+		}
+	}
+	return ret
+}
+
+// Index lists the current user's galleries a page at a time,
+// following the cursor of the previous page
+// GET /api/v1/galleries
+func (a *APIGalleries) Index(w http.ResponseWriter, r *http.Request) {
+	var params GalleryListParams
+	if err := parseURLParams(r, &params); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "per_page must be a number")
+		return
+	}
+	if params.PerPage < 1 {
+		params.PerPage = defaultPerPage
+	}
+	if params.PerPage > maxPerPage {
+		params.PerPage = maxPerPage
+	}
+	page, err := a.gs.Page(params.query(context.User(r.Context())))
+	if err != nil {
+		writeModelError(w, err)
+		return
+	}
+	data := make([]APIGallery, 0, len(page.Galleries))
+	for i := range page.Galleries {
+		data = append(data, newAPIGallery(&page.Galleries[i]))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": data,
+		"pagination": Pagination{
+			PerPage:    params.PerPage,
+			Total:      page.Total,
+			NextCursor: page.Next,
+		},
+	})
+}
+
+// Show returns a single gallery
+// GET /api/v1/galleries/:id
+func (a *APIGalleries) Show(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.galleryByID(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": newAPIGallery(gallery),
+	})
+}
+
+// Create creates a gallery owned by the current user
+// POST /api/v1/galleries
+func (a *APIGalleries) Create(w http.ResponseWriter, r *http.Request) {
+	var form APIGalleryForm
+	if !decodeJSON(w, r, &form) {
+		return
+	}
+	user := context.User(r.Context())
+	gallery := models.Gallery{
+		UserID: user.ID,
+		// A new gallery has no images to be its cover
+		Images: []models.Image{},
+	}
+	if err := form.apply(&gallery); err != nil {
+		writeModelError(w, err)
+		return
+	}
+	if err := a.gs.Create(&gallery); err != nil {
+		writeModelError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"data": newAPIGallery(&gallery),
+	})
+}
+
+// Update changes the title and details of a gallery
+// PATCH /api/v1/galleries/:id
+func (a *APIGalleries) Update(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.galleryByID(w, r)
+	if !ok {
+		return
+	}
+	var form APIGalleryForm
+	if !decodeJSON(w, r, &form) {
+		return
+	}
+	if form.Cover != nil {
+		// so the cover can be checked against the gallery's images
+		images, err := a.is.ByGalleryID(gallery.ID)
+		if err != nil {
+			writeModelError(w, err)
+			return
+		}
+		gallery.Images = images
+	}
+	if err := form.apply(gallery); err != nil {
+		writeModelError(w, err)
+		return
+	}
+	if err := a.gs.Update(gallery); err != nil {
+		writeModelError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": newAPIGallery(gallery),
+	})
+}
+
+// Delete deletes a gallery
+// DELETE /api/v1/galleries/:id
+func (a *APIGalleries) Delete(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.galleryByID(w, r)
+	if !ok {
+		return
+	}
+	if err := a.gs.Delete(gallery.ID); err != nil {
+		writeModelError(w, err)
+		return
+	}
+	a.al.GalleryDeleted(r, gallery)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ImageIndex lists the images in a gallery a page at a time
+// GET /api/v1/galleries/:id/images
+func (a *APIGalleries) ImageIndex(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.galleryByID(w, r)
+	if !ok {
+		return
+	}
+	images, err := a.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		writeModelError(w, err)
+		return
+	}
+	page := parsePageParams(r)
+	start, end := page.bounds(len(images))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": newAPIImages(images[start:end]),
+		"pagination": Pagination{
+			Page:    page.Page,
+			PerPage: page.PerPage,
+			Total:   len(images),
+		},
+	})
+}
+
+// ImageUpload uploads the files in the "images" multipart field
+// POST /api/v1/galleries/:id/images
+func (a *APIGalleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.galleryByID(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, unpack.DefaultLimits.MaxTotalSize)
+	if err := r.ParseMultipartForm(maxMultipartMem); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Expected a multipart form with an images field")
+		return
+	}
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "Expected a multipart form with an images field")
+		return
+	}
+	uploaded := make([]models.Image, 0, len(files))
+	for _, f := range files {
+		file, err := f.Open()
+		if err != nil {
+			writeModelError(w, err)
+			return
+		}
+		err = a.is.Create(gallery.ID, file, f.Filename)
+		file.Close()
+		if err != nil {
+			writeModelError(w, err)
+			return
+		}
+		uploaded = append(uploaded, models.Image{
+			GalleryID: gallery.ID,
+			Filename:  f.Filename,
+		})
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"data": newAPIImages(uploaded),
+	})
+}
+
+// ImageImport imports the images in the zip or tar archive in the
+// "archive" multipart field, reporting what happened to each file
+// POST /api/v1/galleries/:id/archive
+func (a *APIGalleries) ImageImport(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.galleryByID(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, unpack.DefaultLimits.MaxTotalSize)
+	if err := r.ParseMultipartForm(maxMultipartMem); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Expected a multipart form with an archive field")
+		return
+	}
+	files := r.MultipartForm.File["archive"]
+	if len(files) != 1 {
+		writeAPIError(w, http.StatusBadRequest, "Expected a multipart form with an archive field")
+		return
+	}
+	file, err := files[0].Open()
+	if err != nil {
+		writeModelError(w, err)
+		return
+	}
+	defer file.Close()
+	summary, err := importArchive(a.is, gallery.ID, file, files[0].Size)
+	if summary == nil {
+		writeModelError(w, err)
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": APIErrorBody{
+				Status:  http.StatusUnprocessableEntity,
+				Message: importReason(err),
+			},
+			"data": summary,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": summary,
+	})
+}
+
+// ImageDelete deletes an image from a gallery
+// DELETE /api/v1/galleries/:id/images/:filename
+func (a *APIGalleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.galleryByID(w, r)
+	if !ok {
+		return
+	}
+	i := models.Image{
+		Filename:  mux.Vars(r)["filename"],
+		GalleryID: gallery.ID,
+	}
+	if err := a.is.Delete(&i); err != nil {
+		writeModelError(w, err)
+		return
+	}
+	a.al.ImageDeleted(r, gallery, i.Filename)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// galleryByID looks up the gallery in the URL, writing a 404 if it
+// doesn't exist or belongs to somebody else.
+func (a *APIGalleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, models.ErrNotFound.Public())
+		return nil, false
+	}
+	gallery, err := a.gs.ByID(uint(id))
+	if err != nil {
+		writeModelError(w, err)
+		return nil, false
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		writeAPIError(w, http.StatusNotFound, models.ErrNotFound.Public())
+		return nil, false
+	}
+	return gallery, true
+}
+
+// decodeJSON decodes the request body into dst, writing a 400 if
+// it isn't valid JSON.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Request body must be valid JSON")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/audit"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/views"
+)
+
+// NewAPITokens is used to create the personal API token controller
+func NewAPITokens(ts models.APITokenService, al *audit.Log) *APITokens {
+	return &APITokens{
+		IndexView: views.NewView("bootstrap", "users/tokens"),
+		ts:        ts,
+		al:        al,
+	}
+}
+
+// APITokens lets users manage their personal API tokens from
+// their settings
+type APITokens struct {
+	IndexView *views.View
+	ts        models.APITokenService
+	al        *audit.Log
+}
+
+// APITokenForm is used to create an API token. ExpiresIn is the
+// number of days the token is valid for, 0 means it never expires.
+type APITokenForm struct {
+	Name      string `schema:"name"`
+	Scope     string `schema:"scope"`
+	ExpiresIn int    `schema:"expires_in"`
+}
+
+// APITokensData is what the tokens page renders. Created is only
+// set right after creating a token, since that is the only time
+// the raw token is available.
+type APITokensData struct {
+	Tokens  []models.APIToken
+	Created *models.APIToken
+	Form    APITokenForm
+}
+
+// Index lists the current user's API tokens
+// GET /settings/tokens
+func (at *APITokens) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	at.render(w, r, vd, APITokensData{})
+}
+
+// Create creates a new API token and shows it once
+// POST /settings/tokens
+func (at *APITokens) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var data APITokensData
+	if err := parseForm(r, &data.Form); err != nil {
+		vd.SetAlert(err)
+		at.render(w, r, vd, data)
+		return
+	}
+	if impersonating(r, at.al, "api_token") {
+		vd.Alert = &views.Alert{
+			Level:   views.AlertLvlError,
+			Message: "API tokens can't be created while acting as a user.",
+		}
+		at.render(w, r, vd, data)
+		return
+	}
+	user := context.User(r.Context())
+	token := models.APIToken{
+		UserID: user.ID,
+		Name:   data.Form.Name,
+		Scope:  data.Form.Scope,
+	}
+	if data.Form.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, data.Form.ExpiresIn)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := at.ts.Create(&token); err != nil {
+		vd.SetAlert(err)
+		at.render(w, r, vd, data)
+		return
+	}
+	at.al.Record(r, models.AuditEvent{
+		Action:     models.AuditAPITokenCreated,
+		TargetType: "api_token",
+		TargetID:   strconv.Itoa(int(token.ID)),
+	}, audit.Details{"name": token.Name, "scope": token.Scope})
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your API token was created. Copy it now, you won't be able to see it again!",
+	}
+	at.render(w, r, vd, APITokensData{Created: &token})
+}
+
+// Revoke deletes one of the current user's API tokens
+// POST /settings/tokens/:id/revoke
+func (at *APITokens) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+	token, err := at.ts.ByID(uint(id))
+	user := context.User(r.Context())
+	if err != nil || token.UserID != user.ID || token.OAuthClientID != 0 {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+	if err := at.ts.Delete(token.ID); err != nil {
+		log.Println(err)
+		var vd views.Data
+		vd.SetAlert(err)
+		at.render(w, r, vd, APITokensData{})
+		return
+	}
+	at.al.Record(r, models.AuditEvent{
+		Action:     models.AuditAPITokenRevoked,
+		TargetType: "api_token",
+		TargetID:   strconv.Itoa(int(token.ID)),
+	}, audit.Details{"name": token.Name})
+	views.RedirectAlert(w, r, "/settings/tokens", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "API token " + token.Name + " was revoked.",
+	})
+}
+
+// render loads the user's personal tokens into data before
+// rendering, leaving out the ones issued to OAuth apps
+func (at *APITokens) render(w http.ResponseWriter, r *http.Request, vd views.Data, data APITokensData) {
+	user := context.User(r.Context())
+	tokens, err := at.ts.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	for _, token := range tokens {
+		if token.OAuthClientID == 0 {
+			data.Tokens = append(data.Tokens, token)
+		}
+	}
+	vd.Yield = data
+	at.IndexView.Render(w, r, vd)
+}
@@ -0,0 +1,511 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/views"
+)
+
+const (
+	// ShowCollection for named route
+	ShowCollection = "show_collection"
+	// EditCollection for named route
+	EditCollection = "edit_collection"
+)
+
+// NewCollections is used to create the collections controller.
+// should only be used at setup
+func NewCollections(cs models.CollectionService, gs models.GalleryService, is models.ImageService, r *mux.Router) *Collections {
+	return &Collections{
+		NewView:  views.NewView("bootstrap", "collections/new", "collections/partials"),
+		ShowView: views.NewView("bootstrap", "collections/show", "collections/partials"),
+		EditView: views.NewView("bootstrap", "collections/edit", "collections/partials"),
+		cs:       cs,
+		gs:       gs,
+		is:       is,
+		r:        r,
+	}
+}
+
+// Collections group galleries, and other collections, so users
+// with lots of galleries can find their way around them
+type Collections struct {
+	NewView  *views.View
+	ShowView *views.View
+	EditView *views.View
+	cs       models.CollectionService
+	gs       models.GalleryService
+	is       models.ImageService
+	r        *mux.Router
+}
+
+// CollectionForm holds the settings of a collection
+type CollectionForm struct {
+	Title      string `schema:"title"`
+	Parent     uint   `schema:"parent"`
+	Visibility string `schema:"visibility"`
+	Sort       string `schema:"sort"`
+	Cover      uint   `schema:"cover"`
+}
+
+// NewCollectionData is what the new collection page shows
+type NewCollectionData struct {
+	Parent  uint
+	Options []models.CollectionOption
+}
+
+// CollectionData is what the collection pages show
+type CollectionData struct {
+	*models.Collection
+	// Visible is set when the current user can see the collection,
+	// which they always can if they are the Owner
+	Visible bool
+	Owner   bool
+	// Breadcrumbs are the collections this one is in, from the top
+	// level
+	Breadcrumbs []models.Collection
+	// Children are the collections in this one, with their galleries
+	// loaded for their covers
+	Children []models.Collection
+	// Parents are the collections this one can be moved into, and
+	// Options every collection galleries can be moved into
+	Parents []models.CollectionOption
+	Options []models.CollectionOption
+}
+
+// MoveForm returns what the form moving gallery elsewhere needs
+func (cd *CollectionData) MoveForm(gallery models.Gallery) MoveGalleryData {
+	return MoveGalleryData{
+		Gallery: gallery,
+		Options: cd.Options,
+		Next:    fmt.Sprintf("/collections/%d/edit", cd.ID),
+	}
+}
+
+// MoveGalleryData is what the form moving a gallery to another
+// collection needs
+type MoveGalleryData struct {
+	Gallery models.Gallery
+	Options []models.CollectionOption
+	// Next is where to go once the gallery is moved
+	Next string
+}
+
+// MoveGalleryForm moves a gallery into a collection, or out of any
+// when Collection is 0
+type MoveGalleryForm struct {
+	Collection uint   `schema:"collection"`
+	Next       string `schema:"next"`
+}
+
+// OrderCollectionsForm orders the collections in Parent
+type OrderCollectionsForm struct {
+	Parent uint   `schema:"parent"`
+	IDs    []uint `schema:"ids"`
+}
+
+// New shows the form to create a collection
+// GET /collections/new
+func (c *Collections) New(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	tree, err := c.tree(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	data := NewCollectionData{Options: tree.Options()}
+	// the new collection button of a collection passes itself as the
+	// parent
+	if id, err := strconv.Atoi(r.URL.Query().Get("parent")); err == nil && tree.ByID(uint(id)) != nil {
+		data.Parent = uint(id)
+	}
+	vd.Yield = data
+	c.NewView.Render(w, r, vd)
+}
+
+// Create creates a collection, in the top level or in another of
+// the user's collections
+// POST /collections
+func (c *Collections) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	var form CollectionForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		c.renderNew(w, r, vd, user.ID, form.Parent)
+		return
+	}
+	collection := models.Collection{
+		UserID:     user.ID,
+		ParentID:   form.Parent,
+		Title:      form.Title,
+		Visibility: form.Visibility,
+		Sort:       form.Sort,
+	}
+	if err := c.cs.Create(&collection); err != nil {
+		vd.SetAlert(err)
+		c.renderNew(w, r, vd, user.ID, form.Parent)
+		return
+	}
+	c.redirectToEdit(w, r, &collection, "Collection created!")
+}
+
+func (c *Collections) renderNew(w http.ResponseWriter, r *http.Request, vd views.Data, userID, parent uint) {
+	data := NewCollectionData{Parent: parent}
+	if tree, err := c.tree(userID); err == nil {
+		data.Options = tree.Options()
+	}
+	vd.Yield = data
+	c.NewView.Render(w, r, vd)
+}
+
+// Show displays a collection to its owner, or to anyone if it and
+// the collections it is in are public
+// GET /collections/:id
+func (c *Collections) Show(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.collectionByID(w, r)
+	if err != nil {
+		return
+	}
+	data, err := c.data(collection, context.User(r.Context()))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if !data.Visible {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = data
+	c.ShowView.Render(w, r, vd)
+}
+
+// Edit displays the collection edit page
+// GET /collections/:id/edit
+func (c *Collections) Edit(w http.ResponseWriter, r *http.Request) {
+	collection, ok := c.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+	c.renderEdit(w, r, views.Data{}, collection)
+}
+
+// Update changes the settings of a collection, including the
+// collection it is in
+// POST /collections/:id/update
+func (c *Collections) Update(w http.ResponseWriter, r *http.Request) {
+	collection, ok := c.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	var form CollectionForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		c.renderEdit(w, r, vd, collection)
+		return
+	}
+	galleries, err := c.gs.ByCollectionID(collection.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		c.renderEdit(w, r, vd, collection)
+		return
+	}
+	collection.Galleries = galleries
+	collection.Title = form.Title
+	collection.ParentID = form.Parent
+	collection.Visibility = form.Visibility
+	collection.Sort = form.Sort
+	collection.CoverGalleryID = form.Cover
+	if err := c.cs.Update(collection); err != nil {
+		vd.SetAlert(err)
+		c.renderEdit(w, r, vd, collection)
+		return
+	}
+	c.redirectToEdit(w, r, collection, "Collection successfully updated!")
+}
+
+// Delete deletes a collection, moving its galleries and the
+// collections in it up to where it was
+// POST /collections/:id/delete
+func (c *Collections) Delete(w http.ResponseWriter, r *http.Request) {
+	collection, ok := c.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	if err := c.empty(collection); err != nil {
+		vd.SetAlert(err)
+		c.renderEdit(w, r, vd, collection)
+		return
+	}
+	if err := c.cs.Delete(collection.ID); err != nil {
+		vd.SetAlert(err)
+		c.renderEdit(w, r, vd, collection)
+		return
+	}
+	next := "/galleries"
+	if collection.ParentID != 0 {
+		next = fmt.Sprintf("/collections/%d/edit", collection.ParentID)
+	}
+	views.RedirectAlert(w, r, next, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Deleted %s, anything in it was moved up a level", collection.Title),
+	})
+}
+
+// empty moves everything in collection to its parent
+func (c *Collections) empty(collection *models.Collection) error {
+	galleries, err := c.gs.ByCollectionID(collection.ID)
+	if err != nil {
+		return err
+	}
+	for i := range galleries {
+		galleries[i].CollectionID = collection.ParentID
+		if err := c.gs.Update(&galleries[i]); err != nil {
+			return err
+		}
+	}
+	tree, err := c.tree(collection.UserID)
+	if err != nil {
+		return err
+	}
+	for _, child := range tree.Children(collection.ID) {
+		child := child
+		child.ParentID = collection.ParentID
+		if err := c.cs.Update(&child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Order sets the order of the collections in a collection, or at
+// the top level when the parent is 0
+// POST /collections/order
+func (c *Collections) Order(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var form OrderCollectionsForm
+	if err := parseForm(r, &form); err != nil {
+		http.Error(w, "Invalid order", http.StatusBadRequest)
+		return
+	}
+	next := "/galleries"
+	if form.Parent != 0 {
+		parent, err := c.cs.ByID(form.Parent)
+		if err != nil || parent.UserID != user.ID {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		next = fmt.Sprintf("/collections/%d/edit", parent.ID)
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Collections reordered!",
+	}
+	if err := c.cs.Reorder(user.ID, form.Parent, form.IDs); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		alert = *vd.Alert
+	}
+	views.RedirectAlert(w, r, next, http.StatusFound, alert)
+}
+
+// MoveGallery moves a gallery into one of the user's collections,
+// or out of any. The gallery and the collection both have to
+// belong to the user.
+// POST /galleries/:id/collection
+func (c *Collections) MoveGallery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return
+	}
+	gallery, err := c.gs.ByID(uint(id))
+	user := context.User(r.Context())
+	if err != nil || gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var form MoveGalleryForm
+	if err := parseForm(r, &form); err != nil {
+		http.Error(w, "Invalid collection", http.StatusBadRequest)
+		return
+	}
+	msg := fmt.Sprintf("Moved %s out of its collection", gallery.Title)
+	if form.Collection != 0 {
+		collection, err := c.cs.ByID(form.Collection)
+		if err != nil || collection.UserID != user.ID {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		msg = fmt.Sprintf("Moved %s to %s", gallery.Title, collection.Title)
+	}
+	alert := views.Alert{Level: views.AlertLvlSuccess, Message: msg}
+	gallery.CollectionID = form.Collection
+	if err := c.gs.Update(gallery); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		alert = *vd.Alert
+	}
+	views.RedirectAlert(w, r, localPath(form.Next, "/galleries"), http.StatusFound, alert)
+}
+
+func (c *Collections) renderEdit(w http.ResponseWriter, r *http.Request, vd views.Data, collection *models.Collection) {
+	data, err := c.data(collection, context.User(r.Context()))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = data
+	c.EditView.Render(w, r, vd)
+}
+
+// data loads what the collection pages show. Visitors only get the
+// public collections in it, and nothing at all if they can't see
+// the collection.
+func (c *Collections) data(collection *models.Collection, user *models.User) (*CollectionData, error) {
+	tree, err := c.tree(collection.UserID)
+	if err != nil {
+		return nil, err
+	}
+	data := &CollectionData{
+		Collection: collection,
+		Owner:      user != nil && user.ID == collection.UserID,
+	}
+	data.Visible = data.Owner || tree.Visible(collection.ID)
+	if !data.Visible {
+		return data, nil
+	}
+	path := tree.Path(collection.ID)
+	data.Breadcrumbs = path[:len(path)-1]
+	for _, child := range tree.Children(collection.ID) {
+		if data.Owner || child.Public() {
+			data.Children = append(data.Children, child)
+		}
+	}
+	if err := loadCollectionCovers(c.gs, c.is, data.Children, !data.Owner); err != nil {
+		return nil, err
+	}
+	galleries, err := c.gs.ByCollectionID(collection.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !data.Owner {
+		galleries = publicGalleries(galleries)
+	}
+	collection.SortGalleries(galleries)
+	for i := range galleries {
+		galleries[i].Images, _ = c.is.ByGalleryID(galleries[i].ID)
+	}
+	collection.Galleries = galleries
+	if data.Owner {
+		data.Options = tree.Options()
+		for _, opt := range data.Options {
+			if !tree.Contains(collection.ID, opt.ID) {
+				data.Parents = append(data.Parents, opt)
+			}
+		}
+	}
+	return data, nil
+}
+
+// loadCollectionCovers loads the galleries in each collection, and
+// the images of the one that is its cover, for showing them as
+// cards. Private galleries are left out when publicOnly is set.
+func loadCollectionCovers(gs models.GalleryService, is models.ImageService, collections []models.Collection, publicOnly bool) error {
+	for i := range collections {
+		galleries, err := gs.ByCollectionID(collections[i].ID)
+		if err != nil {
+			return err
+		}
+		if publicOnly {
+			galleries = publicGalleries(galleries)
+		}
+		collections[i].SortGalleries(galleries)
+		collections[i].Galleries = galleries
+		if cover := collections[i].Cover(); cover != nil {
+			cover.Images, _ = is.ByGalleryID(cover.ID)
+		}
+	}
+	return nil
+}
+
+// publicGalleries returns the galleries that are public
+func publicGalleries(galleries []models.Gallery) []models.Gallery {
+	var ret []models.Gallery
+	for _, gallery := range galleries {
+		if gallery.Public() {
+			ret = append(ret, gallery)
+		}
+	}
+	return ret
+}
+
+func (c *Collections) tree(userID uint) (*models.CollectionTree, error) {
+	collections, err := c.cs.ByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return models.NewCollectionTree(collections), nil
+}
+
+func (c *Collections) redirectToEdit(w http.ResponseWriter, r *http.Request, collection *models.Collection, msg string) {
+	url, err := c.r.Get(EditCollection).URL("id", fmt.Sprintf("%v", collection.ID))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: msg,
+	})
+}
+
+// ownedCollection looks up the collection in the URL, writing a 404
+// unless it belongs to the current user, the same as we do for
+// galleries
+func (c *Collections) ownedCollection(w http.ResponseWriter, r *http.Request) (*models.Collection, bool) {
+	collection, err := c.collectionByID(w, r)
+	if err != nil {
+		return nil, false
+	}
+	user := context.User(r.Context())
+	if collection.UserID != user.ID {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return nil, false
+	}
+	return collection, true
+}
+
+func (c *Collections) collectionByID(w http.ResponseWriter, r *http.Request) (*models.Collection, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid collection ID", http.StatusNotFound)
+		return nil, err
+	}
+	collection, err := c.cs.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Collection not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return collection, nil
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sajicode/go-photo/audit"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/views"
+)
+
+// NewExports is used to create the controller users download their
+// data with
+func NewExports(es models.ExportService, al *audit.Log) *Exports {
+	return &Exports{
+		IndexView: views.NewView("bootstrap", "users/export"),
+		es:        es,
+		al:        al,
+	}
+}
+
+// Exports lets users ask for an archive of their data and download
+// it once the jobs.Exports job has built it
+type Exports struct {
+	IndexView *views.View
+	es        models.ExportService
+	al        *audit.Log
+}
+
+// ExportDownloadForm holds the token from the link we emailed
+type ExportDownloadForm struct {
+	Token string `schema:"token"`
+}
+
+// Index lists the user's exports
+// GET /settings/export
+func (e *Exports) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	e.render(w, r, vd)
+}
+
+// Create asks for a new export to be built
+// POST /settings/export
+func (e *Exports) Create(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	export, err := e.es.Request(user.ID)
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		e.render(w, r, vd)
+		return
+	}
+	e.al.Record(r, models.AuditEvent{
+		Action:     models.AuditExportRequested,
+		TargetType: "export",
+		TargetID:   strconv.Itoa(int(export.ID)),
+	}, nil)
+	views.RedirectAlert(w, r, "/settings/export", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "We're preparing your export, you will get an email with a download link when it is ready.",
+	})
+}
+
+// Download sends the archive the emailed link is for, as long as
+// it belongs to the logged in user and hasn't expired. Users who
+// aren't logged in come back here once they are.
+// GET /settings/export/download
+func (e *Exports) Download(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if user == nil {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+	var form ExportDownloadForm
+	parseURLParams(r, &form)
+	export, err := e.es.ByToken(form.Token)
+	if err == nil && export.UserID != user.ID {
+		err = models.ErrNotFound
+	}
+	switch err {
+	case nil:
+	case models.ErrNotFound:
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	default:
+		var vd views.Data
+		vd.SetAlert(err)
+		e.render(w, r, vd)
+		return
+	}
+	if !export.Downloadable(time.Now()) {
+		views.RedirectAlert(w, r, "/settings/export", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlWarning,
+			Message: "That download link has expired, ask for a new export below.",
+		})
+		return
+	}
+	f, err := os.Open(export.Path)
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		e.render(w, r, vd)
+		return
+	}
+	defer f.Close()
+	e.al.Record(r, models.AuditEvent{
+		Action:     models.AuditExportDownloaded,
+		TargetType: "export",
+		TargetID:   strconv.Itoa(int(export.ID)),
+	}, nil)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="shutters-export-`+export.CreatedAt.Format("2006-01-02")+`.zip"`)
+	http.ServeContent(w, r, "", export.UpdatedAt, f)
+}
+
+func (e *Exports) render(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	user := context.User(r.Context())
+	exports, err := e.es.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = exports
+	e.IndexView.Render(w, r, vd)
+}
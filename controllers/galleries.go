@@ -1,14 +1,27 @@
 package controllers
 
 import (
+	"archive/zip"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/audit"
 	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/imaging"
 	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/unpack"
 	"github.com/sajicode/go-photo/views"
 )
 
@@ -21,61 +34,321 @@ const (
 	maxMultipartMem = 1 << 20 // 1 megabyte
 )
 
-// NewGalleries contains all the requirements for a new gallery
-func NewGalleries(gs models.GalleryService, is models.ImageService, r *mux.Router) *Galleries {
+// NewGalleries contains all the requirements for a new gallery.
+// Deleted galleries stay in the trash for retention.
+func NewGalleries(gs models.GalleryService, cs models.CollectionService, is models.ImageService, retention time.Duration, al *audit.Log, r *mux.Router) *Galleries {
 	return &Galleries{
-		New:       views.NewView("bootstrap", "galleries/new"),
-		ShowView:  views.NewView("bootstrap", "galleries/show"),
-		EditView:  views.NewView("bootstrap", "galleries/edit"),
-		IndexView: views.NewView("bootstrap", "galleries/index"),
-		gs:        gs,
-		is:        is,
-		r:         r,
+		New:        views.NewView("bootstrap", "galleries/new"),
+		ShowView:   views.NewView("bootstrap", "galleries/show"),
+		EditView:   views.NewView("bootstrap", "galleries/edit"),
+		IndexView:  views.NewView("bootstrap", "galleries/index", "collections/partials"),
+		ImportView: views.NewView("bootstrap", "galleries/import"),
+		DeleteView: views.NewView("bootstrap", "galleries/delete"),
+		gs:         gs,
+		cs:         cs,
+		is:         is,
+		retention:  retention,
+		al:         al,
+		r:          r,
 	}
 }
 
 // Galleries struct
 type Galleries struct {
-	New       *views.View
-	ShowView  *views.View
-	EditView  *views.View
-	IndexView *views.View
-	gs        models.GalleryService
-	is        models.ImageService
-	r         *mux.Router
+	New        *views.View
+	ShowView   *views.View
+	EditView   *views.View
+	IndexView  *views.View
+	ImportView *views.View
+	DeleteView *views.View
+	gs         models.GalleryService
+	cs         models.CollectionService
+	is         models.ImageService
+	retention  time.Duration
+	al         *audit.Log
+	r          *mux.Router
 }
 
+// eventDateFormat is how event dates are entered and sent, which
+// is what date inputs use
+const eventDateFormat = "2006-01-02"
+
 // GalleryForm input form
 type GalleryForm struct {
-	Title string `schema:"title"`
+	Title       string `schema:"title"`
+	Description string `schema:"description"`
+	EventDate   string `schema:"event_date"`
+	Location    string `schema:"location"`
+	Cover       string `schema:"cover"`
+	Tags        string `schema:"tags"`
+}
+
+// apply copies the form onto gallery
+func (form *GalleryForm) apply(gallery *models.Gallery) error {
+	eventDate, err := parseEventDate(form.EventDate)
+	if err != nil {
+		return err
+	}
+	gallery.Title = form.Title
+	gallery.Description = form.Description
+	gallery.EventDate = eventDate
+	gallery.Location = form.Location
+	gallery.CoverFilename = form.Cover
+	gallery.Tags = form.Tags
+	return nil
 }
 
-// Index displays all galleries created by a user
+// parseEventDate parses an event date, which is optional
+func parseEventDate(s string) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(eventDateFormat, s)
+	if err != nil {
+		return nil, models.ErrEventDateInvalid
+	}
+	return &t, nil
+}
+
+// ImageForm holds the details of an image
+type ImageForm struct {
+	Caption string `schema:"caption"`
+	AltText string `schema:"alt"`
+	Tags    string `schema:"tags"`
+}
+
+// ImageOrderForm lists every image of a gallery in its new order
+type ImageOrderForm struct {
+	Filenames []string `schema:"filenames"`
+}
+
+// The actions that can be taken on the images picked on the edit
+// gallery page
+const (
+	imageActionDelete = "delete"
+	imageActionMove   = "move"
+	imageActionCopy   = "copy"
+	imageActionCover  = "cover"
+)
+
+// ImageSelectionForm is what to do with the images picked on the
+// edit gallery page
+type ImageSelectionForm struct {
+	Filenames []string `schema:"filenames"`
+	Action    string   `schema:"action"`
+	// GalleryID is where images are moved or copied to
+	GalleryID uint `schema:"gallery"`
+}
+
+// EditData is what the edit gallery page shows: the gallery and
+// the user's other galleries, which images can be moved or copied
+// to
+type EditData struct {
+	*models.Gallery
+	Targets []models.Gallery
+}
+
+// GalleryListParams are the query params used to sort, filter and
+// page through galleries, on the galleries page and in the API
+type GalleryListParams struct {
+	Title   string `schema:"title"`
+	Sort    string `schema:"sort"`
+	Order   string `schema:"order"`
+	Cursor  string `schema:"cursor"`
+	PerPage int    `schema:"per_page"`
+}
+
+// query turns the params into a GalleryQuery for user
+func (p *GalleryListParams) query(user *models.User) *models.GalleryQuery {
+	return &models.GalleryQuery{
+		UserID: user.ID,
+		Title:  p.Title,
+		Sort:   p.Sort,
+		Order:  p.Order,
+		Cursor: p.Cursor,
+		Limit:  p.PerPage,
+	}
+}
+
+// values returns the params as URL parameters, without the cursor
+func (p *GalleryListParams) values() url.Values {
+	v := url.Values{}
+	for key, value := range map[string]string{
+		"title": p.Title,
+		"sort":  p.Sort,
+		"order": p.Order,
+	} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	if p.PerPage > 0 {
+		v.Set("per_page", strconv.Itoa(p.PerPage))
+	}
+	return v
+}
+
+// IndexData is what the galleries page shows: the top level
+// collections and the galleries that aren't in any. When filtering
+// by title it shows the matching galleries wherever they are
+// instead.
+type IndexData struct {
+	Params      GalleryListParams
+	Collections []models.Collection
+	Galleries   []models.Gallery
+	// Total is how many galleries there are on every page
+	Total int
+	// Next is the cursor of the next page, empty on the last one
+	Next string
+	// Options are every collection galleries can be moved into
+	Options []models.CollectionOption
+}
+
+// FirstURL links to the first page of the galleries
+func (d *IndexData) FirstURL() string {
+	v := d.Params.values()
+	if len(v) == 0 {
+		return "/galleries"
+	}
+	return "/galleries?" + v.Encode()
+}
+
+// NextURL links to the next page of the galleries
+func (d *IndexData) NextURL() string {
+	v := d.Params.values()
+	v.Set("cursor", d.Next)
+	return "/galleries?" + v.Encode()
+}
+
+// MoveForm returns what the form moving gallery into a collection
+// needs
+func (d *IndexData) MoveForm(gallery models.Gallery) MoveGalleryData {
+	return MoveGalleryData{
+		Gallery: gallery,
+		Options: d.Options,
+		Next:    "/galleries",
+	}
+}
+
+// Index displays the top level collections and a page of the
+// galleries that aren't in a collection, or a page of the
+// galleries matching a title
 // GET /galleries
 func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 	user := context.User(r.Context())
-	galleries, err := g.gs.ByUserID(user.ID)
+	var vd views.Data
+	var data IndexData
+	vd.Yield = &data
+	if err := parseURLParams(r, &data.Params); err != nil {
+		vd.SetAlert(err)
+		g.IndexView.Render(w, r, vd)
+		return
+	}
+	collections, err := g.cs.ByUserID(user.ID)
 	if err != nil {
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
-	var vd views.Data
-	vd.Yield = galleries
+	tree := models.NewCollectionTree(collections)
+	data.Options = tree.Options()
+	q := data.Params.query(user)
+	q.Ungrouped = q.Title == ""
+	page, err := g.gs.Page(q)
+	if err != nil {
+		vd.SetAlert(err)
+		g.IndexView.Render(w, r, vd)
+		return
+	}
+	// The collections are only shown above the first page
+	if q.Title == "" && q.Cursor == "" {
+		data.Collections = tree.Children(0)
+		if err := loadCollectionCovers(g.gs, g.is, data.Collections, false); err != nil {
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, gallery := range page.Galleries {
+		// The cards show each gallery's cover and image count
+		gallery.Images, _ = g.is.ByGalleryID(gallery.ID)
+		data.Galleries = append(data.Galleries, gallery)
+	}
+	data.Total = page.Total
+	data.Next = page.Next
 	g.IndexView.Render(w, r, vd)
 }
 
-// Show displays a gallery
+// ShowData is what the gallery page shows
+type ShowData struct {
+	*models.Gallery
+	// Breadcrumbs lead to the collection the gallery is in, if the
+	// visitor can see it
+	Breadcrumbs []models.Collection
+	// CanDownload is whether the visitor can download the gallery
+	CanDownload bool
+	// Share is the share token the visitor came with, kept on the
+	// download link and the image URLs
+	Share string
+}
+
+// Show displays a gallery to its owner, to anyone if it is visible
+// and to those with its share link
 // GET /galleries/:id
 func (g *Galleries) Show(w http.ResponseWriter, r *http.Request) {
 	gallery, err := g.galleryByID(w, r)
 	if err != nil {
 		return
 	}
+	tree := g.tree(gallery)
+	view, download := g.access(r, gallery, tree)
+	if !view {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	data := ShowData{Gallery: gallery, CanDownload: download}
+	if share := r.URL.Query().Get("share"); gallery.SharedWith(share) {
+		data.Share = share
+	}
+	if gallery.CollectionID != 0 {
+		user := context.User(r.Context())
+		if (user != nil && user.ID == gallery.UserID) || tree.Visible(gallery.CollectionID) {
+			data.Breadcrumbs = tree.Path(gallery.CollectionID)
+		}
+	}
 	var vd views.Data
-	vd.Yield = gallery
+	vd.Yield = data
 	g.ShowView.Render(w, r, vd)
 }
 
+// tree gets the collections of the gallery's owner
+func (g *Galleries) tree(gallery *models.Gallery) *models.CollectionTree {
+	var collections []models.Collection
+	if gallery.CollectionID != 0 {
+		var err error
+		if collections, err = g.cs.ByUserID(gallery.UserID); err != nil {
+			log.Println(err)
+		}
+	}
+	return models.NewCollectionTree(collections)
+}
+
+// access works out whether the visitor can see a gallery and
+// download it. Owners can do both, as can anyone if the gallery is
+// visible. The share link lets others see it, and download it if
+// the owner allows.
+func (g *Galleries) access(r *http.Request, gallery *models.Gallery, tree *models.CollectionTree) (view, download bool) {
+	if user := context.User(r.Context()); user != nil && user.ID == gallery.UserID {
+		return true, true
+	}
+	if gallery.Visible(tree) {
+		return true, true
+	}
+	if gallery.SharedWith(r.URL.Query().Get("share")) {
+		return true, gallery.ShareDownload
+	}
+	return false, false
+}
+
 // Edit displays the gallery edit page with existing data
 // GET /galleries/:id/edit
 func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
@@ -89,7 +362,7 @@ func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var vd views.Data
-	vd.Yield = gallery
+	vd.Yield = g.editData(gallery)
 	g.EditView.Render(w, r, vd)
 }
 
@@ -106,14 +379,18 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var vd views.Data
-	vd.Yield = gallery
+	vd.Yield = g.editData(gallery)
 	var form GalleryForm
 	if err := parseForm(r, &form); err != nil {
 		vd.SetAlert(err)
 		g.EditView.Render(w, r, vd)
 		return
 	}
-	gallery.Title = form.Title
+	if err := form.apply(gallery); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
 	err = g.gs.Update(gallery)
 	if err != nil {
 		vd.SetAlert(err)
@@ -127,6 +404,56 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 	g.EditView.Render(w, r, vd)
 }
 
+// GallerySharingForm sets who can see a gallery
+type GallerySharingForm struct {
+	Visibility string `schema:"visibility"`
+	// Shared turns the share link on or off
+	Shared bool `schema:"shared"`
+	// NewLink replaces the share link, so the old one stops working
+	NewLink  bool `schema:"new_link"`
+	Download bool `schema:"download"`
+}
+
+// Sharing changes the visibility of a gallery and its share link
+// POST /galleries/:id/sharing
+func (g *Galleries) Sharing(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = g.editData(gallery)
+	var form GallerySharingForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	gallery.Visibility = form.Visibility
+	gallery.ShareDownload = form.Download
+	switch {
+	case !form.Shared:
+		gallery.ShareToken = ""
+	case form.NewLink || gallery.ShareToken == "":
+		if err := gallery.Share(); err != nil {
+			vd.SetAlert(err)
+			g.EditView.Render(w, r, vd)
+			return
+		}
+	}
+	if err := g.gs.Update(gallery); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery, "Sharing updated")
+}
+
 // Create intiates gallery creation
 // POST /galleries
 func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
@@ -168,8 +495,9 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Gallery not found", http.StatusNotFound)
 		return
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, unpack.DefaultLimits.MaxTotalSize)
 	var vd views.Data
-	vd.Yield = gallery
+	vd.Yield = g.editData(gallery)
 	err = r.ParseMultipartForm(maxMultipartMem)
 	if err != nil {
 		vd.SetAlert(err)
@@ -203,6 +531,259 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, url.Path, http.StatusFound)
 }
 
+// ImportData is shown after importing an archive
+type ImportData struct {
+	Gallery *models.Gallery
+	*ImportSummary
+}
+
+// ImportArchive adds the images in an uploaded zip or tar archive
+// to a gallery and shows what happened to each file
+// POST /galleries/:id/archive
+func (g *Galleries) ImportArchive(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, unpack.DefaultLimits.MaxTotalSize)
+	var vd views.Data
+	vd.Yield = g.editData(gallery)
+	err = r.ParseMultipartForm(maxMultipartMem)
+	if err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	files := r.MultipartForm.File["archive"]
+	if len(files) != 1 {
+		vd.AlertError("Please choose one archive to import")
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	file, err := files[0].Open()
+	if err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	defer file.Close()
+	summary, err := importArchive(g.is, gallery.ID, file, files[0].Size)
+	if summary == nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	if err != nil {
+		vd.AlertError(importReason(err))
+	} else {
+		vd.Alert = &views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: fmt.Sprintf("Imported %d of %d files", summary.Imported, len(summary.Results)),
+		}
+	}
+	vd.Yield = ImportData{
+		Gallery:       gallery,
+		ImportSummary: summary,
+	}
+	g.ImportView.Render(w, r, vd)
+}
+
+// ImageUpdate saves the caption, alt text and tags of an image
+// POST /galleries/:id/images/:filename/update
+func (g *Galleries) ImageUpdate(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = g.editData(gallery)
+	var form ImageForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	i := models.Image{
+		GalleryID: gallery.ID,
+		Filename:  mux.Vars(r)["filename"],
+		Caption:   form.Caption,
+		AltText:   form.AltText,
+		Tags:      form.Tags,
+	}
+	if err := g.is.Update(&i); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery, "Image details saved")
+}
+
+// ImageReorder saves the order images were dragged into
+// POST /galleries/:id/images/order
+func (g *Galleries) ImageReorder(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = g.editData(gallery)
+	var form ImageOrderForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	if err := g.is.Reorder(gallery.ID, form.Filenames); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery, "Image order saved")
+}
+
+// ImageSelection deletes the picked images, moves or copies them to
+// another of the user's galleries, or makes the picked image the
+// cover
+// POST /galleries/:id/images/selection
+func (g *Galleries) ImageSelection(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = g.editData(gallery)
+	var form ImageSelectionForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	var msg string
+	switch form.Action {
+	case imageActionDelete:
+		err = g.is.DeleteMany(gallery.ID, form.Filenames)
+		if err == nil {
+			for _, filename := range form.Filenames {
+				g.al.ImageDeleted(r, gallery, filename)
+			}
+			g.dropCover(gallery, form.Filenames)
+			msg = fmt.Sprintf("Deleted %s", imageCount(len(form.Filenames)))
+		}
+	case imageActionMove, imageActionCopy:
+		var target *models.Gallery
+		target, err = g.gs.ByID(form.GalleryID)
+		if err == nil && target.UserID != user.ID {
+			err = models.ErrNotFound
+		}
+		if err != nil {
+			break
+		}
+		if form.Action == imageActionMove {
+			err = g.is.Move(gallery.ID, form.Filenames, target.ID)
+			if err == nil {
+				g.dropCover(gallery, form.Filenames)
+			}
+			msg = fmt.Sprintf("Moved %s to %s", imageCount(len(form.Filenames)), target.Title)
+		} else {
+			err = g.is.Copy(gallery.ID, form.Filenames, target.ID)
+			msg = fmt.Sprintf("Copied %s to %s", imageCount(len(form.Filenames)), target.Title)
+		}
+	case imageActionCover:
+		if len(form.Filenames) != 1 {
+			err = models.ErrCoverNeedsOneImage
+			break
+		}
+		gallery.CoverFilename = form.Filenames[0]
+		err = g.gs.Update(gallery)
+		msg = fmt.Sprintf("%s is now the cover", form.Filenames[0])
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// The images are where they were, whatever failed
+		gallery.Images, _ = g.is.ByGalleryID(gallery.ID)
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery, msg)
+}
+
+// dropCover goes back to the first image as the cover when the
+// cover is one of filenames, which are no longer in the gallery
+func (g *Galleries) dropCover(gallery *models.Gallery, filenames []string) {
+	for _, filename := range filenames {
+		if filename != gallery.CoverFilename {
+			continue
+		}
+		gallery.CoverFilename = ""
+		if err := g.gs.Update(gallery); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+}
+
+// imageCount describes n images
+func imageCount(n int) string {
+	if n == 1 {
+		return "1 image"
+	}
+	return fmt.Sprintf("%d images", n)
+}
+
+// editData adds the user's other galleries to gallery for the edit
+// page
+func (g *Galleries) editData(gallery *models.Gallery) *EditData {
+	data := EditData{Gallery: gallery}
+	galleries, err := g.gs.ByUserID(gallery.UserID)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, other := range galleries {
+		if other.ID != gallery.ID {
+			data.Targets = append(data.Targets, other)
+		}
+	}
+	return &data
+}
+
+// redirectToEdit sends the user back to the edit page with a
+// success message
+func (g *Galleries) redirectToEdit(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, msg string) {
+	url, err := g.r.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: msg,
+	})
+}
+
 // ImageDelete deletes an image from a gallery
 // POST /galleries/:id/images/:filename/delete
 func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
@@ -223,11 +804,12 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 	err = g.is.Delete(&i)
 	if err != nil {
 		var vd views.Data
-		vd.Yield = gallery
+		vd.Yield = g.editData(gallery)
 		vd.SetAlert(err)
 		g.EditView.Render(w, r, vd)
 		return
 	}
+	g.al.ImageDeleted(r, gallery, filename)
 	url, err := g.r.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
 	if err != nil {
 		http.Redirect(w, r, "/galleries", http.StatusFound)
@@ -236,7 +818,45 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, url.Path, http.StatusFound)
 }
 
-// Delete a gallery
+// DeleteGalleryForm confirms a gallery is to be deleted
+type DeleteGalleryForm struct {
+	Confirm bool `schema:"confirm"`
+}
+
+// DeleteGalleryData is what the page confirming a gallery is to be
+// deleted shows
+type DeleteGalleryData struct {
+	*models.Gallery
+	// RetentionDays is how long the gallery stays in the trash
+	RetentionDays int
+}
+
+// ConfirmDelete asks the user to confirm deleting a gallery
+// GET /galleries/:id/delete
+func (g *Galleries) ConfirmDelete(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	g.renderConfirmDelete(w, r, vd, gallery)
+}
+
+func (g *Galleries) renderConfirmDelete(w http.ResponseWriter, r *http.Request, vd views.Data, gallery *models.Gallery) {
+	vd.Yield = &DeleteGalleryData{
+		Gallery:       gallery,
+		RetentionDays: int(g.retention.Hours() / 24),
+	}
+	g.DeleteView.Render(w, r, vd)
+}
+
+// Delete moves a gallery to the trash once the user has confirmed
+// it, asking them to if they haven't
 // POST /galleries/:id/delete
 func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 	gallery, err := g.galleryByID(w, r)
@@ -249,14 +869,260 @@ func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var vd views.Data
+	var form DeleteGalleryForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.renderConfirmDelete(w, r, vd, gallery)
+		return
+	}
+	if !form.Confirm {
+		g.renderConfirmDelete(w, r, vd, gallery)
+		return
+	}
 	err = g.gs.Delete(gallery.ID)
 	if err != nil {
 		vd.SetAlert(err)
-		vd.Yield = gallery
+		vd.Yield = g.editData(gallery)
 		g.EditView.Render(w, r, vd)
 		return
 	}
-	http.Redirect(w, r, "/galleries", http.StatusFound)
+	g.al.GalleryDeleted(r, gallery)
+	views.RedirectAlert(w, r, "/galleries", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Moved %s to the trash", gallery.Title),
+	})
+}
+
+// ImageFile serves an image of a gallery to those who can see the
+// gallery. Galleries in the trash keep their images where they are
+// until they are purged, so their images are refused here rather
+// than served to everyone.
+// GET /images/galleries/:id/:filename
+func (g *Galleries) ImageFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil || models.ValidateImageFilename(vars["filename"]) != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	gallery, err := g.gs.ByID(uint(id))
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	// moderators see the images of every gallery, to review them
+	user := context.User(r.Context())
+	if user == nil || !user.HasRole(models.RoleModerator) {
+		if view, _ := g.access(r, gallery, g.tree(gallery)); !view {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+	}
+	f, err := g.is.Open(&models.Image{GalleryID: uint(id), Filename: vars["filename"]})
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	// files on disk can be served with ranges and caching like
+	// any other, anything else is just copied
+	if rs, ok := f.(io.ReadSeeker); ok {
+		var modtime time.Time
+		if st, ok := f.(interface{ Stat() (os.FileInfo, error) }); ok {
+			if info, err := st.Stat(); err == nil {
+				modtime = info.ModTime()
+			}
+		}
+		http.ServeContent(w, r, vars["filename"], modtime, rs)
+		return
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(vars["filename"])); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		log.Println(err)
+	}
+}
+
+// Renditions of images a gallery can be downloaded in
+const (
+	RenditionOriginal = "original"
+	RenditionWeb      = "web"
+)
+
+// DownloadForm picks what to download from a gallery. Leaving
+// Files empty downloads every image.
+type DownloadForm struct {
+	Files     []string `schema:"files"`
+	Rendition string   `schema:"size"`
+}
+
+// Download streams a ZIP of the gallery's images as it is built,
+// without buffering it anywhere. It is open to whoever can see the
+// gallery, except those with a share link that doesn't allow
+// downloads.
+// GET /galleries/:id/download
+func (g *Galleries) Download(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	view, download := g.access(r, gallery, g.tree(gallery))
+	if !view {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if !download {
+		http.Error(w, "This link doesn't allow downloads", http.StatusForbidden)
+		return
+	}
+	var form DownloadForm
+	if err := parseURLParams(r, &form); err != nil {
+		http.Error(w, "Invalid download", http.StatusBadRequest)
+		return
+	}
+	switch form.Rendition {
+	case "":
+		form.Rendition = RenditionOriginal
+	case RenditionOriginal, RenditionWeb:
+	default:
+		http.Error(w, "Unknown image size", http.StatusBadRequest)
+		return
+	}
+	images := gallery.Images
+	if len(form.Files) > 0 {
+		var ok bool
+		if images, ok = selectImages(images, form.Files); !ok {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+zipName(gallery.Title)+`"`)
+	zw := zip.NewWriter(newProgressWriter(w))
+	names := make(map[string]bool, len(images))
+	for i := range images {
+		if err := g.zipImage(zw, &images[i], uniqueName(names, images[i].Filename), form.Rendition); err != nil {
+			// the response has started, all we can do is cut it
+			// short so the archive is clearly broken
+			log.Printf("downloading gallery %d: %v", gallery.ID, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("downloading gallery %d: %v", gallery.ID, err)
+	}
+}
+
+// downloadStallTimeout is how long a download can go without
+// writing anything before it is cut off
+const downloadStallTimeout = time.Minute
+
+// progressWriter pushes the response's write deadline back as it
+// is written to, so a large download is only cut off when it
+// stalls, rather than once the server's WriteTimeout is up
+type progressWriter struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	extended time.Time
+}
+
+func newProgressWriter(w http.ResponseWriter) *progressWriter {
+	pw := &progressWriter{w: w, rc: http.NewResponseController(w)}
+	pw.extend(time.Now())
+	return pw
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	// there is no need to move the deadline on every little write
+	if now := time.Now(); now.Sub(pw.extended) > time.Second {
+		pw.extend(now)
+	}
+	return pw.w.Write(p)
+}
+
+// extend moves the deadline to a stall from now. Writers that
+// don't support deadlines have none to move.
+func (pw *progressWriter) extend(now time.Time) {
+	if err := pw.rc.SetWriteDeadline(now.Add(downloadStallTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Println(err)
+	}
+	pw.extended = now
+}
+
+// zipImage adds image to zw as name. Images are already compressed
+// so they are stored as they are.
+func (g *Galleries) zipImage(zw *zip.Writer, image *models.Image, name, rendition string) error {
+	src, err := g.is.Open(image)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if rendition == RenditionWeb {
+		return imaging.WebSized(dst, src)
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// selectImages returns the images named by filenames, in the
+// gallery's order, and whether every one of them was found
+func selectImages(images []models.Image, filenames []string) ([]models.Image, bool) {
+	wanted := make(map[string]bool, len(filenames))
+	for _, f := range filenames {
+		wanted[f] = true
+	}
+	var ret []models.Image
+	for _, image := range images {
+		if wanted[image.Filename] {
+			ret = append(ret, image)
+			delete(wanted, image.Filename)
+		}
+	}
+	return ret, len(wanted) == 0
+}
+
+// uniqueName returns name, or name with a number added before its
+// extension if it was already used. Names differing only by case
+// count as the same, since they would overwrite each other when
+// unzipped on most desktops.
+func uniqueName(used map[string]bool, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// zipName turns a gallery title into a safe name for its archive
+func zipName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '"' || r == '\\' || r == '/' || r < ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" {
+		name = "gallery"
+	}
+	return name + ".zip"
 }
 
 func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
@@ -3,8 +3,12 @@ package controllers
 import (
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/gorilla/schema"
+	"github.com/sajicode/go-photo/audit"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
 )
 
 func parseForm(r *http.Request, dst interface{}) error {
@@ -29,3 +33,26 @@ func parseValues(values url.Values, dst interface{}) error {
 	}
 	return nil
 }
+
+// localPath returns next if it is a path on our own site and
+// fallback otherwise, so that redirecting to a user provided path
+// can't send anyone to another site
+func localPath(next, fallback string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+	return next
+}
+
+// impersonating reports whether an admin is acting as the current
+// user, recording that they tried to do what if so. Admins can't
+// hand out access to the account, like API tokens, OAuth grants,
+// connected accounts or a password, since it would outlive the
+// impersonation, nor delete it.
+func impersonating(r *http.Request, al *audit.Log, what string) bool {
+	if context.Impersonator(r.Context()) == nil {
+		return false
+	}
+	al.Record(r, models.AuditEvent{Action: models.AuditImpersonationBlocked}, audit.Details{"attempted": what})
+	return true
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"path"
+
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/unpack"
+	"github.com/sajicode/go-photo/views"
+)
+
+// What happened to each file in an uploaded archive
+const (
+	ImportImported = "imported"
+	ImportSkipped  = "skipped"
+	ImportFailed   = "failed"
+)
+
+// importMsgs are the public messages for archive errors
+var importMsgs = map[error]string{
+	unpack.ErrUnknownFormat:  "Only zip, tar and tar.gz archives can be imported",
+	unpack.ErrTooManyEntries: "That archive holds too many files, please split it up",
+	unpack.ErrTooLarge:       "That archive is too large once extracted, please split it up",
+	unpack.ErrUnsafePath:     "Its path points outside the archive",
+	unpack.ErrEntryTooLarge:  "It is too large",
+	unpack.ErrNotRegular:     "It isn't a regular file",
+}
+
+// ImportResult is what happened to one file in an uploaded archive
+type ImportResult struct {
+	// Path is where the file was in the archive
+	Path string `json:"path"`
+	// Filename is the name it was imported as
+	Filename string `json:"filename,omitempty"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ImportSummary is the outcome of importing an archive
+type ImportSummary struct {
+	Results  []ImportResult `json:"results"`
+	Imported int            `json:"imported"`
+	Skipped  int            `json:"skipped"`
+	Failed   int            `json:"failed"`
+}
+
+func (s *ImportSummary) add(res ImportResult) {
+	switch res.Status {
+	case ImportImported:
+		s.Imported++
+	case ImportSkipped:
+		s.Skipped++
+	default:
+		s.Failed++
+	}
+	s.Results = append(s.Results, res)
+}
+
+// importArchive adds every image in the archive to the gallery
+// through is.Create, so they are validated like any other upload.
+// Folders inside the archive are flattened, and clashing names get
+// a number added. Files that aren't images are skipped and files
+// that can't be stored fail, without stopping the import. The
+// error is only set if the archive itself can't be read, in which
+// case the summary holds whatever was imported before then.
+func importArchive(is models.ImageService, galleryID uint, ra io.ReaderAt, size int64) (*ImportSummary, error) {
+	existing, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool, len(existing))
+	for _, image := range existing {
+		uniqueName(used, image.Filename)
+	}
+	var summary ImportSummary
+	err = unpack.Walk(ra, size, unpack.DefaultLimits, func(name string, r io.Reader, err error) error {
+		res := ImportResult{Path: name}
+		if err != nil {
+			res.Status = ImportSkipped
+			res.Reason = importReason(err)
+			summary.add(res)
+			return nil
+		}
+		if err := models.ValidateImageFilename(path.Base(name)); err != nil {
+			res.Status = ImportSkipped
+			res.Reason = importReason(err)
+			summary.add(res)
+			return nil
+		}
+		res.Filename = uniqueName(used, path.Base(name))
+		if err := is.Create(galleryID, r, res.Filename); err != nil {
+			// Don't leave half an image behind if we gave up part
+			// way, but keep any in the trash with the same name
+			is.Discard(&models.Image{GalleryID: galleryID, Filename: res.Filename})
+			res.Status = ImportFailed
+			res.Reason = importReason(err)
+			res.Filename = ""
+			summary.add(res)
+			return nil
+		}
+		res.Status = ImportImported
+		summary.add(res)
+		return nil
+	})
+	return &summary, err
+}
+
+// importReason is the public message for an import error, logging
+// anything we can't show
+func importReason(err error) string {
+	if msg, ok := importMsgs[err]; ok {
+		return msg
+	}
+	if pErr, ok := err.(views.PublicError); ok {
+		return pErr.Public()
+	}
+	log.Println(err)
+	return views.AlertMsgGeneric
+}
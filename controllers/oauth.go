@@ -0,0 +1,421 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/audit"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/views"
+)
+
+// scopeDescriptions are shown on the consent screen so users know
+// what they are granting
+var scopeDescriptions = map[string]string{
+	models.ScopeRead:      "See your galleries and images",
+	models.ScopeReadWrite: "Create, edit and delete your galleries and images",
+	models.ScopeUpload:    "Upload images to your galleries",
+}
+
+// NewOAuth is used to create the OAuth2 authorization server
+// controller, including the pages used to register apps
+func NewOAuth(oas models.OAuthService, al *audit.Log) *OAuth {
+	return &OAuth{
+		ConsentView: views.NewView("bootstrap", "oauth/consent"),
+		AppsView:    views.NewView("bootstrap", "oauth/apps"),
+		oas:         oas,
+		al:          al,
+	}
+}
+
+// OAuth lets third party apps access a user's galleries once the
+// user approved them
+type OAuth struct {
+	ConsentView *views.View
+	AppsView    *views.View
+	oas         models.OAuthService
+	al          *audit.Log
+}
+
+// AuthorizeForm holds the authorization request sent by an app.
+// The consent screen posts it back along with the user's decision.
+type AuthorizeForm struct {
+	ResponseType        string `schema:"response_type"`
+	ClientID            string `schema:"client_id"`
+	RedirectURI         string `schema:"redirect_uri"`
+	Scope               string `schema:"scope"`
+	State               string `schema:"state"`
+	CodeChallenge       string `schema:"code_challenge"`
+	CodeChallengeMethod string `schema:"code_challenge_method"`
+	Decision            string `schema:"decision"`
+}
+
+// ConsentData is what the consent screen renders
+type ConsentData struct {
+	Client *models.OAuthClient
+	Scopes []string
+	Form   AuthorizeForm
+}
+
+// Authorize shows the consent screen, sending users that aren't
+// logged in to the login page first
+// GET /oauth/authorize
+func (o *OAuth) Authorize(w http.ResponseWriter, r *http.Request) {
+	var form AuthorizeForm
+	if err := parseURLParams(r, &form); err != nil {
+		http.Error(w, "Invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	client, _, ok := o.authorizeRequest(w, r, &form)
+	if !ok {
+		return
+	}
+	if context.User(r.Context()) == nil {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+	var scopes []string
+	for _, scope := range strings.Fields(form.Scope) {
+		scopes = append(scopes, scopeDescriptions[scope])
+	}
+	// the consent screen must not be framed, or another site could
+	// trick users into clicking approve
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	o.ConsentView.Render(w, r, ConsentData{
+		Client: client,
+		Scopes: scopes,
+		Form:   form,
+	})
+}
+
+// Approve handles the user's decision on the consent screen,
+// sending them back to the app with either a code or an error
+// POST /oauth/authorize
+func (o *OAuth) Approve(w http.ResponseWriter, r *http.Request) {
+	var form AuthorizeForm
+	if err := parseForm(r, &form); err != nil {
+		http.Error(w, "Invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	client, redirectURI, ok := o.authorizeRequest(w, r, &form)
+	if !ok {
+		return
+	}
+	if form.Decision != "approve" {
+		redirectOAuthError(w, r, redirectURI, form.State, "access_denied", "The user denied access")
+		return
+	}
+	if impersonating(r, o.al, "oauth_grant") {
+		http.Error(w, "Apps can't be authorized while acting as a user", http.StatusForbidden)
+		return
+	}
+	user := context.User(r.Context())
+	code := models.OAuthCode{
+		OAuthClientID:       client.ID,
+		UserID:              user.ID,
+		RedirectURI:         form.RedirectURI,
+		Scope:               form.Scope,
+		CodeChallenge:       form.CodeChallenge,
+		CodeChallengeMethod: form.CodeChallengeMethod,
+	}
+	if err := o.oas.CreateCode(&code); err != nil {
+		if pErr, ok := err.(views.PublicError); ok {
+			redirectOAuthError(w, r, redirectURI, form.State, "invalid_request", pErr.Public())
+			return
+		}
+		log.Println(err)
+		redirectOAuthError(w, r, redirectURI, form.State, "server_error", "")
+		return
+	}
+	redirectOAuth(w, r, redirectURI, url.Values{
+		"code":  {code.Code},
+		"state": {form.State},
+	})
+}
+
+// authorizeRequest checks the app and redirect URI, which can't be
+// reported back to the app if they are wrong, and then the rest of
+// the request, which is. ok is false if a response was written.
+func (o *OAuth) authorizeRequest(w http.ResponseWriter, r *http.Request, form *AuthorizeForm) (*models.OAuthClient, string, bool) {
+	client, err := o.oas.ClientByClientID(form.ClientID)
+	if err != nil {
+		http.Error(w, "Unknown client_id", http.StatusBadRequest)
+		return nil, "", false
+	}
+	redirectURI, ok := client.RedirectURI(form.RedirectURI)
+	if !ok {
+		http.Error(w, "redirect_uri is not registered for this app", http.StatusBadRequest)
+		return nil, "", false
+	}
+	if form.ResponseType != "code" {
+		redirectOAuthError(w, r, redirectURI, form.State, "unsupported_response_type", "Only the code response type is supported")
+		return nil, "", false
+	}
+	scope, err := models.NormalizeScope(form.Scope)
+	if err != nil {
+		redirectOAuthError(w, r, redirectURI, form.State, "invalid_scope", "Supported scopes are read, read-write and upload")
+		return nil, "", false
+	}
+	form.Scope = scope
+	if (client.Public || form.CodeChallengeMethod != "") && (form.CodeChallenge == "" || form.CodeChallengeMethod != models.PKCEMethodS256) {
+		redirectOAuthError(w, r, redirectURI, form.State, "invalid_request", "A S256 code_challenge is required")
+		return nil, "", false
+	}
+	return client, redirectURI, true
+}
+
+// redirectOAuth sends the user back to the app with params added
+// to the redirect URI's query
+func redirectOAuth(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "redirect_uri is not valid", http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			q.Set(key, values[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func redirectOAuthError(w http.ResponseWriter, r *http.Request, redirectURI, state, code, description string) {
+	redirectOAuth(w, r, redirectURI, url.Values{
+		"error":             {code},
+		"error_description": {description},
+		"state":             {state},
+	})
+}
+
+// TokenResponse is returned by the token endpoint
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// TokenError is the error body of the token endpoint, which
+// follows RFC 6749 rather than our API errors
+type TokenError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Token exchanges authorization codes and refresh tokens for
+// access tokens. Apps authenticate with HTTP basic auth or the
+// client_id and client_secret params.
+// POST /oauth/token
+func (o *OAuth) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, TokenError{"invalid_request", "Request body is not valid"})
+		return
+	}
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	client, err := o.oas.AuthenticateClient(clientID, secret)
+	if err != nil {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeJSON(w, http.StatusUnauthorized, TokenError{"invalid_client", "Client authentication failed"})
+		return
+	}
+
+	var grant *models.OAuthGrant
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		grant, err = o.oas.Exchange(client,
+			r.PostForm.Get("code"),
+			r.PostForm.Get("redirect_uri"),
+			r.PostForm.Get("code_verifier"))
+	case "refresh_token":
+		grant, err = o.oas.Refresh(client, r.PostForm.Get("refresh_token"))
+	default:
+		writeJSON(w, http.StatusBadRequest, TokenError{"unsupported_grant_type", "Supported grant types are authorization_code and refresh_token"})
+		return
+	}
+	switch err {
+	case nil:
+	case models.ErrGrantInvalid:
+		writeJSON(w, http.StatusBadRequest, TokenError{"invalid_grant", models.ErrGrantInvalid.Public()})
+		return
+	default:
+		log.Println(err)
+		writeJSON(w, http.StatusInternalServerError, TokenError{Error: "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, TokenResponse{
+		AccessToken:  grant.AccessToken.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(models.OAuthAccessTokenDuration.Seconds()),
+		RefreshToken: grant.RefreshToken.Token,
+		Scope:        grant.AccessToken.Scope,
+	})
+}
+
+// AppForm is used to register an app
+type AppForm struct {
+	Name         string `schema:"name"`
+	RedirectURIs string `schema:"redirect_uris"`
+	Public       bool   `schema:"public"`
+}
+
+// AppsData is what the apps page renders. Created is only set
+// right after registering an app, since that is the only time its
+// secret is available.
+type AppsData struct {
+	// Authorized are the apps the user let into their account
+	Authorized []models.OAuthClient
+	// Apps are the apps the user registered
+	Apps    []models.OAuthClient
+	Created *models.OAuthClient
+	Form    AppForm
+}
+
+// Apps lists the apps the current user authorized and the ones
+// they registered
+// GET /settings/apps
+func (o *OAuth) Apps(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	o.renderApps(w, r, vd, AppsData{})
+}
+
+// CreateApp registers a new app and shows its credentials once
+// POST /settings/apps
+func (o *OAuth) CreateApp(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var data AppsData
+	if err := parseForm(r, &data.Form); err != nil {
+		vd.SetAlert(err)
+		o.renderApps(w, r, vd, data)
+		return
+	}
+	user := context.User(r.Context())
+	client := models.OAuthClient{
+		UserID:       user.ID,
+		Name:         data.Form.Name,
+		RedirectURIs: data.Form.RedirectURIs,
+		Public:       data.Form.Public,
+	}
+	if err := o.oas.CreateClient(&client); err != nil {
+		vd.SetAlert(err)
+		o.renderApps(w, r, vd, data)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your app was registered. Copy its credentials now, you won't be able to see the secret again!",
+	}
+	o.renderApps(w, r, vd, AppsData{Created: &client})
+}
+
+// DeleteApp deletes one of the current user's apps, revoking every
+// token issued to it
+// POST /settings/apps/:id/delete
+func (o *OAuth) DeleteApp(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+	client, err := o.oas.ClientByID(uint(id))
+	user := context.User(r.Context())
+	if err != nil || client.UserID != user.ID {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+	if err := o.oas.DeleteClient(client.ID); err != nil {
+		log.Println(err)
+		var vd views.Data
+		vd.SetAlert(err)
+		o.renderApps(w, r, vd, AppsData{})
+		return
+	}
+	views.RedirectAlert(w, r, "/settings/apps", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "App " + client.Name + " was deleted.",
+	})
+}
+
+// RevokeApp takes back the access the current user gave an app,
+// revoking every token it holds for them
+// POST /settings/apps/authorized/:id/revoke
+func (o *OAuth) RevokeApp(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+	user := context.User(r.Context())
+	authorized, err := o.oas.AuthorizedClients(user.ID)
+	if err != nil {
+		log.Println(err)
+		var vd views.Data
+		vd.SetAlert(err)
+		o.renderApps(w, r, vd, AppsData{})
+		return
+	}
+	var client *models.OAuthClient
+	for i := range authorized {
+		if authorized[i].ID == uint(id) {
+			client = &authorized[i]
+		}
+	}
+	if client == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+	if err := o.oas.RevokeClient(client.ID, user.ID); err != nil {
+		log.Println(err)
+		var vd views.Data
+		vd.SetAlert(err)
+		o.renderApps(w, r, vd, AppsData{})
+		return
+	}
+	o.al.Record(r, models.AuditEvent{
+		Action:     models.AuditAppRevoked,
+		TargetType: "oauth_client",
+		TargetID:   strconv.Itoa(int(client.ID)),
+	}, audit.Details{"name": client.Name})
+	views.RedirectAlert(w, r, "/settings/apps", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: client.Name + " can no longer access your account.",
+	})
+}
+
+// renderApps loads the apps the user authorized and registered
+// into data before rendering
+func (o *OAuth) renderApps(w http.ResponseWriter, r *http.Request, vd views.Data, data AppsData) {
+	user := context.User(r.Context())
+	authorized, err := o.oas.AuthorizedClients(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	data.Authorized = authorized
+	apps, err := o.oas.ClientsByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	data.Apps = apps
+	vd.Yield = data
+	o.AppsView.Render(w, r, vd)
+}
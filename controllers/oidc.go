@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/audit"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/oidc"
+	"github.com/sajicode/go-photo/rand"
+	"github.com/sajicode/go-photo/views"
+)
+
+// oidcStateCookie holds the state, nonce and PKCE verifier of a
+// sign in that is in progress with an external provider
+const oidcStateCookie = "oidc_state"
+
+// oidcStateDuration is how long users have to sign in with the
+// provider before having to start over
+const oidcStateDuration = 10 * time.Minute
+
+// NewOIDC is used to create the controller that signs users in
+// with external OpenID Connect providers
+func NewOIDC(us models.UserService, uis models.UserIdentityService, ads models.AccountDeletionService, providers []*oidc.Provider, al *audit.Log) *OIDC {
+	byName := make(map[string]*oidc.Provider, len(providers))
+	for _, p := range providers {
+		byName[p.Name()] = p
+	}
+	return &OIDC{
+		us:        us,
+		uis:       uis,
+		ads:       ads,
+		providers: byName,
+		al:        al,
+	}
+}
+
+// OIDC signs users in with external providers, or connects them to
+// the account of a user who is already logged in
+type OIDC struct {
+	us        models.UserService
+	uis       models.UserIdentityService
+	ads       models.AccountDeletionService
+	providers map[string]*oidc.Provider
+	al        *audit.Log
+}
+
+// Start sends the user to the provider to sign in
+// GET /auth/:provider
+func (o *OIDC) Start(w http.ResponseWriter, r *http.Request) {
+	p, ok := o.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		http.Error(w, "Sign in provider not found", http.StatusNotFound)
+		return
+	}
+	state, nonce, verifier, err := oidcSecrets()
+	if err != nil {
+		o.fail(w, r, err)
+		return
+	}
+	authURL, err := p.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		o.fail(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    strings.Join([]string{state, nonce, verifier}, "."),
+		Path:     "/auth/" + p.Name(),
+		MaxAge:   int(oidcStateDuration.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback is where the provider sends users back to. Users who
+// are logged in get the provider's account connected to theirs,
+// everyone else is signed in.
+// GET /auth/:provider/callback
+func (o *OIDC) Callback(w http.ResponseWriter, r *http.Request) {
+	p, ok := o.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		http.Error(w, "Sign in provider not found", http.StatusNotFound)
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/auth/" + p.Name(),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	var parts []string
+	if err == nil {
+		parts = strings.Split(cookie.Value, ".")
+	}
+	q := r.URL.Query()
+	if len(parts) != 3 || q.Get("state") != parts[0] {
+		o.failMessage(w, r, "Your sign in with "+p.DisplayName()+" expired, please try again.")
+		return
+	}
+	if q.Get("error") != "" {
+		o.failMessage(w, r, "Signing in with "+p.DisplayName()+" was cancelled.")
+		return
+	}
+	claims, err := p.Exchange(r.Context(), q.Get("code"), parts[2], parts[1])
+	if err != nil {
+		log.Println(err)
+		o.failMessage(w, r, "We couldn't sign you in with "+p.DisplayName()+", please try again.")
+		return
+	}
+	ext := models.ExternalIdentity{
+		Provider:      p.Name(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}
+
+	if user := context.User(r.Context()); user != nil {
+		if impersonating(r, o.al, "identity_link") {
+			views.RedirectAlert(w, r, "/settings/account", http.StatusFound, views.Alert{
+				Level:   views.AlertLvlError,
+				Message: "Accounts can't be connected while acting as a user.",
+			})
+			return
+		}
+		alert := views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: "Your " + p.DisplayName() + " account is now connected.",
+		}
+		if err := o.uis.Link(user.ID, ext); err != nil {
+			alert = errorAlert(err)
+		} else {
+			o.al.Record(r, models.AuditEvent{Action: models.AuditIdentityLinked}, audit.Details{
+				"provider": ext.Provider,
+				"subject":  ext.Subject,
+			})
+		}
+		views.RedirectAlert(w, r, "/settings/account", http.StatusFound, alert)
+		return
+	}
+
+	user, err := o.uis.SignIn(ext)
+	if err != nil {
+		o.al.Record(r, models.AuditEvent{Action: models.AuditLoginFailed}, audit.Details{
+			"method": p.Name(),
+			"email":  ext.Email,
+			"error":  err.Error(),
+		})
+		o.fail(w, r, err)
+		return
+	}
+	if err := signIn(w, o.us, user); err != nil {
+		o.fail(w, r, err)
+		return
+	}
+	o.al.Record(r, models.AuditEvent{Action: models.AuditLogin, ActorID: user.ID}, audit.Details{"method": p.Name()})
+	redirectSignedIn(w, r, o.ads, user, "/galleries")
+}
+
+// fail sends the user back to the login page with err
+func (o *OIDC) fail(w http.ResponseWriter, r *http.Request, err error) {
+	views.RedirectAlert(w, r, "/login", http.StatusFound, errorAlert(err))
+}
+
+func (o *OIDC) failMessage(w http.ResponseWriter, r *http.Request, msg string) {
+	views.RedirectAlert(w, r, "/login", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlError,
+		Message: msg,
+	})
+}
+
+// errorAlert builds the alert SetAlert would show for err, for
+// errors we report after a redirect
+func errorAlert(err error) views.Alert {
+	var vd views.Data
+	vd.SetAlert(err)
+	return *vd.Alert
+}
+
+// oidcSecrets generates the state, nonce and PKCE verifier for a
+// new sign in. They are unpadded so the verifier only uses the
+// characters PKCE allows.
+func oidcSecrets() (state, nonce, verifier string, err error) {
+	secrets := make([]string, 3)
+	for i := range secrets {
+		s, err := rand.String(32)
+		if err != nil {
+			return "", "", "", err
+		}
+		secrets[i] = strings.TrimRight(s, "=")
+	}
+	return secrets[0], secrets[1], secrets[2], nil
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/views"
+)
+
+// NewSearch is used to create the search controller
+func NewSearch(ss models.SearchService, is models.ImageService) *Search {
+	return &Search{
+		IndexView: views.NewView("bootstrap", "search/index", "collections/partials"),
+		ss:        ss,
+		is:        is,
+	}
+}
+
+// Search finds the current user's galleries and images
+type Search struct {
+	IndexView *views.View
+	ss        models.SearchService
+	is        models.ImageService
+}
+
+// SearchForm is what can be searched for. From and To are dates,
+// both inclusive.
+type SearchForm struct {
+	Query  string `schema:"q"`
+	Tag    string `schema:"tag"`
+	Camera string `schema:"camera"`
+	From   string `schema:"from"`
+	To     string `schema:"to"`
+}
+
+// query turns the form into a SearchQuery for user
+func (form *SearchForm) query(user *models.User) (*models.SearchQuery, error) {
+	q := models.SearchQuery{
+		UserID: user.ID,
+		Text:   form.Query,
+		Tag:    form.Tag,
+		Camera: form.Camera,
+	}
+	var err error
+	if q.From, err = parseSearchDate(form.From); err != nil {
+		return nil, err
+	}
+	if q.To, err = parseSearchDate(form.To); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// parseSearchDate parses a date searched from or to, which is
+// optional
+func parseSearchDate(s string) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(eventDateFormat, s)
+	if err != nil {
+		return nil, models.ErrSearchDateInvalid
+	}
+	return &t, nil
+}
+
+// SearchData is what the search page shows
+type SearchData struct {
+	Form SearchForm
+	// Results are nil until there is something to search for
+	Results *models.SearchResults
+}
+
+// values returns the current search as URL parameters
+func (d *SearchData) values() url.Values {
+	v := url.Values{}
+	for key, value := range map[string]string{
+		"q":      d.Form.Query,
+		"tag":    d.Form.Tag,
+		"camera": d.Form.Camera,
+		"from":   d.Form.From,
+		"to":     d.Form.To,
+	} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	return v
+}
+
+// CameraURL narrows the current search down to images taken with
+// camera
+func (d *SearchData) CameraURL(camera string) string {
+	v := d.values()
+	v.Set("camera", camera)
+	return "/search?" + v.Encode()
+}
+
+// YearURL narrows the current search down to images taken in year
+func (d *SearchData) YearURL(year string) string {
+	v := d.values()
+	v.Set("from", year+"-01-01")
+	v.Set("to", year+"-12-31")
+	return "/search?" + v.Encode()
+}
+
+// Index searches the current user's galleries and images, showing
+// just the form until there is something to search for
+// GET /search
+func (s *Search) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var data SearchData
+	vd.Yield = &data
+	if err := parseURLParams(r, &data.Form); err != nil {
+		vd.SetAlert(err)
+		s.IndexView.Render(w, r, vd)
+		return
+	}
+	q, err := data.Form.query(context.User(r.Context()))
+	if err != nil {
+		vd.SetAlert(err)
+		s.IndexView.Render(w, r, vd)
+		return
+	}
+	if q.Empty() {
+		s.IndexView.Render(w, r, vd)
+		return
+	}
+	results, err := s.ss.Search(q)
+	if err != nil {
+		vd.SetAlert(err)
+		s.IndexView.Render(w, r, vd)
+		return
+	}
+	// The cards show each gallery's cover and image count
+	for i := range results.Galleries {
+		results.Galleries[i].Images, _ = s.is.ByGalleryID(results.Galleries[i].ID)
+	}
+	data.Results = results
+	s.IndexView.Render(w, r, vd)
+}
@@ -1,17 +1,46 @@
 package controllers
 
-import "github.com/sajicode/go-photo/views"
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/sajicode/go-photo/views"
+)
 
 // NewStatic function that helps render static pages
 func NewStatic() *Static {
 	return &Static{
-		Home:    views.NewView("bootstrap", "static/home"),
-		Contact: views.NewView("bootstrap", "static/contact"),
+		Home:      views.NewView("bootstrap", "static/home"),
+		Contact:   views.NewView("bootstrap", "static/contact"),
+		CSRFError: views.NewView("bootstrap", "static/csrf"),
 	}
 }
 
 // Static struct
 type Static struct {
-	Home    *views.View
-	Contact *views.View
+	Home      *views.View
+	Contact   *views.View
+	CSRFError *views.View
+}
+
+// CSRFFailure is used as the csrf error handler. Browsers get a
+// page explaining what happened while API clients get a JSON
+// error, both with a 403 status.
+func (s *Static) CSRFFailure(w http.ResponseWriter, r *http.Request) {
+	log.Printf("csrf: %s %s: %v", r.Method, r.URL.Path, csrf.FailureReason(r))
+	if wantsJSON(r) {
+		writeAPIError(w, http.StatusForbidden, "CSRF token missing or invalid")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusForbidden)
+	s.CSRFError.Render(w, r, nil)
+}
+
+// wantsJSON reports whether the client asked for, or sent, JSON
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
 }
@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/views"
+)
+
+// NewTrash is used to create the trash controller. Whatever is in
+// the trash is purged once retention has passed.
+func NewTrash(gs models.GalleryService, cs models.CollectionService, is models.ImageService, retention time.Duration) *Trash {
+	return &Trash{
+		IndexView: views.NewView("bootstrap", "trash/index"),
+		gs:        gs,
+		cs:        cs,
+		is:        is,
+		retention: retention,
+	}
+}
+
+// Trash lists the galleries and images the current user deleted,
+// so they can be restored or deleted for good
+type Trash struct {
+	IndexView *views.View
+	gs        models.GalleryService
+	cs        models.CollectionService
+	is        models.ImageService
+	retention time.Duration
+}
+
+// TrashedGallery is a deleted gallery with how many images it
+// holds and when it will be purged
+type TrashedGallery struct {
+	models.Gallery
+	ImageCount int
+	PurgeAt    time.Time
+}
+
+// TrashedImage is a deleted image of one of the user's galleries
+// and when it will be purged
+type TrashedImage struct {
+	models.Image
+	GalleryTitle string
+	PurgeAt      time.Time
+}
+
+// TrashData is what the trash page shows
+type TrashData struct {
+	Galleries []TrashedGallery
+	Images    []TrashedImage
+	// RetentionDays is how long things stay in the trash
+	RetentionDays int
+}
+
+// Index shows the current user's trash
+// GET /trash
+func (t *Trash) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	t.render(w, r, vd)
+}
+
+// render shows the trash along with any alert already in vd
+func (t *Trash) render(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	user := context.User(r.Context())
+	data := TrashData{RetentionDays: int(t.retention.Hours() / 24)}
+	vd.Yield = &data
+	deleted, err := t.gs.DeletedByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		t.IndexView.Render(w, r, vd)
+		return
+	}
+	for _, gallery := range deleted {
+		images, _ := t.is.ByGalleryID(gallery.ID)
+		data.Galleries = append(data.Galleries, TrashedGallery{
+			Gallery:    gallery,
+			ImageCount: len(images),
+			PurgeAt:    t.purgeAt(gallery.DeletedAt),
+		})
+	}
+	galleries, err := t.gs.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		t.IndexView.Render(w, r, vd)
+		return
+	}
+	for _, gallery := range galleries {
+		images, err := t.is.DeletedByGalleryID(gallery.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, image := range images {
+			data.Images = append(data.Images, TrashedImage{
+				Image:        image,
+				GalleryTitle: gallery.Title,
+				PurgeAt:      t.purgeAt(image.DeletedAt),
+			})
+		}
+	}
+	t.IndexView.Render(w, r, vd)
+}
+
+// purgeAt is when something deleted at deletedAt will be purged
+func (t *Trash) purgeAt(deletedAt *time.Time) time.Time {
+	if deletedAt == nil {
+		return time.Time{}
+	}
+	return deletedAt.Add(t.retention)
+}
+
+// RestoreGallery takes a gallery out of the trash. It is moved out
+// of its collection if that was deleted in the meantime.
+// POST /trash/galleries/:id/restore
+func (t *Trash) RestoreGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := t.deletedGallery(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	if err := t.gs.Restore(gallery.ID); err != nil {
+		vd.SetAlert(err)
+		t.render(w, r, vd)
+		return
+	}
+	if gallery.CollectionID != 0 {
+		collection, err := t.cs.ByID(gallery.CollectionID)
+		if err != nil || collection.UserID != gallery.UserID {
+			gallery.CollectionID = 0
+			if err := t.gs.Update(gallery); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	views.RedirectAlert(w, r, "/trash", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Restored %s", gallery.Title),
+	})
+}
+
+// DeleteGallery deletes a gallery in the trash and its images for
+// good
+// POST /trash/galleries/:id/delete
+func (t *Trash) DeleteGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := t.deletedGallery(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	if err := t.is.DeleteAll(gallery.ID); err != nil {
+		vd.SetAlert(err)
+		t.render(w, r, vd)
+		return
+	}
+	if err := t.gs.Purge(gallery.ID); err != nil {
+		vd.SetAlert(err)
+		t.render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/trash", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Deleted %s for good", gallery.Title),
+	})
+}
+
+// ShowImage serves an image in the trash to the owner of its
+// gallery, since the trash isn't served with the other images
+// GET /trash/galleries/:id/images/:filename
+func (t *Trash) ShowImage(w http.ResponseWriter, r *http.Request) {
+	image, ok := t.deletedImage(w, r)
+	if !ok {
+		return
+	}
+	f, err := t.is.OpenDeleted(image)
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if contentType := mime.TypeByExtension(filepath.Ext(image.Filename)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Cache-Control", "private")
+	if _, err := io.Copy(w, f); err != nil {
+		log.Println(err)
+	}
+}
+
+// RestoreImage puts an image in the trash back in its gallery
+// POST /trash/galleries/:id/images/:filename/restore
+func (t *Trash) RestoreImage(w http.ResponseWriter, r *http.Request) {
+	image, ok := t.deletedImage(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	if err := t.is.Restore(image); err != nil {
+		vd.SetAlert(err)
+		t.render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/trash", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Restored %s", image.Filename),
+	})
+}
+
+// DeleteImage deletes an image in the trash for good
+// POST /trash/galleries/:id/images/:filename/delete
+func (t *Trash) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	image, ok := t.deletedImage(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	if err := t.is.Purge(image); err != nil {
+		vd.SetAlert(err)
+		t.render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/trash", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Deleted %s for good", image.Filename),
+	})
+}
+
+// deletedGallery finds the current user's deleted gallery named by
+// the URL, responding with a 404 if there isn't one
+func (t *Trash) deletedGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, false
+	}
+	user := context.User(r.Context())
+	deleted, err := t.gs.DeletedByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return nil, false
+	}
+	for i := range deleted {
+		if deleted[i].ID == uint(id) {
+			return &deleted[i], true
+		}
+	}
+	http.Error(w, "Gallery not found", http.StatusNotFound)
+	return nil, false
+}
+
+// deletedImage finds the image named by the URL in the trash of one
+// of the current user's galleries, responding with a 404 if there
+// isn't one
+func (t *Trash) deletedImage(w http.ResponseWriter, r *http.Request) (*models.Image, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return nil, false
+	}
+	gallery, err := t.gs.ByID(uint(id))
+	if err != nil || gallery.UserID != context.User(r.Context()).ID {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return nil, false
+	}
+	images, err := t.is.DeletedByGalleryID(gallery.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return nil, false
+	}
+	for i := range images {
+		if images[i].Filename == vars["filename"] {
+			return &images[i], true
+		}
+	}
+	http.Error(w, "Image not found", http.StatusNotFound)
+	return nil, false
+}
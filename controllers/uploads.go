@@ -0,0 +1,430 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/context"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/views"
+)
+
+// The tus protocol version and extensions we implement, see
+// https://tus.io/protocols/resumable-upload.html
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusChunkType  = "application/offset+octet-stream"
+)
+
+// NewUploads is used to create the controller for chunked uploads.
+// Chunks are stored in dir until the upload is complete, and
+// unfinished uploads expire after expiry.
+func NewUploads(gs models.GalleryService, is models.ImageService, us models.UploadService, usage models.UsageService, dir string, expiry time.Duration) *Uploads {
+	return &Uploads{
+		gs:     gs,
+		is:     is,
+		us:     us,
+		usage:  usage,
+		dir:    dir,
+		expiry: expiry,
+		busy:   make(map[uint]bool),
+	}
+}
+
+// Uploads implements the tus resumable upload protocol for images,
+// so large files survive flaky connections. Each upload belongs to
+// a gallery and is handed to the ImageService once every byte has
+// arrived.
+type Uploads struct {
+	gs     models.GalleryService
+	is     models.ImageService
+	us     models.UploadService
+	usage  models.UsageService
+	dir    string
+	expiry time.Duration
+
+	// busy holds the uploads a chunk is being written to, since
+	// two chunks can't be appended at once
+	mu   sync.Mutex
+	busy map[uint]bool
+}
+
+// Options tells tus clients what we support
+// OPTIONS /galleries/:id/uploads
+func (u *Uploads) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(models.MaxUploadLength))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Create starts an upload of Upload-Length bytes, named by the
+// filename in its Upload-Metadata
+// POST /galleries/:id/uploads
+func (u *Uploads) Create(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	gallery, ok := u.galleryByID(w, r)
+	if !ok {
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Upload-Length must be set", http.StatusBadRequest)
+		return
+	}
+	if length > models.MaxUploadLength {
+		http.Error(w, models.ErrUploadLengthInvalid.Public(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Upload-Metadata is not valid", http.StatusBadRequest)
+		return
+	}
+	filename := meta["filename"]
+	if filename == "" {
+		filename = meta["name"]
+	}
+	// the image is checked again once it has arrived, but there is
+	// no point taking bytes that won't fit. Uploads still open count
+	// as if they had finished, or a user could start any number of
+	// them that each fit on their own.
+	user := context.User(r.Context())
+	quota, err := u.usage.ImageQuota(gallery.ID, filename)
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	open, err := u.us.ByUserID(user.ID)
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	bytes, images := length, 1
+	for _, o := range open {
+		if o.ExpiresAt.After(time.Now()) {
+			bytes += o.Length
+			images++
+		}
+	}
+	if err := quota.Check(bytes, images); err != nil {
+		if pErr, ok := err.(views.PublicError); ok {
+			http.Error(w, pErr.Public(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		uploadError(w, err)
+		return
+	}
+	upload := models.Upload{
+		UserID:    user.ID,
+		GalleryID: gallery.ID,
+		Filename:  filename,
+		Length:    length,
+		ExpiresAt: time.Now().Add(u.expiry),
+	}
+	if err := u.us.Create(&upload); err != nil {
+		uploadError(w, err)
+		return
+	}
+	if err := u.createPart(&upload); err != nil {
+		u.us.Delete(upload.ID)
+		uploadError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/galleries/%d/uploads/%d", gallery.ID, upload.ID))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Head reports how much of an upload we have, so clients know
+// where to resume from
+// HEAD /galleries/:id/uploads/:upload
+func (u *Uploads) Head(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	upload, ok := u.uploadByID(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// Patch appends a chunk at Upload-Offset. The chunk completing
+// the upload adds the image to the gallery.
+// PATCH /galleries/:id/uploads/:upload
+func (u *Uploads) Patch(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusChunkType {
+		http.Error(w, "Content-Type must be "+tusChunkType, http.StatusUnsupportedMediaType)
+		return
+	}
+	upload, ok := u.uploadByID(w, r)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Offset must be set", http.StatusBadRequest)
+		return
+	}
+	if !u.lock(upload.ID) {
+		http.Error(w, "Another chunk is being written to this upload", http.StatusLocked)
+		return
+	}
+	defer u.unlock(upload.ID)
+	// Another chunk may have finished while we waited for the lock
+	upload, err = u.us.ByID(upload.ID)
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	if offset != upload.Offset {
+		http.Error(w, "Upload-Offset doesn't match what we have", http.StatusConflict)
+		return
+	}
+
+	n, copyErr := u.appendPart(upload, r.Body)
+	upload.Offset += n
+	upload.ExpiresAt = time.Now().Add(u.expiry)
+	if err := u.us.Update(upload); err != nil {
+		uploadError(w, err)
+		return
+	}
+	if copyErr != nil {
+		// The client went away part way, it can resume from the
+		// new offset
+		log.Println(copyErr)
+		http.Error(w, "The chunk was only partly received", http.StatusBadRequest)
+		return
+	}
+	if upload.Complete() {
+		if err := u.finish(upload); err != nil {
+			uploadError(w, err)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete abandons an upload
+// DELETE /galleries/:id/uploads/:upload
+func (u *Uploads) Delete(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	upload, ok := u.uploadByID(w, r)
+	if !ok {
+		return
+	}
+	if !u.lock(upload.ID) {
+		http.Error(w, "A chunk is being written to this upload", http.StatusLocked)
+		return
+	}
+	defer u.unlock(upload.ID)
+	if err := u.remove(upload); err != nil {
+		uploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finish hands a complete upload to the ImageService. Uploads that
+// fail validation are removed since sending them again won't help,
+// anything else is kept so the last chunk can be retried.
+func (u *Uploads) finish(upload *models.Upload) error {
+	f, err := os.Open(u.partPath(upload))
+	if err != nil {
+		return err
+	}
+	err = u.is.Create(upload.GalleryID, f, upload.Filename)
+	f.Close()
+	if _, public := err.(views.PublicError); err != nil && !public {
+		return err
+	}
+	if rmErr := u.remove(upload); rmErr != nil {
+		log.Println(rmErr)
+	}
+	return err
+}
+
+// remove deletes an upload and its part file
+func (u *Uploads) remove(upload *models.Upload) error {
+	if err := os.Remove(u.partPath(upload)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return u.us.Delete(upload.ID)
+}
+
+func (u *Uploads) createPart(upload *models.Upload) error {
+	if err := os.MkdirAll(u.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(u.partPath(upload))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// appendPart writes r to the part file at the upload's offset,
+// without going past its length, and returns how much was written
+func (u *Uploads) appendPart(upload *models.Upload, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(u.partPath(upload), os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	// Drop anything written past the offset we recorded, which a
+	// failed update could leave behind
+	if err := f.Truncate(upload.Offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(upload.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(f, io.LimitReader(r, upload.Length-upload.Offset))
+}
+
+func (u *Uploads) partPath(upload *models.Upload) string {
+	return filepath.Join(u.dir, upload.PartName())
+}
+
+func (u *Uploads) lock(id uint) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.busy[id] {
+		return false
+	}
+	u.busy[id] = true
+	return true
+}
+
+func (u *Uploads) unlock(id uint) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.busy, id)
+}
+
+// galleryByID looks up the gallery in the URL, writing a 404 if it
+// doesn't exist or belongs to somebody else.
+func (u *Uploads) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, false
+	}
+	gallery, err := u.gs.ByID(uint(id))
+	if err != nil {
+		uploadError(w, err)
+		return nil, false
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, false
+	}
+	return gallery, true
+}
+
+// uploadByID looks up the upload in the URL, writing a 404 if it
+// isn't one of the user's uploads to that gallery and a 410 if it
+// expired.
+func (u *Uploads) uploadByID(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+	gallery, ok := u.galleryByID(w, r)
+	if !ok {
+		return nil, false
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["upload"])
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	upload, err := u.us.ByID(uint(id))
+	if err != nil {
+		uploadError(w, err)
+		return nil, false
+	}
+	if upload.GalleryID != gallery.ID || upload.UserID != gallery.UserID {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !time.Now().Before(upload.ExpiresAt) {
+		http.Error(w, "Upload expired", http.StatusGone)
+		return nil, false
+	}
+	return upload, true
+}
+
+// tusResumable sets the Tus-Resumable header every response needs
+// and checks the client speaks our version, writing a 412 if not
+func tusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Tus-Resumable must be "+tusVersion, http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header, comma
+// separated keys each followed by a space and a base64 value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, " ", 2)
+		if len(parts) == 1 {
+			meta[parts[0]] = ""
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		meta[parts[0]] = string(value)
+	}
+	return meta, nil
+}
+
+// uploadError writes err as a plain text response, the only kind
+// of body tus clients expect
+func uploadError(w http.ResponseWriter, err error) {
+	switch {
+	case err == models.ErrNotFound:
+		http.Error(w, "Not found", http.StatusNotFound)
+	default:
+		if pErr, ok := err.(views.PublicError); ok {
+			http.Error(w, pErr.Public(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Println(err)
+		http.Error(w, views.AlertMsgGeneric, http.StatusInternalServerError)
+	}
+}
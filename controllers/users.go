@@ -6,22 +6,28 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/sajicode/go-photo/audit"
 	"github.com/sajicode/go-photo/context"
 	"github.com/sajicode/go-photo/email"
 	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/oidc"
 	"github.com/sajicode/go-photo/rand"
 	"github.com/sajicode/go-photo/views"
 )
 
-// NewUsers is used to create a new user controller. should only be used at setup
-func NewUsers(us models.UserService, emailer email.Client) *Users {
+// NewUsers is used to create a new user controller. should only be used at setup.
+// The login page offers to sign in with each of providers.
+func NewUsers(us models.UserService, ads models.AccountDeletionService, emailer email.Client, providers []*oidc.Provider, al *audit.Log) *Users {
 	return &Users{
 		NewView:      views.NewView("bootstrap", "users/new"),
 		LoginView:    views.NewView("bootstrap", "users/login"),
 		ForgotPwView: views.NewView("bootstrap", "users/forgot_pw"),
 		ResetPwView:  views.NewView("bootstrap", "users/reset_pw"),
 		us:           us,
+		ads:          ads,
 		emailer:      emailer,
+		providers:    providers,
+		al:           al,
 	}
 }
 
@@ -32,7 +38,10 @@ type Users struct {
 	ForgotPwView *views.View
 	ResetPwView  *views.View
 	us           models.UserService
+	ads          models.AccountDeletionService
 	emailer      email.Client
+	providers    []*oidc.Provider
+	al           *audit.Log
 }
 
 // SignupForm struct
@@ -72,12 +81,13 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 		u.NewView.Render(w, r, vd)
 		return
 	}
+	u.al.Record(r, models.AuditEvent{Action: models.AuditSignup, ActorID: user.ID}, nil)
 	err := u.emailer.Welcome(user.Name, user.Email)
 
 	if err != nil {
 		log.Println(err)
 	}
-	err = u.signIn(w, &user)
+	err = signIn(w, u.us, &user)
 
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
@@ -96,6 +106,23 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 type LoginForm struct {
 	Email    string `schema:"email"`
 	Password string `schema:"password"`
+	// Next is a path on our site to go to after logging in
+	Next string `schema:"next"`
+}
+
+// LoginData is what the login page renders
+type LoginData struct {
+	Form      *LoginForm
+	Providers []*oidc.Provider
+}
+
+// LoginPage renders the login form, keeping track of where to go
+// after logging in
+// GET /login
+func (u *Users) LoginPage(w http.ResponseWriter, r *http.Request) {
+	var form LoginForm
+	parseURLParams(r, &form)
+	u.LoginView.Render(w, r, LoginData{&form, u.providers})
 }
 
 // Login is used to verify a user's email & password
@@ -103,6 +130,7 @@ type LoginForm struct {
 func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	vd := views.Data{}
 	form := LoginForm{}
+	vd.Yield = LoginData{&form, u.providers}
 	if err := parseForm(r, &form); err != nil {
 		log.Println(err)
 		vd.SetAlert(err)
@@ -113,6 +141,7 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	user, err := u.us.Authenticate(form.Email, form.Password)
 
 	if err != nil {
+		u.recordLoginFailed(r, form.Email, err)
 		switch err {
 		case models.ErrNotFound:
 			vd.AlertError("Invalid email address")
@@ -122,7 +151,7 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 		u.LoginView.Render(w, r, vd)
 		return
 	}
-	err = u.signIn(w, user)
+	err = signIn(w, u.us, user)
 
 	if err != nil {
 		vd.SetAlert(err)
@@ -130,8 +159,19 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 
 		return
 	}
+	u.al.Record(r, models.AuditEvent{Action: models.AuditLogin, ActorID: user.ID}, audit.Details{"method": "password"})
 	//* we need to set the cookie before printing the user object
-	http.Redirect(w, r, "/galleries", http.StatusFound)
+	redirectSignedIn(w, r, u.ads, user, localPath(form.Next, "/galleries"))
+}
+
+// recordLoginFailed records a failed login, against the account
+// that was tried if there is one
+func (u *Users) recordLoginFailed(r *http.Request, email string, err error) {
+	e := models.AuditEvent{Action: models.AuditLoginFailed}
+	if user, uerr := u.us.ByEmail(email); uerr == nil {
+		e.UserID = user.ID
+	}
+	u.al.Record(r, e, audit.Details{"email": email, "error": err.Error()})
 }
 
 // ResetPwForm is used to process the forgot password form
@@ -167,6 +207,11 @@ func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
 		u.ForgotPwView.Render(w, r, vd)
 		return
 	}
+	e := models.AuditEvent{Action: models.AuditPasswordResetRequested}
+	if user, err := u.us.ByEmail(form.Email); err == nil {
+		e.UserID = user.ID
+	}
+	u.al.Record(r, e, audit.Details{"email": form.Email})
 
 	views.RedirectAlert(w, r, "/reset", http.StatusFound, views.Alert{
 		Level:   views.AlertLvlSuccess,
@@ -209,21 +254,24 @@ func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u.signIn(w, user)
+	u.al.Record(r, models.AuditEvent{Action: models.AuditPasswordReset, ActorID: user.ID}, nil)
+	signIn(w, u.us, user)
 	views.RedirectAlert(w, r, "/galleries", http.StatusFound, views.Alert{
 		Level:   views.AlertLvlSuccess,
 		Message: "Your password has been reset and you have been logged in!",
 	})
 }
 
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
+// signIn sets the remember token cookie for user, creating a
+// remember token first if they don't have one
+func signIn(w http.ResponseWriter, us models.UserService, user *models.User) error {
 	if user.Remember == "" {
 		token, err := rand.RememberToken()
 		if err != nil {
 			return err
 		}
 		user.Remember = token
-		err = u.us.Update(user)
+		err = us.Update(user)
 		if err != nil {
 			return err
 		}
@@ -231,12 +279,31 @@ func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
 	cookie := http.Cookie{
 		Name:     "remember_token",
 		Value:    user.Remember,
+		Path:     "/",
 		HttpOnly: true, //! remember to remove when we want to connect a frontend
 	}
 	http.SetCookie(w, &cookie)
 	return nil
 }
 
+// redirectSignedIn sends a user who just signed in on to next,
+// unless their account is about to be deleted. Then they go to
+// their account page, where they can cancel the deletion.
+func redirectSignedIn(w http.ResponseWriter, r *http.Request, ads models.AccountDeletionService, user *models.User, next string) {
+	deletion, err := ads.ByUserID(user.ID)
+	if err != nil {
+		if err != models.ErrNotFound {
+			log.Println(err)
+		}
+		http.Redirect(w, r, next, http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, "/settings/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlWarning,
+		Message: "Your account will be deleted on " + deletion.PurgeAfter.Format(deletionDateFormat) + ". Cancel the deletion below if you want to keep it.",
+	})
+}
+
 // Logout is used to delete a users session cookie (remember_token)
 // and then will update the user resource with a new remmeber
 // token.
@@ -246,6 +313,7 @@ func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
 	cookie := http.Cookie{
 		Name:     "remember_token",
 		Value:    "",
+		Path:     "/",
 		Expires:  time.Now(),
 		HttpOnly: true, //! remove when connecting to client apps
 	}
@@ -255,6 +323,7 @@ func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
 	token, _ := rand.RememberToken()
 	user.Remember = token
 	u.us.Update(user)
+	u.al.Record(r, models.AuditEvent{Action: models.AuditLogout}, nil)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
@@ -3,6 +3,8 @@ package email
 import (
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	mailgun "gopkg.in/mailgun/mailgun-go.v1"
 )
@@ -10,7 +12,8 @@ import (
 const (
 	welcomeSubject = "Welcome to Shutters.com!"
 	resetSubject   = "Instructions for resetting your password."
-	resetBaseURL   = "https://www.lenslocked.com/reset"
+	exportSubject  = "Your Shutters data export is ready"
+	defaultBaseURL = "https://www.lenslocked.com"
 )
 
 const welcomeText = `Hi there!
@@ -62,11 +65,57 @@ Best,<br/>
 Shutters Support<br/>
 `
 
+const exportTextTmpl = `Hi there!
+
+The export of your photos and account data you asked for is ready. You can download it until %s from the link below:
+
+%s
+
+You will need to be logged in. If you didn't ask for an export, please change your password.
+
+Best,
+Shutters Support
+`
+
+const exportHTMLTmpl = `Hi there!<br/>
+<br/>
+The export of your photos and account data you asked for is ready. You can download it until %s from the link below:<br/>
+<br/>
+<a href="%s">%s</a><br/>
+<br/>
+You will need to be logged in. If you didn't ask for an export, please change your password.<br/>
+<br/>
+Best,<br/>
+Shutters Support<br/>
+`
+
+// Transport delivers a single email. Mailgun is what we use in
+// production, but anything implementing this can be plugged in
+// using WithTransport, e.g. to capture emails in tests.
+type Transport interface {
+	Send(from, to, subject, text, html string) error
+}
+
 // WithMailgun builds our mailgun credentials
 func WithMailgun(domain, apiKey, publicKey string) ClientConfig {
 	return func(c *Client) {
 		mg := mailgun.NewMailgun(domain, apiKey, publicKey)
-		c.mg = mg
+		c.transport = &mailgunTransport{mg}
+	}
+}
+
+// WithBaseURL sets the address of our app, used to build the
+// links we put in emails
+func WithBaseURL(baseURL string) ClientConfig {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithTransport sends every email through the provided transport
+func WithTransport(t Transport) ClientConfig {
+	return func(c *Client) {
+		c.transport = t
 	}
 }
 
@@ -84,7 +133,8 @@ type ClientConfig func(*Client)
 func NewClient(opts ...ClientConfig) *Client {
 	client := Client{
 		// set a default from email address
-		from: "support@shutters.com",
+		from:    "support@shutters.com",
+		baseURL: defaultBaseURL,
 	}
 	for _, opt := range opts {
 		opt(&client)
@@ -94,27 +144,46 @@ func NewClient(opts ...ClientConfig) *Client {
 
 // Client struct for our email
 type Client struct {
-	from string
-	mg   mailgun.Mailgun
+	from      string
+	baseURL   string
+	transport Transport
 }
 
 // Welcome sends the welcome email to users
 func (c *Client) Welcome(toName, toEmail string) error {
-	message := mailgun.NewMessage(c.from, welcomeSubject, welcomeText, buildEmail(toName, toEmail))
-	message.SetHtml(welcomeHTML)
-	_, _, err := c.mg.Send(message)
-	return err
+	return c.transport.Send(c.from, buildEmail(toName, toEmail), welcomeSubject, welcomeText, welcomeHTML)
 }
 
 func (c *Client) ResetPw(toEmail, token string) error {
 	v := url.Values{}
 	v.Set("token", token)
-	resetUrl := resetBaseURL + "?" + v.Encode()
+	resetUrl := c.baseURL + "/reset?" + v.Encode()
 	resetText := fmt.Sprintf(resetTextTmpl, resetUrl, token)
-	message := mailgun.NewMessage(c.from, resetSubject, resetText, toEmail)
 	resetHTML := fmt.Sprintf(resetHTMLTmpl, resetUrl, resetUrl, token)
-	message.SetHtml(resetHTML)
-	_, _, err := c.mg.Send(message)
+	return c.transport.Send(c.from, toEmail, resetSubject, resetText, resetHTML)
+}
+
+// ExportReady sends users the link to download their data export,
+// which works until expires
+func (c *Client) ExportReady(toName, toEmail, token string, expires time.Time) error {
+	v := url.Values{}
+	v.Set("token", token)
+	exportURL := c.baseURL + "/settings/export/download?" + v.Encode()
+	until := expires.Format("January 2, 2006 at 15:04 MST")
+	exportText := fmt.Sprintf(exportTextTmpl, until, exportURL)
+	exportHTML := fmt.Sprintf(exportHTMLTmpl, until, exportURL, exportURL)
+	return c.transport.Send(c.from, buildEmail(toName, toEmail), exportSubject, exportText, exportHTML)
+}
+
+type mailgunTransport struct {
+	mg mailgun.Mailgun
+}
+
+// Send delivers the email using the mailgun API
+func (mt *mailgunTransport) Send(from, to, subject, text, html string) error {
+	message := mailgun.NewMessage(from, subject, text, to)
+	message.SetHtml(html)
+	_, _, err := mt.mg.Send(message)
 	return err
 }
 
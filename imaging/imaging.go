@@ -0,0 +1,118 @@
+// Package imaging makes the smaller renditions of images we serve
+// alongside the originals.
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	// gif is only decoded so we can tell it apart, animations are
+	// always served as they are
+	_ "image/gif"
+)
+
+// WebMaxSize is the longest side of a web-sized rendition in
+// pixels
+const WebMaxSize = 2048
+
+// Limits on the images WebSized will decode, since decoding holds
+// every pixel in memory. Images over either are copied as they are.
+const (
+	// MaxDecodeBytes is the largest file that is read into memory
+	MaxDecodeBytes = 64 << 20
+	// MaxDecodePixels is the most pixels, width times height, an
+	// image can have. 50 megapixels take 200MB once decoded.
+	MaxDecodePixels = 50 * 1000 * 1000
+)
+
+// webJPEGQuality is the quality web-sized JPEGs are encoded with
+const webJPEGQuality = 85
+
+// WebSized writes a rendition of the image in src that fits within
+// WebMaxSize to dst. JPEGs and PNGs keep their format, anything
+// else, images that are already small enough and those too large
+// to decode safely, see MaxDecodeBytes and MaxDecodePixels, are
+// copied as they are.
+func WebSized(dst io.Writer, src io.Reader) error {
+	b, err := ioutil.ReadAll(io.LimitReader(src, MaxDecodeBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > MaxDecodeBytes {
+		if _, err := dst.Write(b); err != nil {
+			return err
+		}
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil || (format != "jpeg" && format != "png") || fits(cfg.Width, cfg.Height, WebMaxSize) ||
+		int64(cfg.Width)*int64(cfg.Height) > MaxDecodePixels {
+		_, err := dst.Write(b)
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resized := Fit(img, WebMaxSize)
+	if format == "jpeg" {
+		return jpeg.Encode(dst, resized, &jpeg.Options{Quality: webJPEGQuality})
+	}
+	return png.Encode(dst, resized)
+}
+
+func fits(width, height, max int) bool {
+	return width <= max && height <= max
+}
+
+// Fit scales img down, keeping its aspect ratio, so that neither
+// side is longer than max. Every pixel of the result is the
+// average of the pixels of img it covers, which keeps the detail
+// a plain nearest neighbour scale would lose.
+func Fit(img image.Image, max int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if fits(w, h, max) {
+		return img
+	}
+	dw, dh := max, h*max/w
+	if h > w {
+		dw, dh = w*max/h, max
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, (y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, (x+1)*w/dw
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			// the sums are alpha premultiplied, which is what
+			// color.RGBA64 expects
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
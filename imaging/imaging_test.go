@@ -0,0 +1,80 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	// a 4x2 image with white on the left half and black on the
+	// right half
+	src := image.NewGray(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			src.SetGray(x, y, color.Gray{255})
+		}
+	}
+	dst := Fit(src, 2)
+	if b := dst.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("Expected a 2x1 image, received %v", b)
+	}
+	if r, _, _, _ := dst.At(0, 0).RGBA(); r != 0xffff {
+		t.Errorf("Expected the left pixel to be white, received %x", r)
+	}
+	if r, _, _, _ := dst.At(1, 0).RGBA(); r != 0 {
+		t.Errorf("Expected the right pixel to be black, received %x", r)
+	}
+
+	if Fit(src, 4) != image.Image(src) {
+		t.Error("Expected an image that already fits to be returned as is")
+	}
+}
+
+func TestWebSized(t *testing.T) {
+	var small bytes.Buffer
+	png.Encode(&small, image.NewGray(image.Rect(0, 0, 10, 10)))
+	var out bytes.Buffer
+	if err := WebSized(&out, bytes.NewReader(small.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), small.Bytes()) {
+		t.Error("Expected a small image to be copied as is")
+	}
+
+	var tall bytes.Buffer
+	png.Encode(&tall, image.NewGray(image.Rect(0, 0, 10, WebMaxSize*2)))
+	out.Reset()
+	if err := WebSized(&out, bytes.NewReader(tall.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || cfg.Width != 5 || cfg.Height != WebMaxSize {
+		t.Errorf("Expected a 5x%d png, received a %dx%d %s", WebMaxSize, cfg.Width, cfg.Height, format)
+	}
+
+	out.Reset()
+	if err := WebSized(&out, bytes.NewReader([]byte("not an image"))); err != nil || out.String() != "not an image" {
+		t.Errorf("Expected anything else to be copied as is, received %q, %v", out.String(), err)
+	}
+
+	// a decompression bomb is copied rather than decoded, going by
+	// the size in its header
+	var bomb bytes.Buffer
+	png.Encode(&bomb, image.NewGray(image.Rect(0, 0, 1, 1)))
+	b := bomb.Bytes()
+	binary.BigEndian.PutUint32(b[16:], 100000)
+	binary.BigEndian.PutUint32(b[20:], 100000)
+	binary.BigEndian.PutUint32(b[29:], crc32.ChecksumIEEE(b[12:29]))
+	out.Reset()
+	if err := WebSized(&out, bytes.NewReader(b)); err != nil || !bytes.Equal(out.Bytes(), b) {
+		t.Errorf("Expected an image over MaxDecodePixels to be copied as is, received %v", err)
+	}
+}
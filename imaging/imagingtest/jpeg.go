@@ -0,0 +1,136 @@
+// Package imagingtest builds JPEGs carrying the metadata cameras
+// and photo editors write, to test reading it without sample files.
+package imagingtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"time"
+)
+
+// JPEG returns a tiny JPEG with camera as the EXIF model and
+// takenAt as the original date, and keywords in both its IPTC and
+// XMP metadata. Empty values are left out.
+func JPEG(camera string, takenAt time.Time, keywords ...string) []byte {
+	var img bytes.Buffer
+	jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 2, 2)), nil)
+	b := img.Bytes()
+
+	var out bytes.Buffer
+	// SOI comes first, then our segments, then the rest of the
+	// encoded image
+	out.Write(b[:2])
+	if camera != "" || !takenAt.IsZero() {
+		segment(&out, 0xe1, append([]byte("Exif\x00\x00"), exif(camera, takenAt)...))
+	}
+	if len(keywords) > 0 {
+		segment(&out, 0xe1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp(keywords)...))
+		segment(&out, 0xed, append([]byte("Photoshop 3.0\x00"), photoshop(iptc(keywords))...))
+	}
+	out.Write(b[2:])
+	return out.Bytes()
+}
+
+func segment(out *bytes.Buffer, marker byte, data []byte) {
+	out.Write([]byte{0xff, marker})
+	binary.Write(out, binary.BigEndian, uint16(len(data)+2))
+	out.Write(data)
+}
+
+// exif writes a little endian TIFF structure with the model in
+// IFD0 pointing to an EXIF IFD with the original date
+func exif(camera string, takenAt time.Time) []byte {
+	type entry struct {
+		tag   uint16
+		value string
+	}
+	var ifd0, sub []entry
+	if camera != "" {
+		ifd0 = append(ifd0, entry{0x0110, camera})
+	}
+	if !takenAt.IsZero() {
+		sub = append(sub, entry{0x9003, takenAt.Format("2006:01:02 15:04:05")})
+	}
+	order := binary.LittleEndian
+	ifdSize := func(entries []entry) int { return 2 + 12*len(entries) + 4 }
+	// IFD0 has an extra entry pointing to the EXIF IFD, and the
+	// values follow both directories
+	ifd0Offset := 8
+	subOffset := ifd0Offset + ifdSize(ifd0) + 12
+	dataOffset := subOffset + ifdSize(sub)
+
+	var head, data bytes.Buffer
+	head.WriteString("II")
+	binary.Write(&head, order, uint16(42))
+	binary.Write(&head, order, uint32(ifd0Offset))
+	write := func(entries []entry, extra func()) {
+		n := len(entries)
+		if extra != nil {
+			n++
+		}
+		binary.Write(&head, order, uint16(n))
+		for _, e := range entries {
+			value := append([]byte(e.value), 0)
+			binary.Write(&head, order, e.tag)
+			binary.Write(&head, order, uint16(2))
+			binary.Write(&head, order, uint32(len(value)))
+			if len(value) <= 4 {
+				head.Write(append(value, make([]byte, 4-len(value))...))
+				continue
+			}
+			binary.Write(&head, order, uint32(dataOffset+data.Len()))
+			data.Write(value)
+		}
+		if extra != nil {
+			extra()
+		}
+		// no next IFD
+		binary.Write(&head, order, uint32(0))
+	}
+	write(ifd0, func() {
+		binary.Write(&head, order, uint16(0x8769))
+		binary.Write(&head, order, uint16(4))
+		binary.Write(&head, order, uint32(1))
+		binary.Write(&head, order, uint32(subOffset))
+	})
+	write(sub, nil)
+	return append(head.Bytes(), data.Bytes()...)
+}
+
+func xmp(keywords []string) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	b.WriteString(`<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:subject><rdf:Bag>`)
+	for _, k := range keywords {
+		fmt.Fprintf(&b, "<rdf:li>%s</rdf:li>", k)
+	}
+	b.WriteString(`</rdf:Bag></dc:subject></rdf:Description></rdf:RDF></x:xmpmeta>`)
+	return b.Bytes()
+}
+
+func iptc(keywords []string) []byte {
+	var b bytes.Buffer
+	for _, k := range keywords {
+		b.Write([]byte{0x1c, 2, 25})
+		binary.Write(&b, binary.BigEndian, uint16(len(k)))
+		b.WriteString(k)
+	}
+	return b.Bytes()
+}
+
+// photoshop wraps IPTC data in an image resource with an empty name
+func photoshop(iptc []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("8BIM")
+	binary.Write(&b, binary.BigEndian, uint16(0x0404))
+	b.Write([]byte{0, 0})
+	binary.Write(&b, binary.BigEndian, uint32(len(iptc)))
+	b.Write(iptc)
+	if len(iptc)%2 == 1 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,290 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Metadata is what the camera, or the software the photo was
+// edited with, recorded about an image
+type Metadata struct {
+	// Camera is the camera model from EXIF
+	Camera string
+	// TakenAt is when the photo was taken from EXIF. Cameras don't
+	// record a time zone, so it is the camera's clock read as UTC.
+	TakenAt *time.Time
+	// Keywords are the IPTC and XMP keywords in the order they
+	// were found, they may repeat
+	Keywords []string
+}
+
+// JPEG markers we care about, see ITU T.81 B.1.1.3
+const (
+	markerSOI   = 0xd8
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerAPP1  = 0xe1
+	markerAPP13 = 0xed
+)
+
+var (
+	exifPrefix      = []byte("Exif\x00\x00")
+	xmpPrefix       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopPrefix = []byte("Photoshop 3.0\x00")
+)
+
+// exifDateFormat is how EXIF writes dates and times
+const exifDateFormat = "2006:01:02 15:04:05"
+
+// ReadMetadata reads the EXIF, IPTC and XMP metadata at the start
+// of a JPEG, stopping before the image data. None of it is
+// required, so anything that isn't a JPEG, or metadata we can't
+// make sense of, gives empty Metadata rather than an error.
+func ReadMetadata(r io.Reader) Metadata {
+	var md Metadata
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xff || soi[1] != markerSOI {
+		return md
+	}
+	for {
+		marker, err := nextMarker(br)
+		if err != nil || marker == markerSOS || marker == markerEOI {
+			return md
+		}
+		// RSTn and TEM stand alone, everything else has a length
+		if (marker >= 0xd0 && marker <= 0xd7) || marker == 0x01 {
+			continue
+		}
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil || length < 2 {
+			return md
+		}
+		if marker != markerAPP1 && marker != markerAPP13 {
+			if _, err := io.CopyN(ioutil.Discard, br, int64(length)-2); err != nil {
+				return md
+			}
+			continue
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			return md
+		}
+		switch {
+		case marker == markerAPP1 && bytes.HasPrefix(segment, exifPrefix):
+			md.readEXIF(segment[len(exifPrefix):])
+		case marker == markerAPP1 && bytes.HasPrefix(segment, xmpPrefix):
+			md.readXMP(segment[len(xmpPrefix):])
+		case marker == markerAPP13 && bytes.HasPrefix(segment, photoshopPrefix):
+			md.readPhotoshop(segment[len(photoshopPrefix):])
+		}
+	}
+}
+
+// nextMarker skips to the next marker, including any 0xff fill
+// bytes in front of it
+func nextMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xff {
+		return 0, io.ErrUnexpectedEOF
+	}
+	for b == 0xff {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// EXIF tags we read, from the TIFF 6.0 and EXIF 2.3 specs
+const (
+	tagModel            = 0x0110
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// tiffASCII is the TIFF type of text values
+const tiffASCII = 2
+
+// readEXIF reads the camera model and when the photo was taken
+// from a TIFF structure. The date comes from the EXIF sub IFD when
+// it's there, and the date the file was last changed otherwise.
+func (md *Metadata) readEXIF(tiff []byte) {
+	if len(tiff) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	ifd0, exifOffset := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	md.Camera = ifd0[tagModel]
+	taken := ifd0[tagDateTime]
+	if exifOffset != 0 {
+		exif, _ := readIFD(tiff, order, exifOffset)
+		if original := exif[tagDateTimeOriginal]; original != "" {
+			taken = original
+		}
+	}
+	if t, err := time.Parse(exifDateFormat, taken); err == nil {
+		md.TakenAt = &t
+	}
+}
+
+// readIFD reads the text values of the image file directory at
+// offset in tiff, trimmed of the NULs and spaces they are padded
+// with, along with the offset of the EXIF directory if it points
+// to one
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) (map[uint16]string, uint32) {
+	values := make(map[uint16]string)
+	var exifOffset uint32
+	if offset < 8 || int64(offset)+2 > int64(len(tiff)) {
+		return values, 0
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	entries := tiff[offset+2:]
+	for i := 0; i < n && (i+1)*12 <= len(entries); i++ {
+		entry := entries[i*12 : (i+1)*12]
+		tag := order.Uint16(entry)
+		typ := order.Uint16(entry[2:])
+		count := order.Uint32(entry[4:])
+		if tag == tagExifIFD {
+			exifOffset = order.Uint32(entry[8:])
+			continue
+		}
+		if typ != tiffASCII || (tag != tagModel && tag != tagDateTime && tag != tagDateTimeOriginal) {
+			continue
+		}
+		// values of up to 4 bytes are kept in the entry itself
+		value := entry[8:12]
+		if count > 4 {
+			start := order.Uint32(entry[8:])
+			if int64(start)+int64(count) > int64(len(tiff)) {
+				continue
+			}
+			value = tiff[start : start+count]
+		} else {
+			value = value[:count]
+		}
+		values[tag] = strings.Trim(string(value), "\x00 ")
+	}
+	return values, exifOffset
+}
+
+// XML namespaces of XMP keywords
+const (
+	nsDC  = "http://purl.org/dc/elements/1.1/"
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// readXMP adds the keywords in dc:subject, which is a bag of
+// rdf:li elements
+func (md *Metadata) readXMP(packet []byte) {
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	inSubject, inItem := false, false
+	var item strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Space == nsDC && tok.Name.Local == "subject" {
+				inSubject = true
+			} else if inSubject && tok.Name.Space == nsRDF && tok.Name.Local == "li" {
+				inItem = true
+				item.Reset()
+			}
+		case xml.EndElement:
+			if tok.Name.Space == nsDC && tok.Name.Local == "subject" {
+				inSubject = false
+			} else if inItem && tok.Name.Space == nsRDF && tok.Name.Local == "li" {
+				inItem = false
+				md.addKeyword(item.String())
+			}
+		case xml.CharData:
+			if inItem {
+				item.Write(tok)
+			}
+		}
+	}
+}
+
+// iptcResourceID is the Photoshop image resource holding IPTC data
+const iptcResourceID = 0x0404
+
+// readPhotoshop finds the IPTC data among Photoshop image
+// resources. Each resource is "8BIM", a 2 byte ID, a padded Pascal
+// string name and a 4 byte length followed by its padded data.
+func (md *Metadata) readPhotoshop(b []byte) {
+	for len(b) >= 12 && string(b[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(b[4:])
+		// the name and its length byte are padded to an even length
+		nameLen := int(b[6]) + 1
+		nameLen += nameLen % 2
+		if 6+nameLen+4 > len(b) {
+			return
+		}
+		b = b[6+nameLen:]
+		size := int64(binary.BigEndian.Uint32(b))
+		b = b[4:]
+		if size > int64(len(b)) {
+			return
+		}
+		if id == iptcResourceID {
+			md.readIPTC(b[:size])
+		}
+		size += size % 2
+		if size > int64(len(b)) {
+			return
+		}
+		b = b[size:]
+	}
+}
+
+// IPTC record 2 dataset 25 is a keyword
+const (
+	iptcApplicationRecord = 2
+	iptcKeywords          = 25
+)
+
+// readIPTC adds the keywords among IPTC datasets, which are a 0x1c
+// tag marker, record and dataset numbers and a 2 byte length
+// followed by the data
+func (md *Metadata) readIPTC(b []byte) {
+	for len(b) >= 5 && b[0] == 0x1c {
+		record, dataset := b[1], b[2]
+		size := int(binary.BigEndian.Uint16(b[3:]))
+		// extended datasets, which are far bigger than a keyword,
+		// are where we stop
+		if size&0x8000 != 0 || 5+size > len(b) {
+			return
+		}
+		if record == iptcApplicationRecord && dataset == iptcKeywords {
+			md.addKeyword(string(b[5 : 5+size]))
+		}
+		b = b[5+size:]
+	}
+}
+
+func (md *Metadata) addKeyword(keyword string) {
+	if keyword = strings.TrimSpace(keyword); keyword != "" {
+		md.Keywords = append(md.Keywords, keyword)
+	}
+}
@@ -0,0 +1,49 @@
+package imaging_test
+
+import (
+	"bytes"
+	"image/jpeg"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/imaging"
+	"github.com/sajicode/go-photo/imaging/imagingtest"
+)
+
+func TestReadMetadata(t *testing.T) {
+	taken := time.Date(2019, 6, 1, 14, 30, 0, 0, time.UTC)
+	b := imagingtest.JPEG("Canon EOS 5D", taken, "wedding", "Lagos")
+	if _, err := jpeg.Decode(bytes.NewReader(b)); err != nil {
+		t.Fatalf("Expected a JPEG that still decodes, received %v", err)
+	}
+	md := imaging.ReadMetadata(bytes.NewReader(b))
+	if md.Camera != "Canon EOS 5D" {
+		t.Errorf("Expected the camera model, received %q", md.Camera)
+	}
+	if md.TakenAt == nil || !md.TakenAt.Equal(taken) {
+		t.Errorf("Expected it to be taken at %v, received %v", taken, md.TakenAt)
+	}
+	// XMP comes before IPTC in the file
+	if got := strings.Join(md.Keywords, ","); got != "wedding,Lagos,wedding,Lagos" {
+		t.Errorf("Expected the XMP and IPTC keywords, received %s", got)
+	}
+
+	// short values are stored in the EXIF entry itself
+	md = imaging.ReadMetadata(bytes.NewReader(imagingtest.JPEG("X1", time.Time{})))
+	if md.Camera != "X1" || md.TakenAt != nil || md.Keywords != nil {
+		t.Errorf("Expected only the camera, received %+v", md)
+	}
+
+	for name, b := range map[string][]byte{
+		"no metadata": imagingtest.JPEG("", time.Time{}),
+		"not a JPEG":  []byte("\x89PNG\r\n\x1a\n"),
+		"truncated":   b[:40],
+		"empty":       nil,
+	} {
+		md := imaging.ReadMetadata(bytes.NewReader(b))
+		if md.Camera != "" || md.TakenAt != nil || len(md.Keywords) != 0 {
+			t.Errorf("%s: expected no metadata, received %+v", name, md)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// Steps of an account purge, in the order they run. A deletion
+// remembers the last one that finished.
+const (
+	purgeGalleries   = "galleries"
+	purgeCollections = "collections"
+	purgeAPITokens   = "api_tokens"
+	purgeOAuth       = "oauth"
+	purgeIdentities  = "identities"
+	purgeExports     = "exports"
+	purgeAudit       = "audit"
+	purgeUser        = "user"
+)
+
+// AccountPurge deletes everything belonging to accounts whose
+// deletion grace period is over
+type AccountPurge struct {
+	Deletions   models.AccountDeletionService
+	Users       models.UserService
+	Identities  models.UserIdentityService
+	Galleries   models.GalleryService
+	Collections models.CollectionService
+	Images      models.ImageService
+	APITokens   models.APITokenService
+	OAuth       models.OAuthService
+	Exports     models.ExportService
+	Audit       models.AuditService
+}
+
+type purgeStep struct {
+	name string
+	run  func(userID uint) error
+}
+
+func (ap *AccountPurge) steps() []purgeStep {
+	return []purgeStep{
+		{purgeGalleries, ap.purgeGalleries},
+		{purgeCollections, ap.purgeCollections},
+		{purgeAPITokens, ap.APITokens.DeleteByUserID},
+		{purgeOAuth, ap.purgeOAuth},
+		{purgeIdentities, ap.purgeIdentities},
+		{purgeExports, ap.purgeExports},
+		{purgeAudit, ap.purgeAudit},
+		// this also removes any password reset tokens
+		{purgeUser, ap.Users.Purge},
+	}
+}
+
+// Run purges every account that is due at now. Each account picks
+// up after the last step it finished, so a purge that was
+// interrupted, or failed, carries on the next time we run. One
+// account failing doesn't hold up the others, the first error is
+// returned once they have all been tried.
+func (ap *AccountPurge) Run(now time.Time) error {
+	deletions, err := ap.Deletions.Due(now)
+	if err != nil {
+		return err
+	}
+	var first error
+	for i := range deletions {
+		if err := ap.purge(&deletions[i]); err != nil && first == nil {
+			first = fmt.Errorf("purging user %d: %v", deletions[i].UserID, err)
+		}
+	}
+	return first
+}
+
+func (ap *AccountPurge) purge(d *models.AccountDeletion) error {
+	steps := ap.steps()
+	start := 0
+	for i, step := range steps {
+		if step.name == d.Step {
+			start = i + 1
+		}
+	}
+	for _, step := range steps[start:] {
+		if err := step.run(d.UserID); err != nil {
+			return fmt.Errorf("%s: %v", step.name, err)
+		}
+		d.Step = step.name
+		if err := ap.Deletions.Update(d); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	d.PurgedAt = &now
+	if err := ap.Deletions.Update(d); err != nil {
+		return err
+	}
+	return ap.Audit.Create(&models.AuditEvent{
+		Action:     models.AuditAccountPurged,
+		UserID:     d.UserID,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(int(d.UserID)),
+	})
+}
+
+// purgeGalleries removes every gallery of the user, including the
+// ones they already deleted, along with their images
+func (ap *AccountPurge) purgeGalleries(userID uint) error {
+	live, err := ap.Galleries.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	deleted, err := ap.Galleries.DeletedByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, gallery := range append(live, deleted...) {
+		if err := ap.Images.DeleteAll(gallery.ID); err != nil {
+			return err
+		}
+		if err := ap.Galleries.Purge(gallery.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeCollections removes every collection of the user, whose
+// galleries are already gone
+func (ap *AccountPurge) purgeCollections(userID uint) error {
+	collections, err := ap.Collections.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, collection := range collections {
+		if err := ap.Collections.Delete(collection.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeOAuth removes the apps the user registered, and the tokens
+// issued for them, along with anything the user authorized
+func (ap *AccountPurge) purgeOAuth(userID uint) error {
+	clients, err := ap.OAuth.ClientsByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if err := ap.OAuth.DeleteClient(client.ID); err != nil {
+			return err
+		}
+	}
+	return ap.OAuth.DeleteGrantsByUserID(userID)
+}
+
+func (ap *AccountPurge) purgeIdentities(userID uint) error {
+	identities, err := ap.Identities.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		if err := ap.Identities.Delete(identity.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeExports removes the user's data exports and their archives.
+// Telling the user an export is ready is the only email we send
+// later, so dropping the pending exports drops any mail still
+// waiting to go to them.
+func (ap *AccountPurge) purgeExports(userID uint) error {
+	exports, err := ap.Exports.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if err := removeArchive(export.Path); err != nil {
+			return err
+		}
+	}
+	return ap.Exports.DeleteByUserID(userID)
+}
+
+// purgeAudit redacts the user's email address and other personal
+// details from the audit log, the events themselves are kept
+func (ap *AccountPurge) purgeAudit(userID uint) error {
+	user, err := ap.Users.ByID(userID)
+	if err != nil {
+		return err
+	}
+	return ap.Audit.RedactUser(userID, user.Email)
+}
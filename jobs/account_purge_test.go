@@ -0,0 +1,220 @@
+package jobs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/email"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/models/memstore"
+)
+
+func newTestPurge() *AccountPurge {
+	users := memstore.NewUserService("test-pepper", "test-hmac-key")
+	tokens := memstore.NewAPITokenService("test-hmac-key")
+	images := memstore.NewImageService()
+	return &AccountPurge{
+		Deletions:   memstore.NewAccountDeletionService(),
+		Users:       users,
+		Identities:  memstore.NewUserIdentityService(users),
+		Galleries:   memstore.NewGalleryService(images),
+		Collections: memstore.NewCollectionService(),
+		Images:      images,
+		APITokens:   tokens,
+		OAuth:       memstore.NewOAuthService(tokens, "test-hmac-key"),
+		Exports:     memstore.NewExportService("test-hmac-key"),
+		Audit:       memstore.NewAuditService(),
+	}
+}
+
+// createAccount creates a user with a gallery holding an image, in
+// a collection, and an API token
+func createAccount(t *testing.T, ap *AccountPurge, email string) (*models.User, *models.Gallery) {
+	t.Helper()
+	user := models.User{Name: "Test", Email: email, Password: "secret-password"}
+	if err := ap.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	collection := models.Collection{UserID: user.ID, Title: "Trips"}
+	if err := ap.Collections.Create(&collection); err != nil {
+		t.Fatal(err)
+	}
+	gallery := models.Gallery{UserID: user.ID, Title: "Holiday", CollectionID: collection.ID}
+	if err := ap.Galleries.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.Images.Create(gallery.ID, strings.NewReader("jpeg"), "beach.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	token := models.APIToken{UserID: user.ID, Name: "cli", Scope: models.ScopeRead}
+	if err := ap.APITokens.Create(&token); err != nil {
+		t.Fatal(err)
+	}
+	return &user, &gallery
+}
+
+func TestAccountPurge(t *testing.T) {
+	ap := newTestPurge()
+	kept, _ := createAccount(t, ap, "kept@test.dev")
+	gone, gallery := createAccount(t, ap, "gone@test.dev")
+	if _, err := ap.Deletions.Schedule(gone.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing happens before the grace period is over
+	if err := ap.Run(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ap.Users.ByID(gone.ID); err != nil {
+		t.Fatalf("Expected the user to be kept during the grace period, received %v", err)
+	}
+
+	if err := ap.Run(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ap.Users.ByID(gone.ID); err != models.ErrNotFound {
+		t.Errorf("Expected the user to be purged, received %v", err)
+	}
+	if _, err := ap.Galleries.ByID(gallery.ID); err != models.ErrNotFound {
+		t.Errorf("Expected the gallery to be purged, received %v", err)
+	}
+	if collections, _ := ap.Collections.ByUserID(gone.ID); len(collections) != 0 {
+		t.Errorf("Expected the collections to be purged, received %v", collections)
+	}
+	if images, _ := ap.Images.ByGalleryID(gallery.ID); len(images) != 0 {
+		t.Errorf("Expected the images to be purged, received %v", images)
+	}
+	if tokens, _ := ap.APITokens.ByUserID(gone.ID); len(tokens) != 0 {
+		t.Errorf("Expected the API tokens to be purged, received %v", tokens)
+	}
+	deletion, err := ap.Deletions.ByUserID(gone.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deletion.Purged() || deletion.Step != purgeUser {
+		t.Errorf("Expected the deletion to be finished, received %+v", deletion)
+	}
+	events, err := ap.Audit.Find(models.AuditFilter{UserID: gone.ID, Action: models.AuditAccountPurged})
+	if err != nil || len(events) != 1 {
+		t.Errorf("Expected the purge to be audited, received %v, %v", events, err)
+	}
+
+	if _, err := ap.Users.ByID(kept.ID); err != nil {
+		t.Errorf("Expected other users to be kept, received %v", err)
+	}
+	if tokens, _ := ap.APITokens.ByUserID(kept.ID); len(tokens) != 1 {
+		t.Errorf("Expected other users' tokens to be kept, received %v", tokens)
+	}
+}
+
+// TestAccountPurgeResumes checks a purge that stopped part way
+// only runs the steps it hadn't finished
+func TestAccountPurgeResumes(t *testing.T) {
+	ap := newTestPurge()
+	user, gallery := createAccount(t, ap, "gone@test.dev")
+	deletion, err := ap.Deletions.Schedule(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletion.Step = purgeAPITokens
+	if err := ap.Deletions.Update(deletion); err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.Deletions.Cancel(user.ID); err != models.ErrNotFound {
+		t.Errorf("Expected a started purge not to be cancellable, received %v", err)
+	}
+
+	if err := ap.Run(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ap.Users.ByID(user.ID); err != models.ErrNotFound {
+		t.Errorf("Expected the user to be purged, received %v", err)
+	}
+	// the finished steps are not run again
+	if _, err := ap.Galleries.ByID(gallery.ID); err != nil {
+		t.Errorf("Expected the gallery step to be skipped, received %v", err)
+	}
+	if tokens, _ := ap.APITokens.ByUserID(user.ID); len(tokens) != 1 {
+		t.Errorf("Expected the API token step to be skipped, received %v", tokens)
+	}
+}
+
+// TestAccountPurgePersonalDetails checks no mail is left to send to
+// a purged user, and the audit log keeps their events without their
+// personal details
+func TestAccountPurgePersonalDetails(t *testing.T) {
+	ap := newTestPurge()
+	user, _ := createAccount(t, ap, "gone@test.dev")
+	if _, err := ap.Exports.Request(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	events := []models.AuditEvent{
+		{Action: models.AuditLogin, ActorID: user.ID, UserID: user.ID, IP: "10.0.0.1", UserAgent: "curl"},
+		{Action: models.AuditGalleryDeleted, ActorID: user.ID, UserID: user.ID, IP: "10.0.0.1", Details: `{"title": "Holiday"}`},
+		{Action: models.AuditLoginFailed, UserID: user.ID, IP: "10.0.0.2", Details: `{"email": "gone@test.dev", "error": "wrong password"}`},
+		// signing in with a provider that doesn't match the account
+		{Action: models.AuditLoginFailed, IP: "10.0.0.3", Details: `{"email": "Gone@test.dev", "method": "google"}`},
+		// an admin acting on the user keeps their own IP
+		{Action: models.AuditRoleChanged, ActorID: 99, UserID: user.ID, IP: "10.0.0.4", Details: `{"from": "user", "to": "admin"}`},
+		{Action: models.AuditLoginFailed, IP: "10.0.0.5", Details: `{"email": "kept@test.dev"}`},
+	}
+	for i := range events {
+		if err := ap.Audit.Create(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ap.Deletions.Schedule(user.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.Run(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	mail := &mailRecorder{}
+	ej := &Exports{
+		Exports:     ap.Exports,
+		Users:       ap.Users,
+		Galleries:   ap.Galleries,
+		Collections: ap.Collections,
+		Images:      ap.Images,
+		Emailer:     email.NewClient(email.WithTransport(mail), email.WithBaseURL("http://test.dev")),
+		Dir:         t.TempDir(),
+		Expiry:      time.Hour,
+	}
+	if err := ej.Run(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(mail.sent) != 0 {
+		t.Errorf("Expected no mail to be sent to the purged user, received %+v", mail.sent)
+	}
+
+	stored, err := ap.Audit.Find(models.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the events are kept, along with the purge itself
+	if len(stored) != len(events)+1 {
+		t.Fatalf("Expected the events to be kept, received %+v", stored)
+	}
+	want := map[uint]struct{ ip, details string }{
+		events[0].ID: {"", "{}"},
+		events[1].ID: {"", "{}"},
+		events[2].ID: {"", `{"error":"wrong password"}`},
+		events[3].ID: {"", `{"method":"google"}`},
+		events[4].ID: {"10.0.0.4", `{"from":"user","to":"admin"}`},
+		events[5].ID: {"10.0.0.5", `{"email": "kept@test.dev"}`},
+	}
+	for _, e := range stored {
+		w, ok := want[e.ID]
+		if !ok {
+			continue
+		}
+		if e.IP != w.ip || e.Details != w.details {
+			t.Errorf("Expected event %d to have IP %q and details %s, received %+v", e.ID, w.ip, w.details, e)
+		}
+		if e.IP == "" && e.UserAgent != "" {
+			t.Errorf("Expected the user agent of event %d to be cleared, received %q", e.ID, e.UserAgent)
+		}
+	}
+}
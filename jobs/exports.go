@@ -0,0 +1,293 @@
+package jobs
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/sajicode/go-photo/email"
+	"github.com/sajicode/go-photo/models"
+)
+
+// exportManifest is the manifest.json at the root of an export. It
+// describes everything else in the archive.
+type exportManifest struct {
+	ExportedAt  time.Time          `json:"exported_at"`
+	Profile     exportProfile      `json:"profile"`
+	Collections []exportCollection `json:"collections"`
+	Galleries   []exportGallery    `json:"galleries"`
+}
+
+type exportProfile struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportCollection struct {
+	ID uint `json:"id"`
+	// ParentID is the collection this one is in, if any
+	ParentID   uint   `json:"parent_id,omitempty"`
+	Title      string `json:"title"`
+	Visibility string `json:"visibility"`
+	Position   int    `json:"position"`
+	Sort       string `json:"sort"`
+}
+
+type exportGallery struct {
+	ID           uint   `json:"id"`
+	CollectionID uint   `json:"collection_id,omitempty"`
+	Title        string `json:"title"`
+	Description  string `json:"description,omitempty"`
+	// EventDate is the day the photos were taken, as YYYY-MM-DD
+	EventDate string        `json:"event_date,omitempty"`
+	Location  string        `json:"location,omitempty"`
+	Cover     string        `json:"cover,omitempty"`
+	Tags      []string      `json:"tags,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Images    []exportImage `json:"images"`
+}
+
+type exportImage struct {
+	// Position is the place of the image in the gallery, from 1
+	Position int    `json:"position"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	// Path is where the original is in the archive
+	Path    string     `json:"path"`
+	Caption string     `json:"caption,omitempty"`
+	AltText string     `json:"alt_text,omitempty"`
+	Tags    []string   `json:"tags,omitempty"`
+	Camera  string     `json:"camera,omitempty"`
+	TakenAt *time.Time `json:"taken_at,omitempty"`
+}
+
+// Exports builds the archives users ask for with their data, emails
+// them a link once it is ready, and removes archives that expired
+type Exports struct {
+	Exports     models.ExportService
+	Users       models.UserService
+	Galleries   models.GalleryService
+	Collections models.CollectionService
+	Images      models.ImageService
+	Emailer     *email.Client
+	// Dir is where archives are stored until they expire
+	Dir string
+	// Expiry is how long an archive can be downloaded for,
+	// models.DefaultExportExpiry when zero
+	Expiry time.Duration
+}
+
+// Run removes the archives that expired at now and then builds
+// every pending one. An export that fails is marked as such so
+// users can ask again, the first error is returned once all of
+// them have been tried.
+func (ej *Exports) Run(now time.Time) error {
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+	expired, err := ej.Exports.Expiring(now)
+	if err != nil {
+		return err
+	}
+	for i := range expired {
+		if err := ej.expire(&expired[i]); err != nil {
+			fail(fmt.Errorf("expiring export %d: %v", expired[i].ID, err))
+		}
+	}
+	pending, err := ej.Exports.Pending()
+	if err != nil {
+		return err
+	}
+	for i := range pending {
+		if err := ej.build(&pending[i], now); err != nil {
+			fail(fmt.Errorf("building export %d: %v", pending[i].ID, err))
+		}
+	}
+	return first
+}
+
+func (ej *Exports) expire(export *models.Export) error {
+	if err := removeArchive(export.Path); err != nil {
+		return err
+	}
+	export.Status = models.ExportExpired
+	export.Path = ""
+	export.Token = ""
+	export.TokenHash = ""
+	return ej.Exports.Update(export)
+}
+
+func (ej *Exports) build(export *models.Export, now time.Time) error {
+	user, err := ej.Users.ByID(export.UserID)
+	if err != nil {
+		return ej.failed(export, err)
+	}
+	if err := os.MkdirAll(ej.Dir, 0700); err != nil {
+		return ej.failed(export, err)
+	}
+	// the archive only gets its final name once it is complete
+	tmp, err := ioutil.TempFile(ej.Dir, "export-*.zip.tmp")
+	if err != nil {
+		return ej.failed(export, err)
+	}
+	size, err := ej.write(tmp, user, now)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return ej.failed(export, err)
+	}
+	final := filepath.Join(ej.Dir, fmt.Sprintf("export-%d.zip", export.ID))
+	if err := os.Rename(tmp.Name(), final); err != nil {
+		os.Remove(tmp.Name())
+		return ej.failed(export, err)
+	}
+	expiry := ej.Expiry
+	if expiry == 0 {
+		expiry = models.DefaultExportExpiry
+	}
+	expiresAt := now.Add(expiry)
+	if err := ej.Exports.Finish(export, final, size, expiresAt); err != nil {
+		return err
+	}
+	return ej.Emailer.ExportReady(user.Name, user.Email, export.Token, expiresAt)
+}
+
+// failed marks export as failed and returns err
+func (ej *Exports) failed(export *models.Export, err error) error {
+	export.Status = models.ExportFailed
+	if uerr := ej.Exports.Update(export); uerr != nil {
+		log.Printf("jobs: marking export %d as failed: %v", export.ID, uerr)
+	}
+	return err
+}
+
+// write writes the archive of everything user has to f, returning
+// its size
+func (ej *Exports) write(f *os.File, user *models.User, now time.Time) (int64, error) {
+	manifest := exportManifest{
+		ExportedAt: now.UTC(),
+		Profile: exportProfile{
+			ID:        user.ID,
+			Name:      user.Name,
+			Email:     user.Email,
+			Role:      user.Role,
+			CreatedAt: user.CreatedAt.UTC(),
+		},
+		Collections: []exportCollection{},
+		Galleries:   []exportGallery{},
+	}
+	zw := zip.NewWriter(f)
+	collections, err := ej.Collections.ByUserID(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range collections {
+		manifest.Collections = append(manifest.Collections, exportCollection{
+			ID:         c.ID,
+			ParentID:   c.ParentID,
+			Title:      c.Title,
+			Visibility: c.Visibility,
+			Position:   c.Position,
+			Sort:       c.Sort,
+		})
+	}
+	galleries, err := ej.Galleries.ByUserID(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	for _, gallery := range galleries {
+		images, err := ej.Images.ByGalleryID(gallery.ID)
+		if err != nil {
+			return 0, err
+		}
+		eg := exportGallery{
+			ID:           gallery.ID,
+			CollectionID: gallery.CollectionID,
+			Title:        gallery.Title,
+			Description:  gallery.Description,
+			Location:     gallery.Location,
+			Cover:        gallery.CoverFilename,
+			Tags:         gallery.TagList(),
+			CreatedAt:    gallery.CreatedAt.UTC(),
+			UpdatedAt:    gallery.UpdatedAt.UTC(),
+			Images:       []exportImage{},
+		}
+		if gallery.EventDate != nil {
+			eg.EventDate = gallery.EventDate.Format("2006-01-02")
+		}
+		for i := range images {
+			name := path.Join("galleries", fmt.Sprint(gallery.ID), images[i].Filename)
+			if err := ej.addImage(zw, name, &images[i]); err != nil {
+				return 0, err
+			}
+			eg.Images = append(eg.Images, exportImage{
+				Position: i + 1,
+				Filename: images[i].Filename,
+				Size:     images[i].Size,
+				Path:     name,
+				Caption:  images[i].Caption,
+				AltText:  images[i].AltText,
+				Tags:     images[i].TagList(),
+				Camera:   images[i].Camera,
+				TakenAt:  images[i].TakenAt,
+			})
+		}
+		manifest.Galleries = append(manifest.Galleries, eg)
+	}
+	w, err := zw.Create("manifest.json")
+	if err != nil {
+		return 0, err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&manifest); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	return f.Seek(0, io.SeekCurrent)
+}
+
+// addImage copies the original image into the archive. Images are
+// already compressed, so they are stored as they are.
+func (ej *Exports) addImage(zw *zip.Writer, name string, image *models.Image) error {
+	r, err := ej.Images.Open(image)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// removeArchive deletes the archive at p, if there is one
+func removeArchive(p string) error {
+	if p == "" {
+		return nil
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package jobs
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/email"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/models/memstore"
+)
+
+type sentEmail struct {
+	to, text string
+}
+
+type mailRecorder struct {
+	sent []sentEmail
+}
+
+func (mr *mailRecorder) Send(from, to, subject, text, html string) error {
+	mr.sent = append(mr.sent, sentEmail{to: to, text: text})
+	return nil
+}
+
+func TestExports(t *testing.T) {
+	mail := &mailRecorder{}
+	images := memstore.NewImageService()
+	ej := &Exports{
+		Exports:     memstore.NewExportService("test-hmac-key"),
+		Users:       memstore.NewUserService("test-pepper", "test-hmac-key"),
+		Galleries:   memstore.NewGalleryService(images),
+		Collections: memstore.NewCollectionService(),
+		Images:      images,
+		Emailer:     email.NewClient(email.WithTransport(mail), email.WithBaseURL("http://test.dev")),
+		Dir:         t.TempDir(),
+		Expiry:      time.Hour,
+	}
+	user := models.User{Name: "Gary", Email: "gary@test.dev", Password: "secret-password"}
+	if err := ej.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	gallery := models.Gallery{UserID: user.ID, Title: "Holiday"}
+	if err := ej.Galleries.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"beach.jpg", "sunset.jpg"} {
+		if err := ej.Images.Create(gallery.ID, strings.NewReader("jpeg "+name), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	export, err := ej.Exports.Request(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ej.Exports.Request(user.ID); err != models.ErrExportPending {
+		t.Errorf("Expected ErrExportPending, received %v", err)
+	}
+
+	now := time.Now()
+	if err := ej.Run(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].to != "Gary <gary@test.dev>" {
+		t.Fatalf("Expected the download link to be emailed to gary, received %+v", mail.sent)
+	}
+	i := strings.Index(mail.sent[0].text, "token=")
+	if i < 0 {
+		t.Fatalf("Expected a download link, received %s", mail.sent[0].text)
+	}
+	token, err := url.QueryUnescape(strings.Fields(mail.sent[0].text[i+len("token="):])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	export, err = ej.Exports.ByToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !export.Downloadable(now) || export.Downloadable(now.Add(2*time.Hour)) {
+		t.Errorf("Expected the export to be downloadable for an hour, received %+v", export)
+	}
+
+	zr, err := zip.OpenReader(export.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(b)
+	}
+	zr.Close()
+	var manifest exportManifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Profile.Email != "gary@test.dev" || len(manifest.Galleries) != 1 || len(manifest.Galleries[0].Images) != 2 {
+		t.Fatalf("Expected gary's gallery with 2 images in the manifest, received %+v", manifest)
+	}
+	for _, image := range manifest.Galleries[0].Images {
+		if files[image.Path] != "jpeg "+image.Filename {
+			t.Errorf("Expected %s in the archive, received %q", image.Path, files[image.Path])
+		}
+	}
+
+	if err := ej.Run(now.Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	exports, err := ej.Exports.ByUserID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exports[0].Status != models.ExportExpired {
+		t.Errorf("Expected the export to expire, received %+v", exports[0])
+	}
+	if _, err := os.Stat(export.Path); !os.IsNotExist(err) {
+		t.Errorf("Expected the archive to be removed, received %v", err)
+	}
+	if _, err := ej.Exports.ByToken(token); err != models.ErrNotFound {
+		t.Errorf("Expected the token to stop working, received %v", err)
+	}
+}
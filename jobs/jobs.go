@@ -0,0 +1,28 @@
+// Package jobs holds the work we do in the background rather than
+// while someone waits on a request.
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every calls fn straight away and then every interval until ctx
+// is done. Errors are logged under name and don't stop later runs,
+// so a job should be safe to run again after failing part way.
+func Every(ctx context.Context, interval time.Duration, name string, fn func(now time.Time) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	now := time.Now()
+	for {
+		if err := fn(now); err != nil {
+			log.Printf("jobs: %s: %v", name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+	}
+}
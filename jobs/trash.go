@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// Trash purges the galleries and images that have been in the
+// trash for longer than Retention
+type Trash struct {
+	Galleries models.GalleryService
+	Images    models.ImageService
+	// Retention is how long deleted things are kept,
+	// models.DefaultTrashRetention when zero
+	Retention time.Duration
+}
+
+// Run purges everything deleted more than Retention before now.
+// Galleries take their images with them, trash and all. The first
+// error is returned once everything has been tried.
+func (tj *Trash) Run(now time.Time) error {
+	retention := tj.Retention
+	if retention == 0 {
+		retention = models.DefaultTrashRetention
+	}
+	cutoff := now.Add(-retention)
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+
+	galleries, err := tj.Galleries.DeletedBefore(cutoff)
+	if err != nil {
+		return err
+	}
+	for _, gallery := range galleries {
+		err := tj.Images.DeleteAll(gallery.ID)
+		if err == nil {
+			err = tj.Galleries.Purge(gallery.ID)
+		}
+		if err != nil {
+			fail(fmt.Errorf("purging gallery %d: %v", gallery.ID, err))
+		}
+	}
+
+	images, err := tj.Images.DeletedBefore(cutoff)
+	if err != nil {
+		fail(err)
+		return first
+	}
+	for i := range images {
+		if err := tj.Images.Purge(&images[i]); err != nil && err != models.ErrNotFound {
+			fail(fmt.Errorf("purging image %s: %v", images[i].RelativePath(), err))
+		}
+	}
+	return first
+}
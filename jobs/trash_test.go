@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/models/memstore"
+)
+
+func TestTrash(t *testing.T) {
+	images := memstore.NewImageService()
+	tj := &Trash{
+		Galleries: memstore.NewGalleryService(images),
+		Images:    images,
+		Retention: time.Hour,
+	}
+	deleted := models.Gallery{UserID: 1, Title: "Wedding"}
+	kept := models.Gallery{UserID: 1, Title: "Reception"}
+	for _, g := range []*models.Gallery{&deleted, &kept} {
+		if err := tj.Galleries.Create(g); err != nil {
+			t.Fatal(err)
+		}
+		if err := images.Create(g.ID, strings.NewReader("cake"), "cake.png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	images.Create(kept.ID, strings.NewReader("dance"), "dance.png")
+	tj.Galleries.Delete(deleted.ID)
+	images.Delete(&models.Image{GalleryID: kept.ID, Filename: "cake.png"})
+
+	// nothing has been in the trash long enough yet
+	if err := tj.Run(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if trashed, _ := tj.Galleries.DeletedByUserID(1); len(trashed) != 1 {
+		t.Errorf("Expected the gallery to stay in the trash, received %+v", trashed)
+	}
+	if trashed, _ := images.DeletedByGalleryID(kept.ID); len(trashed) != 1 {
+		t.Errorf("Expected the image to stay in the trash, received %+v", trashed)
+	}
+
+	if err := tj.Run(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if trashed, _ := tj.Galleries.DeletedByUserID(1); len(trashed) != 0 {
+		t.Errorf("Expected the gallery to be purged, received %+v", trashed)
+	}
+	if left, _ := images.ByGalleryID(deleted.ID); len(left) != 0 {
+		t.Errorf("Expected the purged gallery's images to go with it, received %+v", left)
+	}
+	if trashed, _ := images.DeletedByGalleryID(kept.ID); len(trashed) != 0 {
+		t.Errorf("Expected the image to be purged, received %+v", trashed)
+	}
+	if left, _ := images.ByGalleryID(kept.ID); len(left) != 1 || left[0].Filename != "dance.png" {
+		t.Errorf("Expected the live image to be kept, received %+v", left)
+	}
+}
@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// Uploads removes chunked uploads that were abandoned before they
+// were complete
+type Uploads struct {
+	Uploads models.UploadService
+	// Dir is where the controller stores the chunks
+	Dir string
+}
+
+// Run removes every upload that expired at now along with its part
+// file, returning the first error once all of them have been tried
+func (uj *Uploads) Run(now time.Time) error {
+	expired, err := uj.Uploads.Expired(now)
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, upload := range expired {
+		err := os.Remove(filepath.Join(uj.Dir, upload.PartName()))
+		if err == nil || os.IsNotExist(err) {
+			err = uj.Uploads.Delete(upload.ID)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/models/memstore"
+)
+
+func TestUploads(t *testing.T) {
+	uj := &Uploads{
+		Uploads: memstore.NewUploadService(),
+		Dir:     t.TempDir(),
+	}
+	now := time.Now()
+	var uploads []models.Upload
+	for _, expiresAt := range []time.Time{now.Add(-time.Minute), now.Add(time.Hour)} {
+		u := models.Upload{UserID: 1, GalleryID: 1, Filename: "cake.png", Length: 10, ExpiresAt: expiresAt}
+		if err := uj.Uploads.Create(&u); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(uj.Dir, u.PartName()), []byte("cake"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		uploads = append(uploads, u)
+	}
+
+	if err := uj.Run(now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uj.Uploads.ByID(uploads[0].ID); err != models.ErrNotFound {
+		t.Errorf("Expected the abandoned upload to be removed, received %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(uj.Dir, uploads[0].PartName())); !os.IsNotExist(err) {
+		t.Errorf("Expected the abandoned part file to be removed, received %v", err)
+	}
+	if _, err := uj.Uploads.ByID(uploads[1].ID); err != nil {
+		t.Errorf("Expected the active upload to be kept, received %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(uj.Dir, uploads[1].PartName())); err != nil {
+		t.Errorf("Expected the active part file to be kept, received %v", err)
+	}
+}
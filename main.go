@@ -1,44 +1,46 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
-	"os"
+	"time"
 
-	"github.com/gorilla/csrf"
-	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	"github.com/sajicode/go-photo/controllers"
+	"github.com/sajicode/go-photo/config"
 	"github.com/sajicode/go-photo/email"
-	"github.com/sajicode/go-photo/middleware"
+	"github.com/sajicode/go-photo/jobs"
 	"github.com/sajicode/go-photo/models"
-	"github.com/sajicode/go-photo/rand"
+	"github.com/sajicode/go-photo/oidc"
+	"github.com/sajicode/go-photo/server"
 )
 
-func init() {
-	// loads values from .env into the system
-	if err := godotenv.Load(); err != nil {
-		log.Print(err)
-	}
-}
-
 func main() {
+	configPath := flag.String("config", "", "Path to an optional JSON or TOML (.toml) config file. Environment variables and .env take precedence over it.")
+	makeAdmin := flag.String("make-admin", "", "Give the user with this email address the admin role and exit.")
+	reconcileUsage := flag.Bool("reconcile-usage", false, "Recount the storage every gallery uses from the image files, fix any that has drifted and exit.")
+	flag.Parse()
 
-	// Get environment variables
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	dbname := os.Getenv("DB_NAME")
-	dbDriver := os.Getenv("DB_DRIVER")
-	mgDomain := os.Getenv("MG_DOMAIN")
-	mgAPIKey := os.Getenv("MG_API_KEY")
-	mgPublicKey := os.Getenv("MG_PUBLIC_KEY")
-
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-
-	services, err := models.NewServices(dbDriver, psqlInfo)
+	cfg, err := config.Load(*configPath)
+	must(err)
+	dbCfg := cfg.Database
+
+	services, err := models.NewServices(
+		models.WithGorm(dbCfg.Driver, dbCfg.ConnectionInfo()),
+		models.WithLogMode(!cfg.IsProd()),
+		models.WithUser(cfg.Pepper, cfg.HMACKey),
+		models.WithUserIdentity(),
+		models.WithAPIToken(cfg.DerivedKey("api_tokens")),
+		models.WithOAuth(cfg.DerivedKey("oauth")),
+		models.WithAudit(),
+		models.WithAccountDeletion(),
+		models.WithExport(cfg.DerivedKey("exports")),
+		models.WithUpload(),
+		models.WithGallery(),
+		models.WithCollection(),
+		models.WithImage(),
+		models.WithSearch(),
+		models.WithUsage(),
+	)
 	must(err)
 	defer services.Close()
 	//! to clear db
@@ -46,96 +48,127 @@ func main() {
 
 	services.AutoMigrate()
 
+	if *makeAdmin != "" {
+		user, err := services.User.ByEmail(*makeAdmin)
+		must(err)
+		user.Role = models.RoleAdmin
+		must(services.User.Update(user))
+		fmt.Printf("%s is now an admin\n", user.Email)
+		return
+	}
+
+	if *reconcileUsage {
+		must(reconcile(services.Usage))
+		return
+	}
+
 	// use emailer
+	mgCfg := cfg.Mailgun
 	emailer := email.NewClient(
 		email.WithSender("Shutters Support", "support@shutters.co"),
-		email.WithMailgun(mgDomain, mgAPIKey, mgPublicKey),
+		email.WithBaseURL(cfg.BaseURL),
+		email.WithMailgun(mgCfg.Domain, mgCfg.APIKey, mgCfg.PublicKey),
 	)
 
-	// mock usage to prevent errors
-	// _ = emailer
-
-	r := mux.NewRouter()
-	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(services.User, *emailer)
-	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, r)
+	var providers []*oidc.Provider
+	for _, p := range cfg.OIDCProviders {
+		providers = append(providers, oidc.NewProvider(oidc.Config{
+			Name:         p.Name,
+			DisplayName:  p.DisplayName,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  fmt.Sprintf("%s/auth/%s/callback", cfg.BaseURL, p.Name),
+		}, nil))
+	}
 
-	var isProd bool
-	if os.Getenv("APP_ENV") != "production" {
-		isProd = false
-	} else {
-		isProd = true
+	csrfKeys, err := cfg.CSRFKeys()
+	must(err)
+	serverCfg := server.Config{
+		Addr:                 fmt.Sprintf(":%s", cfg.Port),
+		CSRFKeys:             csrfKeys,
+		Secure:               cfg.IsProd(),
+		FlashSecret:          cfg.DerivedKey("flash"),
+		ImpersonationSecret:  cfg.DerivedKey("impersonation"),
+		AccountDeletionGrace: cfg.AccountDeletionGrace(),
+		UploadDir:            cfg.UploadDir,
+		UploadExpiry:         cfg.UploadExpiry(),
+		TrashRetention:       cfg.TrashRetention(),
 	}
-	b, err := rand.Bytes(32)
-	if err != nil {
-		must(err)
+	handler := server.New(serverCfg, server.Deps{
+		User:            services.User,
+		UserIdentity:    services.UserIdentity,
+		Gallery:         services.Gallery,
+		Collection:      services.Collection,
+		Image:           services.Image,
+		Search:          services.Search,
+		APIToken:        services.APIToken,
+		OAuth:           services.OAuth,
+		Audit:           services.Audit,
+		AccountDeletion: services.AccountDeletion,
+		Export:          services.Export,
+		Upload:          services.Upload,
+		Usage:           services.Usage,
+		Emailer:         emailer,
+		OIDCProviders:   providers,
+	})
+
+	purge := &jobs.AccountPurge{
+		Deletions:   services.AccountDeletion,
+		Users:       services.User,
+		Identities:  services.UserIdentity,
+		Galleries:   services.Gallery,
+		Collections: services.Collection,
+		Images:      services.Image,
+		APITokens:   services.APIToken,
+		OAuth:       services.OAuth,
+		Exports:     services.Export,
+		Audit:       services.Audit,
 	}
-	csrfMw := csrf.Protect((b), csrf.Secure(isProd))
-	userMw := middleware.User{
-		UserService: services.User,
+	go jobs.Every(context.Background(), time.Hour, "account purge", purge.Run)
+	exports := &jobs.Exports{
+		Exports:     services.Export,
+		Users:       services.User,
+		Galleries:   services.Gallery,
+		Collections: services.Collection,
+		Images:      services.Image,
+		Emailer:     emailer,
+		Dir:         cfg.ExportDir,
+		Expiry:      cfg.ExportExpiry(),
 	}
-
-	// user middleware
-	requireUserMw := middleware.RequireUser{
-		User: userMw,
+	go jobs.Every(context.Background(), time.Minute, "exports", exports.Run)
+	uploads := &jobs.Uploads{
+		Uploads: services.Upload,
+		Dir:     cfg.UploadDir,
 	}
+	go jobs.Every(context.Background(), time.Hour, "uploads", uploads.Run)
+	trash := &jobs.Trash{
+		Galleries: services.Gallery,
+		Images:    services.Image,
+		Retention: cfg.TrashRetention(),
+	}
+	go jobs.Every(context.Background(), time.Hour, "trash", trash.Run)
 
-	r.NotFoundHandler = http.HandlerFunc(notFound)
-	r.Handle("/", staticC.Home).Methods("GET")
-	r.Handle("/contact", staticC.Contact).Methods("GET")
-
-	// User routes
-	r.HandleFunc("/signup", usersC.New).Methods("GET")
-	r.HandleFunc("/signup", usersC.Create).Methods("POST")
-	r.HandleFunc("/cookietest", usersC.CookieTest).Methods("GET")
-	r.Handle("/login", usersC.LoginView).Methods("GET")
-	r.HandleFunc("/login", usersC.Login).Methods("POST")
-	r.HandleFunc("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST")
-	r.Handle("/forgot", usersC.ForgotPwView).Methods("GET")
-	r.HandleFunc("/forgot", usersC.InitiateReset).Methods("POST")
-	r.HandleFunc("/reset", usersC.ResetPw).Methods("GET")
-	r.HandleFunc("/reset", usersC.CompleteReset).Methods("POST")
-
-	r.HandleFunc("/faq", faq).Methods("GET")
-
-	// Assets
-	assetHandler := http.FileServer(http.Dir("./assets/"))
-	assetHandler = http.StripPrefix("/assets/", assetHandler)
-	r.PathPrefix("/assets/").Handler(assetHandler)
-
-	// Image routes
-	// * so far a route has an image prefix, run the accompanying function
-	imageHandler := http.FileServer(http.Dir("./images/"))
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", imageHandler))
-
-	// * named routes are useful for when we want to redirect to a particular route after an action
-	// Gallery routes
-	r.Handle("/galleries", requireUserMw.ApplyFn(galleriesC.Index)).Methods("GET")
-	r.Handle("/galleries/new", requireUserMw.Apply(galleriesC.New)).Methods("GET")
-	r.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Create)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Edit)).Methods("GET").Name(controllers.EditGallery)
-	r.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleriesC.Update)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.Delete)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST")
-	// POST /galleries/:id/images/:filename/delete
-	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", requireUserMw.ApplyFn(galleriesC.ImageDelete)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).Methods("GET").Name(controllers.ShowGallery)
-
-	appPort := fmt.Sprintf(":%s", os.Getenv("APP_PORT"))
-	fmt.Println("Starting Server on PORT " + appPort)
-	// apply middleware on all routes
-	http.ListenAndServe(appPort, csrfMw(userMw.Apply(r)))
-}
-
-func faq(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	fmt.Fprint(w, "What questions do you have? Share them here and we would do our best to answer. :)")
+	fmt.Printf("Starting Server on PORT %s (%s)\n", serverCfg.Addr, cfg.Env)
+	must(server.Run(serverCfg, handler))
 }
 
-func notFound(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprint(w, "Sorry, we couldn't get the page you requested")
+// reconcile recounts the usage of every gallery, printing what it
+// had to fix
+func reconcile(us models.UsageService) error {
+	report, err := us.Reconcile()
+	if err != nil {
+		return err
+	}
+	for _, c := range report.Changed {
+		fmt.Printf("gallery %d of user %d: %d bytes in %d images, was %d bytes in %d images\n",
+			c.GalleryID, c.UserID, c.To.Bytes, c.To.Images, c.From.Bytes, c.From.Images)
+	}
+	for _, id := range report.Orphaned {
+		fmt.Printf("gallery %d no longer exists but still has images stored\n", id)
+	}
+	fmt.Printf("Recounted %d galleries, fixed %d\n", report.Galleries, len(report.Changed))
+	return nil
 }
 
 func must(err error) {
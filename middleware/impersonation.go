@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/sajicode/go-photo/models"
+)
+
+const (
+	impersonationCookieName = "impersonate"
+	impersonationMaxAge     = time.Hour
+)
+
+// Impersonation lets admins act as another user for support. The
+// user being impersonated is kept in a signed cookie, so it can only
+// be set through the admin console, which records who did it. The
+// cookie is tied to the admin who set it and is only honoured while
+// they are still an admin.
+type Impersonation struct {
+	sc *securecookie.SecureCookie
+}
+
+type impersonationCookie struct {
+	AdminID uint
+	UserID  uint
+}
+
+// NewImpersonation derives the key used to sign the impersonation
+// cookie from secret
+func NewImpersonation(secret string) *Impersonation {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte("impersonation-hash"))
+	sc := securecookie.New(h.Sum(nil), nil)
+	sc.SetSerializer(securecookie.JSONEncoder{})
+	sc.MaxAge(int(impersonationMaxAge.Seconds()))
+	return &Impersonation{sc: sc}
+}
+
+// Start makes admin act as user on the following requests
+func (im *Impersonation) Start(w http.ResponseWriter, admin, user *models.User) error {
+	encoded, err := im.sc.Encode(impersonationCookieName, impersonationCookie{
+		AdminID: admin.ID,
+		UserID:  user.ID,
+	})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonationCookieName,
+		Value:    encoded,
+		Path:     "/",
+		Expires:  time.Now().Add(impersonationMaxAge),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Stop makes the admin act as themselves again
+func (im *Impersonation) Stop(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonationCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// userID returns the ID of the user admin is impersonating
+func (im *Impersonation) userID(r *http.Request, admin *models.User) (uint, bool) {
+	if !admin.HasRole(models.RoleAdmin) {
+		return 0, false
+	}
+	cookie, err := r.Cookie(impersonationCookieName)
+	if err != nil {
+		return 0, false
+	}
+	var value impersonationCookie
+	if err := im.sc.Decode(impersonationCookieName, cookie.Value, &value); err != nil {
+		return 0, false
+	}
+	if value.AdminID != admin.ID || value.UserID == admin.ID {
+		return 0, false
+	}
+	return value.UserID, true
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -11,6 +13,16 @@ import (
 // User struct
 type User struct {
 	models.UserService
+	// APITokens is used to authenticate API requests carrying an
+	// "Authorization: Bearer" header. Bearer tokens are ignored if
+	// it is nil.
+	APITokens models.APITokenService
+	// Impersonation lets admins act as other users. It is disabled
+	// if nil.
+	Impersonation *Impersonation
+	// Usage looks up the quota of users browsing the site, for the
+	// usage meter. It is skipped if nil.
+	Usage models.UsageService
 }
 
 // Apply middleware takes http handler as arg and returns ApplyFn function
@@ -23,28 +35,92 @@ func (u *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		path := r.URL.Path
-		// If the user is requesting a static asset or images, we skip looking for the current user
-		if strings.HasPrefix(path, "/assets/") || strings.HasPrefix(path, "/images/") {
+		// If the user is requesting a static asset, we skip looking
+		// for the current user. Images need them, since only some
+		// visitors can see a gallery.
+		if strings.HasPrefix(path, "/assets/") {
 			next(w, r)
 			return
 		}
+		// API tokens are only accepted by the API, where every route
+		// checks the token's scope
+		if token, ok := bearerToken(r); ok && u.APITokens != nil && strings.HasPrefix(path, "/api/") {
+			u.applyAPIToken(w, r, token, next)
+			return
+		}
 		cookie, err := r.Cookie("remember_token")
 		if err != nil {
 			next(w, r)
 			return
 		}
 		user, err := u.UserService.ByRemember(cookie.Value)
-		if err != nil {
+		if err != nil || user.Disabled {
 			next(w, r)
 			return
 		}
 		ctx := r.Context()
 		ctx = context.WithUser(ctx, user)
+		if target := u.impersonated(r, user); target != nil {
+			ctx = context.WithImpersonator(ctx, user)
+			ctx = context.WithUser(ctx, target)
+		}
+		if u.Usage != nil && !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/images/") {
+			if quota, err := u.Usage.Quota(context.User(ctx)); err == nil {
+				ctx = context.WithQuota(ctx, quota)
+			}
+		}
 		r = r.WithContext(ctx)
 		next(w, r)
 	})
 }
 
+// applyAPIToken sets both the token and its user on the request
+// context, rejecting the request if the token isn't valid rather
+// than falling back to the session cookie.
+func (u *User) applyAPIToken(w http.ResponseWriter, r *http.Request, token string, next http.HandlerFunc) {
+	apiToken, err := u.APITokens.Authenticate(token)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "API token is invalid, expired or revoked")
+		return
+	}
+	user, err := u.UserService.ByID(apiToken.UserID)
+	if err != nil || user.Disabled {
+		writeJSONError(w, http.StatusUnauthorized, "API token is invalid, expired or revoked")
+		return
+	}
+	ctx := r.Context()
+	ctx = context.WithUser(ctx, user)
+	ctx = context.WithAPIToken(ctx, apiToken)
+	next(w, r.WithContext(ctx))
+}
+
+// impersonated returns the user admin is acting as, if any
+func (u *User) impersonated(r *http.Request, admin *models.User) *models.User {
+	if u.Impersonation == nil {
+		return nil
+	}
+	id, ok := u.Impersonation.userID(r, admin)
+	if !ok {
+		return nil
+	}
+	target, err := u.UserService.ByID(id)
+	if err != nil || target.Disabled {
+		return nil
+	}
+	return target
+}
+
+// bearerToken returns the token in an "Authorization: Bearer"
+// header
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
 // RequireUser struct holds the fields required
 type RequireUser struct {
 	User
@@ -67,3 +143,82 @@ func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 		next(w, r)
 	})
 }
+
+// RequireRole only lets users with Role, or a more privileged one,
+// through. Users who aren't logged in are sent to the login page.
+type RequireRole struct {
+	User
+	Role string
+}
+
+// Apply assumes that User middleware has already been run
+func (mw *RequireRole) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn assumes that User middleware has already been run
+func (mw *RequireRole) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if !user.HasRole(mw.Role) {
+			http.Error(w, "You don't have access to this page", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireAPIUser is the JSON API equivalent of RequireUser,
+// responding with a 401 JSON error instead of redirecting to the
+// login page.
+type RequireAPIUser struct {
+	User
+}
+
+// Apply assumes that User middleware has already been run
+func (mw *RequireAPIUser) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn assumes that User middleware has already been run
+func (mw *RequireAPIUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			writeJSONError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// ApplyScopeFn works like ApplyFn, and when the request was
+// authenticated with an API token it also requires the token to
+// allow scope. Session cookies can do everything.
+func (mw *RequireAPIUser) ApplyScopeFn(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		token := context.APIToken(r.Context())
+		if token != nil && !token.Allows(scope) {
+			writeJSONError(w, http.StatusForbidden, fmt.Sprintf("API token does not allow %s access", scope))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// writeJSONError writes the same error body as the API
+// controllers
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"status":  status,
+			"message": msg,
+		},
+	})
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultDeletionGrace is how long users have to change their mind
+// after asking for their account to be deleted
+const DefaultDeletionGrace = 14 * 24 * time.Hour
+
+// AccountDeletion is a user's request to delete their account.
+// Nothing is removed until PurgeAfter, so the user can cancel it
+// until then. Once purged the row is kept, without anything that
+// identifies the user, as a record that it happened.
+type AccountDeletion struct {
+	gorm.Model
+	UserID     uint      `gorm:"not null;unique_index"`
+	PurgeAfter time.Time `gorm:"not null;index"`
+	// Step is the last step of the purge that finished, so an
+	// interrupted purge can carry on where it stopped
+	Step     string
+	PurgedAt *time.Time `gorm:"index"`
+}
+
+// Purged reports whether the account is gone
+func (d *AccountDeletion) Purged() bool {
+	return d.PurgedAt != nil
+}
+
+// AccountDeletionDB is used to interact with the
+// account_deletions table
+type AccountDeletionDB interface {
+	// ByUserID gets the deletion requested by a user
+	ByUserID(userID uint) (*AccountDeletion, error)
+	// Due gets the deletions whose grace period ended before t
+	// and that haven't been purged yet, oldest first
+	Due(t time.Time) ([]AccountDeletion, error)
+
+	Create(deletion *AccountDeletion) error
+	Update(deletion *AccountDeletion) error
+	// Delete cancels a deletion
+	Delete(id uint) error
+}
+
+// AccountDeletionService schedules and cancels account deletions
+type AccountDeletionService interface {
+	// Schedule marks a user's account to be purged once grace has
+	// passed, returning ErrDeletionScheduled if it already is
+	Schedule(userID uint, grace time.Duration) (*AccountDeletion, error)
+	// Cancel stops a scheduled deletion, returning ErrNotFound if
+	// there is none or it is too late to cancel it
+	Cancel(userID uint) error
+	AccountDeletionDB
+}
+
+// NewAccountDeletionService handles DB connection
+func NewAccountDeletionService(db *gorm.DB) AccountDeletionService {
+	return NewAccountDeletionServiceFromDB(&accountDeletionGorm{db})
+}
+
+// NewAccountDeletionServiceFromDB builds an AccountDeletionService
+// on top of any AccountDeletionDB implementation, wrapping it in
+// our validation.
+func NewAccountDeletionServiceFromDB(addb AccountDeletionDB) AccountDeletionService {
+	return &accountDeletionService{
+		AccountDeletionDB: &accountDeletionValidator{addb},
+	}
+}
+
+type accountDeletionService struct {
+	AccountDeletionDB
+}
+
+// Schedule creates the deletion request
+func (ads *accountDeletionService) Schedule(userID uint, grace time.Duration) (*AccountDeletion, error) {
+	switch _, err := ads.ByUserID(userID); err {
+	case nil:
+		return nil, ErrDeletionScheduled
+	case ErrNotFound:
+	default:
+		return nil, err
+	}
+	deletion := AccountDeletion{
+		UserID:     userID,
+		PurgeAfter: time.Now().Add(grace),
+	}
+	if err := ads.Create(&deletion); err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+// Cancel removes the deletion request if the purge hasn't started
+func (ads *accountDeletionService) Cancel(userID uint) error {
+	deletion, err := ads.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	if deletion.Step != "" || deletion.Purged() {
+		return ErrNotFound
+	}
+	return ads.Delete(deletion.ID)
+}
+
+type accountDeletionValFunc func(*AccountDeletion) error
+
+func runAccountDeletionValFuncs(deletion *AccountDeletion, fns ...accountDeletionValFunc) error {
+	for _, fn := range fns {
+		if err := fn(deletion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * validators
+type accountDeletionValidator struct {
+	AccountDeletionDB
+}
+
+// Create validator for account deletions
+func (adv *accountDeletionValidator) Create(deletion *AccountDeletion) error {
+	err := runAccountDeletionValFuncs(deletion,
+		adv.userIDRequired,
+		adv.purgeAfterRequired)
+	if err != nil {
+		return err
+	}
+	return adv.AccountDeletionDB.Create(deletion)
+}
+
+// Update validator for account deletions
+func (adv *accountDeletionValidator) Update(deletion *AccountDeletion) error {
+	err := runAccountDeletionValFuncs(deletion,
+		adv.userIDRequired,
+		adv.purgeAfterRequired)
+	if err != nil {
+		return err
+	}
+	return adv.AccountDeletionDB.Update(deletion)
+}
+
+// Delete validator for account deletions
+func (adv *accountDeletionValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return adv.AccountDeletionDB.Delete(id)
+}
+
+func (adv *accountDeletionValidator) userIDRequired(d *AccountDeletion) error {
+	if d.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (adv *accountDeletionValidator) purgeAfterRequired(d *AccountDeletion) error {
+	if d.PurgeAfter.IsZero() {
+		return ErrPurgeAfterRequired
+	}
+	return nil
+}
+
+var _ AccountDeletionDB = &accountDeletionGorm{}
+
+type accountDeletionGorm struct {
+	db *gorm.DB
+}
+
+// ByUserID gets the deletion requested by a user
+func (adg *accountDeletionGorm) ByUserID(userID uint) (*AccountDeletion, error) {
+	var deletion AccountDeletion
+	err := first(adg.db.Where("user_id = ?", userID), &deletion)
+	if err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+// Due gets the deletions ready to be purged
+func (adg *accountDeletionGorm) Due(t time.Time) ([]AccountDeletion, error) {
+	var deletions []AccountDeletion
+	err := adg.db.Where("purge_after <= ? AND purged_at IS NULL", t).Order("purge_after").Find(&deletions).Error
+	if err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+// Create stores a new deletion
+func (adg *accountDeletionGorm) Create(deletion *AccountDeletion) error {
+	return adg.db.Create(deletion).Error
+}
+
+// Update saves the progress of a deletion
+func (adg *accountDeletionGorm) Update(deletion *AccountDeletion) error {
+	return adg.db.Save(deletion).Error
+}
+
+// Delete removes a cancelled deletion for good, so the user can ask
+// again later
+func (adg *accountDeletionGorm) Delete(id uint) error {
+	deletion := AccountDeletion{Model: gorm.Model{ID: id}}
+	return adg.db.Unscoped().Delete(&deletion).Error
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAccountDeletionSchedule checks deletions become due once
+// their grace period is over and can be cancelled until then
+func TestAccountDeletionSchedule(t *testing.T) {
+	ads := testingServices(t).AccountDeletion
+	deletion, err := ads.Schedule(1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ads.Schedule(1, time.Hour); err != ErrDeletionScheduled {
+		t.Errorf("Expected ErrDeletionScheduled, received %v", err)
+	}
+	if _, err := ads.Schedule(2, 3*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := ads.Due(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Errorf("Expected nothing to be due yet, received %v", due)
+	}
+	due, err = ads.Due(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].ID != deletion.ID {
+		t.Errorf("Expected the first deletion to be due, received %v", due)
+	}
+
+	now := time.Now()
+	deletion.PurgedAt = &now
+	if err := ads.Update(deletion); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := ads.Due(now.Add(2 * time.Hour)); len(due) != 0 {
+		t.Errorf("Expected purged deletions not to be due, received %v", due)
+	}
+	if err := ads.Cancel(1); err != ErrNotFound {
+		t.Errorf("Expected a purged deletion not to be cancellable, received %v", err)
+	}
+
+	if err := ads.Cancel(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ads.Schedule(2, time.Hour); err != nil {
+		t.Errorf("Expected to be able to schedule again after cancelling, received %v", err)
+	}
+}
+
+// TestPurge checks purging removes rows for good, including ones
+// that were already soft deleted
+func TestPurge(t *testing.T) {
+	s := testingServices(t)
+	user := User{Name: "Gone", Email: "gone@test.dev", Password: "secret-password"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.User.InitiateReset(user.Email); err != nil {
+		t.Fatal(err)
+	}
+	kept := Gallery{UserID: user.ID, Title: "Kept"}
+	deleted := Gallery{UserID: user.ID, Title: "Deleted"}
+	for _, g := range []*Gallery{&kept, &deleted} {
+		if err := s.Gallery.Create(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Gallery.Delete(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	galleries, err := s.Gallery.DeletedByUserID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(galleries) != 1 || galleries[0].ID != deleted.ID {
+		t.Fatalf("Expected the deleted gallery, received %v", galleries)
+	}
+	for _, g := range []*Gallery{&kept, &deleted} {
+		if err := s.Gallery.Purge(g.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if galleries, _ := s.Gallery.DeletedByUserID(user.ID); len(galleries) != 0 {
+		t.Errorf("Expected no galleries left, received %v", galleries)
+	}
+
+	if err := s.User.Purge(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	s.db.Unscoped().Model(&User{}).Where("id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected the user row to be gone, found %d", count)
+	}
+	s.db.Unscoped().Model(&PwReset{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected the reset tokens to be gone, found %d", count)
+	}
+}
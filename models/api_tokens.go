@@ -0,0 +1,332 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sajicode/go-photo/hash"
+	"github.com/sajicode/go-photo/rand"
+)
+
+// Scopes a personal API token or OAuth app can be granted. They
+// are also used to describe what each API route requires.
+const (
+	// ScopeRead allows reading galleries and images
+	ScopeRead = "read"
+	// ScopeReadWrite allows everything a user can do through the API
+	ScopeReadWrite = "read-write"
+	// ScopeUpload only allows uploading images
+	ScopeUpload = "upload"
+)
+
+// apiTokenLastUsedInterval limits how often we write the last used
+// time of a token, so busy clients don't cause a write per request.
+const apiTokenLastUsedInterval = time.Minute
+
+// NormalizeScope checks every space separated scope in scope and
+// returns them without duplicates. An empty scope is read only.
+func NormalizeScope(scope string) (string, error) {
+	fields := strings.Fields(scope)
+	if len(fields) == 0 {
+		return ScopeRead, nil
+	}
+	var ret []string
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		switch f {
+		case ScopeRead, ScopeReadWrite, ScopeUpload:
+		default:
+			return "", ErrScopeInvalid
+		}
+		if !seen[f] {
+			seen[f] = true
+			ret = append(ret, f)
+		}
+	}
+	return strings.Join(ret, " "), nil
+}
+
+// APIToken is a personal access token that lets non-browser
+// clients use the API on behalf of a user. Only the hash of the
+// token is stored, so it can only be shown once when created.
+//
+// Access tokens issued to OAuth apps are APITokens too, with
+// OAuthClientID set to the app they were issued to.
+type APIToken struct {
+	gorm.Model
+	UserID        uint   `gorm:"not null;index"`
+	OAuthClientID uint   `gorm:"column:oauth_client_id;index"`
+	Name          string `gorm:"not null"`
+	Scope         string `gorm:"not null"`
+	Token         string `gorm:"-"`
+	TokenHash     string `gorm:"not null;unique_index"`
+	ExpiresAt     *time.Time
+	LastUsedAt    *time.Time
+}
+
+// Allows reports whether the token was granted the access
+// required by a route. Scope may hold several space separated
+// scopes. read-write can do everything, read and upload can only
+// do exactly that.
+func (t *APIToken) Allows(required string) bool {
+	for _, scope := range strings.Fields(t.Scope) {
+		if scope == ScopeReadWrite || scope == required {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the token has an expiry date in the past
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// APITokenDB is used to interact with stored API tokens.
+// ByToken expects the raw token and hashes it before the lookup.
+type APITokenDB interface {
+	ByID(id uint) (*APIToken, error)
+	ByToken(token string) (*APIToken, error)
+	ByUserID(userID uint) ([]APIToken, error)
+
+	Create(token *APIToken) error
+	Update(token *APIToken) error
+	Delete(id uint) error
+	// DeleteByOAuthClientID revokes every token issued to an app
+	DeleteByOAuthClientID(clientID uint) error
+	// DeleteByUserID removes every token of a user for good,
+	// including those issued to apps on their behalf
+	DeleteByUserID(userID uint) error
+}
+
+// APITokenService is a set of methods used to manage personal API
+// tokens
+type APITokenService interface {
+	// Authenticate looks up the provided raw token. If it exists
+	// and has not expired, its last used time is recorded and it is
+	// returned, otherwise ErrTokenInvalid is returned.
+	Authenticate(token string) (*APIToken, error)
+	APITokenDB
+}
+
+// NewAPITokenService handles DB connection
+func NewAPITokenService(db *gorm.DB, hmacKey string) APITokenService {
+	return NewAPITokenServiceFromDB(&apiTokenGorm{db}, hmacKey)
+}
+
+// NewAPITokenServiceFromDB builds an APITokenService on top of
+// any APITokenDB implementation, wrapping it in our validation.
+func NewAPITokenServiceFromDB(atdb APITokenDB, hmacKey string) APITokenService {
+	return &apiTokenService{
+		APITokenDB: &apiTokenValidator{
+			APITokenDB: atdb,
+			hmac:       hash.NewHMAC(hmacKey),
+		},
+	}
+}
+
+var _ APITokenService = &apiTokenService{}
+
+type apiTokenService struct {
+	APITokenDB
+}
+
+func (ats *apiTokenService) Authenticate(token string) (*APIToken, error) {
+	apiToken, err := ats.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if apiToken.Expired() {
+		return nil, ErrTokenInvalid
+	}
+	now := time.Now()
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > apiTokenLastUsedInterval {
+		apiToken.LastUsedAt = &now
+		if err := ats.Update(apiToken); err != nil {
+			return nil, err
+		}
+	}
+	return apiToken, nil
+}
+
+type apiTokenValFunc func(*APIToken) error
+
+func runAPITokenValFuncs(token *APIToken, fns ...apiTokenValFunc) error {
+	for _, fn := range fns {
+		if err := fn(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * validators
+type apiTokenValidator struct {
+	APITokenDB
+	hmac hash.HMAC
+}
+
+// ByToken hashes the token before looking it up
+func (atv *apiTokenValidator) ByToken(token string) (*APIToken, error) {
+	apiToken := APIToken{Token: token}
+	if err := runAPITokenValFuncs(&apiToken, atv.hmacToken); err != nil {
+		return nil, err
+	}
+	return atv.APITokenDB.ByToken(apiToken.TokenHash)
+}
+
+// Create generates the token and stores its hash
+func (atv *apiTokenValidator) Create(token *APIToken) error {
+	err := runAPITokenValFuncs(token,
+		atv.userIDRequired,
+		atv.nameRequired,
+		atv.scopeValid,
+		atv.setTokenIfUnset,
+		atv.hmacToken,
+		atv.tokenHashRequired)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Create(token)
+}
+
+// Update validator for API tokens
+func (atv *apiTokenValidator) Update(token *APIToken) error {
+	err := runAPITokenValFuncs(token,
+		atv.userIDRequired,
+		atv.nameRequired,
+		atv.scopeValid,
+		atv.hmacToken,
+		atv.tokenHashRequired)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Update(token)
+}
+
+// Delete revokes the API token with the provided ID
+func (atv *apiTokenValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return atv.APITokenDB.Delete(id)
+}
+
+func (atv *apiTokenValidator) userIDRequired(t *APIToken) error {
+	if t.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) nameRequired(t *APIToken) error {
+	if t.Name == "" {
+		return ErrNameRequired
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) scopeValid(t *APIToken) error {
+	if t.Scope == "" {
+		return ErrScopeInvalid
+	}
+	scope, err := NormalizeScope(t.Scope)
+	if err != nil {
+		return err
+	}
+	t.Scope = scope
+	return nil
+}
+
+func (atv *apiTokenValidator) setTokenIfUnset(t *APIToken) error {
+	if t.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	t.Token = token
+	return nil
+}
+
+func (atv *apiTokenValidator) hmacToken(t *APIToken) error {
+	if t.Token == "" {
+		return nil
+	}
+	t.TokenHash = atv.hmac.Hash(t.Token)
+	return nil
+}
+
+func (atv *apiTokenValidator) tokenHashRequired(t *APIToken) error {
+	if t.TokenHash == "" {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+var _ APITokenDB = &apiTokenGorm{}
+
+type apiTokenGorm struct {
+	db *gorm.DB
+}
+
+// ByID gets an API token by its ID
+func (atg *apiTokenGorm) ByID(id uint) (*APIToken, error) {
+	var token APIToken
+	err := first(atg.db.Where("id = ?", id), &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ByToken gets an API token by the hash of its token
+func (atg *apiTokenGorm) ByToken(tokenHash string) (*APIToken, error) {
+	var token APIToken
+	err := first(atg.db.Where("token_hash = ?", tokenHash), &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ByUserID gets all of a user's API tokens, newest first
+func (atg *apiTokenGorm) ByUserID(userID uint) ([]APIToken, error) {
+	var tokens []APIToken
+	err := atg.db.Where("user_id = ?", userID).Order("id desc").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Create stores a new API token
+func (atg *apiTokenGorm) Create(token *APIToken) error {
+	return atg.db.Create(token).Error
+}
+
+// Update saves every field of the API token
+func (atg *apiTokenGorm) Update(token *APIToken) error {
+	return atg.db.Save(token).Error
+}
+
+// Delete revokes the API token with the provided ID
+func (atg *apiTokenGorm) Delete(id uint) error {
+	token := APIToken{Model: gorm.Model{ID: id}}
+	return atg.db.Delete(&token).Error
+}
+
+// DeleteByOAuthClientID revokes every token issued to an app
+func (atg *apiTokenGorm) DeleteByOAuthClientID(clientID uint) error {
+	return atg.db.Where("oauth_client_id = ?", clientID).Delete(&APIToken{}).Error
+}
+
+// DeleteByUserID removes every token of a user for good
+func (atg *apiTokenGorm) DeleteByUserID(userID uint) error {
+	return atg.db.Unscoped().Where("user_id = ?", userID).Delete(&APIToken{}).Error
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPITokenAuthenticate(t *testing.T) {
+	ts := testingServices(t).APIToken
+	token := APIToken{UserID: 1, Name: "Lightroom", Scope: ScopeUpload}
+	if err := ts.Create(&token); err != nil {
+		t.Fatal(err)
+	}
+	if token.Token == "" || token.TokenHash == "" || token.Token == token.TokenHash {
+		t.Fatalf("Expected a raw token and its hash, received %+v", token)
+	}
+
+	found, err := ts.Authenticate(token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != token.ID || found.LastUsedAt == nil {
+		t.Errorf("Expected the token with its last use recorded, received %+v", found)
+	}
+	if !found.Allows(ScopeUpload) || found.Allows(ScopeRead) {
+		t.Errorf("Expected an upload only token")
+	}
+
+	if _, err := ts.Authenticate("not-a-token"); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid, received %v", err)
+	}
+	if err := ts.Delete(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Authenticate(token.Token); err != ErrTokenInvalid {
+		t.Errorf("Expected a revoked token to be invalid, received %v", err)
+	}
+}
+
+func TestAPITokenExpiry(t *testing.T) {
+	ts := testingServices(t).APIToken
+	yesterday := time.Now().Add(-24 * time.Hour)
+	token := APIToken{UserID: 1, Name: "Old script", Scope: ScopeRead, ExpiresAt: &yesterday}
+	if err := ts.Create(&token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Authenticate(token.Token); err != ErrTokenInvalid {
+		t.Errorf("Expected an expired token to be invalid, received %v", err)
+	}
+}
+
+func TestAPITokenValidation(t *testing.T) {
+	ts := testingServices(t).APIToken
+	if err := ts.Create(&APIToken{UserID: 1, Scope: ScopeRead}); err != ErrNameRequired {
+		t.Errorf("Expected ErrNameRequired, received %v", err)
+	}
+	if err := ts.Create(&APIToken{UserID: 1, Name: "Admin", Scope: "admin"}); err != ErrScopeInvalid {
+		t.Errorf("Expected ErrScopeInvalid, received %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AuditEvent records a security relevant or content changing thing
+// that happened, who did it and from where. Events are append only,
+// there is no way to delete them, and the only change allowed is
+// redacting what they hold about a deleted user.
+type AuditEvent struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+	Action    string    `gorm:"not null;index"`
+	// ActorID is the user who did it, or 0 when nobody was logged
+	// in, e.g. for a failed login
+	ActorID uint `gorm:"index"`
+	// ImpersonatorID is the admin who was acting as the actor, if
+	// any
+	ImpersonatorID uint `gorm:"index"`
+	// UserID is the user whose account or content was affected
+	UserID uint `gorm:"index"`
+	// TargetType and TargetID name what was acted on, like a
+	// gallery and its ID
+	TargetType string
+	TargetID   string
+	IP         string
+	UserAgent  string
+	// Details is a JSON object with anything else worth keeping,
+	// like the email address a failed login used
+	Details string `gorm:"type:text"`
+}
+
+// Audit actions. Admins and moderators acting on someone else's
+// content use the same actions as the owner would, with the owner
+// as the UserID.
+const (
+	AuditSignup                 = "user.signup"
+	AuditLogin                  = "user.login"
+	AuditLoginFailed            = "user.login_failed"
+	AuditLogout                 = "user.logout"
+	AuditPasswordResetRequested = "user.password_reset_requested"
+	AuditPasswordReset          = "user.password_reset"
+	AuditPasswordChanged        = "user.password_changed"
+	AuditIdentityLinked         = "user.identity_linked"
+	AuditIdentityUnlinked       = "user.identity_unlinked"
+	AuditAPITokenCreated        = "api_token.created"
+	AuditAPITokenRevoked        = "api_token.revoked"
+	AuditAppRevoked             = "oauth.app_revoked"
+	AuditGalleryDeleted         = "gallery.deleted"
+	AuditImageDeleted           = "image.deleted"
+	AuditDeletionScheduled      = "user.deletion_scheduled"
+	AuditDeletionCancelled      = "user.deletion_cancelled"
+	AuditAccountPurged          = "user.purged"
+	AuditExportRequested        = "user.export_requested"
+	AuditExportDownloaded       = "user.export_downloaded"
+
+	AuditUserDisabled          = "admin.user_disabled"
+	AuditUserEnabled           = "admin.user_enabled"
+	AuditRoleChanged           = "admin.role_changed"
+	AuditPlanChanged           = "admin.plan_changed"
+	AuditPasswordResetForced   = "admin.password_reset_forced"
+	AuditImpersonationStarted  = "admin.impersonation_started"
+	AuditImpersonationFinished = "admin.impersonation_finished"
+	AuditImpersonationBlocked  = "admin.impersonation_blocked"
+)
+
+// AuditActions lists every action, in the order admins pick them
+// from when filtering
+var AuditActions = []string{
+	AuditSignup,
+	AuditLogin,
+	AuditLoginFailed,
+	AuditLogout,
+	AuditPasswordResetRequested,
+	AuditPasswordReset,
+	AuditPasswordChanged,
+	AuditIdentityLinked,
+	AuditIdentityUnlinked,
+	AuditAPITokenCreated,
+	AuditAPITokenRevoked,
+	AuditAppRevoked,
+	AuditGalleryDeleted,
+	AuditImageDeleted,
+	AuditDeletionScheduled,
+	AuditDeletionCancelled,
+	AuditAccountPurged,
+	AuditExportRequested,
+	AuditExportDownloaded,
+	AuditUserDisabled,
+	AuditUserEnabled,
+	AuditRoleChanged,
+	AuditPlanChanged,
+	AuditPasswordResetForced,
+	AuditImpersonationStarted,
+	AuditImpersonationFinished,
+	AuditImpersonationBlocked,
+}
+
+var auditDescriptions = map[string]string{
+	AuditSignup:                 "Signed up",
+	AuditLogin:                  "Logged in",
+	AuditLoginFailed:            "Failed to log in",
+	AuditLogout:                 "Logged out everywhere",
+	AuditPasswordResetRequested: "Asked to reset password",
+	AuditPasswordReset:          "Reset password",
+	AuditPasswordChanged:        "Changed password",
+	AuditIdentityLinked:         "Connected a sign in provider",
+	AuditIdentityUnlinked:       "Disconnected a sign in provider",
+	AuditAPITokenCreated:        "Created an API token",
+	AuditAPITokenRevoked:        "Revoked an API token",
+	AuditAppRevoked:             "Disconnected an app",
+	AuditGalleryDeleted:         "Deleted a gallery",
+	AuditImageDeleted:           "Deleted an image",
+	AuditDeletionScheduled:      "Asked for the account to be deleted",
+	AuditDeletionCancelled:      "Cancelled deleting the account",
+	AuditAccountPurged:          "Account deleted",
+	AuditExportRequested:        "Asked for a data export",
+	AuditExportDownloaded:       "Downloaded a data export",
+	AuditUserDisabled:           "Account disabled by an admin",
+	AuditUserEnabled:            "Account enabled by an admin",
+	AuditRoleChanged:            "Role changed by an admin",
+	AuditPlanChanged:            "Plan changed by an admin",
+	AuditPasswordResetForced:    "Password reset required by an admin",
+	AuditImpersonationStarted:   "Support started acting as you",
+	AuditImpersonationFinished:  "Support stopped acting as you",
+	AuditImpersonationBlocked:   "Support was stopped from giving access to your account",
+}
+
+// Description describes the event to the user it affected
+func (e *AuditEvent) Description() string {
+	if desc, ok := auditDescriptions[e.Action]; ok {
+		return desc
+	}
+	return e.Action
+}
+
+// AuditFilter narrows down the events returned by Find. Zero values
+// match everything.
+type AuditFilter struct {
+	// UserID matches events the user did, was impersonated doing,
+	// or that affected them
+	UserID uint
+	Action string
+	// Since and Until bound when the event happened, Until is
+	// exclusive
+	Since time.Time
+	Until time.Time
+	// Limit is the most events to return, 0 for all of them
+	Limit int
+}
+
+// AuditDB is used to interact with the audit_events table
+type AuditDB interface {
+	// Find returns the events matching filter, most recent first
+	Find(filter AuditFilter) ([]AuditEvent, error)
+
+	Create(event *AuditEvent) error
+	// Redact overwrites the IP, user agent and details of a stored
+	// event, leaving everything else as it was
+	Redact(event *AuditEvent) error
+}
+
+// AuditService records and finds audit events
+type AuditService interface {
+	AuditDB
+
+	// RedactUser removes the personal details of a deleted user from
+	// the events about them, keeping the events themselves
+	RedactUser(userID uint, email string) error
+}
+
+// NewAuditService handles DB connection
+func NewAuditService(db *gorm.DB) AuditService {
+	return NewAuditServiceFromDB(&auditGorm{db})
+}
+
+// NewAuditServiceFromDB builds an AuditService on top of any
+// AuditDB implementation, wrapping it in our validation.
+func NewAuditServiceFromDB(adb AuditDB) AuditService {
+	return &auditService{
+		AuditDB: &auditValidator{adb},
+	}
+}
+
+type auditService struct {
+	AuditDB
+}
+
+// auditContentDetails are the details naming things the user owned
+// or who they are elsewhere
+var auditContentDetails = []string{"title", "filename", "name", "subject"}
+
+// RedactUser strips the email address of the user from the details
+// of every event that has it, including failed logins and password
+// reset requests that were never tied to the account. Events about
+// the user also lose the names of their galleries, images and
+// tokens, and the IP and user agent are cleared from the ones they,
+// or an anonymous visitor using their email, did.
+func (as *auditService) RedactUser(userID uint, email string) error {
+	events, err := as.Find(AuditFilter{UserID: userID})
+	if err != nil {
+		return err
+	}
+	for _, action := range []string{AuditLoginFailed, AuditPasswordResetRequested} {
+		anonymous, err := as.Find(AuditFilter{Action: action})
+		if err != nil {
+			return err
+		}
+		events = append(events, anonymous...)
+	}
+	seen := make(map[uint]bool)
+	for i := range events {
+		e := &events[i]
+		if seen[e.ID] {
+			continue
+		}
+		seen[e.ID] = true
+		ours, err := redactEvent(e, userID, email)
+		if err != nil {
+			return err
+		}
+		if !ours {
+			continue
+		}
+		if err := as.Redact(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactEvent removes what e holds about the user, reporting
+// whether it is one of theirs
+func redactEvent(e *AuditEvent, userID uint, email string) (bool, error) {
+	var details map[string]interface{}
+	if err := json.Unmarshal([]byte(e.Details), &details); err != nil {
+		return false, err
+	}
+	hadEmail := false
+	for key, value := range details {
+		if s, ok := value.(string); ok && email != "" && strings.EqualFold(s, email) {
+			delete(details, key)
+			hadEmail = true
+		}
+	}
+	if !hadEmail && e.UserID != userID && e.ActorID != userID {
+		return false, nil
+	}
+	if e.UserID == userID {
+		for _, key := range auditContentDetails {
+			delete(details, key)
+		}
+	}
+	if e.ActorID == userID || e.ActorID == 0 {
+		e.IP = ""
+		e.UserAgent = ""
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		return false, err
+	}
+	e.Details = string(b)
+	return true, nil
+}
+
+type auditValFunc func(*AuditEvent) error
+
+func runAuditValFuncs(event *AuditEvent, fns ...auditValFunc) error {
+	for _, fn := range fns {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * validators
+type auditValidator struct {
+	AuditDB
+}
+
+// Create validator for audit events
+func (av *auditValidator) Create(event *AuditEvent) error {
+	err := runAuditValFuncs(event,
+		av.actionRequired,
+		av.detailsObject,
+		av.setCreatedAt)
+	if err != nil {
+		return err
+	}
+	return av.AuditDB.Create(event)
+}
+
+// Redact validator for audit events
+func (av *auditValidator) Redact(event *AuditEvent) error {
+	if err := runAuditValFuncs(event, av.detailsObject); err != nil {
+		return err
+	}
+	return av.AuditDB.Redact(event)
+}
+
+func (av *auditValidator) actionRequired(e *AuditEvent) error {
+	if e.Action == "" {
+		return ErrActionRequired
+	}
+	return nil
+}
+
+func (av *auditValidator) detailsObject(e *AuditEvent) error {
+	if e.Details == "" {
+		e.Details = "{}"
+		return nil
+	}
+	var details map[string]interface{}
+	if err := json.Unmarshal([]byte(e.Details), &details); err != nil {
+		return ErrDetailsInvalid
+	}
+	return nil
+}
+
+func (av *auditValidator) setCreatedAt(e *AuditEvent) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	return nil
+}
+
+var _ AuditDB = &auditGorm{}
+
+type auditGorm struct {
+	db *gorm.DB
+}
+
+// Find gets the events matching filter
+func (ag *auditGorm) Find(filter AuditFilter) ([]AuditEvent, error) {
+	db := ag.db
+	if filter.UserID > 0 {
+		db = db.Where("actor_id = ? OR impersonator_id = ? OR user_id = ?", filter.UserID, filter.UserID, filter.UserID)
+	}
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		db = db.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		db = db.Where("created_at < ?", filter.Until)
+	}
+	if filter.Limit > 0 {
+		db = db.Limit(filter.Limit)
+	}
+	var events []AuditEvent
+	if err := db.Order("id desc").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Create stores a new event
+func (ag *auditGorm) Create(event *AuditEvent) error {
+	return ag.db.Create(event).Error
+}
+
+// Redact updates the IP, user agent and details of an event
+func (ag *auditGorm) Redact(event *AuditEvent) error {
+	return ag.db.Model(&AuditEvent{ID: event.ID}).Updates(map[string]interface{}{
+		"ip":         event.IP,
+		"user_agent": event.UserAgent,
+		"details":    event.Details,
+	}).Error
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAuditValidation checks events need an action and JSON object
+// details
+func TestAuditValidation(t *testing.T) {
+	as := testingServices(t).Audit
+	if err := as.Create(&AuditEvent{ActorID: 1}); err != ErrActionRequired {
+		t.Errorf("Expected ErrActionRequired, received %v", err)
+	}
+	if err := as.Create(&AuditEvent{Action: AuditLogin, Details: "[1, 2]"}); err != ErrDetailsInvalid {
+		t.Errorf("Expected ErrDetailsInvalid, received %v", err)
+	}
+	event := AuditEvent{Action: AuditLogin, ActorID: 1, UserID: 1}
+	if err := as.Create(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID == 0 || event.Details != "{}" || time.Since(event.CreatedAt) > 5*time.Second {
+		t.Errorf("Expected the event to be stored with empty details, received %+v", event)
+	}
+}
+
+// TestAuditFind checks each filter narrows down the events
+func TestAuditFind(t *testing.T) {
+	as := testingServices(t).Audit
+	day := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	events := []AuditEvent{
+		{Action: AuditLogin, ActorID: 1, UserID: 1, CreatedAt: day},
+		{Action: AuditLoginFailed, UserID: 2, CreatedAt: day.AddDate(0, 0, 1)},
+		{Action: AuditGalleryDeleted, ActorID: 3, UserID: 2, CreatedAt: day.AddDate(0, 0, 2)},
+		{Action: AuditImageDeleted, ActorID: 2, ImpersonatorID: 3, UserID: 2, CreatedAt: day.AddDate(0, 0, 3)},
+	}
+	for i := range events {
+		if err := as.Create(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(filter AuditFilter) []uint {
+		t.Helper()
+		found, err := as.Find(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ret []uint
+		for _, e := range found {
+			ret = append(ret, e.ID)
+		}
+		return ret
+	}
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   []uint
+	}{
+		{"all", AuditFilter{}, []uint{4, 3, 2, 1}},
+		{"affected user", AuditFilter{UserID: 2}, []uint{4, 3, 2}},
+		{"actor or impersonator", AuditFilter{UserID: 3}, []uint{4, 3}},
+		{"action", AuditFilter{Action: AuditLoginFailed}, []uint{2}},
+		{"dates", AuditFilter{Since: day.AddDate(0, 0, 1), Until: day.AddDate(0, 0, 3)}, []uint{3, 2}},
+		{"limit", AuditFilter{Limit: 1}, []uint{4}},
+	}
+	for _, tc := range tests {
+		got := ids(tc.filter)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: expected %v, received %v", tc.name, tc.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: expected %v, received %v", tc.name, tc.want, got)
+				break
+			}
+		}
+	}
+}
+
+// TestAuditRedactUser checks a deleted user's email and IP are
+// removed from the events, which are kept
+func TestAuditRedactUser(t *testing.T) {
+	as := testingServices(t).Audit
+	events := []AuditEvent{
+		{Action: AuditLogin, ActorID: 1, UserID: 1, IP: "10.0.0.1", UserAgent: "curl"},
+		{Action: AuditPasswordResetRequested, IP: "10.0.0.2", Details: `{"email": "gone@test.dev"}`},
+		{Action: AuditPasswordResetRequested, IP: "10.0.0.3", Details: `{"email": "kept@test.dev"}`},
+	}
+	for i := range events {
+		if err := as.Create(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := as.RedactUser(1, "gone@test.dev"); err != nil {
+		t.Fatal(err)
+	}
+	found, err := as.Find(AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 {
+		t.Fatalf("Expected the events to be kept, received %+v", found)
+	}
+	if e := found[2]; e.IP != "" || e.UserAgent != "" || e.Action != AuditLogin || e.ActorID != 1 {
+		t.Errorf("Expected the login to lose its IP and user agent, received %+v", e)
+	}
+	if e := found[1]; e.IP != "" || e.Details != "{}" {
+		t.Errorf("Expected the reset request to lose the email, received %+v", e)
+	}
+	if e := found[0]; e.IP != "10.0.0.3" || e.Details != `{"email": "kept@test.dev"}` {
+		t.Errorf("Expected other users' events to be left alone, received %+v", e)
+	}
+}
@@ -0,0 +1,503 @@
+package models
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Who can see a collection or gallery. A public gallery in a
+// collection can only be seen by others if the collection can be,
+// see Gallery.Visible.
+const (
+	VisibilityPrivate = "private"
+	VisibilityPublic  = "public"
+)
+
+// How the galleries in a collection are ordered
+const (
+	CollectionSortNewest = "newest"
+	CollectionSortOldest = "oldest"
+	CollectionSortTitle  = "title"
+	// CollectionSortDate orders galleries by their event date,
+	// newest first, with undated galleries last
+	CollectionSortDate = "date"
+)
+
+// MaxCollectionDepth is how deeply collections can be nested, a
+// top level collection being 1 deep
+const MaxCollectionDepth = 5
+
+// Collection groups galleries, and other collections, of a user
+type Collection struct {
+	gorm.Model
+	UserID uint `gorm:"not null;index"`
+	// ParentID is the collection this one is in, 0 at the top level
+	ParentID   uint   `gorm:"not null;index"`
+	Title      string `gorm:"not null"`
+	Visibility string `gorm:"not null"`
+	// Position orders the collection among the others in its parent,
+	// from 1
+	Position int `gorm:"not null"`
+	// Sort is how the galleries in the collection are ordered
+	Sort string `gorm:"not null"`
+	// CoverGalleryID is the gallery whose cover is shown for the
+	// collection, the first gallery when 0
+	CoverGalleryID uint
+	Galleries      []Gallery `gorm:"-"`
+}
+
+// Public reports whether anyone can browse the collection. Its
+// parents need to be public too, see CollectionTree.Visible.
+func (c *Collection) Public() bool {
+	return c.Visibility == VisibilityPublic
+}
+
+// Cover returns the gallery shown for the collection, which is the
+// first one unless the owner picked another. It is nil if the
+// collection has no galleries.
+func (c *Collection) Cover() *Gallery {
+	for i := range c.Galleries {
+		if c.Galleries[i].ID == c.CoverGalleryID {
+			return &c.Galleries[i]
+		}
+	}
+	if len(c.Galleries) > 0 {
+		return &c.Galleries[0]
+	}
+	return nil
+}
+
+// SortGalleries orders galleries the way the collection asks for
+func (c *Collection) SortGalleries(galleries []Gallery) {
+	var less func(a, b *Gallery) bool
+	switch c.Sort {
+	case CollectionSortOldest:
+		less = func(a, b *Gallery) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case CollectionSortTitle:
+		less = func(a, b *Gallery) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) }
+	case CollectionSortDate:
+		less = func(a, b *Gallery) bool {
+			if a.EventDate == nil || b.EventDate == nil {
+				return a.EventDate != nil
+			}
+			return a.EventDate.After(*b.EventDate)
+		}
+	default:
+		less = func(a, b *Gallery) bool { return a.CreatedAt.After(b.CreatedAt) }
+	}
+	sort.SliceStable(galleries, func(i, j int) bool {
+		return less(&galleries[i], &galleries[j])
+	})
+}
+
+// CollectionTree answers questions about how a user's collections
+// are nested
+type CollectionTree struct {
+	byID     map[uint]*Collection
+	children map[uint][]*Collection
+}
+
+// NewCollectionTree builds the tree of collections, which should
+// all belong to the same user
+func NewCollectionTree(collections []Collection) *CollectionTree {
+	t := &CollectionTree{
+		byID:     make(map[uint]*Collection, len(collections)),
+		children: make(map[uint][]*Collection),
+	}
+	for i := range collections {
+		c := &collections[i]
+		t.byID[c.ID] = c
+		t.children[c.ParentID] = append(t.children[c.ParentID], c)
+	}
+	for _, cs := range t.children {
+		sort.SliceStable(cs, func(i, j int) bool {
+			if cs[i].Position != cs[j].Position {
+				return cs[i].Position < cs[j].Position
+			}
+			return cs[i].ID < cs[j].ID
+		})
+	}
+	return t
+}
+
+// ByID finds a collection in the tree
+func (t *CollectionTree) ByID(id uint) *Collection {
+	return t.byID[id]
+}
+
+// Children returns the collections in parentID, in order. A
+// parentID of 0 gets the top level collections.
+func (t *CollectionTree) Children(parentID uint) []Collection {
+	ret := make([]Collection, len(t.children[parentID]))
+	for i, c := range t.children[parentID] {
+		ret[i] = *c
+	}
+	return ret
+}
+
+// Path returns the collections leading to id, starting at the top
+// level and ending with id itself, which is what breadcrumbs show.
+func (t *CollectionTree) Path(id uint) []Collection {
+	var ret []Collection
+	// the length check stops at cycles, which validation prevents
+	for c := t.byID[id]; c != nil && len(ret) <= len(t.byID); c = t.byID[c.ParentID] {
+		ret = append([]Collection{*c}, ret...)
+	}
+	return ret
+}
+
+// Visible reports whether the collection and every one it is in
+// are public
+func (t *CollectionTree) Visible(id uint) bool {
+	path := t.Path(id)
+	for i := range path {
+		if !path[i].Public() {
+			return false
+		}
+	}
+	return len(path) > 0
+}
+
+// Depth is how many collections deep id is, 1 at the top level
+func (t *CollectionTree) Depth(id uint) int {
+	return len(t.Path(id))
+}
+
+// Height is how many levels of collections id holds, counting
+// itself
+func (t *CollectionTree) Height(id uint) int {
+	h := 0
+	for _, c := range t.children[id] {
+		if ch := t.Height(c.ID); ch > h {
+			h = ch
+		}
+	}
+	return h + 1
+}
+
+// Contains reports whether id is ancestorID, or nested somewhere in
+// it
+func (t *CollectionTree) Contains(ancestorID, id uint) bool {
+	for _, c := range t.Path(id) {
+		if c.ID == ancestorID {
+			return true
+		}
+	}
+	return false
+}
+
+// CollectionOption is a collection listed in a select, indented by
+// its depth
+type CollectionOption struct {
+	ID    uint
+	Title string
+	Depth int
+}
+
+// Label is the title indented by the depth of the collection
+func (o CollectionOption) Label() string {
+	return strings.Repeat("\u2014 ", o.Depth) + o.Title
+}
+
+// Options lists every collection depth first, in order, for
+// picking one from a select
+func (t *CollectionTree) Options() []CollectionOption {
+	var ret []CollectionOption
+	var walk func(parentID uint, depth int)
+	walk = func(parentID uint, depth int) {
+		for _, c := range t.children[parentID] {
+			ret = append(ret, CollectionOption{ID: c.ID, Title: c.Title, Depth: depth})
+			walk(c.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+	return ret
+}
+
+// CollectionDB is used to interact with the collections table
+type CollectionDB interface {
+	ByID(id uint) (*Collection, error)
+	// ByUserID gets every collection of a user, at any depth
+	ByUserID(userID uint) ([]Collection, error)
+
+	Create(collection *Collection) error
+	Update(collection *Collection) error
+	// Delete removes a collection for good, anything in it should be
+	// moved out first
+	Delete(id uint) error
+}
+
+// CollectionService groups galleries into nested collections
+type CollectionService interface {
+	CollectionDB
+	// Reorder sets the order of the collections in parentID to the
+	// order of ids, which has to name each of them once
+	Reorder(userID, parentID uint, ids []uint) error
+}
+
+// NewCollectionService handles DB connection
+func NewCollectionService(db *gorm.DB) CollectionService {
+	return NewCollectionServiceFromDB(&collectionGorm{db})
+}
+
+// NewCollectionServiceFromDB builds a CollectionService on top of
+// any CollectionDB implementation, wrapping it in our validation.
+func NewCollectionServiceFromDB(cdb CollectionDB) CollectionService {
+	return &collectionService{
+		CollectionDB: &collectionValidator{cdb},
+	}
+}
+
+type collectionService struct {
+	CollectionDB
+}
+
+func (cs *collectionService) Reorder(userID, parentID uint, ids []uint) error {
+	collections, err := cs.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	siblings := NewCollectionTree(collections).Children(parentID)
+	if len(ids) != len(siblings) {
+		return ErrCollectionOrderInvalid
+	}
+	byID := make(map[uint]*Collection, len(siblings))
+	for i := range siblings {
+		byID[siblings[i].ID] = &siblings[i]
+	}
+	for _, id := range ids {
+		if byID[id] == nil {
+			return ErrCollectionOrderInvalid
+		}
+	}
+	for i, id := range ids {
+		c := byID[id]
+		if c.Position == i+1 {
+			continue
+		}
+		c.Position = i + 1
+		if err := cs.Update(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type collectionValFunc func(*Collection) error
+
+func runCollectionValFuncs(collection *Collection, fns ...collectionValFunc) error {
+	for _, fn := range fns {
+		if err := fn(collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * validators
+type collectionValidator struct {
+	CollectionDB
+}
+
+// Create validator for collections
+func (cv *collectionValidator) Create(collection *Collection) error {
+	err := runCollectionValFuncs(collection,
+		cv.userIDRequired,
+		cv.titleRequired,
+		cv.defaultVisibility,
+		cv.visibilityValid,
+		cv.defaultSort,
+		cv.sortValid,
+		cv.coverValid,
+		cv.parentValid,
+		cv.positionAtEnd)
+	if err != nil {
+		return err
+	}
+	return cv.CollectionDB.Create(collection)
+}
+
+// Update validator for collections
+func (cv *collectionValidator) Update(collection *Collection) error {
+	err := runCollectionValFuncs(collection,
+		cv.userIDRequired,
+		cv.titleRequired,
+		cv.visibilityValid,
+		cv.sortValid,
+		cv.coverValid,
+		cv.parentValid,
+		cv.positionWhenMoved)
+	if err != nil {
+		return err
+	}
+	return cv.CollectionDB.Update(collection)
+}
+
+// Delete validator for collections
+func (cv *collectionValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return cv.CollectionDB.Delete(id)
+}
+
+func (cv *collectionValidator) userIDRequired(c *Collection) error {
+	if c.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (cv *collectionValidator) titleRequired(c *Collection) error {
+	c.Title = strings.TrimSpace(c.Title)
+	if c.Title == "" {
+		return ErrCollectionTitleRequired
+	}
+	return nil
+}
+
+// defaultVisibility keeps new collections private unless asked
+// otherwise
+func (cv *collectionValidator) defaultVisibility(c *Collection) error {
+	if c.Visibility == "" {
+		c.Visibility = VisibilityPrivate
+	}
+	return nil
+}
+
+func (cv *collectionValidator) visibilityValid(c *Collection) error {
+	switch c.Visibility {
+	case VisibilityPrivate, VisibilityPublic:
+		return nil
+	}
+	return ErrVisibilityInvalid
+}
+
+func (cv *collectionValidator) defaultSort(c *Collection) error {
+	if c.Sort == "" {
+		c.Sort = CollectionSortNewest
+	}
+	return nil
+}
+
+func (cv *collectionValidator) sortValid(c *Collection) error {
+	switch c.Sort {
+	case CollectionSortNewest, CollectionSortOldest, CollectionSortTitle, CollectionSortDate:
+		return nil
+	}
+	return ErrCollectionSortInvalid
+}
+
+// coverValid makes sure the cover is one of the collection's
+// galleries. It can only check when the galleries were loaded.
+func (cv *collectionValidator) coverValid(c *Collection) error {
+	if c.CoverGalleryID == 0 || c.Galleries == nil {
+		return nil
+	}
+	for _, g := range c.Galleries {
+		if g.ID == c.CoverGalleryID {
+			return nil
+		}
+	}
+	return ErrCollectionCoverInvalid
+}
+
+// parentValid makes sure the parent belongs to the same user, isn't
+// the collection itself or one nested in it, and that the
+// collection and everything in it still fit within
+// MaxCollectionDepth once moved there
+func (cv *collectionValidator) parentValid(c *Collection) error {
+	if c.ParentID == 0 {
+		return nil
+	}
+	collections, err := cv.ByUserID(c.UserID)
+	if err != nil {
+		return err
+	}
+	tree := NewCollectionTree(collections)
+	if tree.ByID(c.ParentID) == nil {
+		return ErrParentInvalid
+	}
+	height := 1
+	if c.ID != 0 {
+		if tree.Contains(c.ID, c.ParentID) {
+			return ErrParentInvalid
+		}
+		height = tree.Height(c.ID)
+	}
+	if tree.Depth(c.ParentID)+height > MaxCollectionDepth {
+		return ErrCollectionTooDeep
+	}
+	return nil
+}
+
+// positionAtEnd puts a new collection after the others in its
+// parent
+func (cv *collectionValidator) positionAtEnd(c *Collection) error {
+	collections, err := cv.ByUserID(c.UserID)
+	if err != nil {
+		return err
+	}
+	c.Position = 1
+	for _, other := range collections {
+		if other.ParentID == c.ParentID && other.ID != c.ID && other.Position >= c.Position {
+			c.Position = other.Position + 1
+		}
+	}
+	return nil
+}
+
+// positionWhenMoved puts a collection that moved to another parent
+// after the ones already there
+func (cv *collectionValidator) positionWhenMoved(c *Collection) error {
+	existing, err := cv.ByID(c.ID)
+	if err != nil {
+		return err
+	}
+	if existing.ParentID == c.ParentID {
+		return nil
+	}
+	return cv.positionAtEnd(c)
+}
+
+var _ CollectionDB = &collectionGorm{}
+
+type collectionGorm struct {
+	db *gorm.DB
+}
+
+// ByID gets a collection by its ID
+func (cg *collectionGorm) ByID(id uint) (*Collection, error) {
+	var collection Collection
+	db := cg.db.Where("id = ?", id)
+	err := first(db, &collection)
+	return &collection, err
+}
+
+// ByUserID gets every collection of a user
+func (cg *collectionGorm) ByUserID(userID uint) ([]Collection, error) {
+	var collections []Collection
+	err := cg.db.Where("user_id = ?", userID).Order("position, id").Find(&collections).Error
+	if err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// Create func creates a new collection in the database
+func (cg *collectionGorm) Create(collection *Collection) error {
+	return cg.db.Create(collection).Error
+}
+
+// Update func updates a collection in the database
+func (cg *collectionGorm) Update(collection *Collection) error {
+	return cg.db.Save(collection).Error
+}
+
+// Delete removes the collection row for good
+func (cg *collectionGorm) Delete(id uint) error {
+	collection := Collection{Model: gorm.Model{ID: id}}
+	return cg.db.Unscoped().Delete(&collection).Error
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// createCollections creates a chain of collections for user 1, each
+// inside the one before it
+func createCollections(t *testing.T, cs CollectionService, titles ...string) []Collection {
+	t.Helper()
+	var ret []Collection
+	var parentID uint
+	for _, title := range titles {
+		c := Collection{UserID: 1, ParentID: parentID, Title: title}
+		if err := cs.Create(&c); err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, c)
+		parentID = c.ID
+	}
+	return ret
+}
+
+// TestCollectionNesting checks collections can't be moved into
+// themselves, someone else's collections or too deep
+func TestCollectionNesting(t *testing.T) {
+	cs := testingServices(t).Collection
+	chain := createCollections(t, cs, "Family", "Weddings", "2019")
+	if chain[1].Visibility != VisibilityPrivate || chain[1].Sort != CollectionSortNewest {
+		t.Errorf("Expected private collections sorted newest first by default, received %+v", chain[1])
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(c *Collection)
+		want   error
+	}{
+		{"into itself", func(c *Collection) { c.ParentID = c.ID }, ErrParentInvalid},
+		{"into a child", func(c *Collection) { c.ParentID = chain[2].ID }, ErrParentInvalid},
+		{"into a missing collection", func(c *Collection) { c.ParentID = 99 }, ErrParentInvalid},
+		{"someone else's", func(c *Collection) { c.UserID = 2 }, ErrParentInvalid},
+		{"no title", func(c *Collection) { c.Title = " " }, ErrCollectionTitleRequired},
+		{"unknown visibility", func(c *Collection) { c.Visibility = "friends" }, ErrVisibilityInvalid},
+		{"unknown sort", func(c *Collection) { c.Sort = "random" }, ErrCollectionSortInvalid},
+		{"cover elsewhere", func(c *Collection) {
+			c.CoverGalleryID = 5
+			c.Galleries = []Gallery{{Model: gorm.Model{ID: 4}}}
+		}, ErrCollectionCoverInvalid},
+	} {
+		c := chain[1]
+		tc.change(&c)
+		if err := cs.Update(&c); err != tc.want {
+			t.Errorf("%s: expected %v, received %v", tc.name, tc.want, err)
+		}
+	}
+
+	// Family > Weddings > 2019 > June fits, but moving Weddings into
+	// a 3 deep collection would nest June 6 deep
+	june := createCollections(t, cs, "June")[0]
+	june.ParentID = chain[2].ID
+	if err := cs.Update(&june); err != nil {
+		t.Fatal(err)
+	}
+	other := createCollections(t, cs, "Work", "Trips", "Abroad")
+	moved := chain[1]
+	moved.ParentID = other[2].ID
+	if err := cs.Update(&moved); err != ErrCollectionTooDeep {
+		t.Errorf("Expected ErrCollectionTooDeep, received %v", err)
+	}
+	moved.ParentID = other[0].ID
+	if err := cs.Update(&moved); err != nil {
+		t.Errorf("Expected the collection to move, received %v", err)
+	}
+	found, _ := cs.ByID(moved.ID)
+	if found.ParentID != other[0].ID || found.Position != 2 {
+		t.Errorf("Expected the collection at the end of its new parent, received %+v", found)
+	}
+}
+
+func TestCollectionReorder(t *testing.T) {
+	cs := testingServices(t).Collection
+	var ids []uint
+	for _, title := range []string{"A", "B", "C"} {
+		ids = append(ids, createCollections(t, cs, title)[0].ID)
+	}
+	if err := cs.Reorder(1, 0, []uint{ids[2], ids[0], ids[1]}); err != nil {
+		t.Fatal(err)
+	}
+	collections, _ := cs.ByUserID(1)
+	tree := NewCollectionTree(collections)
+	children := tree.Children(0)
+	if children[0].Title != "C" || children[1].Title != "A" || children[2].Title != "B" {
+		t.Errorf("Expected the new order, received %v", children)
+	}
+	if err := cs.Reorder(1, 0, []uint{ids[0], ids[1]}); err != ErrCollectionOrderInvalid {
+		t.Errorf("Expected ErrCollectionOrderInvalid, received %v", err)
+	}
+	if err := cs.Reorder(2, 0, ids); err != ErrCollectionOrderInvalid {
+		t.Errorf("Expected someone else's collections to be refused, received %v", err)
+	}
+}
+
+func TestCollectionTree(t *testing.T) {
+	collections := []Collection{
+		{Model: gorm.Model{ID: 1}, Title: "Family", Visibility: VisibilityPublic},
+		{Model: gorm.Model{ID: 2}, ParentID: 1, Title: "Weddings", Visibility: VisibilityPrivate, Position: 2},
+		{Model: gorm.Model{ID: 3}, ParentID: 1, Title: "Birthdays", Visibility: VisibilityPublic, Position: 1},
+		{Model: gorm.Model{ID: 4}, ParentID: 2, Title: "2019", Visibility: VisibilityPublic},
+	}
+	tree := NewCollectionTree(collections)
+	path := tree.Path(4)
+	if len(path) != 3 || path[0].Title != "Family" || path[2].Title != "2019" {
+		t.Errorf("Expected the path from the top level, received %v", path)
+	}
+	if !tree.Visible(3) || tree.Visible(4) || tree.Visible(99) {
+		t.Errorf("Expected collections in private ones to be hidden")
+	}
+	if tree.Height(1) != 3 || tree.Depth(4) != 3 {
+		t.Errorf("Expected a height of 3 and depth of 3, received %d and %d", tree.Height(1), tree.Depth(4))
+	}
+	var labels []string
+	for _, opt := range tree.Options() {
+		labels = append(labels, opt.Label())
+	}
+	want := []string{"Family", "— Birthdays", "— Weddings", "— — 2019"}
+	if len(labels) != len(want) {
+		t.Fatalf("Expected %v, received %v", want, labels)
+	}
+	for i := range want {
+		if labels[i] != want[i] {
+			t.Errorf("Expected %v, received %v", want, labels)
+		}
+	}
+}
+
+func TestCollectionSortGalleries(t *testing.T) {
+	now := time.Now()
+	old := now.AddDate(-1, 0, 0)
+	galleries := []Gallery{
+		{Model: gorm.Model{ID: 1, CreatedAt: old}, Title: "beach"},
+		{Model: gorm.Model{ID: 2, CreatedAt: now}, Title: "Attic", EventDate: &old},
+		{Model: gorm.Model{ID: 3, CreatedAt: now.Add(-time.Hour)}, Title: "cabin", EventDate: &now},
+	}
+	for sort, want := range map[string][]uint{
+		CollectionSortNewest: {2, 3, 1},
+		CollectionSortOldest: {1, 3, 2},
+		CollectionSortTitle:  {2, 1, 3},
+		CollectionSortDate:   {3, 2, 1},
+	} {
+		c := Collection{Sort: sort}
+		c.SortGalleries(galleries)
+		for i := range want {
+			if galleries[i].ID != want[i] {
+				t.Errorf("%s: expected %v, received %v", sort, want, galleries)
+				break
+			}
+		}
+	}
+}
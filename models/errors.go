@@ -34,6 +34,193 @@ const (
 	// ErrTitleRequired is returned when a title is not added to a gallery
 	ErrTitleRequired modelError = "models: gallery title is required"
 
+	// ErrDescriptionTooLong is returned when a gallery description
+	// is longer than MaxDescriptionLength
+	ErrDescriptionTooLong modelError = "models: gallery descriptions can be at most 5000 characters long"
+
+	// ErrLocationTooLong is returned when a gallery location is
+	// longer than MaxLocationLength
+	ErrLocationTooLong modelError = "models: gallery locations can be at most 200 characters long"
+
+	// ErrEventDateInvalid is returned when a gallery's event date
+	// can't be parsed or is implausible
+	ErrEventDateInvalid modelError = "models: event date must be a real date, no more than a year from now"
+
+	// ErrCoverInvalid is returned when the cover picked for a
+	// gallery isn't one of its images
+	ErrCoverInvalid modelError = "models: the cover must be one of the gallery's images"
+
+	// ErrCollectionTitleRequired is returned when a collection has
+	// no title
+	ErrCollectionTitleRequired modelError = "models: collection title is required"
+
+	// ErrVisibilityInvalid is returned when a collection or gallery
+	// is neither public nor private
+	ErrVisibilityInvalid modelError = "models: visibility must be public or private"
+
+	// ErrCollectionSortInvalid is returned when a collection asks for
+	// an order we don't know
+	ErrCollectionSortInvalid modelError = "models: galleries can be sorted by newest, oldest, title or date"
+
+	// ErrCollectionCoverInvalid is returned when the cover picked for
+	// a collection isn't one of its galleries
+	ErrCollectionCoverInvalid modelError = "models: the cover must be one of the collection's galleries"
+
+	// ErrParentInvalid is returned when a collection is moved into
+	// itself, one of the collections in it or someone else's
+	ErrParentInvalid modelError = "models: a collection can't be moved into itself or one of its own collections"
+
+	// ErrCollectionTooDeep is returned when nesting collections
+	// deeper than MaxCollectionDepth
+	ErrCollectionTooDeep modelError = "models: collections can be nested at most 5 deep"
+
+	// ErrCollectionOrderInvalid is returned when reordering
+	// collections without naming each of them once
+	ErrCollectionOrderInvalid modelError = "models: the collections changed while you were reordering them, please try again"
+
+	// ErrNoImagesSelected is returned when acting on the selected
+	// images of a gallery without selecting any
+	ErrNoImagesSelected modelError = "models: select at least one image first"
+	// ErrSameGallery is returned when moving or copying images into
+	// the gallery they are already in
+	ErrSameGallery modelError = "models: pick another gallery, the images are already in this one"
+	// ErrImageNameTaken is returned when moving or copying an image
+	// into a gallery with an image of the same name
+	ErrImageNameTaken modelError = "models: the other gallery already has an image with the same name as one you picked"
+	// ErrRestoreNameTaken is returned when restoring an image into a
+	// gallery that has a new image with the same name
+	ErrRestoreNameTaken modelError = "models: the gallery has a newer image with the same name, rename or delete it before restoring this one"
+	// ErrStorageQuotaExceeded is returned when storing an image
+	// would take its owner over their plan's storage
+	ErrStorageQuotaExceeded modelError = "models: that would take you over the storage your plan allows, delete some images and empty the trash to make room"
+	// ErrImageQuotaExceeded is returned when storing an image would
+	// take its owner over the number of images their plan allows
+	ErrImageQuotaExceeded modelError = "models: your plan can't hold any more images, delete some and empty the trash to make room"
+
+	// ErrCoverNeedsOneImage is returned when making more or less
+	// than one image the cover
+	ErrCoverNeedsOneImage modelError = "models: pick a single image to use as the cover"
+
+	// ErrGallerySortInvalid is returned when listing galleries in
+	// an order we don't know
+	ErrGallerySortInvalid modelError = "models: galleries can be sorted by created, updated, title or images"
+	// ErrSortOrderInvalid is returned when a sort order is neither
+	// ascending nor descending
+	ErrSortOrderInvalid modelError = "models: the order must be asc or desc"
+	// ErrCursorInvalid is returned for a page cursor we didn't
+	// hand out, or one from a differently sorted list
+	ErrCursorInvalid modelError = "models: that page link is no longer valid, please start again from the first page"
+
+	// ErrNameRequired is returned when an API token is created
+	// without a name
+	ErrNameRequired modelError = "models: token name is required"
+
+	// ErrScopeInvalid is returned when an API token is created
+	// with a scope we don't know about
+	ErrScopeInvalid modelError = "models: token scope is not valid"
+
+	// ErrAppNameRequired is returned when an OAuth app is
+	// registered without a name
+	ErrAppNameRequired modelError = "models: app name is required"
+
+	// ErrRedirectURIInvalid is returned when an OAuth app is
+	// registered without redirect URIs, or with one that is not an
+	// absolute https URL (http is only allowed for localhost)
+	ErrRedirectURIInvalid modelError = "models: redirect URIs must be absolute https URLs, or http URLs on localhost"
+
+	// ErrClientInvalid is returned when an OAuth client can't be
+	// found or its secret is wrong
+	ErrClientInvalid modelError = "models: client is not valid"
+
+	// ErrGrantInvalid is returned when an authorization code or
+	// refresh token is unknown, expired, already used, was issued
+	// to another client or fails the PKCE check
+	ErrGrantInvalid modelError = "models: authorization grant is invalid, expired or revoked"
+
+	// ErrCodeChallengeInvalid is returned when a public client
+	// doesn't use PKCE, or uses a method other than S256
+	ErrCodeChallengeInvalid modelError = "models: a S256 code challenge is required"
+
+	// ErrPasswordNotSet is returned when logging in with a password
+	// to an account that was created through an external provider
+	ErrPasswordNotSet modelError = "models: this account has no password yet, sign in with the provider you signed up with or reset your password"
+
+	// ErrEmailNotVerified is returned when an external provider
+	// can't vouch for the email address of a new user
+	ErrEmailNotVerified modelError = "models: your email address is not verified with that provider"
+
+	// ErrIdentityTaken is returned when linking an external account
+	// that is already linked to another user
+	ErrIdentityTaken modelError = "models: that account is already connected to another user"
+
+	// ErrLastSignInMethod is returned when a user without a password
+	// tries to unlink the last external account they can sign in with
+	ErrLastSignInMethod modelError = "models: set a password before disconnecting your last sign in method"
+
+	// ErrRoleInvalid is returned when a user is given a role we
+	// don't know
+	ErrRoleInvalid modelError = "models: role is not valid"
+
+	// ErrPlanInvalid is returned when a user is put on a plan we
+	// don't offer
+	ErrPlanInvalid modelError = "models: plan is not valid"
+
+	// ErrAccountDisabled is returned when a disabled user tries to
+	// sign in
+	ErrAccountDisabled modelError = "models: this account has been disabled, contact support if you think this is a mistake"
+
+	// ErrPasswordResetRequired is returned when a user has to reset
+	// their password before signing in again
+	ErrPasswordResetRequired modelError = "models: you need to reset your password before logging in, check your email for instructions"
+
+	// ErrDeletionScheduled is returned when asking to delete an
+	// account that is already going to be deleted
+	ErrDeletionScheduled modelError = "models: your account is already scheduled for deletion"
+
+	// ErrImageFilenameInvalid is returned when an image's name
+	// includes a directory or is hidden
+	ErrImageFilenameInvalid modelError = "models: image file names can't include folders or start with a dot"
+
+	// ErrImageTypeInvalid is returned when uploading a file that
+	// isn't one of the kinds of images we accept
+	ErrImageTypeInvalid modelError = "models: only jpg, jpeg and png images can be uploaded"
+
+	// ErrCaptionTooLong is returned when an image caption is longer
+	// than MaxCaptionLength
+	ErrCaptionTooLong modelError = "models: captions can be at most 1000 characters long"
+
+	// ErrAltTextTooLong is returned when an image's alt text is
+	// longer than MaxAltTextLength
+	ErrAltTextTooLong modelError = "models: alt text can be at most 250 characters long, use the caption for longer descriptions"
+
+	// ErrTooManyTags is returned when a gallery or image is given
+	// more tags than MaxTags
+	ErrTooManyTags modelError = "models: there can be at most 50 tags"
+
+	// ErrTagTooLong is returned when a tag is longer than
+	// MaxTagLength
+	ErrTagTooLong modelError = "models: tags can be at most 50 characters long"
+
+	// ErrSearchDateInvalid is returned when a date searched from or
+	// to isn't written as YYYY-MM-DD
+	ErrSearchDateInvalid modelError = "models: dates must be written as YYYY-MM-DD"
+
+	// ErrSearchRangeInvalid is returned when searching from a date
+	// after the one searched to
+	ErrSearchRangeInvalid modelError = "models: the start of the date range must be before its end"
+
+	// ErrImageOrderInvalid is returned when reordering a gallery
+	// without naming each of its images once
+	ErrImageOrderInvalid modelError = "models: the gallery changed while you were reordering it, please try again"
+
+	// ErrUploadLengthInvalid is returned when an upload is empty or
+	// larger than MaxUploadLength
+	ErrUploadLengthInvalid modelError = "models: images must be between 1 byte and 200MB"
+
+	// ErrExportPending is returned when asking for an export while
+	// the previous one is still being built
+	ErrExportPending modelError = "models: your previous export is still being prepared, we will email you when it is ready"
+
 	// ErrInvalidID is returned when an invalid ID is provided
 	// to a method like Delete.
 	ErrInvalidID privateError = "models: ID provided was invalid"
@@ -49,6 +236,38 @@ const (
 	// ErrUserIDRequired is returned when a user ID is not passed in for gallery creation
 	ErrUserIDRequired privateError = "models: user ID is required"
 
+	// ErrPurgeAfterRequired is returned when an account deletion
+	// isn't given a time to purge the account
+	ErrPurgeAfterRequired privateError = "models: purge after is required"
+
+	// ErrExportStatusInvalid is returned when an export has a
+	// status we don't know
+	ErrExportStatusInvalid privateError = "models: export status is not valid"
+
+	// ErrGalleryIDRequired is returned when something that belongs
+	// to a gallery isn't given one
+	ErrGalleryIDRequired privateError = "models: gallery ID is required"
+
+	// ErrUploadOffsetInvalid is returned when an upload's offset is
+	// outside of its length
+	ErrUploadOffsetInvalid privateError = "models: upload offset is not valid"
+
+	// ErrExpiresAtRequired is returned when something that expires
+	// isn't given a time to
+	ErrExpiresAtRequired privateError = "models: expires at is required"
+
+	// ErrActionRequired is returned when recording an audit event
+	// without saying what happened
+	ErrActionRequired privateError = "models: audit action is required"
+
+	// ErrDetailsInvalid is returned when the details of an audit
+	// event aren't a JSON object
+	ErrDetailsInvalid privateError = "models: audit details must be a JSON object"
+
+	// ErrIdentityRequired is returned when an external identity is
+	// missing its provider or subject
+	ErrIdentityRequired privateError = "models: identity provider and subject are required"
+
 	// ErrTokenInvalid const for invalid token errors
 	ErrTokenInvalid modelError = "models: token provided is not valid"
 )
@@ -0,0 +1,268 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sajicode/go-photo/hash"
+	"github.com/sajicode/go-photo/rand"
+)
+
+// Export statuses. An export is pending until the archive is
+// built, then ready until it expires.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+)
+
+// DefaultExportExpiry is how long an export can be downloaded for
+const DefaultExportExpiry = 48 * time.Hour
+
+// Export is a ZIP archive of everything a user has stored with us,
+// built in the background when they ask for it. It is downloaded
+// with a token we email them, of which only the hash is stored.
+type Export struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index"`
+	Status    string `gorm:"not null;index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"index"`
+	// Path is where the archive is stored once it is ready
+	Path      string
+	Size      int64
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+// Downloadable reports whether the archive can be downloaded at t
+func (e *Export) Downloadable(t time.Time) bool {
+	return e.Status == ExportReady && e.ExpiresAt != nil && t.Before(*e.ExpiresAt)
+}
+
+// ExportDB is used to interact with the exports table. ByToken
+// expects the token to already be hashed.
+type ExportDB interface {
+	ByToken(tokenHash string) (*Export, error)
+	// ByUserID gets the exports of a user, most recent first
+	ByUserID(userID uint) ([]Export, error)
+	// Pending gets the exports waiting to be built, oldest first
+	Pending() ([]Export, error)
+	// Expiring gets the ready exports that expired before t
+	Expiring(t time.Time) ([]Export, error)
+
+	Create(export *Export) error
+	Update(export *Export) error
+	// DeleteByUserID removes every export of a user for good
+	DeleteByUserID(userID uint) error
+}
+
+// ExportService requests exports and hands out their download
+// tokens
+type ExportService interface {
+	// Request queues a new export for the user, returning
+	// ErrExportPending if one is already being built
+	Request(userID uint) (*Export, error)
+	// Finish marks export as ready to download from path until
+	// expiresAt, setting a new download token on it
+	Finish(export *Export, path string, size int64, expiresAt time.Time) error
+	ExportDB
+}
+
+// NewExportService handles DB connection
+func NewExportService(db *gorm.DB, hmacKey string) ExportService {
+	return NewExportServiceFromDB(&exportGorm{db}, hmacKey)
+}
+
+// NewExportServiceFromDB builds an ExportService on top of any
+// ExportDB implementation, wrapping it in our validation.
+func NewExportServiceFromDB(edb ExportDB, hmacKey string) ExportService {
+	return &exportService{
+		ExportDB: &exportValidator{
+			ExportDB: edb,
+			hmac:     hash.NewHMAC(hmacKey),
+		},
+	}
+}
+
+type exportService struct {
+	ExportDB
+}
+
+// Request creates a pending export
+func (es *exportService) Request(userID uint) (*Export, error) {
+	exports, err := es.ByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range exports {
+		if e.Status == ExportPending {
+			return nil, ErrExportPending
+		}
+	}
+	export := Export{
+		UserID: userID,
+		Status: ExportPending,
+	}
+	if err := es.Create(&export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Finish stores the archive details and a fresh download token
+func (es *exportService) Finish(export *Export, path string, size int64, expiresAt time.Time) error {
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	export.Token = token
+	export.Status = ExportReady
+	export.Path = path
+	export.Size = size
+	export.ExpiresAt = &expiresAt
+	return es.Update(export)
+}
+
+type exportValFunc func(*Export) error
+
+func runExportValFuncs(export *Export, fns ...exportValFunc) error {
+	for _, fn := range fns {
+		if err := fn(export); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * validators
+type exportValidator struct {
+	ExportDB
+	// hmac is shared by the controllers and the export job, so it
+	// is locked while in use
+	mu   sync.Mutex
+	hmac hash.HMAC
+}
+
+// ByToken hashes the token before looking it up
+func (ev *exportValidator) ByToken(token string) (*Export, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	export := Export{Token: token}
+	if err := runExportValFuncs(&export, ev.hmacToken); err != nil {
+		return nil, err
+	}
+	return ev.ExportDB.ByToken(export.TokenHash)
+}
+
+// Create validator for exports
+func (ev *exportValidator) Create(export *Export) error {
+	err := runExportValFuncs(export,
+		ev.userIDRequired,
+		ev.statusValid,
+		ev.hmacToken)
+	if err != nil {
+		return err
+	}
+	return ev.ExportDB.Create(export)
+}
+
+// Update validator for exports
+func (ev *exportValidator) Update(export *Export) error {
+	err := runExportValFuncs(export,
+		ev.userIDRequired,
+		ev.statusValid,
+		ev.hmacToken)
+	if err != nil {
+		return err
+	}
+	return ev.ExportDB.Update(export)
+}
+
+func (ev *exportValidator) userIDRequired(e *Export) error {
+	if e.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (ev *exportValidator) statusValid(e *Export) error {
+	switch e.Status {
+	case ExportPending, ExportReady, ExportFailed, ExportExpired:
+		return nil
+	}
+	return ErrExportStatusInvalid
+}
+
+func (ev *exportValidator) hmacToken(e *Export) error {
+	if e.Token == "" {
+		return nil
+	}
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	e.TokenHash = ev.hmac.Hash(e.Token)
+	return nil
+}
+
+var _ ExportDB = &exportGorm{}
+
+type exportGorm struct {
+	db *gorm.DB
+}
+
+// ByToken gets an export by the hash of its download token
+func (eg *exportGorm) ByToken(tokenHash string) (*Export, error) {
+	var export Export
+	err := first(eg.db.Where("token_hash = ?", tokenHash), &export)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// ByUserID gets the exports of a user
+func (eg *exportGorm) ByUserID(userID uint) ([]Export, error) {
+	var exports []Export
+	err := eg.db.Where("user_id = ?", userID).Order("id desc").Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// Pending gets the exports waiting to be built
+func (eg *exportGorm) Pending() ([]Export, error) {
+	var exports []Export
+	err := eg.db.Where("status = ?", ExportPending).Order("id").Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// Expiring gets the ready exports that expired before t
+func (eg *exportGorm) Expiring(t time.Time) ([]Export, error) {
+	var exports []Export
+	err := eg.db.Where("status = ? AND expires_at <= ?", ExportReady, t).Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// Create stores a new export
+func (eg *exportGorm) Create(export *Export) error {
+	return eg.db.Create(export).Error
+}
+
+// Update saves an export
+func (eg *exportGorm) Update(export *Export) error {
+	return eg.db.Save(export).Error
+}
+
+// DeleteByUserID removes every export of a user for good
+func (eg *exportGorm) DeleteByUserID(userID uint) error {
+	return eg.db.Unscoped().Where("user_id = ?", userID).Delete(&Export{}).Error
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// TestExportLifecycle checks an export goes from pending to ready
+// and can then be found by its token until it expires
+func TestExportLifecycle(t *testing.T) {
+	es := testingServices(t).Export
+	export, err := es.Request(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := es.Request(1); err != ErrExportPending {
+		t.Errorf("Expected ErrExportPending, received %v", err)
+	}
+	pending, err := es.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != export.ID {
+		t.Fatalf("Expected the export to be pending, received %v", pending)
+	}
+	if _, err := es.ByToken(""); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an empty token, received %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err := es.Finish(export, "exports/export-1.zip", 42, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	found, err := es.ByToken(export.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != export.ID || found.Status != ExportReady || found.Size != 42 {
+		t.Errorf("Expected the ready export, received %+v", found)
+	}
+	if pending, _ := es.Pending(); len(pending) != 0 {
+		t.Errorf("Expected nothing pending, received %v", pending)
+	}
+	if expiring, _ := es.Expiring(time.Now()); len(expiring) != 0 {
+		t.Errorf("Expected nothing to expire yet, received %v", expiring)
+	}
+	if expiring, _ := es.Expiring(expiresAt.Add(time.Minute)); len(expiring) != 1 {
+		t.Errorf("Expected the export to expire, received %v", expiring)
+	}
+	if _, err := es.Request(1); err != nil {
+		t.Errorf("Expected to be able to ask again once ready, received %v", err)
+	}
+}
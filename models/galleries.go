@@ -1,13 +1,104 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"crypto/subtle"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sajicode/go-photo/rand"
+)
+
+const (
+	// MaxDescriptionLength is the most characters a gallery
+	// description can have
+	MaxDescriptionLength = 5000
+	// MaxLocationLength is the most characters a gallery location
+	// can have
+	MaxLocationLength = 200
+)
+
+// earliestEventDate is when the oldest surviving photograph was
+// taken, nothing in a gallery can be older
+var earliestEventDate = time.Date(1826, 1, 1, 0, 0, 0, 0, time.UTC)
 
 // Gallery is our image container resources that visitors view
 type Gallery struct {
 	gorm.Model
-	UserID uint    `gorm:"not_null;index"`
-	Title  string  `gorm:"not_null"`
-	Images []Image `gorm:"-"`
+	UserID uint   `gorm:"not_null;index"`
+	Title  string `gorm:"not_null"`
+	// CollectionID is the collection the gallery is in, 0 for none
+	CollectionID uint `gorm:"not null;default:0;index"`
+	// Description is Markdown written by the owner
+	Description string `gorm:"type:text"`
+	// CoverFilename is the image shown for the gallery, the first
+	// image when empty
+	CoverFilename string
+	// EventDate is the day the photos were taken
+	EventDate *time.Time
+	Location  string
+	// Tags are comma separated, see SplitTags
+	Tags string `gorm:"type:text"`
+	// Visibility is who can see the gallery besides its owner and
+	// those with the share link
+	Visibility string `gorm:"not null;default:'public'"`
+	// ShareToken lets anyone who has it see the gallery, whatever
+	// its visibility. It is empty when the gallery isn't shared.
+	// It is kept as it is so the owner can copy the link again.
+	ShareToken string `gorm:"index"`
+	// ShareDownload lets those with the share link download the
+	// gallery too
+	ShareDownload bool    `gorm:"not null;default:false"`
+	Images        []Image `gorm:"-"`
+}
+
+// Public reports whether the gallery is public. It is only visible
+// to everyone if its collections are too, see Visible.
+func (g *Gallery) Public() bool {
+	return g.Visibility == VisibilityPublic
+}
+
+// Visible reports whether anyone can see the gallery. tree holds
+// the collections of the gallery's owner.
+func (g *Gallery) Visible(tree *CollectionTree) bool {
+	return g.Public() && (g.CollectionID == 0 || tree.Visible(g.CollectionID))
+}
+
+// Share gives the gallery a new share token, so any link shared
+// before stops working
+func (g *Gallery) Share() error {
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	g.ShareToken = token
+	return nil
+}
+
+// SharedWith reports whether token is the gallery's share token
+func (g *Gallery) SharedWith(token string) bool {
+	return g.ShareToken != "" && subtle.ConstantTimeCompare([]byte(g.ShareToken), []byte(token)) == 1
+}
+
+// TagList returns the gallery's tags
+func (g *Gallery) TagList() []string {
+	return SplitTags(g.Tags)
+}
+
+// Cover returns the image shown for the gallery, which is the
+// first one unless the owner picked another. It is nil if the
+// gallery has no images.
+func (g *Gallery) Cover() *Image {
+	for i := range g.Images {
+		if g.Images[i].Filename == g.CoverFilename {
+			return &g.Images[i]
+		}
+	}
+	if len(g.Images) > 0 {
+		return &g.Images[0]
+	}
+	return nil
 }
 
 // ImagesSplitN Splits images acording to size
@@ -23,6 +114,18 @@ func (g *Gallery) ImagesSplitN(n int) [][]Image {
 	return ret
 }
 
+// MissingAltText counts the images without alt text, which screen
+// reader users can't tell apart
+func (g *Gallery) MissingAltText() int {
+	n := 0
+	for _, img := range g.Images {
+		if img.AltText == "" {
+			n++
+		}
+	}
+	return n
+}
+
 // GalleryService interface communicates with the DB
 type GalleryService interface {
 	GalleryDB
@@ -35,12 +138,35 @@ type GalleryDB interface {
 	Update(gallery *Gallery) error
 	Delete(id uint) error
 	ByUserID(id uint) ([]Gallery, error)
+	// ByCollectionID gets the galleries in a collection, but not in
+	// the collections nested in it
+	ByCollectionID(collectionID uint) ([]Gallery, error)
+	// DeletedByUserID gets the galleries of a user that were
+	// deleted but are still stored, in the trash
+	DeletedByUserID(userID uint) ([]Gallery, error)
+	// DeletedBefore gets the galleries of every user deleted before
+	// t
+	DeletedBefore(t time.Time) ([]Gallery, error)
+	// Restore takes a deleted gallery out of the trash
+	Restore(id uint) error
+	// Purge removes a gallery for good, whether or not it was
+	// deleted first
+	Purge(id uint) error
+	// Page gets a page of a user's galleries, sorted and filtered
+	// as the query says
+	Page(q *GalleryQuery) (*GalleryPage, error)
 }
 
 // NewGalleryService tells the db to create a new gallery
 func NewGalleryService(db *gorm.DB) GalleryService {
+	return NewGalleryServiceFromDB(&galleryGorm{db})
+}
+
+// NewGalleryServiceFromDB builds a GalleryService on top of any
+// GalleryDB implementation, wrapping it in our gallery validation.
+func NewGalleryServiceFromDB(gdb GalleryDB) GalleryService {
 	return &galleryService{
-		GalleryDB: &galleryValidator{&galleryGorm{db}},
+		GalleryDB: &galleryValidator{gdb},
 	}
 }
 
@@ -69,7 +195,15 @@ type galleryValidator struct {
 func (gv *galleryValidator) Create(gallery *Gallery) error {
 	err := runGalleryValFuncs(gallery,
 		gv.userIDRequired,
-		gv.titleRequired)
+		gv.titleRequired,
+		gv.normalizeDetails,
+		gv.defaultVisibility,
+		gv.visibilityValid,
+		gv.descriptionLength,
+		gv.locationLength,
+		gv.eventDateValid,
+		gv.tagsValid,
+		gv.coverValid)
 	if err != nil {
 		return err
 	}
@@ -80,7 +214,15 @@ func (gv *galleryValidator) Create(gallery *Gallery) error {
 func (gv *galleryValidator) Update(gallery *Gallery) error {
 	err := runGalleryValFuncs(gallery,
 		gv.userIDRequired,
-		gv.titleRequired)
+		gv.titleRequired,
+		gv.normalizeDetails,
+		gv.defaultVisibility,
+		gv.visibilityValid,
+		gv.descriptionLength,
+		gv.locationLength,
+		gv.eventDateValid,
+		gv.tagsValid,
+		gv.coverValid)
 	if err != nil {
 		return err
 	}
@@ -96,6 +238,23 @@ func (gv *galleryValidator) Delete(id uint) error {
 	return gv.GalleryDB.Delete(id)
 }
 
+// ByCollectionID refuses collection 0, which would be every
+// gallery that isn't in a collection, whoever it belongs to
+func (gv *galleryValidator) ByCollectionID(collectionID uint) ([]Gallery, error) {
+	if collectionID <= 0 {
+		return nil, ErrInvalidID
+	}
+	return gv.GalleryDB.ByCollectionID(collectionID)
+}
+
+// Purge validates the ID before removing the gallery for good
+func (gv *galleryValidator) Purge(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return gv.GalleryDB.Purge(id)
+}
+
 // userIDRequired makes sure a userid is available while creating a gallery
 func (gv *galleryValidator) userIDRequired(g *Gallery) error {
 	if g.UserID <= 0 {
@@ -112,6 +271,87 @@ func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	return nil
 }
 
+// normalizeDetails trims the description and location, and drops
+// the time of day from the event date
+func (gv *galleryValidator) normalizeDetails(g *Gallery) error {
+	g.Description = strings.TrimSpace(g.Description)
+	g.Location = strings.TrimSpace(g.Location)
+	if g.EventDate != nil {
+		y, m, d := g.EventDate.Date()
+		date := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		g.EventDate = &date
+	}
+	return nil
+}
+
+// defaultVisibility keeps galleries public unless asked otherwise,
+// as they were before they had a visibility
+func (gv *galleryValidator) defaultVisibility(g *Gallery) error {
+	if g.Visibility == "" {
+		g.Visibility = VisibilityPublic
+	}
+	return nil
+}
+
+func (gv *galleryValidator) visibilityValid(g *Gallery) error {
+	switch g.Visibility {
+	case VisibilityPrivate, VisibilityPublic:
+		return nil
+	}
+	return ErrVisibilityInvalid
+}
+
+func (gv *galleryValidator) descriptionLength(g *Gallery) error {
+	if utf8.RuneCountInString(g.Description) > MaxDescriptionLength {
+		return ErrDescriptionTooLong
+	}
+	return nil
+}
+
+func (gv *galleryValidator) locationLength(g *Gallery) error {
+	if utf8.RuneCountInString(g.Location) > MaxLocationLength {
+		return ErrLocationTooLong
+	}
+	return nil
+}
+
+// eventDateValid rejects dates before photography existed and more
+// than a year from now, which are almost certainly typos
+func (gv *galleryValidator) eventDateValid(g *Gallery) error {
+	if g.EventDate == nil {
+		return nil
+	}
+	if g.EventDate.Before(earliestEventDate) || g.EventDate.After(time.Now().AddDate(1, 0, 0)) {
+		return ErrEventDateInvalid
+	}
+	return nil
+}
+
+func (gv *galleryValidator) tagsValid(g *Gallery) error {
+	return normalizeTags(&g.Tags)
+}
+
+// coverValid makes sure the cover is one of the gallery's images.
+// It can only check when the images were loaded, otherwise it
+// just checks the name could be an image.
+func (gv *galleryValidator) coverValid(g *Gallery) error {
+	if g.CoverFilename == "" {
+		return nil
+	}
+	if g.Images == nil {
+		if ValidateImageFilename(g.CoverFilename) != nil {
+			return ErrCoverInvalid
+		}
+		return nil
+	}
+	for _, img := range g.Images {
+		if img.Filename == g.CoverFilename {
+			return nil
+		}
+	}
+	return ErrCoverInvalid
+}
+
 var _ GalleryDB = &galleryGorm{}
 
 type galleryGorm struct {
@@ -151,3 +391,31 @@ func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
 	}
 	return galleries, nil
 }
+
+// ByCollectionID gets the galleries in a collection
+func (gg *galleryGorm) ByCollectionID(collectionID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Where("collection_id = ?", collectionID).Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+// DeletedByUserID gets the soft deleted galleries of a user, the
+// latest deleted first
+func (gg *galleryGorm) DeletedByUserID(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+// Purge deletes the gallery row, including soft deleted ones
+func (gg *galleryGorm) Purge(id uint) error {
+	gallery := Gallery{Model: gorm.Model{ID: id}}
+	return gg.db.Unscoped().Delete(&gallery).Error
+}
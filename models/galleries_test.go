@@ -0,0 +1,210 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGalleryByUserID makes sure galleries are scoped to their owner
+func TestGalleryByUserID(t *testing.T) {
+	gs := testingServices(t).Gallery
+	for _, g := range []Gallery{
+		{UserID: 1, Title: "Wedding"},
+		{UserID: 1, Title: "Holiday"},
+		{UserID: 2, Title: "Someone else's"},
+	} {
+		g := g
+		if err := gs.Create(&g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	galleries, err := gs.ByUserID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(galleries) != 2 {
+		t.Errorf("Expected 2 galleries, received %d", len(galleries))
+	}
+	if err := gs.Create(&Gallery{UserID: 1}); err != ErrTitleRequired {
+		t.Errorf("Expected ErrTitleRequired, received %v", err)
+	}
+}
+
+func TestGalleryByCollectionID(t *testing.T) {
+	gs := testingServices(t).Gallery
+	for _, g := range []Gallery{
+		{UserID: 1, Title: "Wedding", CollectionID: 1},
+		{UserID: 1, Title: "Holiday"},
+		{UserID: 2, Title: "Someone else's"},
+	} {
+		g := g
+		if err := gs.Create(&g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	galleries, err := gs.ByCollectionID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(galleries) != 1 || galleries[0].Title != "Wedding" {
+		t.Errorf("Expected the gallery in the collection, received %v", galleries)
+	}
+	// 0 would be everyone's galleries that aren't in a collection
+	if _, err := gs.ByCollectionID(0); err != ErrInvalidID {
+		t.Errorf("Expected ErrInvalidID, received %v", err)
+	}
+}
+
+func TestGalleryDetailsValidation(t *testing.T) {
+	gs := testingServices(t).Gallery
+	future := time.Now().AddDate(2, 0, 0)
+	ancient := time.Date(1800, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		gallery Gallery
+		err     error
+	}{
+		{"long description", Gallery{Description: strings.Repeat("a", MaxDescriptionLength+1)}, ErrDescriptionTooLong},
+		{"long location", Gallery{Location: strings.Repeat("a", MaxLocationLength+1)}, ErrLocationTooLong},
+		{"future date", Gallery{EventDate: &future}, ErrEventDateInvalid},
+		{"ancient date", Gallery{EventDate: &ancient}, ErrEventDateInvalid},
+		{"cover not in gallery", Gallery{CoverFilename: "a.jpg", Images: []Image{{Filename: "b.jpg"}}}, ErrCoverInvalid},
+		{"cover not an image", Gallery{CoverFilename: "../a.jpg"}, ErrCoverInvalid},
+	} {
+		tc.gallery.UserID = 1
+		tc.gallery.Title = "Wedding"
+		if err := gs.Create(&tc.gallery); err != tc.err {
+			t.Errorf("%s: expected %v, received %v", tc.name, tc.err, err)
+		}
+	}
+
+	taken := time.Date(2019, 6, 1, 15, 30, 0, 0, time.FixedZone("WAT", 3600))
+	g := Gallery{
+		UserID:        1,
+		Title:         "Wedding",
+		Description:   "  The **big** day \n",
+		Location:      " Lagos ",
+		EventDate:     &taken,
+		CoverFilename: "b.jpg",
+		Images:        []Image{{Filename: "a.jpg"}, {Filename: "b.jpg"}},
+	}
+	if err := gs.Create(&g); err != nil {
+		t.Fatal(err)
+	}
+	found, err := gs.ByID(g.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Description != "The **big** day" || found.Location != "Lagos" || found.CoverFilename != "b.jpg" {
+		t.Errorf("Expected trimmed details, received %q, %q, %q", found.Description, found.Location, found.CoverFilename)
+	}
+	if found.EventDate == nil || found.EventDate.Format("2006-01-02 15:04") != "2019-06-01 00:00" {
+		t.Errorf("Expected the event date without a time, received %v", found.EventDate)
+	}
+}
+
+func TestGalleryCover(t *testing.T) {
+	g := Gallery{}
+	if g.Cover() != nil {
+		t.Errorf("Expected no cover without images")
+	}
+	g.Images = []Image{{Filename: "a.jpg"}, {Filename: "b.jpg"}}
+	if c := g.Cover(); c.Filename != "a.jpg" {
+		t.Errorf("Expected the first image by default, received %s", c.Filename)
+	}
+	g.CoverFilename = "b.jpg"
+	if c := g.Cover(); c.Filename != "b.jpg" {
+		t.Errorf("Expected the chosen cover, received %s", c.Filename)
+	}
+	g.CoverFilename = "deleted.jpg"
+	if c := g.Cover(); c.Filename != "a.jpg" {
+		t.Errorf("Expected the first image when the cover is gone, received %s", c.Filename)
+	}
+}
+
+// TestGalleryPage walks every sort a page at a time, making sure
+// the cursors pick up where the previous page ended
+func TestGalleryPage(t *testing.T) {
+	s := testingServices(t)
+	inTempDir(t)
+	wedding := Gallery{UserID: 1, Title: "Wedding"}
+	holiday := Gallery{UserID: 1, Title: "holiday"}
+	birthday := Gallery{UserID: 1, Title: "Birthday", CollectionID: 1}
+	theirs := Gallery{UserID: 2, Title: "Another wedding"}
+	for _, g := range []*Gallery{&wedding, &holiday, &birthday, &theirs} {
+		if err := s.Gallery.Create(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Gallery.Update(&wedding); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"a.jpg", "b.jpg"} {
+		if err := s.Image.Create(holiday.ID, strings.NewReader(filename), filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Image.Create(birthday.ID, strings.NewReader("a"), "a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		q    GalleryQuery
+		want string
+	}{
+		{"created", GalleryQuery{}, "Birthday,holiday,Wedding"},
+		{"created oldest first", GalleryQuery{Order: SortAsc}, "Wedding,holiday,Birthday"},
+		{"updated", GalleryQuery{Sort: GallerySortUpdated}, "Wedding,Birthday,holiday"},
+		{"title", GalleryQuery{Sort: GallerySortTitle}, "Birthday,holiday,Wedding"},
+		{"title Z to A", GalleryQuery{Sort: GallerySortTitle, Order: SortDesc}, "Wedding,holiday,Birthday"},
+		{"images", GalleryQuery{Sort: GallerySortImages}, "holiday,Birthday,Wedding"},
+		{"title filter", GalleryQuery{Title: " DAY"}, "Birthday,holiday"},
+		{"like characters", GalleryQuery{Title: "%"}, ""},
+		{"ungrouped", GalleryQuery{Ungrouped: true}, "holiday,Wedding"},
+	} {
+		tc.q.UserID = 1
+		tc.q.Limit = 2
+		var titles []string
+		for pages := 0; ; pages++ {
+			page, err := s.Gallery.Page(&tc.q)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if want := len(strings.Split(tc.want, ",")); tc.want != "" && page.Total != want {
+				t.Errorf("%s: expected a total of %d, received %d", tc.name, want, page.Total)
+			}
+			for _, g := range page.Galleries {
+				titles = append(titles, g.Title)
+			}
+			if page.Next == "" || pages > 3 {
+				break
+			}
+			tc.q.Cursor = page.Next
+		}
+		if got := strings.Join(titles, ","); got != tc.want {
+			t.Errorf("%s: expected %q, received %q", tc.name, tc.want, got)
+		}
+	}
+
+	first, err := s.Gallery.Page(&GalleryQuery{UserID: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		q    GalleryQuery
+		err  error
+	}{
+		{"no user", GalleryQuery{}, ErrUserIDRequired},
+		{"unknown sort", GalleryQuery{UserID: 1, Sort: "size"}, ErrGallerySortInvalid},
+		{"unknown order", GalleryQuery{UserID: 1, Order: "up"}, ErrSortOrderInvalid},
+		{"made up cursor", GalleryQuery{UserID: 1, Cursor: "page-2"}, ErrCursorInvalid},
+		{"cursor of another sort", GalleryQuery{UserID: 1, Sort: GallerySortTitle, Cursor: first.Next}, ErrCursorInvalid},
+	} {
+		if _, err := s.Gallery.Page(&tc.q); err != tc.err {
+			t.Errorf("%s: expected %v, received %v", tc.name, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The ways galleries can be sorted
+const (
+	GallerySortCreated = "created"
+	GallerySortUpdated = "updated"
+	GallerySortTitle   = "title"
+	GallerySortImages  = "images"
+)
+
+// The orders galleries can be sorted in
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+const (
+	// DefaultGalleryPageSize is how many galleries a page holds
+	// when the query doesn't say
+	DefaultGalleryPageSize = 24
+	// MaxGalleryPageSize is the most galleries a page can hold
+	MaxGalleryPageSize = 100
+)
+
+// galleryOrderExprs is what the database sorts galleries by for
+// each sort
+var galleryOrderExprs = map[string]string{
+	GallerySortCreated: "galleries.created_at",
+	GallerySortUpdated: "galleries.updated_at",
+	GallerySortTitle:   "lower(galleries.title)",
+	GallerySortImages:  imageCountExpr,
+}
+
+// imageCountExpr counts the images in each gallery
+const imageCountExpr = "(SELECT count(*) FROM image_details WHERE image_details.gallery_id = galleries.id AND image_details.deleted_at IS NULL)"
+
+// GalleryQuery asks for a page of a user's galleries
+type GalleryQuery struct {
+	UserID uint
+	// Title only keeps galleries with this in their title, ignoring
+	// case
+	Title string
+	// Ungrouped only keeps galleries that aren't in a collection
+	Ungrouped bool
+	// Sort is one of the GallerySort constants, GallerySortCreated
+	// when empty
+	Sort string
+	// Order is SortAsc or SortDesc. When empty titles go from A to
+	// Z, and everything else puts the newest or fullest first.
+	Order string
+	// Cursor is GalleryPage.Next of the previous page, empty for
+	// the first page
+	Cursor string
+	// Limit is how many galleries the page holds
+	Limit int
+}
+
+// GalleryPage is one page of galleries
+type GalleryPage struct {
+	Galleries []Gallery
+	// Total is how many galleries match the query, on every page
+	Total int
+	// Next is the cursor of the following page, empty on the last
+	// page
+	Next string
+}
+
+// galleryCursor is where a page ends: the sort key and ID of its
+// last gallery. The sort is kept so a cursor can't be used with
+// a different one.
+type galleryCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Key   string `json:"k"`
+	ID    uint   `json:"id"`
+}
+
+// cursor decodes the query's cursor, nil for the first page
+func (q *GalleryQuery) cursor() (*galleryCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrCursorInvalid
+	}
+	var c galleryCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrCursorInvalid
+	}
+	if c.Sort != q.Sort || c.Order != q.Order || c.ID == 0 {
+		return nil, ErrCursorInvalid
+	}
+	if _, err := q.keyValue(c.Key); err != nil {
+		return nil, ErrCursorInvalid
+	}
+	return &c, nil
+}
+
+// nextCursor returns the cursor of the page after the one ending
+// with g
+func (q *GalleryQuery) nextCursor(g *Gallery, images int) string {
+	b, _ := json.Marshal(galleryCursor{
+		Sort:  q.Sort,
+		Order: q.Order,
+		Key:   q.sortKey(g, images),
+		ID:    g.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sortKey returns what g is sorted by, written as a string
+func (q *GalleryQuery) sortKey(g *Gallery, images int) string {
+	switch q.Sort {
+	case GallerySortUpdated:
+		return g.UpdatedAt.Format(time.RFC3339Nano)
+	case GallerySortTitle:
+		return strings.ToLower(g.Title)
+	case GallerySortImages:
+		return strconv.Itoa(images)
+	default:
+		return g.CreatedAt.Format(time.RFC3339Nano)
+	}
+}
+
+// keyValue parses a sort key back into the value it was written
+// from
+func (q *GalleryQuery) keyValue(key string) (interface{}, error) {
+	switch q.Sort {
+	case GallerySortTitle:
+		return key, nil
+	case GallerySortImages:
+		return strconv.Atoi(key)
+	default:
+		return time.Parse(time.RFC3339Nano, key)
+	}
+}
+
+// compareKeys compares two sort keys, returning -1, 0 or 1
+func (q *GalleryQuery) compareKeys(a, b string) int {
+	va, _ := q.keyValue(a)
+	vb, _ := q.keyValue(b)
+	switch va := va.(type) {
+	case time.Time:
+		vb := vb.(time.Time)
+		switch {
+		case va.Before(vb):
+			return -1
+		case va.After(vb):
+			return 1
+		}
+		return 0
+	case int:
+		vb := vb.(int)
+		switch {
+		case va < vb:
+			return -1
+		case va > vb:
+			return 1
+		}
+		return 0
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// PageGalleries picks the page q asks for out of a user's
+// galleries, for GalleryDBs that can't query a database. images
+// counts the images in each gallery.
+func PageGalleries(galleries []Gallery, images map[uint]int, q *GalleryQuery) (*GalleryPage, error) {
+	c, err := q.cursor()
+	if err != nil {
+		return nil, err
+	}
+	title := strings.ToLower(q.Title)
+	type entry struct {
+		g   Gallery
+		key string
+	}
+	var entries []entry
+	for _, g := range galleries {
+		if g.UserID != q.UserID || (q.Ungrouped && g.CollectionID != 0) {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(g.Title), title) {
+			continue
+		}
+		entries = append(entries, entry{g, q.sortKey(&g, images[g.ID])})
+	}
+	// compare orders a before b as the database would, ties broken
+	// by ID
+	compare := func(aKey string, aID uint, bKey string, bID uint) int {
+		cmp := q.compareKeys(aKey, bKey)
+		if cmp == 0 {
+			switch {
+			case aID < bID:
+				cmp = -1
+			case aID > bID:
+				cmp = 1
+			}
+		}
+		if q.Order == SortDesc {
+			return -cmp
+		}
+		return cmp
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return compare(entries[i].key, entries[i].g.ID, entries[j].key, entries[j].g.ID) < 0
+	})
+	page := GalleryPage{Galleries: []Gallery{}, Total: len(entries)}
+	for _, e := range entries {
+		if c != nil && compare(e.key, e.g.ID, c.Key, c.ID) <= 0 {
+			continue
+		}
+		if len(page.Galleries) == q.Limit {
+			last := &page.Galleries[len(page.Galleries)-1]
+			page.Next = q.nextCursor(last, images[last.ID])
+			break
+		}
+		page.Galleries = append(page.Galleries, e.g)
+	}
+	return &page, nil
+}
+
+// Page fills in the query's defaults and checks it before getting
+// the page
+func (gv *galleryValidator) Page(q *GalleryQuery) (*GalleryPage, error) {
+	if q.UserID <= 0 {
+		return nil, ErrUserIDRequired
+	}
+	q.Title = strings.TrimSpace(q.Title)
+	if q.Sort == "" {
+		q.Sort = GallerySortCreated
+	}
+	if _, ok := galleryOrderExprs[q.Sort]; !ok {
+		return nil, ErrGallerySortInvalid
+	}
+	switch q.Order {
+	case "":
+		q.Order = SortDesc
+		if q.Sort == GallerySortTitle {
+			q.Order = SortAsc
+		}
+	case SortAsc, SortDesc:
+	default:
+		return nil, ErrSortOrderInvalid
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultGalleryPageSize
+	}
+	if q.Limit > MaxGalleryPageSize {
+		q.Limit = MaxGalleryPageSize
+	}
+	if _, err := q.cursor(); err != nil {
+		return nil, err
+	}
+	return gv.GalleryDB.Page(q)
+}
+
+// galleryRow is a gallery with its image count
+type galleryRow struct {
+	Gallery
+	ImageCount int
+}
+
+// Page gets the galleries after the cursor using the sort key and
+// ID of the last one, so pages stay put when galleries are added
+func (gg *galleryGorm) Page(q *GalleryQuery) (*GalleryPage, error) {
+	db := gg.db.Table("galleries").
+		Where("galleries.deleted_at IS NULL AND galleries.user_id = ?", q.UserID)
+	if q.Ungrouped {
+		db = db.Where("galleries.collection_id = 0")
+	}
+	if q.Title != "" {
+		db = db.Where(`lower(galleries.title) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(q.Title))+"%")
+	}
+	page := GalleryPage{Galleries: []Gallery{}}
+	if err := db.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	expr := galleryOrderExprs[q.Sort]
+	op, dir := ">", "ASC"
+	if q.Order == SortDesc {
+		op, dir = "<", "DESC"
+	}
+	c, err := q.cursor()
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		key, _ := q.keyValue(c.Key)
+		db = db.Where(fmt.Sprintf("%[1]s %[2]s ? OR (%[1]s = ? AND galleries.id %[2]s ?)", expr, op), key, key, c.ID)
+	}
+	var rows []galleryRow
+	err = db.Select("galleries.*, " + imageCountExpr + " AS image_count").
+		Order(expr + " " + dir).
+		Order("galleries.id " + dir).
+		Limit(q.Limit + 1).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+		last := rows[len(rows)-1]
+		page.Next = q.nextCursor(&last.Gallery, last.ImageCount)
+	}
+	for _, row := range rows {
+		page.Galleries = append(page.Galleries, row.Gallery)
+	}
+	return &page, nil
+}
@@ -0,0 +1,239 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SelectImages returns the images named filenames, in gallery
+// order. Naming an image that isn't in images is ErrNotFound.
+func SelectImages(images []Image, filenames []string) ([]Image, error) {
+	if len(filenames) == 0 {
+		return nil, ErrNoImagesSelected
+	}
+	want := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		want[filename] = true
+	}
+	var selected []Image
+	for _, image := range images {
+		if want[image.Filename] {
+			selected = append(selected, image)
+			delete(want, image.Filename)
+		}
+	}
+	if len(want) > 0 {
+		return nil, ErrNotFound
+	}
+	return selected, nil
+}
+
+// ValidateImageTransfer checks images of galleryID can be moved or
+// copied into toGalleryID, which already holds dest
+func ValidateImageTransfer(galleryID, toGalleryID uint, images, dest []Image) error {
+	if toGalleryID <= 0 {
+		return ErrInvalidID
+	}
+	if galleryID == toGalleryID {
+		return ErrSameGallery
+	}
+	taken := make(map[string]bool, len(dest))
+	for _, image := range dest {
+		taken[image.Filename] = true
+	}
+	for _, image := range images {
+		if taken[image.Filename] {
+			return ErrImageNameTaken
+		}
+	}
+	return nil
+}
+
+// fileBatch changes image files in a way that can be undone, so
+// that nothing changes on disk when a later file, or saving the
+// image details, fails
+type fileBatch struct {
+	undo []func() error
+}
+
+// rename renames from to to, which mustn't exist
+func (b *fileBatch) rename(from, to string) error {
+	if _, err := os.Lstat(to); err == nil {
+		return ErrImageNameTaken
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	b.undo = append(b.undo, func() error { return os.Rename(to, from) })
+	return nil
+}
+
+// copy copies from to to, which mustn't exist
+func (b *fileBatch) copy(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return ErrImageNameTaken
+	}
+	if err != nil {
+		return err
+	}
+	b.undo = append(b.undo, func() error { return os.Remove(to) })
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// rollback undoes every change, latest first, and returns err
+// along with anything that couldn't be undone
+func (b *fileBatch) rollback(err error) error {
+	for i := len(b.undo) - 1; i >= 0; i-- {
+		if undoErr := b.undo[i](); undoErr != nil {
+			err = fmt.Errorf("%v, then undoing it: %v", err, undoErr)
+		}
+	}
+	b.undo = nil
+	return err
+}
+
+// selected returns the images of a gallery named filenames
+func (is *imageService) selected(galleryID uint, filenames []string) ([]Image, error) {
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	return SelectImages(images, filenames)
+}
+
+// DeleteMany moves the files into the gallery's trash directory
+// first, so they can be put back if their details can't be marked
+// deleted
+func (is *imageService) DeleteMany(galleryID uint, filenames []string) error {
+	images, err := is.selected(galleryID, filenames)
+	if err != nil {
+		return err
+	}
+	// Images stored before details existed need some to delete
+	for _, image := range images {
+		if err := is.addDetails(galleryID, image.Filename); err != nil {
+			return err
+		}
+	}
+	trash, err := is.mkTrashPath(galleryID)
+	if err != nil {
+		return err
+	}
+	var b fileBatch
+	for _, image := range images {
+		if err := b.rename(image.RelativePath(), trash+image.Filename); err != nil {
+			return b.rollback(err)
+		}
+	}
+	err = is.db.Where("gallery_id = ? AND filename IN (?)", galleryID, imageFilenames(images)).
+		Delete(&ImageDetails{}).Error
+	if err != nil {
+		return b.rollback(err)
+	}
+	return nil
+}
+
+// Move renames the files into the other gallery's directory, then
+// moves their details
+func (is *imageService) Move(galleryID uint, filenames []string, toGalleryID uint) error {
+	return is.transfer(galleryID, filenames, toGalleryID, true)
+}
+
+// Copy copies the files into the other gallery's directory, then
+// copies their details
+func (is *imageService) Copy(galleryID uint, filenames []string, toGalleryID uint) error {
+	return is.transfer(galleryID, filenames, toGalleryID, false)
+}
+
+// transfer moves or copies images into another gallery, putting
+// every file back if anything fails
+func (is *imageService) transfer(galleryID uint, filenames []string, toGalleryID uint, move bool) error {
+	images, err := is.selected(galleryID, filenames)
+	if err != nil {
+		return err
+	}
+	dest, err := is.ByGalleryID(toGalleryID)
+	if err != nil {
+		return err
+	}
+	if err := ValidateImageTransfer(galleryID, toGalleryID, images, dest); err != nil {
+		return err
+	}
+	path, err := is.mkImagePath(toGalleryID)
+	if err != nil {
+		return err
+	}
+	var b fileBatch
+	for _, image := range images {
+		if move {
+			err = b.rename(image.RelativePath(), path+image.Filename)
+		} else {
+			err = b.copy(image.RelativePath(), path+image.Filename)
+		}
+		if err != nil {
+			return b.rollback(err)
+		}
+	}
+	err = is.db.Transaction(func(tx *gorm.DB) error {
+		names := imageFilenames(images)
+		if move {
+			err := tx.Unscoped().Where("gallery_id = ? AND filename IN (?)", galleryID, names).
+				Delete(&ImageDetails{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		// Images of the same name in the trash are replaced, as
+		// they would be by an upload, and details left behind by a
+		// file removed some other way would clash with ours
+		err := tx.Unscoped().Where("gallery_id = ? AND filename IN (?)", toGalleryID, names).
+			Delete(&ImageDetails{}).Error
+		if err != nil {
+			return err
+		}
+		var last struct{ Position int }
+		err = tx.Model(&ImageDetails{}).Select("coalesce(max(position), 0) as position").
+			Where("gallery_id = ?", toGalleryID).Scan(&last).Error
+		if err != nil {
+			return err
+		}
+		for n, image := range images {
+			details := image.details()
+			details.GalleryID = toGalleryID
+			details.Position = last.Position + n + 1
+			if err := tx.Create(&details).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return b.rollback(err)
+	}
+	for _, image := range images {
+		os.Remove(is.trashPath(toGalleryID) + image.Filename)
+	}
+	return nil
+}
+
+// imageFilenames returns the filename of each image
+func imageFilenames(images []Image) []string {
+	filenames := make([]string, len(images))
+	for i, image := range images {
+		filenames[i] = image.Filename
+	}
+	return filenames
+}
@@ -6,20 +6,229 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sajicode/go-photo/imaging"
+)
+
+const (
+	// MaxCaptionLength is the most characters a caption can have
+	MaxCaptionLength = 1000
+	// MaxAltTextLength is the most characters alt text can have,
+	// screen readers struggle with anything much longer
+	MaxAltTextLength = 250
 )
 
 // ImageService interface describes methods present on this service
 type ImageService interface {
+	// Create stores an image at the end of the gallery, or replaces
+	// the contents of one with the same name keeping its details.
+	// The camera, date taken and keywords are read from the image,
+	// see ApplyImageMetadata.
 	Create(galleryID uint, r io.Reader, filename string) error
+	// ByGalleryID returns the images in a gallery in order
 	ByGalleryID(galleryID uint) ([]Image, error)
+	// Open reads the original contents of an image
+	Open(i *Image) (io.ReadCloser, error)
+	// Update saves the caption, alt text and tags of an image
+	Update(i *Image) error
+	// Reorder puts the images of a gallery in the order of
+	// filenames, which must name each of them once
+	Reorder(galleryID uint, filenames []string) error
+	// Delete moves an image to the trash, see Restore
 	Delete(i *Image) error
+	// DeleteMany moves the images of a gallery named filenames to
+	// the trash, or none of them if any can't be moved
+	DeleteMany(galleryID uint, filenames []string) error
+	// Move moves the images of a gallery named filenames to the end
+	// of another gallery, keeping their details. Nothing is moved if
+	// any of them can't be.
+	Move(galleryID uint, filenames []string, toGalleryID uint) error
+	// Copy copies the images of a gallery named filenames to the end
+	// of another gallery, details and all. Nothing is copied if any
+	// of them can't be.
+	Copy(galleryID uint, filenames []string, toGalleryID uint) error
+	// DeletedByGalleryID returns the images of a gallery that are
+	// in the trash, the latest deleted first
+	DeletedByGalleryID(galleryID uint) ([]Image, error)
+	// DeletedBefore returns the images of every gallery that went in
+	// the trash before t
+	DeletedBefore(t time.Time) ([]Image, error)
+	// OpenDeleted reads the contents of an image in the trash
+	OpenDeleted(i *Image) (io.ReadCloser, error)
+	// Restore takes an image out of the trash and puts it back in
+	// its gallery, unless the gallery has another image with the
+	// same name by now
+	Restore(i *Image) error
+	// Purge removes an image for good, whether or not it is in the
+	// trash
+	Purge(i *Image) error
+	// Discard removes whatever Create left behind of an image it
+	// failed to store, leaving an image of the same name in the
+	// trash alone
+	Discard(i *Image) error
+	// DeleteAll removes every image in a gallery, including those
+	// in the trash, along with its directories
+	DeleteAll(galleryID uint) error
+	// GalleryIDs lists every gallery with images stored, in the
+	// trash or not, whether or not the gallery still exists
+	GalleryIDs() ([]uint, error)
+}
+
+// imageExtensions are the kinds of images we accept
+var imageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+}
+
+// ValidateImageFilename checks filename is a plain file name, with
+// no directories, of a kind of image we accept. Every ImageService
+// runs it before storing an image.
+func ValidateImageFilename(filename string) error {
+	if filename == "" || filename != filepath.Base(filename) || strings.ContainsAny(filename, `/\`) || strings.HasPrefix(filename, ".") {
+		return ErrImageFilenameInvalid
+	}
+	if !imageExtensions[strings.ToLower(filepath.Ext(filename))] {
+		return ErrImageTypeInvalid
+	}
+	return nil
+}
+
+// ValidateImageDetails trims the caption and alt text of an image,
+// cleans up its tags and checks none of them are too long. Every
+// ImageService runs it before updating an image.
+func ValidateImageDetails(i *Image) error {
+	i.Caption = strings.TrimSpace(i.Caption)
+	i.AltText = strings.TrimSpace(i.AltText)
+	if utf8.RuneCountInString(i.Caption) > MaxCaptionLength {
+		return ErrCaptionTooLong
+	}
+	if utf8.RuneCountInString(i.AltText) > MaxAltTextLength {
+		return ErrAltTextTooLong
+	}
+	return normalizeTags(&i.Tags)
+}
+
+// ApplyImageMetadata sets the camera and date taken of an image
+// from its metadata, and adds its keywords to the image's tags.
+// Every ImageService runs it when storing an image, replacing the
+// camera and date of the file it replaces.
+func ApplyImageMetadata(i *Image, md imaging.Metadata) {
+	i.Camera = strings.TrimSpace(md.Camera)
+	i.TakenAt = nil
+	if md.TakenAt != nil {
+		taken := md.TakenAt.UTC()
+		i.TakenAt = &taken
+	}
+	i.Tags = addTags(i.Tags, md.Keywords)
+}
+
+// ValidateImageOrder checks filenames names every image once
+func ValidateImageOrder(images []Image, filenames []string) error {
+	if len(images) != len(filenames) {
+		return ErrImageOrderInvalid
+	}
+	want := make(map[string]bool, len(images))
+	for _, image := range images {
+		want[image.Filename] = true
+	}
+	for _, filename := range filenames {
+		if !want[filename] {
+			return ErrImageOrderInvalid
+		}
+		delete(want, filename)
+	}
+	return nil
+}
+
+// SortImages orders images by position. Images without one, stored
+// before images could be ordered, go last by name.
+func SortImages(images []Image) {
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if (a.Position == 0) != (b.Position == 0) {
+			return b.Position == 0
+		}
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.Filename < b.Filename
+	})
 }
 
-// Image is not stored in the database
+// Image is an uploaded file, its details are stored in the
+// database as ImageDetails
 type Image struct {
 	GalleryID uint
 	Filename  string
+	// Size is the size of the file in bytes
+	Size int64
+	// Position is the place of the image in its gallery, from 1
+	Position int
+	Caption  string
+	AltText  string
+	// Tags are comma separated, see SplitTags
+	Tags string
+	// Camera and TakenAt are read from the image when it is
+	// uploaded
+	Camera  string
+	TakenAt *time.Time
+	// DeletedAt is when the image was put in the trash
+	DeletedAt *time.Time
+}
+
+// TagList returns the image's tags
+func (i *Image) TagList() []string {
+	return SplitTags(i.Tags)
+}
+
+// ImageDetails are what we know about an image besides its file
+type ImageDetails struct {
+	gorm.Model
+	GalleryID uint   `gorm:"not null;unique_index:idx_image_details_file"`
+	Filename  string `gorm:"not null;unique_index:idx_image_details_file"`
+	Position  int    `gorm:"not null"`
+	Caption   string
+	AltText   string
+	Tags      string `gorm:"type:text"`
+	Camera    string
+	TakenAt   *time.Time
+}
+
+// image returns the image the details are about, without its size
+func (d *ImageDetails) image() Image {
+	return Image{
+		GalleryID: d.GalleryID,
+		Filename:  d.Filename,
+		Position:  d.Position,
+		Caption:   d.Caption,
+		AltText:   d.AltText,
+		Tags:      d.Tags,
+		Camera:    d.Camera,
+		TakenAt:   d.TakenAt,
+		DeletedAt: d.DeletedAt,
+	}
+}
+
+// details returns the details of the image for storing in the
+// database
+func (i *Image) details() ImageDetails {
+	return ImageDetails{
+		GalleryID: i.GalleryID,
+		Filename:  i.Filename,
+		Position:  i.Position,
+		Caption:   i.Caption,
+		AltText:   i.AltText,
+		Tags:      i.Tags,
+		Camera:    i.Camera,
+		TakenAt:   i.TakenAt,
+	}
 }
 
 // Path returns an image path as a string
@@ -35,15 +244,20 @@ func (i *Image) RelativePath() string {
 	return fmt.Sprintf("images/galleries/%v/%v", i.GalleryID, i.Filename)
 }
 
-// NewImageService function ?
-func NewImageService() ImageService {
-	return &imageService{}
+// NewImageService stores images on disk and their details in db
+func NewImageService(db *gorm.DB) ImageService {
+	return &imageService{db}
 }
 
-type imageService struct{}
+type imageService struct {
+	db *gorm.DB
+}
 
 // Create initiates image upload
 func (is *imageService) Create(galleryID uint, r io.Reader, filename string) error {
+	if err := ValidateImageFilename(filename); err != nil {
+		return err
+	}
 	path, err := is.mkImagePath(galleryID)
 	if err != nil {
 		return err
@@ -54,12 +268,71 @@ func (is *imageService) Create(galleryID uint, r io.Reader, filename string) err
 		return err
 	}
 	defer dst.Close()
-	// Copy reader data to the destination file
+	// The metadata is at the start of the file, so read it on the
+	// way through and copy whatever is left after it
+	md := imaging.ReadMetadata(io.TeeReader(r, dst))
 	_, err = io.Copy(dst, r)
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := is.addDetails(galleryID, filename); err != nil {
+		return err
+	}
+	return is.addMetadata(galleryID, filename, md)
+}
+
+// Discard removes the image file from the gallery along with its
+// details, if it got that far, but not from its trash
+func (is *imageService) Discard(i *Image) error {
+	if err := ValidateImageFilename(i.Filename); err != nil {
+		return ErrNotFound
+	}
+	if err := os.Remove(i.RelativePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return is.db.Unscoped().Where("gallery_id = ? AND filename = ? AND deleted_at IS NULL", i.GalleryID, i.Filename).
+		Delete(&ImageDetails{}).Error
+}
+
+// addMetadata saves what was read from an image onto its details
+func (is *imageService) addMetadata(galleryID uint, filename string, md imaging.Metadata) error {
+	var details ImageDetails
+	db := is.db.Where("gallery_id = ? AND filename = ?", galleryID, filename)
+	if err := first(db, &details); err != nil {
+		return err
+	}
+	i := details.image()
+	ApplyImageMetadata(&i, md)
+	return is.db.Model(&details).Updates(map[string]interface{}{
+		"tags":     i.Tags,
+		"camera":   i.Camera,
+		"taken_at": i.TakenAt,
+	}).Error
+}
+
+// addDetails puts a new image at the end of its gallery, replacing
+// an image of the same name in the trash for good
+func (is *imageService) addDetails(galleryID uint, filename string) error {
+	var details ImageDetails
+	err := first(is.db.Where("gallery_id = ? AND filename = ?", galleryID, filename), &details)
+	if err != ErrNotFound {
+		return err
+	}
+	if err := is.purgeDeleted(galleryID, []string{filename}); err != nil {
+		return err
+	}
+	var last struct{ Position int }
+	err = is.db.Model(&ImageDetails{}).Select("coalesce(max(position), 0) as position").
+		Where("gallery_id = ?", galleryID).Scan(&last).Error
+	if err != nil {
+		return err
+	}
+	details = ImageDetails{
+		GalleryID: galleryID,
+		Filename:  filename,
+		Position:  last.Position + 1,
+	}
+	return is.db.Create(&details).Error
 }
 
 // ByGalleryID fetches images linked to a gallery
@@ -71,16 +344,82 @@ func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
 	}
 	ret := make([]Image, len(imgStrings))
 	for i := range imgStrings {
+		var size int64
+		if info, err := os.Stat(imgStrings[i]); err == nil {
+			size = info.Size()
+		}
 		imgStrings[i] = strings.Replace(imgStrings[i], path, "", 1)
 		ret[i] = Image{
 			Filename:  imgStrings[i],
 			GalleryID: galleryID,
+			Size:      size,
 		}
 
 	}
+	var details []ImageDetails
+	if err := is.db.Where("gallery_id = ?", galleryID).Find(&details).Error; err != nil {
+		return nil, err
+	}
+	byName := make(map[string]ImageDetails, len(details))
+	for _, d := range details {
+		byName[d.Filename] = d
+	}
+	for i := range ret {
+		d := byName[ret[i].Filename]
+		size := ret[i].Size
+		ret[i] = d.image()
+		ret[i].GalleryID = galleryID
+		ret[i].Filename = imgStrings[i]
+		ret[i].Size = size
+	}
+	SortImages(ret)
 	return ret, nil
 }
 
+// Update saves the caption, alt text and tags of an image,
+// returning ErrNotFound if it doesn't exist
+func (is *imageService) Update(i *Image) error {
+	if err := ValidateImageDetails(i); err != nil {
+		return err
+	}
+	if _, err := os.Stat(i.RelativePath()); os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err := is.addDetails(i.GalleryID, i.Filename); err != nil {
+		return err
+	}
+	return is.db.Model(&ImageDetails{}).
+		Where("gallery_id = ? AND filename = ?", i.GalleryID, i.Filename).
+		Updates(map[string]interface{}{"caption": i.Caption, "alt_text": i.AltText, "tags": i.Tags}).Error
+}
+
+// Reorder saves the position of every image at once
+func (is *imageService) Reorder(galleryID uint, filenames []string) error {
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return err
+	}
+	if err := ValidateImageOrder(images, filenames); err != nil {
+		return err
+	}
+	for _, image := range images {
+		if err := is.addDetails(galleryID, image.Filename); err != nil {
+			return err
+		}
+	}
+	return is.db.Transaction(func(tx *gorm.DB) error {
+		for i, filename := range filenames {
+			err := tx.Model(&ImageDetails{}).
+				Where("gallery_id = ? AND filename = ?", galleryID, filename).
+				Update("position", i+1).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (is *imageService) imagePath(galleryID uint) string {
 	return fmt.Sprintf("images/galleries/%v/", galleryID)
 }
@@ -94,7 +433,52 @@ func (is *imageService) mkImagePath(galleryID uint) (string, error) {
 	return galleryPath, nil
 }
 
-// Delete removes an image from the filesystem
+// Open opens the image file, returning ErrNotFound if it doesn't
+// exist
+func (is *imageService) Open(i *Image) (io.ReadCloser, error) {
+	f, err := os.Open(i.RelativePath())
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete moves an image to the trash
 func (is *imageService) Delete(i *Image) error {
-	return os.Remove(i.RelativePath())
+	return is.DeleteMany(i.GalleryID, []string{i.Filename})
+}
+
+// DeleteAll removes a gallery's image directories and the details
+// of its images. It is not an error if the gallery never had any
+// images.
+func (is *imageService) DeleteAll(galleryID uint) error {
+	if err := os.RemoveAll(is.imagePath(galleryID)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(is.trashPath(galleryID)); err != nil {
+		return err
+	}
+	return is.db.Unscoped().Where("gallery_id = ?", galleryID).Delete(&ImageDetails{}).Error
+}
+
+// GalleryIDs reads the names of the gallery directories under both
+// images/ and trash/
+func (is *imageService) GalleryIDs() ([]uint, error) {
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, pattern := range []string{is.imagePath(0), is.trashPath(0)} {
+		dirs, err := filepath.Glob(strings.TrimSuffix(pattern, "0/") + "*")
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			id, err := strconv.ParseUint(filepath.Base(dir), 10, 64)
+			if err != nil || seen[uint(id)] {
+				continue
+			}
+			seen[uint(id)] = true
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids, nil
 }
@@ -0,0 +1,267 @@
+package models
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestValidateImageFilename makes sure only plain image names get
+// through
+func TestValidateImageFilename(t *testing.T) {
+	for name, want := range map[string]error{
+		"cake.png":        nil,
+		"first dance.JPG": nil,
+		"photo.jpeg":      nil,
+		"":                ErrImageFilenameInvalid,
+		".hidden.jpg":     ErrImageFilenameInvalid,
+		"../cake.png":     ErrImageFilenameInvalid,
+		"shoot/cake.png":  ErrImageFilenameInvalid,
+		`shoot\cake.png`:  ErrImageFilenameInvalid,
+		"notes.txt":       ErrImageTypeInvalid,
+		"cake":            ErrImageTypeInvalid,
+	} {
+		if err := ValidateImageFilename(name); err != want {
+			t.Errorf("%q: Expected %v, received %v", name, want, err)
+		}
+	}
+}
+
+// TestImageOrder makes sure positioned images come first and a new
+// order has to name every image once
+func TestImageOrder(t *testing.T) {
+	images := []Image{
+		{Filename: "old.jpg"},
+		{Filename: "second.jpg", Position: 2},
+		{Filename: "first.jpg", Position: 1},
+		{Filename: "ancient.jpg"},
+	}
+	SortImages(images)
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Filename)
+	}
+	if got := strings.Join(names, ","); got != "first.jpg,second.jpg,ancient.jpg,old.jpg" {
+		t.Errorf("Expected positioned images first, received %s", got)
+	}
+
+	for _, tc := range []struct {
+		filenames []string
+		want      error
+	}{
+		{[]string{"old.jpg", "ancient.jpg", "first.jpg", "second.jpg"}, nil},
+		{[]string{"old.jpg", "ancient.jpg", "first.jpg"}, ErrImageOrderInvalid},
+		{[]string{"old.jpg", "old.jpg", "first.jpg", "second.jpg"}, ErrImageOrderInvalid},
+		{[]string{"old.jpg", "ancient.jpg", "first.jpg", "other.jpg"}, ErrImageOrderInvalid},
+	} {
+		if err := ValidateImageOrder(images, tc.filenames); err != tc.want {
+			t.Errorf("%v: Expected %v, received %v", tc.filenames, tc.want, err)
+		}
+	}
+}
+
+// TestValidateImageDetails checks captions and alt text are trimmed
+// and limited in length
+func TestValidateImageDetails(t *testing.T) {
+	i := Image{Caption: "  First dance \n", AltText: " Two people dancing "}
+	if err := ValidateImageDetails(&i); err != nil {
+		t.Fatal(err)
+	}
+	if i.Caption != "First dance" || i.AltText != "Two people dancing" {
+		t.Errorf("Expected trimmed details, received %+v", i)
+	}
+	i.AltText = strings.Repeat("é", MaxAltTextLength)
+	if err := ValidateImageDetails(&i); err != nil {
+		t.Errorf("Expected %d characters of alt text to be fine, received %v", MaxAltTextLength, err)
+	}
+	i.AltText += "é"
+	if err := ValidateImageDetails(&i); err != ErrAltTextTooLong {
+		t.Errorf("Expected ErrAltTextTooLong, received %v", err)
+	}
+	i = Image{Caption: strings.Repeat("a", MaxCaptionLength+1)}
+	if err := ValidateImageDetails(&i); err != ErrCaptionTooLong {
+		t.Errorf("Expected ErrCaptionTooLong, received %v", err)
+	}
+}
+
+// TestImageServiceDetails stores images in a temporary directory
+// and checks their order and details survive a reorder and delete
+func TestImageServiceDetails(t *testing.T) {
+	is := testingServices(t).Image
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, name := range []string{"b.jpg", "a.jpg", "c.jpg"} {
+		if err := is.Create(1, strings.NewReader(name), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filenames := func() string {
+		images, err := is.ByGalleryID(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, image := range images {
+			names = append(names, image.Filename)
+		}
+		return strings.Join(names, ",")
+	}
+	if got := filenames(); got != "b.jpg,a.jpg,c.jpg" {
+		t.Errorf("Expected upload order, received %s", got)
+	}
+	if err := is.Reorder(1, []string{"c.jpg", "a.jpg", "b.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := filenames(); got != "c.jpg,a.jpg,b.jpg" {
+		t.Errorf("Expected the new order, received %s", got)
+	}
+	if err := is.Reorder(1, []string{"c.jpg", "a.jpg"}); err != ErrImageOrderInvalid {
+		t.Errorf("Expected ErrImageOrderInvalid, received %v", err)
+	}
+
+	if err := is.Update(&Image{GalleryID: 1, Filename: "a.jpg", AltText: "The letter a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Update(&Image{GalleryID: 1, Filename: "z.jpg"}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, received %v", err)
+	}
+	images, _ := is.ByGalleryID(1)
+	if images[1].AltText != "The letter a" || images[1].Position != 2 {
+		t.Errorf("Expected the alt text to be saved, received %+v", images[1])
+	}
+
+	if err := is.Delete(&Image{GalleryID: 1, Filename: "c.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Create(1, strings.NewReader("d"), "d.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if got := filenames(); got != "a.jpg,b.jpg,d.jpg" {
+		t.Errorf("Expected new images to go last, received %s", got)
+	}
+}
+
+// TestImageServiceSelection moves, copies and deletes several
+// images at once
+func TestImageServiceSelection(t *testing.T) {
+	is := testingServices(t).Image
+	inTempDir(t)
+	for _, image := range []Image{
+		{GalleryID: 1, Filename: "a.jpg"},
+		{GalleryID: 1, Filename: "b.jpg"},
+		{GalleryID: 1, Filename: "c.jpg"},
+		{GalleryID: 2, Filename: "z.jpg"},
+	} {
+		if err := is.Create(image.GalleryID, strings.NewReader(image.Filename), image.Filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := is.Update(&Image{GalleryID: 1, Filename: "b.jpg", Caption: "Bee"}); err != nil {
+		t.Fatal(err)
+	}
+	filenames := func(galleryID uint) string {
+		images, err := is.ByGalleryID(galleryID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, image := range images {
+			names = append(names, image.Filename)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if err := is.Move(1, []string{"c.jpg", "b.jpg"}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := filenames(1), filenames(2); a != "a.jpg" || b != "z.jpg,b.jpg,c.jpg" {
+		t.Errorf("Expected the images at the end of the other gallery, received %q and %q", a, b)
+	}
+	images, _ := is.ByGalleryID(2)
+	if images[1].Caption != "Bee" || images[1].Position != 2 {
+		t.Errorf("Expected the details to move with the image, received %+v", images[1])
+	}
+
+	if err := is.Copy(2, []string{"b.jpg"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	images, _ = is.ByGalleryID(1)
+	if len(images) != 2 || images[1].Filename != "b.jpg" || images[1].Caption != "Bee" || filenames(2) != "z.jpg,b.jpg,c.jpg" {
+		t.Errorf("Expected a copy of the image, received %+v", images)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		filenames []string
+		to        uint
+		want      error
+	}{
+		{"nothing selected", nil, 2, ErrNoImagesSelected},
+		{"unknown image", []string{"a.jpg", "x.jpg"}, 2, ErrNotFound},
+		{"same gallery", []string{"a.jpg"}, 1, ErrSameGallery},
+		{"name taken", []string{"a.jpg", "b.jpg"}, 2, ErrImageNameTaken},
+	} {
+		if err := is.Move(1, tc.filenames, tc.to); err != tc.want {
+			t.Errorf("%s: expected %v, received %v", tc.name, tc.want, err)
+		}
+	}
+	if got := filenames(1); got != "a.jpg,b.jpg" {
+		t.Errorf("Expected nothing to move when one image can't, received %s", got)
+	}
+
+	if err := is.DeleteMany(2, []string{"z.jpg", "c.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := filenames(2); got != "b.jpg" {
+		t.Errorf("Expected just b.jpg left, received %s", got)
+	}
+	if err := is.DeleteMany(2, []string{"b.jpg", "c.jpg"}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, received %v", err)
+	}
+}
+
+// TestFileBatchRollback makes sure a failed batch leaves every file
+// as it was
+func TestFileBatchRollback(t *testing.T) {
+	inTempDir(t)
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if err := ioutil.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var b fileBatch
+	if err := b.rename("a.jpg", "moved.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.copy("b.jpg", "copied.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.rename("c.jpg", "renamed.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.rename("moved.jpg", "b.jpg"); err != ErrImageNameTaken {
+		t.Fatalf("Expected ErrImageNameTaken, received %v", err)
+	}
+	failed := errors.New("saving the details failed")
+	if err := b.rollback(failed); err != failed {
+		t.Errorf("Expected the error that caused the rollback, received %v", err)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if contents, err := ioutil.ReadFile(name); err != nil || string(contents) != name {
+			t.Errorf("Expected %s to be put back, received %q %v", name, contents, err)
+		}
+	}
+	entries, _ := ioutil.ReadDir(".")
+	if len(entries) != 3 {
+		t.Errorf("Expected just the three files, received %d entries", len(entries))
+	}
+}
@@ -0,0 +1,97 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewAccountDeletionService returns a models.AccountDeletionService
+// that keeps deletions in memory
+func NewAccountDeletionService() models.AccountDeletionService {
+	return models.NewAccountDeletionServiceFromDB(NewAccountDeletionDB())
+}
+
+// NewAccountDeletionDB returns an empty in-memory
+// models.AccountDeletionDB
+func NewAccountDeletionDB() *AccountDeletionDB {
+	return &AccountDeletionDB{
+		deletions: make(map[uint]models.AccountDeletion),
+	}
+}
+
+var _ models.AccountDeletionDB = &AccountDeletionDB{}
+
+// AccountDeletionDB stores account deletions in a map keyed by
+// their ID.
+type AccountDeletionDB struct {
+	mu        sync.RWMutex
+	deletions map[uint]models.AccountDeletion
+	nextID    uint
+}
+
+// ByUserID looks up the deletion requested by a user
+func (addb *AccountDeletionDB) ByUserID(userID uint) (*models.AccountDeletion, error) {
+	addb.mu.RLock()
+	defer addb.mu.RUnlock()
+	for _, d := range addb.deletions {
+		if d.UserID == userID {
+			return &d, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// Due returns the unpurged deletions whose grace period ended
+// before t, oldest first
+func (addb *AccountDeletionDB) Due(t time.Time) ([]models.AccountDeletion, error) {
+	addb.mu.RLock()
+	defer addb.mu.RUnlock()
+	ret := []models.AccountDeletion{}
+	for _, d := range addb.deletions {
+		if !d.PurgeAfter.After(t) && d.PurgedAt == nil {
+			ret = append(ret, d)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].PurgeAfter.Before(ret[j].PurgeAfter)
+	})
+	return ret, nil
+}
+
+// Create will store the provided deletion and backfill the ID,
+// CreatedAt, and UpdatedAt fields.
+func (addb *AccountDeletionDB) Create(d *models.AccountDeletion) error {
+	addb.mu.Lock()
+	defer addb.mu.Unlock()
+	addb.nextID++
+	now := time.Now()
+	d.ID = addb.nextID
+	d.CreatedAt = now
+	d.UpdatedAt = now
+	addb.deletions[d.ID] = *d
+	return nil
+}
+
+// Update will replace the stored deletion with the provided one
+// and bump its UpdatedAt field.
+func (addb *AccountDeletionDB) Update(d *models.AccountDeletion) error {
+	addb.mu.Lock()
+	defer addb.mu.Unlock()
+	if _, ok := addb.deletions[d.ID]; !ok {
+		return models.ErrNotFound
+	}
+	d.UpdatedAt = time.Now()
+	addb.deletions[d.ID] = *d
+	return nil
+}
+
+// Delete will delete the deletion with the provided ID
+func (addb *AccountDeletionDB) Delete(id uint) error {
+	addb.mu.Lock()
+	defer addb.mu.Unlock()
+	delete(addb.deletions, id)
+	return nil
+}
@@ -0,0 +1,132 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewAPITokenService returns a models.APITokenService that keeps
+// API tokens in memory.
+func NewAPITokenService(hmacKey string) models.APITokenService {
+	return models.NewAPITokenServiceFromDB(NewAPITokenDB(), hmacKey)
+}
+
+// NewAPITokenDB returns an empty in-memory models.APITokenDB
+func NewAPITokenDB() *APITokenDB {
+	return &APITokenDB{
+		tokens: make(map[uint]models.APIToken),
+	}
+}
+
+var _ models.APITokenDB = &APITokenDB{}
+
+// APITokenDB stores API tokens in a map keyed by their ID.
+type APITokenDB struct {
+	mu     sync.RWMutex
+	tokens map[uint]models.APIToken
+	nextID uint
+}
+
+// ByID gets an API token by its ID
+func (atdb *APITokenDB) ByID(id uint) (*models.APIToken, error) {
+	atdb.mu.RLock()
+	defer atdb.mu.RUnlock()
+	token, ok := atdb.tokens[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &token, nil
+}
+
+// ByToken gets an API token by the hash of its token
+func (atdb *APITokenDB) ByToken(tokenHash string) (*models.APIToken, error) {
+	atdb.mu.RLock()
+	defer atdb.mu.RUnlock()
+	for _, token := range atdb.tokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// ByUserID gets all of a user's API tokens, newest first
+func (atdb *APITokenDB) ByUserID(userID uint) ([]models.APIToken, error) {
+	atdb.mu.RLock()
+	defer atdb.mu.RUnlock()
+	tokens := []models.APIToken{}
+	for _, token := range atdb.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID > tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// Create will store the provided API token and backfill the ID,
+// CreatedAt, and UpdatedAt fields.
+func (atdb *APITokenDB) Create(token *models.APIToken) error {
+	atdb.mu.Lock()
+	defer atdb.mu.Unlock()
+	atdb.nextID++
+	now := time.Now()
+	token.ID = atdb.nextID
+	token.CreatedAt = now
+	token.UpdatedAt = now
+	stored := *token
+	stored.Token = ""
+	atdb.tokens[token.ID] = stored
+	return nil
+}
+
+// Update will replace the stored API token with the provided one.
+func (atdb *APITokenDB) Update(token *models.APIToken) error {
+	atdb.mu.Lock()
+	defer atdb.mu.Unlock()
+	if _, ok := atdb.tokens[token.ID]; !ok {
+		return models.ErrNotFound
+	}
+	token.UpdatedAt = time.Now()
+	stored := *token
+	stored.Token = ""
+	atdb.tokens[token.ID] = stored
+	return nil
+}
+
+// Delete revokes the API token with the provided ID
+func (atdb *APITokenDB) Delete(id uint) error {
+	atdb.mu.Lock()
+	defer atdb.mu.Unlock()
+	delete(atdb.tokens, id)
+	return nil
+}
+
+// DeleteByOAuthClientID revokes every token issued to an app
+func (atdb *APITokenDB) DeleteByOAuthClientID(clientID uint) error {
+	atdb.mu.Lock()
+	defer atdb.mu.Unlock()
+	for id, token := range atdb.tokens {
+		if token.OAuthClientID == clientID {
+			delete(atdb.tokens, id)
+		}
+	}
+	return nil
+}
+
+// DeleteByUserID deletes every token of a user
+func (atdb *APITokenDB) DeleteByUserID(userID uint) error {
+	atdb.mu.Lock()
+	defer atdb.mu.Unlock()
+	for id, token := range atdb.tokens {
+		if token.UserID == userID {
+			delete(atdb.tokens, id)
+		}
+	}
+	return nil
+}
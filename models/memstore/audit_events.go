@@ -0,0 +1,77 @@
+package memstore
+
+import (
+	"sync"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewAuditService returns a models.AuditService that keeps events
+// in memory
+func NewAuditService() models.AuditService {
+	return models.NewAuditServiceFromDB(NewAuditDB())
+}
+
+// NewAuditDB returns an empty in-memory models.AuditDB
+func NewAuditDB() *AuditDB {
+	return &AuditDB{}
+}
+
+var _ models.AuditDB = &AuditDB{}
+
+// AuditDB stores audit events in the order they happened
+type AuditDB struct {
+	mu     sync.RWMutex
+	events []models.AuditEvent
+}
+
+// Find returns the events matching filter, most recent first
+func (adb *AuditDB) Find(filter models.AuditFilter) ([]models.AuditEvent, error) {
+	adb.mu.RLock()
+	defer adb.mu.RUnlock()
+	ret := []models.AuditEvent{}
+	for i := len(adb.events) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(ret) >= filter.Limit {
+			break
+		}
+		e := adb.events[i]
+		if filter.UserID > 0 && e.ActorID != filter.UserID && e.ImpersonatorID != filter.UserID && e.UserID != filter.UserID {
+			continue
+		}
+		if filter.Action != "" && e.Action != filter.Action {
+			continue
+		}
+		if !filter.Since.IsZero() && e.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !e.CreatedAt.Before(filter.Until) {
+			continue
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+// Create stores the event and backfills its ID
+func (adb *AuditDB) Create(event *models.AuditEvent) error {
+	adb.mu.Lock()
+	defer adb.mu.Unlock()
+	event.ID = uint(len(adb.events) + 1)
+	adb.events = append(adb.events, *event)
+	return nil
+}
+
+// Redact updates the IP, user agent and details of a stored event
+func (adb *AuditDB) Redact(event *models.AuditEvent) error {
+	adb.mu.Lock()
+	defer adb.mu.Unlock()
+	for i := range adb.events {
+		if adb.events[i].ID == event.ID {
+			adb.events[i].IP = event.IP
+			adb.events[i].UserAgent = event.UserAgent
+			adb.events[i].Details = event.Details
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
@@ -0,0 +1,104 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewCollectionService returns a models.CollectionService that
+// keeps collections in memory
+func NewCollectionService() models.CollectionService {
+	return models.NewCollectionServiceFromDB(NewCollectionDB())
+}
+
+// NewCollectionDB returns an empty in-memory models.CollectionDB
+func NewCollectionDB() *CollectionDB {
+	return &CollectionDB{
+		collections: make(map[uint]models.Collection),
+	}
+}
+
+var _ models.CollectionDB = &CollectionDB{}
+
+// CollectionDB stores collections in a map keyed by their ID.
+type CollectionDB struct {
+	mu          sync.RWMutex
+	collections map[uint]models.Collection
+	nextID      uint
+}
+
+// ByID gets a collection by its ID
+func (cdb *CollectionDB) ByID(id uint) (*models.Collection, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+	c, ok := cdb.collections[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &c, nil
+}
+
+// ByUserID gets every collection of a user, ordered by position
+// and ID
+func (cdb *CollectionDB) ByUserID(userID uint) ([]models.Collection, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+	ret := []models.Collection{}
+	for _, c := range cdb.collections {
+		if c.UserID == userID {
+			ret = append(ret, c)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Position != ret[j].Position {
+			return ret[i].Position < ret[j].Position
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return ret, nil
+}
+
+// Create will store the provided collection and backfill the ID,
+// CreatedAt, and UpdatedAt fields.
+func (cdb *CollectionDB) Create(c *models.Collection) error {
+	cdb.mu.Lock()
+	defer cdb.mu.Unlock()
+	cdb.nextID++
+	now := time.Now()
+	c.ID = cdb.nextID
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	cdb.collections[c.ID] = storedCollection(c)
+	return nil
+}
+
+// Update will replace the stored collection with the provided one.
+func (cdb *CollectionDB) Update(c *models.Collection) error {
+	cdb.mu.Lock()
+	defer cdb.mu.Unlock()
+	if _, ok := cdb.collections[c.ID]; !ok {
+		return models.ErrNotFound
+	}
+	c.UpdatedAt = time.Now()
+	cdb.collections[c.ID] = storedCollection(c)
+	return nil
+}
+
+// Delete will delete the collection with the provided ID
+func (cdb *CollectionDB) Delete(id uint) error {
+	cdb.mu.Lock()
+	defer cdb.mu.Unlock()
+	delete(cdb.collections, id)
+	return nil
+}
+
+// storedCollection copies a collection without its galleries, which
+// the database doesn't store either
+func storedCollection(c *models.Collection) models.Collection {
+	ret := *c
+	ret.Galleries = nil
+	return ret
+}
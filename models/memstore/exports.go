@@ -0,0 +1,124 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewExportService returns a models.ExportService that keeps
+// exports in memory
+func NewExportService(hmacKey string) models.ExportService {
+	return models.NewExportServiceFromDB(NewExportDB(), hmacKey)
+}
+
+// NewExportDB returns an empty in-memory models.ExportDB
+func NewExportDB() *ExportDB {
+	return &ExportDB{
+		exports: make(map[uint]models.Export),
+	}
+}
+
+var _ models.ExportDB = &ExportDB{}
+
+// ExportDB stores exports in a map keyed by their ID.
+type ExportDB struct {
+	mu      sync.RWMutex
+	exports map[uint]models.Export
+	nextID  uint
+}
+
+// ByToken looks up an export by its token hash.
+func (edb *ExportDB) ByToken(tokenHash string) (*models.Export, error) {
+	edb.mu.RLock()
+	defer edb.mu.RUnlock()
+	for _, e := range edb.exports {
+		if e.TokenHash != "" && e.TokenHash == tokenHash {
+			return &e, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// ByUserID returns the exports of a user, most recent first
+func (edb *ExportDB) ByUserID(userID uint) ([]models.Export, error) {
+	return edb.filter(func(e *models.Export) bool {
+		return e.UserID == userID
+	}, func(a, b *models.Export) bool {
+		return a.ID > b.ID
+	}), nil
+}
+
+// Pending returns the exports waiting to be built, oldest first
+func (edb *ExportDB) Pending() ([]models.Export, error) {
+	return edb.filter(func(e *models.Export) bool {
+		return e.Status == models.ExportPending
+	}, func(a, b *models.Export) bool {
+		return a.ID < b.ID
+	}), nil
+}
+
+// Expiring returns the ready exports that expired before t
+func (edb *ExportDB) Expiring(t time.Time) ([]models.Export, error) {
+	return edb.filter(func(e *models.Export) bool {
+		return e.Status == models.ExportReady && e.ExpiresAt != nil && !e.ExpiresAt.After(t)
+	}, func(a, b *models.Export) bool {
+		return a.ID < b.ID
+	}), nil
+}
+
+// Create will store the provided export and backfill the ID,
+// CreatedAt, and UpdatedAt fields.
+func (edb *ExportDB) Create(e *models.Export) error {
+	edb.mu.Lock()
+	defer edb.mu.Unlock()
+	edb.nextID++
+	now := time.Now()
+	e.ID = edb.nextID
+	e.CreatedAt = now
+	e.UpdatedAt = now
+	edb.exports[e.ID] = *e
+	return nil
+}
+
+// Update will replace the stored export with the provided one and
+// bump its UpdatedAt field.
+func (edb *ExportDB) Update(e *models.Export) error {
+	edb.mu.Lock()
+	defer edb.mu.Unlock()
+	if _, ok := edb.exports[e.ID]; !ok {
+		return models.ErrNotFound
+	}
+	e.UpdatedAt = time.Now()
+	edb.exports[e.ID] = *e
+	return nil
+}
+
+// DeleteByUserID deletes every export of a user
+func (edb *ExportDB) DeleteByUserID(userID uint) error {
+	edb.mu.Lock()
+	defer edb.mu.Unlock()
+	for id, e := range edb.exports {
+		if e.UserID == userID {
+			delete(edb.exports, id)
+		}
+	}
+	return nil
+}
+
+func (edb *ExportDB) filter(match func(*models.Export) bool, less func(a, b *models.Export) bool) []models.Export {
+	edb.mu.RLock()
+	defer edb.mu.RUnlock()
+	ret := []models.Export{}
+	for _, e := range edb.exports {
+		if match(&e) {
+			ret = append(ret, e)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return less(&ret[i], &ret[j])
+	})
+	return ret
+}
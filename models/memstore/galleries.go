@@ -0,0 +1,200 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewGalleryService returns a models.GalleryService that keeps
+// galleries in memory. Their images are counted with is.
+func NewGalleryService(is models.ImageService) models.GalleryService {
+	return models.NewGalleryServiceFromDB(NewGalleryDB(is))
+}
+
+// NewGalleryDB returns an empty in-memory models.GalleryDB
+func NewGalleryDB(is models.ImageService) *GalleryDB {
+	return &GalleryDB{
+		galleries: make(map[uint]models.Gallery),
+		deleted:   make(map[uint]models.Gallery),
+		is:        is,
+	}
+}
+
+var _ models.GalleryDB = &GalleryDB{}
+
+// GalleryDB stores galleries in a map keyed by their ID.
+type GalleryDB struct {
+	mu        sync.RWMutex
+	galleries map[uint]models.Gallery
+	// deleted are the galleries in the trash
+	deleted map[uint]models.Gallery
+	nextID  uint
+	// is counts the images of each gallery, for sorting by them
+	is models.ImageService
+}
+
+// ByID gets a gallery by its ID
+func (gdb *GalleryDB) ByID(id uint) (*models.Gallery, error) {
+	gdb.mu.RLock()
+	defer gdb.mu.RUnlock()
+	gallery, ok := gdb.galleries[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &gallery, nil
+}
+
+// ByUserID gets all galleries created by a user, ordered by ID
+func (gdb *GalleryDB) ByUserID(userID uint) ([]models.Gallery, error) {
+	gdb.mu.RLock()
+	defer gdb.mu.RUnlock()
+	galleries := []models.Gallery{}
+	for _, gallery := range gdb.galleries {
+		if gallery.UserID == userID {
+			galleries = append(galleries, gallery)
+		}
+	}
+	sort.Slice(galleries, func(i, j int) bool {
+		return galleries[i].ID < galleries[j].ID
+	})
+	return galleries, nil
+}
+
+// Page gets a page of a user's galleries, see models.PageGalleries
+func (gdb *GalleryDB) Page(q *models.GalleryQuery) (*models.GalleryPage, error) {
+	galleries, err := gdb.ByUserID(q.UserID)
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[uint]int)
+	for _, g := range galleries {
+		gImages, err := gdb.is.ByGalleryID(g.ID)
+		if err != nil {
+			return nil, err
+		}
+		images[g.ID] = len(gImages)
+	}
+	return models.PageGalleries(galleries, images, q)
+}
+
+// ByCollectionID gets the galleries in a collection, ordered by ID
+func (gdb *GalleryDB) ByCollectionID(collectionID uint) ([]models.Gallery, error) {
+	gdb.mu.RLock()
+	defer gdb.mu.RUnlock()
+	galleries := []models.Gallery{}
+	for _, gallery := range gdb.galleries {
+		if gallery.CollectionID == collectionID {
+			galleries = append(galleries, gallery)
+		}
+	}
+	sort.Slice(galleries, func(i, j int) bool {
+		return galleries[i].ID < galleries[j].ID
+	})
+	return galleries, nil
+}
+
+// Create will store the provided gallery and backfill the ID,
+// CreatedAt, and UpdatedAt fields.
+func (gdb *GalleryDB) Create(gallery *models.Gallery) error {
+	gdb.mu.Lock()
+	defer gdb.mu.Unlock()
+	gdb.nextID++
+	now := time.Now()
+	gallery.ID = gdb.nextID
+	gallery.CreatedAt = now
+	gallery.UpdatedAt = now
+	gdb.galleries[gallery.ID] = stored(gallery)
+	return nil
+}
+
+// Update will replace the stored gallery with the provided one.
+func (gdb *GalleryDB) Update(gallery *models.Gallery) error {
+	gdb.mu.Lock()
+	defer gdb.mu.Unlock()
+	if _, ok := gdb.galleries[gallery.ID]; !ok {
+		return models.ErrNotFound
+	}
+	gallery.UpdatedAt = time.Now()
+	gdb.galleries[gallery.ID] = stored(gallery)
+	return nil
+}
+
+// Delete moves the gallery with the provided ID to the trash
+func (gdb *GalleryDB) Delete(id uint) error {
+	gdb.mu.Lock()
+	defer gdb.mu.Unlock()
+	gallery, ok := gdb.galleries[id]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	gallery.DeletedAt = &now
+	delete(gdb.galleries, id)
+	gdb.deleted[id] = gallery
+	return nil
+}
+
+// DeletedByUserID returns the galleries of a user in the trash, the
+// latest deleted first
+func (gdb *GalleryDB) DeletedByUserID(userID uint) ([]models.Gallery, error) {
+	return gdb.deletedWhere(func(g *models.Gallery) bool {
+		return g.UserID == userID
+	}), nil
+}
+
+// DeletedBefore returns the galleries of every user deleted before t
+func (gdb *GalleryDB) DeletedBefore(t time.Time) ([]models.Gallery, error) {
+	return gdb.deletedWhere(func(g *models.Gallery) bool {
+		return g.DeletedAt.Before(t)
+	}), nil
+}
+
+func (gdb *GalleryDB) deletedWhere(match func(g *models.Gallery) bool) []models.Gallery {
+	gdb.mu.RLock()
+	defer gdb.mu.RUnlock()
+	galleries := []models.Gallery{}
+	for _, gallery := range gdb.deleted {
+		if match(&gallery) {
+			galleries = append(galleries, gallery)
+		}
+	}
+	sort.Slice(galleries, func(i, j int) bool {
+		return galleries[i].DeletedAt.After(*galleries[j].DeletedAt)
+	})
+	return galleries
+}
+
+// Restore takes a gallery out of the trash
+func (gdb *GalleryDB) Restore(id uint) error {
+	gdb.mu.Lock()
+	defer gdb.mu.Unlock()
+	gallery, ok := gdb.deleted[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+	gallery.DeletedAt = nil
+	delete(gdb.deleted, id)
+	gdb.galleries[id] = gallery
+	return nil
+}
+
+// Purge removes a gallery for good, whether or not it is in the
+// trash
+func (gdb *GalleryDB) Purge(id uint) error {
+	gdb.mu.Lock()
+	defer gdb.mu.Unlock()
+	delete(gdb.galleries, id)
+	delete(gdb.deleted, id)
+	return nil
+}
+
+// stored copies a gallery without its images, which the database
+// doesn't store either
+func stored(gallery *models.Gallery) models.Gallery {
+	g := *gallery
+	g.Images = nil
+	return g
+}
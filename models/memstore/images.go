@@ -0,0 +1,374 @@
+package memstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/imaging"
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewImageService returns an empty in-memory models.ImageService
+func NewImageService() *ImageService {
+	return &ImageService{
+		images:  make(map[uint]map[string][]byte),
+		details: make(map[uint]map[string]models.Image),
+		trash:   make(map[uint]map[string]trashedImage),
+	}
+}
+
+var _ models.ImageService = &ImageService{}
+
+// ImageService keeps the contents and details of every uploaded
+// image in memory, grouped by gallery.
+type ImageService struct {
+	mu      sync.RWMutex
+	images  map[uint]map[string][]byte
+	details map[uint]map[string]models.Image
+	// trash holds deleted images, grouped by gallery too
+	trash map[uint]map[string]trashedImage
+}
+
+// trashedImage is a deleted image, whose details know when it was
+// deleted
+type trashedImage struct {
+	b []byte
+	d models.Image
+}
+
+// Create stores the contents of r as filename in the gallery
+func (is *ImageService) Create(galleryID uint, r io.Reader, filename string) error {
+	if err := models.ValidateImageFilename(filename); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if is.images[galleryID] == nil {
+		is.images[galleryID] = make(map[string][]byte)
+		is.details[galleryID] = make(map[string]models.Image)
+	}
+	is.images[galleryID][filename] = b
+	delete(is.trash[galleryID], filename)
+	d, ok := is.details[galleryID][filename]
+	if !ok {
+		last := 0
+		for _, d := range is.details[galleryID] {
+			if d.Position > last {
+				last = d.Position
+			}
+		}
+		d = models.Image{Position: last + 1}
+	}
+	models.ApplyImageMetadata(&d, imaging.ReadMetadata(bytes.NewReader(b)))
+	is.details[galleryID][filename] = d
+	return nil
+}
+
+// ByGalleryID returns the images in a gallery in order
+func (is *ImageService) ByGalleryID(galleryID uint) ([]models.Image, error) {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	ret := make([]models.Image, 0, len(is.images[galleryID]))
+	for filename, b := range is.images[galleryID] {
+		d := is.details[galleryID][filename]
+		ret = append(ret, models.Image{
+			GalleryID: galleryID,
+			Filename:  filename,
+			Size:      int64(len(b)),
+			Position:  d.Position,
+			Caption:   d.Caption,
+			AltText:   d.AltText,
+			Tags:      d.Tags,
+			Camera:    d.Camera,
+			TakenAt:   d.TakenAt,
+		})
+	}
+	models.SortImages(ret)
+	return ret, nil
+}
+
+// Update saves the caption, alt text and tags of an image, returning
+// models.ErrNotFound if it doesn't exist
+func (is *ImageService) Update(i *models.Image) error {
+	if err := models.ValidateImageDetails(i); err != nil {
+		return err
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	d, ok := is.details[i.GalleryID][i.Filename]
+	if !ok {
+		return models.ErrNotFound
+	}
+	d.Caption = i.Caption
+	d.AltText = i.AltText
+	d.Tags = i.Tags
+	is.details[i.GalleryID][i.Filename] = d
+	return nil
+}
+
+// Reorder puts the images of a gallery in the order of filenames
+func (is *ImageService) Reorder(galleryID uint, filenames []string) error {
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return err
+	}
+	if err := models.ValidateImageOrder(images, filenames); err != nil {
+		return err
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for i, filename := range filenames {
+		d := is.details[galleryID][filename]
+		d.Position = i + 1
+		is.details[galleryID][filename] = d
+	}
+	return nil
+}
+
+// Delete moves an image to the trash, returning
+// models.ErrNotFound if it doesn't exist.
+func (is *ImageService) Delete(i *models.Image) error {
+	return is.DeleteMany(i.GalleryID, []string{i.Filename})
+}
+
+// DeleteMany moves the images of a gallery named filenames to the
+// trash
+func (is *ImageService) DeleteMany(galleryID uint, filenames []string) error {
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return err
+	}
+	selected, err := models.SelectImages(images, filenames)
+	if err != nil {
+		return err
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if is.trash[galleryID] == nil {
+		is.trash[galleryID] = make(map[string]trashedImage)
+	}
+	now := time.Now()
+	for _, i := range selected {
+		d := is.details[galleryID][i.Filename]
+		d.GalleryID = galleryID
+		d.Filename = i.Filename
+		d.DeletedAt = &now
+		is.trash[galleryID][i.Filename] = trashedImage{b: is.images[galleryID][i.Filename], d: d}
+		delete(is.images[galleryID], i.Filename)
+		delete(is.details[galleryID], i.Filename)
+	}
+	return nil
+}
+
+// DeletedByGalleryID returns the images in a gallery's trash, the
+// latest deleted first
+func (is *ImageService) DeletedByGalleryID(galleryID uint) ([]models.Image, error) {
+	return is.deletedWhere(func(i *models.Image) bool {
+		return i.GalleryID == galleryID
+	}), nil
+}
+
+// DeletedBefore returns the images of every gallery deleted before t
+func (is *ImageService) DeletedBefore(t time.Time) ([]models.Image, error) {
+	return is.deletedWhere(func(i *models.Image) bool {
+		return i.DeletedAt.Before(t)
+	}), nil
+}
+
+func (is *ImageService) deletedWhere(match func(i *models.Image) bool) []models.Image {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	ret := []models.Image{}
+	for _, trashed := range is.trash {
+		for _, t := range trashed {
+			i := t.d
+			i.Size = int64(len(t.b))
+			if match(&i) {
+				ret = append(ret, i)
+			}
+		}
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].DeletedAt.After(*ret[b].DeletedAt)
+	})
+	return ret
+}
+
+// OpenDeleted returns a reader over the contents of an image in the
+// trash
+func (is *ImageService) OpenDeleted(i *models.Image) (io.ReadCloser, error) {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	t, ok := is.trash[i.GalleryID][i.Filename]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(t.b)), nil
+}
+
+// Restore takes an image out of the trash, unless its gallery has
+// an image with the same name
+func (is *ImageService) Restore(i *models.Image) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	t, ok := is.trash[i.GalleryID][i.Filename]
+	if !ok {
+		return models.ErrNotFound
+	}
+	if _, ok := is.images[i.GalleryID][i.Filename]; ok {
+		return models.ErrRestoreNameTaken
+	}
+	if is.images[i.GalleryID] == nil {
+		is.images[i.GalleryID] = make(map[string][]byte)
+		is.details[i.GalleryID] = make(map[string]models.Image)
+	}
+	t.d.DeletedAt = nil
+	is.images[i.GalleryID][i.Filename] = t.b
+	is.details[i.GalleryID][i.Filename] = t.d
+	delete(is.trash[i.GalleryID], i.Filename)
+	return nil
+}
+
+// Purge removes an image for good, whether or not it is in the
+// trash
+func (is *ImageService) Purge(i *models.Image) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	_, live := is.images[i.GalleryID][i.Filename]
+	_, trashed := is.trash[i.GalleryID][i.Filename]
+	if !live && !trashed {
+		return models.ErrNotFound
+	}
+	delete(is.images[i.GalleryID], i.Filename)
+	delete(is.details[i.GalleryID], i.Filename)
+	delete(is.trash[i.GalleryID], i.Filename)
+	return nil
+}
+
+// Discard removes an image from its gallery, but not from its trash
+func (is *ImageService) Discard(i *models.Image) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	delete(is.images[i.GalleryID], i.Filename)
+	delete(is.details[i.GalleryID], i.Filename)
+	return nil
+}
+
+// Move moves the images of a gallery named filenames to the end of
+// another gallery
+func (is *ImageService) Move(galleryID uint, filenames []string, toGalleryID uint) error {
+	return is.transfer(galleryID, filenames, toGalleryID, true)
+}
+
+// Copy copies the images of a gallery named filenames to the end of
+// another gallery
+func (is *ImageService) Copy(galleryID uint, filenames []string, toGalleryID uint) error {
+	return is.transfer(galleryID, filenames, toGalleryID, false)
+}
+
+// transfer moves or copies images into another gallery. It checks
+// everything before changing anything, so there's nothing to undo.
+func (is *ImageService) transfer(galleryID uint, filenames []string, toGalleryID uint, move bool) error {
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return err
+	}
+	selected, err := models.SelectImages(images, filenames)
+	if err != nil {
+		return err
+	}
+	dest, err := is.ByGalleryID(toGalleryID)
+	if err != nil {
+		return err
+	}
+	if err := models.ValidateImageTransfer(galleryID, toGalleryID, selected, dest); err != nil {
+		return err
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if is.images[toGalleryID] == nil {
+		is.images[toGalleryID] = make(map[string][]byte)
+		is.details[toGalleryID] = make(map[string]models.Image)
+	}
+	last := 0
+	for _, d := range is.details[toGalleryID] {
+		if d.Position > last {
+			last = d.Position
+		}
+	}
+	for n, i := range selected {
+		b := is.images[galleryID][i.Filename]
+		d := is.details[galleryID][i.Filename]
+		if move {
+			delete(is.images[galleryID], i.Filename)
+			delete(is.details[galleryID], i.Filename)
+		} else {
+			b = append([]byte(nil), b...)
+		}
+		d.Position = last + n + 1
+		is.images[toGalleryID][i.Filename] = b
+		is.details[toGalleryID][i.Filename] = d
+		delete(is.trash[toGalleryID], i.Filename)
+	}
+	return nil
+}
+
+// DeleteAll removes every image in a gallery, including its trash
+func (is *ImageService) DeleteAll(galleryID uint) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	delete(is.images, galleryID)
+	delete(is.details, galleryID)
+	delete(is.trash, galleryID)
+	return nil
+}
+
+// GalleryIDs lists the galleries with images or trash stored
+func (is *ImageService) GalleryIDs() ([]uint, error) {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	seen := make(map[uint]bool)
+	var ids []uint
+	add := func(id uint, n int) {
+		if n > 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for id, images := range is.images {
+		add(id, len(images))
+	}
+	for id, trash := range is.trash {
+		add(id, len(trash))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// Open returns a reader over the stored contents of an image
+func (is *ImageService) Open(i *models.Image) (io.ReadCloser, error) {
+	b, err := is.Bytes(i)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Bytes returns the stored contents of an image
+func (is *ImageService) Bytes(i *models.Image) ([]byte, error) {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	b, ok := is.images[i.GalleryID][i.Filename]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return b, nil
+}
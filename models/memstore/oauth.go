@@ -0,0 +1,242 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewOAuthService returns a models.OAuthService that keeps apps,
+// codes and refresh tokens in memory and issues access tokens
+// through tokens.
+func NewOAuthService(tokens models.APITokenService, hmacKey string) models.OAuthService {
+	return models.NewOAuthServiceFromDB(NewOAuthDB(), tokens, hmacKey)
+}
+
+// NewOAuthDB returns an empty in-memory models.OAuthDB
+func NewOAuthDB() *OAuthDB {
+	return &OAuthDB{
+		clients: make(map[uint]models.OAuthClient),
+		codes:   make(map[uint]models.OAuthCode),
+		refresh: make(map[uint]models.OAuthRefreshToken),
+	}
+}
+
+var _ models.OAuthDB = &OAuthDB{}
+
+// OAuthDB stores apps, codes and refresh tokens in maps keyed by
+// their ID. The raw secrets and tokens are never stored.
+type OAuthDB struct {
+	mu      sync.RWMutex
+	clients map[uint]models.OAuthClient
+	codes   map[uint]models.OAuthCode
+	refresh map[uint]models.OAuthRefreshToken
+	nextID  uint
+}
+
+// ClientByID gets an app by its ID
+func (odb *OAuthDB) ClientByID(id uint) (*models.OAuthClient, error) {
+	odb.mu.RLock()
+	defer odb.mu.RUnlock()
+	client, ok := odb.clients[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &client, nil
+}
+
+// ClientByClientID gets an app by its public client ID
+func (odb *OAuthDB) ClientByClientID(clientID string) (*models.OAuthClient, error) {
+	odb.mu.RLock()
+	defer odb.mu.RUnlock()
+	for _, client := range odb.clients {
+		if client.ClientID == clientID {
+			return &client, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// ClientsByUserID gets the apps a user registered
+func (odb *OAuthDB) ClientsByUserID(userID uint) ([]models.OAuthClient, error) {
+	odb.mu.RLock()
+	defer odb.mu.RUnlock()
+	clients := []models.OAuthClient{}
+	for _, client := range odb.clients {
+		if client.UserID == userID {
+			clients = append(clients, client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+	return clients, nil
+}
+
+// CreateClient will store the provided app and backfill the ID,
+// CreatedAt, and UpdatedAt fields.
+func (odb *OAuthDB) CreateClient(client *models.OAuthClient) error {
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+	odb.nextID++
+	now := time.Now()
+	client.ID = odb.nextID
+	client.CreatedAt = now
+	client.UpdatedAt = now
+	stored := *client
+	stored.Secret = ""
+	odb.clients[client.ID] = stored
+	return nil
+}
+
+// DeleteClient deletes the app with the provided ID
+func (odb *OAuthDB) DeleteClient(id uint) error {
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+	delete(odb.clients, id)
+	return nil
+}
+
+// CodeByCode gets an authorization code by its hash
+func (odb *OAuthDB) CodeByCode(codeHash string) (*models.OAuthCode, error) {
+	odb.mu.RLock()
+	defer odb.mu.RUnlock()
+	for _, code := range odb.codes {
+		if code.CodeHash == codeHash {
+			return &code, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// CreateCode will store the provided authorization code
+func (odb *OAuthDB) CreateCode(code *models.OAuthCode) error {
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+	odb.nextID++
+	now := time.Now()
+	code.ID = odb.nextID
+	code.CreatedAt = now
+	code.UpdatedAt = now
+	stored := *code
+	stored.Code = ""
+	odb.codes[code.ID] = stored
+	return nil
+}
+
+// DeleteCode removes an authorization code, returning ErrNotFound
+// if it was already gone
+func (odb *OAuthDB) DeleteCode(id uint) error {
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+	if _, ok := odb.codes[id]; !ok {
+		return models.ErrNotFound
+	}
+	delete(odb.codes, id)
+	return nil
+}
+
+// RefreshTokenByToken gets a refresh token by its hash
+func (odb *OAuthDB) RefreshTokenByToken(tokenHash string) (*models.OAuthRefreshToken, error) {
+	odb.mu.RLock()
+	defer odb.mu.RUnlock()
+	for _, token := range odb.refresh {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// RefreshTokensByUserID returns the refresh tokens issued on behalf
+// of a user, oldest first
+func (odb *OAuthDB) RefreshTokensByUserID(userID uint) ([]models.OAuthRefreshToken, error) {
+	odb.mu.RLock()
+	defer odb.mu.RUnlock()
+	ret := []models.OAuthRefreshToken{}
+	for _, token := range odb.refresh {
+		if token.UserID == userID {
+			ret = append(ret, token)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret, nil
+}
+
+// CreateRefreshToken will store the provided refresh token
+func (odb *OAuthDB) CreateRefreshToken(token *models.OAuthRefreshToken) error {
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+	odb.nextID++
+	now := time.Now()
+	token.ID = odb.nextID
+	token.CreatedAt = now
+	token.UpdatedAt = now
+	stored := *token
+	stored.Token = ""
+	odb.refresh[token.ID] = stored
+	return nil
+}
+
+// DeleteRefreshToken revokes a refresh token, returning
+// ErrNotFound if it was already gone
+func (odb *OAuthDB) DeleteRefreshToken(id uint) error {
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+	if _, ok := odb.refresh[id]; !ok {
+		return models.ErrNotFound
+	}
+	delete(odb.refresh, id)
+	return nil
+}
+
+// DeleteRefreshTokensByClientID revokes every refresh token
+// issued to an app
+func (odb *OAuthDB) DeleteRefreshTokensByClientID(clientID uint) error {
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+	for id, token := range odb.refresh {
+		if token.OAuthClientID == clientID {
+			delete(odb.refresh, id)
+		}
+	}
+	return nil
+}
+
+// DeleteGrantsByUserID deletes every code and refresh token issued
+// on behalf of a user
+func (odb *OAuthDB) DeleteGrantsByUserID(userID uint) error {
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+	for id, code := range odb.codes {
+		if code.UserID == userID {
+			delete(odb.codes, id)
+		}
+	}
+	for id, token := range odb.refresh {
+		if token.UserID == userID {
+			delete(odb.refresh, id)
+		}
+	}
+	return nil
+}
+
+// DeleteGrantsByClientAndUserID deletes the codes and refresh
+// tokens issued to an app on behalf of a user
+func (odb *OAuthDB) DeleteGrantsByClientAndUserID(clientID, userID uint) error {
+	odb.mu.Lock()
+	defer odb.mu.Unlock()
+	for id, code := range odb.codes {
+		if code.OAuthClientID == clientID && code.UserID == userID {
+			delete(odb.codes, id)
+		}
+	}
+	for id, token := range odb.refresh {
+		if token.OAuthClientID == clientID && token.UserID == userID {
+			delete(odb.refresh, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package memstore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewPwResetDB returns an empty in-memory models.PwResetDB
+func NewPwResetDB() *PwResetDB {
+	return &PwResetDB{
+		resets: make(map[uint]models.PwReset),
+	}
+}
+
+var _ models.PwResetDB = &PwResetDB{}
+
+// PwResetDB stores password resets in a map keyed by their ID.
+type PwResetDB struct {
+	mu     sync.RWMutex
+	resets map[uint]models.PwReset
+	nextID uint
+}
+
+// ByToken looks up a password reset by its token hash.
+func (pwrdb *PwResetDB) ByToken(tokenHash string) (*models.PwReset, error) {
+	pwrdb.mu.RLock()
+	defer pwrdb.mu.RUnlock()
+	for _, pwr := range pwrdb.resets {
+		if pwr.TokenHash == tokenHash {
+			return &pwr, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// Create will store the provided password reset and backfill
+// the ID, CreatedAt, and UpdatedAt fields.
+func (pwrdb *PwResetDB) Create(pwr *models.PwReset) error {
+	pwrdb.mu.Lock()
+	defer pwrdb.mu.Unlock()
+	pwrdb.nextID++
+	now := time.Now()
+	pwr.ID = pwrdb.nextID
+	pwr.CreatedAt = now
+	pwr.UpdatedAt = now
+	pwrdb.resets[pwr.ID] = *pwr
+	return nil
+}
+
+// Delete will delete the password reset with the provided ID
+func (pwrdb *PwResetDB) Delete(id uint) error {
+	pwrdb.mu.Lock()
+	defer pwrdb.mu.Unlock()
+	delete(pwrdb.resets, id)
+	return nil
+}
+
+// DeleteByUserID deletes every password reset of a user
+func (pwrdb *PwResetDB) DeleteByUserID(userID uint) error {
+	pwrdb.mu.Lock()
+	defer pwrdb.mu.Unlock()
+	for id, pwr := range pwrdb.resets {
+		if pwr.UserID == userID {
+			delete(pwrdb.resets, id)
+		}
+	}
+	return nil
+}
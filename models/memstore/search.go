@@ -0,0 +1,46 @@
+package memstore
+
+import (
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewSearchService returns a models.SearchService that searches
+// the galleries and images of the given services
+func NewSearchService(gs models.GalleryService, is models.ImageService) models.SearchService {
+	return models.NewSearchServiceFromDB(&SearchDB{gs: gs, is: is})
+}
+
+var _ models.SearchDB = &SearchDB{}
+
+// SearchDB goes through every gallery and image of a user, finding
+// what the portable database search would
+type SearchDB struct {
+	gs models.GalleryService
+	is models.ImageService
+}
+
+// Search returns the galleries and images matching q
+func (sdb *SearchDB) Search(q *models.SearchQuery) (*models.SearchResults, error) {
+	galleries, err := sdb.gs.ByUserID(q.UserID)
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Gallery
+	var images []models.ImageResult
+	for i := range galleries {
+		g := &galleries[i]
+		gImages, err := sdb.is.ByGalleryID(g.ID)
+		if err != nil {
+			return nil, err
+		}
+		if q.MatchGallery(g, gImages) {
+			found = append(found, *g)
+		}
+		for j := range gImages {
+			if q.MatchImage(&gImages[j], g) {
+				images = append(images, models.NewImageResult(gImages[j], g))
+			}
+		}
+	}
+	return models.NewSearchResults(found, images), nil
+}
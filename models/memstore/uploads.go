@@ -0,0 +1,110 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewUploadService returns a models.UploadService that keeps
+// uploads in memory
+func NewUploadService() models.UploadService {
+	return models.NewUploadServiceFromDB(NewUploadDB())
+}
+
+// NewUploadDB returns an empty in-memory models.UploadDB
+func NewUploadDB() *UploadDB {
+	return &UploadDB{
+		uploads: make(map[uint]models.Upload),
+	}
+}
+
+var _ models.UploadDB = &UploadDB{}
+
+// UploadDB stores uploads in a map keyed by their ID.
+type UploadDB struct {
+	mu      sync.RWMutex
+	uploads map[uint]models.Upload
+	nextID  uint
+}
+
+// ByID looks up an upload by its ID.
+func (udb *UploadDB) ByID(id uint) (*models.Upload, error) {
+	udb.mu.RLock()
+	defer udb.mu.RUnlock()
+	u, ok := udb.uploads[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &u, nil
+}
+
+// ByUserID returns the uploads the user hasn't finished or
+// abandoned, oldest first
+func (udb *UploadDB) ByUserID(userID uint) ([]models.Upload, error) {
+	udb.mu.RLock()
+	defer udb.mu.RUnlock()
+	ret := []models.Upload{}
+	for _, u := range udb.uploads {
+		if u.UserID == userID {
+			ret = append(ret, u)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret, nil
+}
+
+// Expired returns the uploads that expired before t, oldest first
+func (udb *UploadDB) Expired(t time.Time) ([]models.Upload, error) {
+	udb.mu.RLock()
+	defer udb.mu.RUnlock()
+	ret := []models.Upload{}
+	for _, u := range udb.uploads {
+		if !u.ExpiresAt.After(t) {
+			ret = append(ret, u)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret, nil
+}
+
+// Create will store the provided upload and backfill the ID,
+// CreatedAt, and UpdatedAt fields.
+func (udb *UploadDB) Create(u *models.Upload) error {
+	udb.mu.Lock()
+	defer udb.mu.Unlock()
+	udb.nextID++
+	now := time.Now()
+	u.ID = udb.nextID
+	u.CreatedAt = now
+	u.UpdatedAt = now
+	udb.uploads[u.ID] = *u
+	return nil
+}
+
+// Update will replace the stored upload with the provided one and
+// bump its UpdatedAt field.
+func (udb *UploadDB) Update(u *models.Upload) error {
+	udb.mu.Lock()
+	defer udb.mu.Unlock()
+	if _, ok := udb.uploads[u.ID]; !ok {
+		return models.ErrNotFound
+	}
+	u.UpdatedAt = time.Now()
+	udb.uploads[u.ID] = *u
+	return nil
+}
+
+// Delete will delete the upload with the provided ID
+func (udb *UploadDB) Delete(id uint) error {
+	udb.mu.Lock()
+	defer udb.mu.Unlock()
+	delete(udb.uploads, id)
+	return nil
+}
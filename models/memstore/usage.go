@@ -0,0 +1,92 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewUsageService returns a models.UsageService that keeps usage in
+// memory, counting the images of is
+func NewUsageService(us models.UserService, gs models.GalleryService, is models.ImageService) models.UsageService {
+	return models.NewUsageServiceFromDB(NewUsageDB(), us, gs, is)
+}
+
+// NewUsageDB returns an empty in-memory models.UsageDB
+func NewUsageDB() *UsageDB {
+	return &UsageDB{
+		usage: make(map[uint]models.GalleryUsage),
+	}
+}
+
+var _ models.UsageDB = &UsageDB{}
+
+// UsageDB stores the usage of each gallery in a map keyed by the
+// gallery's ID
+type UsageDB struct {
+	mu    sync.RWMutex
+	usage map[uint]models.GalleryUsage
+}
+
+// ByUserID gets the usage of a user's galleries, the largest first
+func (udb *UsageDB) ByUserID(userID uint) ([]models.GalleryUsage, error) {
+	udb.mu.RLock()
+	defer udb.mu.RUnlock()
+	var usage []models.GalleryUsage
+	for _, u := range udb.usage {
+		if u.UserID == userID {
+			usage = append(usage, u)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Bytes != usage[j].Bytes {
+			return usage[i].Bytes > usage[j].Bytes
+		}
+		return usage[i].GalleryID < usage[j].GalleryID
+	})
+	return usage, nil
+}
+
+// ByGalleryID gets the usage of a gallery
+func (udb *UsageDB) ByGalleryID(galleryID uint) (*models.GalleryUsage, error) {
+	udb.mu.RLock()
+	defer udb.mu.RUnlock()
+	u, ok := udb.usage[galleryID]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &u, nil
+}
+
+// All gets the usage of every gallery, ordered by gallery ID
+func (udb *UsageDB) All() ([]models.GalleryUsage, error) {
+	udb.mu.RLock()
+	defer udb.mu.RUnlock()
+	var usage []models.GalleryUsage
+	for _, u := range udb.usage {
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].GalleryID < usage[j].GalleryID
+	})
+	return usage, nil
+}
+
+// Set stores the usage of a gallery
+func (udb *UsageDB) Set(usage *models.GalleryUsage) error {
+	udb.mu.Lock()
+	defer udb.mu.Unlock()
+	usage.UpdatedAt = time.Now()
+	udb.usage[usage.GalleryID] = *usage
+	return nil
+}
+
+// Delete forgets the usage of a gallery
+func (udb *UsageDB) Delete(galleryID uint) error {
+	udb.mu.Lock()
+	defer udb.mu.Unlock()
+	delete(udb.usage, galleryID)
+	return nil
+}
@@ -0,0 +1,100 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewUserIdentityService returns a models.UserIdentityService that
+// keeps identities in memory and uses us for the users they belong
+// to.
+func NewUserIdentityService(us models.UserService) models.UserIdentityService {
+	return models.NewUserIdentityServiceFromDB(NewUserIdentityDB(), us)
+}
+
+// NewUserIdentityDB returns an empty in-memory
+// models.UserIdentityDB
+func NewUserIdentityDB() *UserIdentityDB {
+	return &UserIdentityDB{
+		identities: make(map[uint]models.UserIdentity),
+	}
+}
+
+var _ models.UserIdentityDB = &UserIdentityDB{}
+
+// UserIdentityDB stores identities in a map keyed by their ID.
+type UserIdentityDB struct {
+	mu         sync.RWMutex
+	identities map[uint]models.UserIdentity
+	nextID     uint
+}
+
+// ByID gets an identity by its ID
+func (uidb *UserIdentityDB) ByID(id uint) (*models.UserIdentity, error) {
+	uidb.mu.RLock()
+	defer uidb.mu.RUnlock()
+	identity, ok := uidb.identities[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &identity, nil
+}
+
+// ByProviderSubject gets the identity a provider knows by subject
+func (uidb *UserIdentityDB) ByProviderSubject(provider, subject string) (*models.UserIdentity, error) {
+	uidb.mu.RLock()
+	defer uidb.mu.RUnlock()
+	for _, identity := range uidb.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// ByUserID gets every identity linked to a user
+func (uidb *UserIdentityDB) ByUserID(userID uint) ([]models.UserIdentity, error) {
+	uidb.mu.RLock()
+	defer uidb.mu.RUnlock()
+	identities := []models.UserIdentity{}
+	for _, identity := range uidb.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].ID < identities[j].ID
+	})
+	return identities, nil
+}
+
+// Create will store the provided identity and backfill the ID,
+// CreatedAt, and UpdatedAt fields. Like the unique index in the
+// database, a provider and subject can only be stored once.
+func (uidb *UserIdentityDB) Create(identity *models.UserIdentity) error {
+	uidb.mu.Lock()
+	defer uidb.mu.Unlock()
+	for _, existing := range uidb.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return models.ErrIdentityTaken
+		}
+	}
+	uidb.nextID++
+	now := time.Now()
+	identity.ID = uidb.nextID
+	identity.CreatedAt = now
+	identity.UpdatedAt = now
+	uidb.identities[identity.ID] = *identity
+	return nil
+}
+
+// Delete removes the identity with the provided ID
+func (uidb *UserIdentityDB) Delete(id uint) error {
+	uidb.mu.Lock()
+	defer uidb.mu.Unlock()
+	delete(uidb.identities, id)
+	return nil
+}
@@ -0,0 +1,131 @@
+// Package memstore provides in-memory implementations of the
+// models services. They share the validation of the database
+// backed services, which makes them useful for testing the
+// controllers and middleware without a database.
+package memstore
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// NewUserService returns a models.UserService that keeps users
+// and password resets in memory.
+func NewUserService(pepper, hmacKey string) models.UserService {
+	return models.NewUserServiceFromDB(NewUserDB(), NewPwResetDB(), pepper, hmacKey)
+}
+
+// NewUserDB returns an empty in-memory models.UserDB
+func NewUserDB() *UserDB {
+	return &UserDB{
+		users: make(map[uint]models.User),
+	}
+}
+
+var _ models.UserDB = &UserDB{}
+
+// UserDB stores users in a map keyed by their ID. Every method
+// works on copies so callers can't change stored users without
+// calling Update, just like with the database.
+type UserDB struct {
+	mu     sync.RWMutex
+	users  map[uint]models.User
+	nextID uint
+}
+
+// ByID will look up a user with the provided ID.
+func (udb *UserDB) ByID(id uint) (*models.User, error) {
+	return udb.find(func(u *models.User) bool {
+		return u.ID == id
+	})
+}
+
+// ByEmail looks up a user with the given email address.
+func (udb *UserDB) ByEmail(email string) (*models.User, error) {
+	return udb.find(func(u *models.User) bool {
+		return u.Email == email
+	})
+}
+
+// ByRemember looks up a user with the given remember token
+// hash.
+func (udb *UserDB) ByRemember(rememberHash string) (*models.User, error) {
+	return udb.find(func(u *models.User) bool {
+		return u.RememberHash == rememberHash
+	})
+}
+
+// Search returns up to limit users whose name or email contains
+// query, ignoring case, most recent first
+func (udb *UserDB) Search(query string, limit int) ([]models.User, error) {
+	udb.mu.RLock()
+	defer udb.mu.RUnlock()
+	query = strings.ToLower(query)
+	var ret []models.User
+	for _, user := range udb.users {
+		if strings.Contains(strings.ToLower(user.Name), query) || strings.Contains(strings.ToLower(user.Email), query) {
+			ret = append(ret, user)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID > ret[j].ID
+	})
+	if len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, nil
+}
+
+// Create will store the provided user and backfill the ID,
+// CreatedAt, and UpdatedAt fields.
+func (udb *UserDB) Create(user *models.User) error {
+	udb.mu.Lock()
+	defer udb.mu.Unlock()
+	udb.nextID++
+	now := time.Now()
+	user.ID = udb.nextID
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	udb.users[user.ID] = *user
+	return nil
+}
+
+// Update will replace the stored user with the provided one.
+func (udb *UserDB) Update(user *models.User) error {
+	udb.mu.Lock()
+	defer udb.mu.Unlock()
+	if _, ok := udb.users[user.ID]; !ok {
+		return models.ErrNotFound
+	}
+	user.UpdatedAt = time.Now()
+	udb.users[user.ID] = *user
+	return nil
+}
+
+// Delete will delete the user with the provided ID
+func (udb *UserDB) Delete(id uint) error {
+	udb.mu.Lock()
+	defer udb.mu.Unlock()
+	delete(udb.users, id)
+	return nil
+}
+
+// Purge is the same as Delete
+func (udb *UserDB) Purge(id uint) error {
+	return udb.Delete(id)
+}
+
+func (udb *UserDB) find(match func(*models.User) bool) (*models.User, error) {
+	udb.mu.RLock()
+	defer udb.mu.RUnlock()
+	for _, user := range udb.users {
+		if match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
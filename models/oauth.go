@@ -0,0 +1,750 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sajicode/go-photo/hash"
+	"github.com/sajicode/go-photo/rand"
+)
+
+const (
+	// PKCEMethodS256 is the only PKCE code challenge method we
+	// accept, plain challenges offer no protection.
+	PKCEMethodS256 = "S256"
+
+	// OAuthAccessTokenDuration is how long an access token issued
+	// to an app is valid for. Apps use their refresh token to get
+	// a new one.
+	OAuthAccessTokenDuration = time.Hour
+
+	// OAuthRefreshTokenDuration is how long a refresh token is
+	// valid for. They are replaced on every use, so only apps that
+	// stop using theirs have to ask the user again.
+	OAuthRefreshTokenDuration = 90 * 24 * time.Hour
+
+	// oauthCodeDuration is how long an app has to exchange an
+	// authorization code
+	oauthCodeDuration = 10 * time.Minute
+)
+
+// OAuthClient is a third party app registered by one of our users
+// that other users can grant access to their galleries. Like API
+// tokens, only the hash of the secret is stored.
+type OAuthClient struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	ClientID   string `gorm:"not null;unique_index"`
+	Secret     string `gorm:"-"`
+	SecretHash string
+	// RedirectURIs is a space separated list of the URIs users may
+	// be sent back to after the consent screen
+	RedirectURIs string `gorm:"not null"`
+	// Public clients, like mobile or single page apps, can't keep
+	// a secret so they have no secret and must use PKCE
+	Public bool
+}
+
+// TableName keeps gorm from naming the table o_auth_clients
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// RedirectURI returns the registered redirect URI matching uri
+// exactly. If uri is empty and only one URI was registered, that
+// one is used.
+func (c *OAuthClient) RedirectURI(uri string) (string, bool) {
+	uris := strings.Fields(c.RedirectURIs)
+	if uri == "" {
+		if len(uris) == 1 {
+			return uris[0], true
+		}
+		return "", false
+	}
+	for _, registered := range uris {
+		if registered == uri {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// OAuthCode is an authorization code handed to an app after a
+// user approved it on the consent screen. It can only be
+// exchanged once.
+type OAuthCode struct {
+	gorm.Model
+	OAuthClientID uint   `gorm:"column:oauth_client_id;not null"`
+	UserID        uint   `gorm:"not null"`
+	Code          string `gorm:"-"`
+	CodeHash      string `gorm:"not null;unique_index"`
+	// RedirectURI is the redirect_uri the app sent, which may be
+	// empty. The token request has to send the same value.
+	RedirectURI         string
+	Scope               string `gorm:"not null"`
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ExpiresAt           time.Time
+}
+
+// TableName keeps gorm from naming the table o_auth_codes
+func (OAuthCode) TableName() string {
+	return "oauth_codes"
+}
+
+// OAuthRefreshToken lets an app get a new access token without
+// asking the user again. Refresh tokens are rotated on every use.
+type OAuthRefreshToken struct {
+	gorm.Model
+	OAuthClientID uint   `gorm:"column:oauth_client_id;not null;index"`
+	UserID        uint   `gorm:"not null;index"`
+	Token         string `gorm:"-"`
+	TokenHash     string `gorm:"not null;unique_index"`
+	Scope         string `gorm:"not null"`
+	// ExpiresAt is when the token stops working. Tokens issued
+	// before refresh tokens expired have none, and are treated as
+	// expired.
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the refresh token can no longer be used
+func (rt *OAuthRefreshToken) Expired() bool {
+	return !time.Now().Before(rt.ExpiresAt)
+}
+
+// TableName keeps gorm from naming the table o_auth_refresh_tokens
+func (OAuthRefreshToken) TableName() string {
+	return "oauth_refresh_tokens"
+}
+
+// OAuthGrant is what an app receives from the token endpoint. The
+// raw tokens are only available here.
+type OAuthGrant struct {
+	AccessToken  *APIToken
+	RefreshToken *OAuthRefreshToken
+}
+
+// OAuthDB is used to interact with registered apps and the codes
+// and refresh tokens issued to them. CodeByCode and
+// RefreshTokenByToken expect the raw values and hash them before
+// the lookup.
+type OAuthDB interface {
+	ClientByID(id uint) (*OAuthClient, error)
+	ClientByClientID(clientID string) (*OAuthClient, error)
+	ClientsByUserID(userID uint) ([]OAuthClient, error)
+	CreateClient(client *OAuthClient) error
+	DeleteClient(id uint) error
+
+	CodeByCode(code string) (*OAuthCode, error)
+	CreateCode(code *OAuthCode) error
+	DeleteCode(id uint) error
+
+	RefreshTokenByToken(token string) (*OAuthRefreshToken, error)
+	// RefreshTokensByUserID returns the refresh tokens issued on
+	// behalf of a user, expired or not
+	RefreshTokensByUserID(userID uint) ([]OAuthRefreshToken, error)
+	CreateRefreshToken(token *OAuthRefreshToken) error
+	DeleteRefreshToken(id uint) error
+	DeleteRefreshTokensByClientID(clientID uint) error
+	// DeleteGrantsByUserID removes every code and refresh token
+	// issued on behalf of a user
+	DeleteGrantsByUserID(userID uint) error
+	// DeleteGrantsByClientAndUserID removes the codes and refresh
+	// tokens issued to an app on behalf of a user
+	DeleteGrantsByClientAndUserID(clientID, userID uint) error
+}
+
+// OAuthService is a set of methods used to run our OAuth2
+// authorization server. Access tokens are issued as APITokens so
+// the API treats them exactly like personal tokens.
+type OAuthService interface {
+	// AuthenticateClient looks up an app by its client ID and, for
+	// confidential apps, checks its secret. ErrClientInvalid is
+	// returned if either is wrong.
+	AuthenticateClient(clientID, secret string) (*OAuthClient, error)
+
+	// Exchange redeems an authorization code issued to client. The
+	// code is used up even if the exchange fails.
+	Exchange(client *OAuthClient, code, redirectURI, verifier string) (*OAuthGrant, error)
+
+	// Refresh issues a new access token and replaces the refresh
+	// token used to get it.
+	Refresh(client *OAuthClient, refreshToken string) (*OAuthGrant, error)
+
+	// AuthorizedClients returns the apps a user authorized that can
+	// still get access tokens
+	AuthorizedClients(userID uint) ([]OAuthClient, error)
+
+	// RevokeClient takes back everything a user granted an app:
+	// its codes, refresh tokens and access tokens
+	RevokeClient(clientID, userID uint) error
+
+	OAuthDB
+}
+
+// NewOAuthService handles DB connection. Access tokens are
+// created through tokens.
+func NewOAuthService(db *gorm.DB, tokens APITokenService, hmacKey string) OAuthService {
+	return NewOAuthServiceFromDB(&oauthGorm{db}, tokens, hmacKey)
+}
+
+// NewOAuthServiceFromDB builds an OAuthService on top of any
+// OAuthDB implementation, wrapping it in our validation.
+func NewOAuthServiceFromDB(odb OAuthDB, tokens APITokenService, hmacKey string) OAuthService {
+	hmac := hash.NewHMAC(hmacKey)
+	return &oauthService{
+		OAuthDB: &oauthValidator{
+			OAuthDB: odb,
+			hmac:    hmac,
+		},
+		tokens: tokens,
+		hmac:   hmac,
+	}
+}
+
+var _ OAuthService = &oauthService{}
+
+type oauthService struct {
+	OAuthDB
+	tokens APITokenService
+	hmac   hash.HMAC
+}
+
+func (oas *oauthService) AuthenticateClient(clientID, secret string) (*OAuthClient, error) {
+	client, err := oas.ClientByClientID(clientID)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrClientInvalid
+		}
+		return nil, err
+	}
+	if client.Public {
+		return client, nil
+	}
+	secretHash := oas.hmac.Hash(secret)
+	if secret == "" || subtle.ConstantTimeCompare([]byte(secretHash), []byte(client.SecretHash)) != 1 {
+		return nil, ErrClientInvalid
+	}
+	return client, nil
+}
+
+func (oas *oauthService) Exchange(client *OAuthClient, code, redirectURI, verifier string) (*OAuthGrant, error) {
+	oc, err := oas.CodeByCode(code)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrGrantInvalid
+		}
+		return nil, err
+	}
+	// deleting the code first makes sure it can only be used once,
+	// even by concurrent requests
+	if err := oas.DeleteCode(oc.ID); err != nil {
+		if err == ErrNotFound {
+			return nil, ErrGrantInvalid
+		}
+		return nil, err
+	}
+	if oc.OAuthClientID != client.ID || oc.RedirectURI != redirectURI || time.Now().After(oc.ExpiresAt) {
+		return nil, ErrGrantInvalid
+	}
+	if !verifyCodeChallenge(oc, verifier) {
+		return nil, ErrGrantInvalid
+	}
+	return oas.grant(client, oc.UserID, oc.Scope)
+}
+
+func (oas *oauthService) Refresh(client *OAuthClient, refreshToken string) (*OAuthGrant, error) {
+	rt, err := oas.RefreshTokenByToken(refreshToken)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrGrantInvalid
+		}
+		return nil, err
+	}
+	if rt.OAuthClientID != client.ID {
+		return nil, ErrGrantInvalid
+	}
+	if err := oas.DeleteRefreshToken(rt.ID); err != nil {
+		if err == ErrNotFound {
+			return nil, ErrGrantInvalid
+		}
+		return nil, err
+	}
+	if rt.Expired() {
+		return nil, ErrGrantInvalid
+	}
+	return oas.grant(client, rt.UserID, rt.Scope)
+}
+
+func (oas *oauthService) AuthorizedClients(userID uint) ([]OAuthClient, error) {
+	tokens, err := oas.RefreshTokensByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint]bool)
+	clients := []OAuthClient{}
+	for _, token := range tokens {
+		if token.Expired() || seen[token.OAuthClientID] {
+			continue
+		}
+		seen[token.OAuthClientID] = true
+		client, err := oas.ClientByID(token.OAuthClientID)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	return clients, nil
+}
+
+func (oas *oauthService) RevokeClient(clientID, userID uint) error {
+	if err := oas.DeleteGrantsByClientAndUserID(clientID, userID); err != nil {
+		return err
+	}
+	tokens, err := oas.tokens.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if token.OAuthClientID != clientID {
+			continue
+		}
+		if err := oas.tokens.Delete(token.ID); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteClient also revokes every token issued to the app
+func (oas *oauthService) DeleteClient(id uint) error {
+	if err := oas.DeleteRefreshTokensByClientID(id); err != nil {
+		return err
+	}
+	if err := oas.tokens.DeleteByOAuthClientID(id); err != nil {
+		return err
+	}
+	return oas.OAuthDB.DeleteClient(id)
+}
+
+// grant issues a new access and refresh token pair
+func (oas *oauthService) grant(client *OAuthClient, userID uint, scope string) (*OAuthGrant, error) {
+	expiresAt := time.Now().Add(OAuthAccessTokenDuration)
+	access := APIToken{
+		UserID:        userID,
+		OAuthClientID: client.ID,
+		Name:          client.Name,
+		Scope:         scope,
+		ExpiresAt:     &expiresAt,
+	}
+	if err := oas.tokens.Create(&access); err != nil {
+		return nil, err
+	}
+	refresh := OAuthRefreshToken{
+		OAuthClientID: client.ID,
+		UserID:        userID,
+		Scope:         scope,
+		ExpiresAt:     time.Now().Add(OAuthRefreshTokenDuration),
+	}
+	if err := oas.CreateRefreshToken(&refresh); err != nil {
+		return nil, err
+	}
+	return &OAuthGrant{
+		AccessToken:  &access,
+		RefreshToken: &refresh,
+	}, nil
+}
+
+// verifyCodeChallenge checks the PKCE code verifier against the
+// challenge stored with the code. Codes issued without a
+// challenge must be exchanged without a verifier.
+func verifyCodeChallenge(oc *OAuthCode, verifier string) bool {
+	if oc.CodeChallenge == "" {
+		return verifier == ""
+	}
+	// RFC 7636 verifiers are 43 to 128 characters long
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(oc.CodeChallenge)) == 1
+}
+
+type oauthClientValFunc func(*OAuthClient) error
+
+func runOAuthClientValFuncs(client *OAuthClient, fns ...oauthClientValFunc) error {
+	for _, fn := range fns {
+		if err := fn(client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type oauthCodeValFunc func(*OAuthCode) error
+
+func runOAuthCodeValFuncs(code *OAuthCode, fns ...oauthCodeValFunc) error {
+	for _, fn := range fns {
+		if err := fn(code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * validators
+type oauthValidator struct {
+	OAuthDB
+	hmac hash.HMAC
+}
+
+// CreateClient generates the client ID and, for confidential
+// apps, the secret
+func (ov *oauthValidator) CreateClient(client *OAuthClient) error {
+	err := runOAuthClientValFuncs(client,
+		ov.clientUserIDRequired,
+		ov.appNameRequired,
+		ov.redirectURIsValid,
+		ov.setClientID,
+		ov.setSecretIfConfidential)
+	if err != nil {
+		return err
+	}
+	return ov.OAuthDB.CreateClient(client)
+}
+
+// DeleteClient deletes the app with the provided ID
+func (ov *oauthValidator) DeleteClient(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return ov.OAuthDB.DeleteClient(id)
+}
+
+// DeleteCode deletes the code with the provided ID
+func (ov *oauthValidator) DeleteCode(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return ov.OAuthDB.DeleteCode(id)
+}
+
+// DeleteRefreshToken deletes the refresh token with the provided ID
+func (ov *oauthValidator) DeleteRefreshToken(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return ov.OAuthDB.DeleteRefreshToken(id)
+}
+
+// CodeByCode hashes the code before looking it up
+func (ov *oauthValidator) CodeByCode(code string) (*OAuthCode, error) {
+	return ov.OAuthDB.CodeByCode(ov.hmac.Hash(code))
+}
+
+// CreateCode generates the code and checks the PKCE challenge
+// public clients are required to send
+func (ov *oauthValidator) CreateCode(code *OAuthCode) error {
+	err := runOAuthCodeValFuncs(code,
+		ov.codeUserIDRequired,
+		ov.codeScopeValid,
+		ov.codeChallengeValid,
+		ov.setCode,
+		ov.setCodeExpiry)
+	if err != nil {
+		return err
+	}
+	return ov.OAuthDB.CreateCode(code)
+}
+
+// RefreshTokenByToken hashes the token before looking it up
+func (ov *oauthValidator) RefreshTokenByToken(token string) (*OAuthRefreshToken, error) {
+	return ov.OAuthDB.RefreshTokenByToken(ov.hmac.Hash(token))
+}
+
+// CreateRefreshToken generates the token and stores its hash
+func (ov *oauthValidator) CreateRefreshToken(token *OAuthRefreshToken) error {
+	if token.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	raw, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	token.Token = raw
+	token.TokenHash = ov.hmac.Hash(raw)
+	return ov.OAuthDB.CreateRefreshToken(token)
+}
+
+func (ov *oauthValidator) clientUserIDRequired(c *OAuthClient) error {
+	if c.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (ov *oauthValidator) appNameRequired(c *OAuthClient) error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return ErrAppNameRequired
+	}
+	return nil
+}
+
+// redirectURIsValid requires at least one redirect URI, all of
+// them absolute, without fragments, and using https unless they
+// point at the loopback interface
+func (ov *oauthValidator) redirectURIsValid(c *OAuthClient) error {
+	uris := strings.Fields(c.RedirectURIs)
+	if len(uris) == 0 {
+		return ErrRedirectURIInvalid
+	}
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+			return ErrRedirectURIInvalid
+		}
+		switch u.Scheme {
+		case "https":
+		case "http":
+			if !isLoopback(u.Hostname()) {
+				return ErrRedirectURIInvalid
+			}
+		default:
+			return ErrRedirectURIInvalid
+		}
+	}
+	c.RedirectURIs = strings.Join(uris, " ")
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (ov *oauthValidator) setClientID(c *OAuthClient) error {
+	clientID, err := rand.String(16)
+	if err != nil {
+		return err
+	}
+	c.ClientID = strings.TrimRight(clientID, "=")
+	return nil
+}
+
+func (ov *oauthValidator) setSecretIfConfidential(c *OAuthClient) error {
+	if c.Public {
+		c.Secret = ""
+		c.SecretHash = ""
+		return nil
+	}
+	secret, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	c.Secret = secret
+	c.SecretHash = ov.hmac.Hash(secret)
+	return nil
+}
+
+func (ov *oauthValidator) codeUserIDRequired(oc *OAuthCode) error {
+	if oc.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (ov *oauthValidator) codeScopeValid(oc *OAuthCode) error {
+	scope, err := NormalizeScope(oc.Scope)
+	if err != nil {
+		return err
+	}
+	oc.Scope = scope
+	return nil
+}
+
+func (ov *oauthValidator) codeChallengeValid(oc *OAuthCode) error {
+	client, err := ov.ClientByID(oc.OAuthClientID)
+	if err != nil {
+		if err == ErrNotFound {
+			return ErrClientInvalid
+		}
+		return err
+	}
+	if oc.CodeChallenge == "" && oc.CodeChallengeMethod == "" && !client.Public {
+		return nil
+	}
+	if oc.CodeChallenge == "" || oc.CodeChallengeMethod != PKCEMethodS256 {
+		return ErrCodeChallengeInvalid
+	}
+	return nil
+}
+
+func (ov *oauthValidator) setCode(oc *OAuthCode) error {
+	code, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	oc.Code = code
+	oc.CodeHash = ov.hmac.Hash(code)
+	return nil
+}
+
+func (ov *oauthValidator) setCodeExpiry(oc *OAuthCode) error {
+	if oc.ExpiresAt.IsZero() {
+		oc.ExpiresAt = time.Now().Add(oauthCodeDuration)
+	}
+	return nil
+}
+
+var _ OAuthDB = &oauthGorm{}
+
+type oauthGorm struct {
+	db *gorm.DB
+}
+
+// ClientByID gets an app by its ID
+func (og *oauthGorm) ClientByID(id uint) (*OAuthClient, error) {
+	var client OAuthClient
+	err := first(og.db.Where("id = ?", id), &client)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// ClientByClientID gets an app by its public client ID
+func (og *oauthGorm) ClientByClientID(clientID string) (*OAuthClient, error) {
+	var client OAuthClient
+	err := first(og.db.Where("client_id = ?", clientID), &client)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// ClientsByUserID gets the apps a user registered
+func (og *oauthGorm) ClientsByUserID(userID uint) ([]OAuthClient, error) {
+	var clients []OAuthClient
+	err := og.db.Where("user_id = ?", userID).Order("id").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// CreateClient stores a new app
+func (og *oauthGorm) CreateClient(client *OAuthClient) error {
+	return og.db.Create(client).Error
+}
+
+// DeleteClient deletes the app with the provided ID
+func (og *oauthGorm) DeleteClient(id uint) error {
+	client := OAuthClient{Model: gorm.Model{ID: id}}
+	return og.db.Delete(&client).Error
+}
+
+// CodeByCode gets an authorization code by its hash
+func (og *oauthGorm) CodeByCode(codeHash string) (*OAuthCode, error) {
+	var code OAuthCode
+	err := first(og.db.Where("code_hash = ?", codeHash), &code)
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// CreateCode stores a new authorization code
+func (og *oauthGorm) CreateCode(code *OAuthCode) error {
+	return og.db.Create(code).Error
+}
+
+// DeleteCode removes an authorization code for good, so it can't
+// be used again. ErrNotFound is returned if it was already gone.
+func (og *oauthGorm) DeleteCode(id uint) error {
+	db := og.db.Unscoped().Delete(&OAuthCode{Model: gorm.Model{ID: id}})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RefreshTokenByToken gets a refresh token by its hash
+func (og *oauthGorm) RefreshTokenByToken(tokenHash string) (*OAuthRefreshToken, error) {
+	var token OAuthRefreshToken
+	err := first(og.db.Where("token_hash = ?", tokenHash), &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RefreshTokensByUserID gets the refresh tokens issued on behalf
+// of a user
+func (og *oauthGorm) RefreshTokensByUserID(userID uint) ([]OAuthRefreshToken, error) {
+	var tokens []OAuthRefreshToken
+	if err := og.db.Where("user_id = ?", userID).Order("id").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// CreateRefreshToken stores a new refresh token
+func (og *oauthGorm) CreateRefreshToken(token *OAuthRefreshToken) error {
+	return og.db.Create(token).Error
+}
+
+// DeleteRefreshToken revokes a refresh token. ErrNotFound is
+// returned if it was already gone.
+func (og *oauthGorm) DeleteRefreshToken(id uint) error {
+	db := og.db.Unscoped().Delete(&OAuthRefreshToken{Model: gorm.Model{ID: id}})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteRefreshTokensByClientID revokes every refresh token
+// issued to an app
+func (og *oauthGorm) DeleteRefreshTokensByClientID(clientID uint) error {
+	return og.db.Unscoped().Where("oauth_client_id = ?", clientID).Delete(&OAuthRefreshToken{}).Error
+}
+
+// DeleteGrantsByUserID removes every code and refresh token issued
+// on behalf of a user
+func (og *oauthGorm) DeleteGrantsByUserID(userID uint) error {
+	if err := og.db.Unscoped().Where("user_id = ?", userID).Delete(&OAuthCode{}).Error; err != nil {
+		return err
+	}
+	return og.db.Unscoped().Where("user_id = ?", userID).Delete(&OAuthRefreshToken{}).Error
+}
+
+// DeleteGrantsByClientAndUserID removes the codes and refresh tokens
+// issued to an app on behalf of a user
+func (og *oauthGorm) DeleteGrantsByClientAndUserID(clientID, userID uint) error {
+	db := og.db.Unscoped().Where("oauth_client_id = ? AND user_id = ?", clientID, userID)
+	if err := db.Delete(&OAuthCode{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(&OAuthRefreshToken{}).Error
+}
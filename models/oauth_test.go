@@ -0,0 +1,199 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testingClient(t *testing.T, s *Services, public bool) *OAuthClient {
+	t.Helper()
+	client := OAuthClient{
+		UserID:       1,
+		Name:         "Darkroom",
+		RedirectURIs: "http://127.0.0.1:9000/callback",
+		Public:       public,
+	}
+	if err := s.OAuth.CreateClient(&client); err != nil {
+		t.Fatal(err)
+	}
+	return &client
+}
+
+func TestOAuthExchangeWithPKCE(t *testing.T) {
+	s := testingServices(t)
+	client := testingClient(t, s, true)
+	if client.ClientID == "" || client.Secret != "" {
+		t.Fatalf("Expected a public client without a secret, received %+v", client)
+	}
+
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	code := OAuthCode{
+		OAuthClientID:       client.ID,
+		UserID:              2,
+		Scope:               "read upload read",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: PKCEMethodS256,
+	}
+	if err := s.OAuth.CreateCode(&code); err != nil {
+		t.Fatal(err)
+	}
+	if code.Scope != "read upload" {
+		t.Errorf("Expected duplicate scopes to be removed, received %q", code.Scope)
+	}
+
+	if _, err := s.OAuth.Exchange(client, code.Code, "", strings.Repeat("x", 43)); err != ErrGrantInvalid {
+		t.Errorf("Expected a wrong verifier to be rejected, received %v", err)
+	}
+	if _, err := s.OAuth.Exchange(client, code.Code, "", verifier); err != ErrGrantInvalid {
+		t.Errorf("Expected a code to only be usable once, received %v", err)
+	}
+
+	code = OAuthCode{
+		OAuthClientID:       client.ID,
+		UserID:              2,
+		Scope:               ScopeRead,
+		CodeChallenge:       code.CodeChallenge,
+		CodeChallengeMethod: PKCEMethodS256,
+	}
+	if err := s.OAuth.CreateCode(&code); err != nil {
+		t.Fatal(err)
+	}
+	grant, err := s.OAuth.Exchange(client, code.Code, "", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.APIToken.Authenticate(grant.AccessToken.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UserID != 2 || token.OAuthClientID != client.ID || !token.Allows(ScopeRead) || token.Allows(ScopeReadWrite) {
+		t.Errorf("Expected a read only access token for user 2, received %+v", token)
+	}
+}
+
+func TestOAuthCodeRequiresPKCEForPublicClients(t *testing.T) {
+	s := testingServices(t)
+	client := testingClient(t, s, true)
+	code := OAuthCode{OAuthClientID: client.ID, UserID: 2}
+	if err := s.OAuth.CreateCode(&code); err != ErrCodeChallengeInvalid {
+		t.Errorf("Expected ErrCodeChallengeInvalid, received %v", err)
+	}
+	code.CodeChallenge = "challenge"
+	code.CodeChallengeMethod = "plain"
+	if err := s.OAuth.CreateCode(&code); err != ErrCodeChallengeInvalid {
+		t.Errorf("Expected plain challenges to be rejected, received %v", err)
+	}
+}
+
+func TestOAuthRefreshAndRevoke(t *testing.T) {
+	s := testingServices(t)
+	client := testingClient(t, s, false)
+	if _, err := s.OAuth.AuthenticateClient(client.ClientID, "wrong"); err != ErrClientInvalid {
+		t.Errorf("Expected a wrong secret to be rejected, received %v", err)
+	}
+	client, err := s.OAuth.AuthenticateClient(client.ClientID, client.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := OAuthCode{OAuthClientID: client.ID, UserID: 2, Scope: ScopeReadWrite}
+	if err := s.OAuth.CreateCode(&code); err != nil {
+		t.Fatal(err)
+	}
+	grant, err := s.OAuth.Exchange(client, code.Code, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := s.OAuth.Refresh(client, grant.RefreshToken.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OAuth.Refresh(client, grant.RefreshToken.Token); err != ErrGrantInvalid {
+		t.Errorf("Expected the old refresh token to be rotated out, received %v", err)
+	}
+
+	if err := s.OAuth.DeleteClient(client.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.APIToken.Authenticate(refreshed.AccessToken.Token); err != ErrTokenInvalid {
+		t.Errorf("Expected deleting the app to revoke its access tokens, received %v", err)
+	}
+	if _, err := s.OAuth.Refresh(client, refreshed.RefreshToken.Token); err != ErrGrantInvalid {
+		t.Errorf("Expected deleting the app to revoke its refresh tokens, received %v", err)
+	}
+}
+
+// TestOAuthRevokeClient checks a user can take back what they
+// granted an app, leaving other users' grants alone, and that
+// refresh tokens expire
+func TestOAuthRevokeClient(t *testing.T) {
+	s := testingServices(t)
+	client := testingClient(t, s, false)
+	grants := make(map[uint]*OAuthGrant)
+	for _, userID := range []uint{2, 3} {
+		code := OAuthCode{OAuthClientID: client.ID, UserID: userID, Scope: ScopeRead}
+		if err := s.OAuth.CreateCode(&code); err != nil {
+			t.Fatal(err)
+		}
+		grant, err := s.OAuth.Exchange(client, code.Code, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		grants[userID] = grant
+	}
+	if time.Until(grants[2].RefreshToken.ExpiresAt) < OAuthRefreshTokenDuration-time.Minute {
+		t.Errorf("Expected the refresh token to expire, received %+v", grants[2].RefreshToken)
+	}
+	authorized, err := s.OAuth.AuthorizedClients(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authorized) != 1 || authorized[0].ID != client.ID {
+		t.Errorf("Expected the app to be authorized, received %+v", authorized)
+	}
+
+	if err := s.OAuth.RevokeClient(client.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.APIToken.Authenticate(grants[2].AccessToken.Token); err != ErrTokenInvalid {
+		t.Errorf("Expected the access token to be revoked, received %v", err)
+	}
+	if _, err := s.OAuth.Refresh(client, grants[2].RefreshToken.Token); err != ErrGrantInvalid {
+		t.Errorf("Expected the refresh token to be revoked, received %v", err)
+	}
+	if authorized, _ := s.OAuth.AuthorizedClients(2); len(authorized) != 0 {
+		t.Errorf("Expected the app not to be authorized any more, received %+v", authorized)
+	}
+	if _, err := s.APIToken.Authenticate(grants[3].AccessToken.Token); err != nil {
+		t.Errorf("Expected other users' tokens to be kept, received %v", err)
+	}
+
+	expired := OAuthRefreshToken{OAuthClientID: client.ID, UserID: 4, Scope: ScopeRead, ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := s.OAuth.CreateRefreshToken(&expired); err != nil {
+		t.Fatal(err)
+	}
+	if authorized, _ := s.OAuth.AuthorizedClients(4); len(authorized) != 0 {
+		t.Errorf("Expected an expired grant not to count, received %+v", authorized)
+	}
+	if _, err := s.OAuth.Refresh(client, expired.Token); err != ErrGrantInvalid {
+		t.Errorf("Expected an expired refresh token to be rejected, received %v", err)
+	}
+}
+
+func TestOAuthClientValidation(t *testing.T) {
+	s := testingServices(t)
+	for _, uris := range []string{"", "http://example.com/callback", "https://example.com/cb#frag", "/callback"} {
+		client := OAuthClient{UserID: 1, Name: "Darkroom", RedirectURIs: uris}
+		if err := s.OAuth.CreateClient(&client); err != ErrRedirectURIInvalid {
+			t.Errorf("Expected %q to be rejected, received %v", uris, err)
+		}
+	}
+	client := OAuthClient{UserID: 1, RedirectURIs: "https://example.com/cb"}
+	if err := s.OAuth.CreateClient(&client); err != ErrAppNameRequired {
+		t.Errorf("Expected ErrAppNameRequired, received %v", err)
+	}
+}
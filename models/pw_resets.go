@@ -6,41 +6,46 @@ import (
 	"github.com/sajicode/go-photo/rand"
 )
 
-type pwReset struct {
+// PwReset is a password reset token issued to a user. Only the
+// hash of the token is ever stored.
+type PwReset struct {
 	gorm.Model
 	UserID    uint   `gorm:"not null"`
 	Token     string `gorm:"-"`
 	TokenHash string `gorm:"not null;unique_index"`
 }
 
-type pwResetDB interface {
-	ByToken(token string) (*pwReset, error)
-	Create(pwr *pwReset) error
+// PwResetDB is used to interact with stored password resets.
+// ByToken expects the token to already be hashed.
+type PwResetDB interface {
+	ByToken(token string) (*PwReset, error)
+	Create(pwr *PwReset) error
 	Delete(id uint) error
+	DeleteByUserID(userID uint) error
 }
 
-func newPwResetValidator(db pwResetDB, hmac hash.HMAC) *pwResetValidator {
+func newPwResetValidator(db PwResetDB, hmac hash.HMAC) *pwResetValidator {
 	return &pwResetValidator{
-		pwResetDB: db,
+		PwResetDB: db,
 		hmac:      hmac,
 	}
 }
 
 type pwResetValidator struct {
-	pwResetDB
+	PwResetDB
 	hmac hash.HMAC
 }
 
-func (pwrv *pwResetValidator) ByToken(token string) (*pwReset, error) {
-	pwr := pwReset{Token: token}
+func (pwrv *pwResetValidator) ByToken(token string) (*PwReset, error) {
+	pwr := PwReset{Token: token}
 	err := runPwResetValFns(&pwr, pwrv.hmacToken)
 	if err != nil {
 		return nil, err
 	}
-	return pwrv.pwResetDB.ByToken(pwr.TokenHash)
+	return pwrv.PwResetDB.ByToken(pwr.TokenHash)
 }
 
-func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
+func (pwrv *pwResetValidator) Create(pwr *PwReset) error {
 	err := runPwResetValFns(pwr,
 		pwrv.requireUserID,
 		pwrv.setTokenIfUnset,
@@ -49,22 +54,22 @@ func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
 	if err != nil {
 		return err
 	}
-	return pwrv.pwResetDB.Create(pwr)
+	return pwrv.PwResetDB.Create(pwr)
 }
 
 func (pwrv *pwResetValidator) Delete(id uint) error {
 	if id <= 0 {
 		return ErrInvalidID
 	}
-	return pwrv.pwResetDB.Delete(id)
+	return pwrv.PwResetDB.Delete(id)
 }
 
 type pwResetGorm struct {
 	db *gorm.DB
 }
 
-func (pwrg *pwResetGorm) ByToken(tokenHash string) (*pwReset, error) {
-	var pwr pwReset
+func (pwrg *pwResetGorm) ByToken(tokenHash string) (*PwReset, error) {
+	var pwr PwReset
 	err := first(pwrg.db.Where("token_hash = ?", tokenHash), &pwr)
 	if err != nil {
 		return nil, err
@@ -72,23 +77,28 @@ func (pwrg *pwResetGorm) ByToken(tokenHash string) (*pwReset, error) {
 	return &pwr, nil
 }
 
-func (pwrg *pwResetGorm) Create(pwr *pwReset) error {
+func (pwrg *pwResetGorm) Create(pwr *PwReset) error {
 	return pwrg.db.Create(pwr).Error
 }
 
 func (pwrg *pwResetGorm) Delete(id uint) error {
-	pwr := pwReset{Model: gorm.Model{ID: id}}
+	pwr := PwReset{Model: gorm.Model{ID: id}}
 	return pwrg.db.Delete(&pwr).Error
 }
 
-func (pwrv *pwResetValidator) requireUserID(pwr *pwReset) error {
+// DeleteByUserID removes every reset token of a user for good
+func (pwrg *pwResetGorm) DeleteByUserID(userID uint) error {
+	return pwrg.db.Unscoped().Where("user_id = ?", userID).Delete(&PwReset{}).Error
+}
+
+func (pwrv *pwResetValidator) requireUserID(pwr *PwReset) error {
 	if pwr.UserID <= 0 {
 		return ErrUserIDRequired
 	}
 	return nil
 }
 
-func (pwrv *pwResetValidator) setTokenIfUnset(pwr *pwReset) error {
+func (pwrv *pwResetValidator) setTokenIfUnset(pwr *PwReset) error {
 	if pwr.Token != "" {
 		return nil
 	}
@@ -100,7 +110,7 @@ func (pwrv *pwResetValidator) setTokenIfUnset(pwr *pwReset) error {
 	return nil
 }
 
-func (pwrv *pwResetValidator) hmacToken(pwr *pwReset) error {
+func (pwrv *pwResetValidator) hmacToken(pwr *PwReset) error {
 	if pwr.Token == "" {
 		return nil
 	}
@@ -108,9 +118,9 @@ func (pwrv *pwResetValidator) hmacToken(pwr *pwReset) error {
 	return nil
 }
 
-type pwResetValFn func(*pwReset) error
+type pwResetValFn func(*PwReset) error
 
-func runPwResetValFns(pwr *pwReset, fns ...pwResetValFn) error {
+func runPwResetValFns(pwr *PwReset, fns ...pwResetValFn) error {
 	for _, fn := range fns {
 		if err := fn(pwr); err != nil {
 			return err
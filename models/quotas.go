@@ -0,0 +1,199 @@
+package models
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+// NewQuotaImageService wraps is so that images can't be stored past
+// their owner's plan, and the usage of each gallery is recounted
+// whenever its images change. Moving images to the trash and back
+// changes nothing, as they are still stored.
+func NewQuotaImageService(is ImageService, us UsageService) ImageService {
+	return &quotaImageService{ImageService: is, us: us}
+}
+
+type quotaImageService struct {
+	ImageService
+	us UsageService
+	// locks holds a *sync.Mutex for each user, held from checking
+	// their quota until what they stored has been counted, so two
+	// uploads at once can't both fit in the room left for one.
+	// Other processes sharing the storage aren't covered, their
+	// usage is set straight by UsageService.Reconcile.
+	locks sync.Map
+}
+
+// lock serialises the changes to a user's usage, returning the
+// function that unlocks it
+func (qis *quotaImageService) lock(userID uint) func() {
+	mu, _ := qis.locks.LoadOrStore(userID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// Create checks the image fits before storing it. When the size of
+// r can't be known up front it is first read into a temporary file,
+// stopping as soon as it doesn't fit. That is done before taking
+// the user's lock, which is only held to check the quota again and
+// store the image.
+func (qis *quotaImageService) Create(galleryID uint, r io.Reader, filename string) error {
+	if err := ValidateImageFilename(filename); err != nil {
+		return err
+	}
+	q, err := qis.us.ImageQuota(galleryID, filename)
+	if err != nil {
+		return err
+	}
+	if err := q.Check(0, 1); err != nil {
+		return err
+	}
+	size, ok := readerSize(r)
+	if remaining := q.RemainingBytes(); !ok && remaining >= 0 {
+		tmp, n, err := spool(r, remaining)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		r, size = tmp, n
+	}
+
+	defer qis.lock(q.UserID)()
+	if q, err = qis.us.ImageQuota(galleryID, filename); err != nil {
+		return err
+	}
+	if err := q.Check(size, 1); err != nil {
+		return err
+	}
+	if err := qis.ImageService.Create(galleryID, r, filename); err != nil {
+		return err
+	}
+	qis.recount(galleryID)
+	return nil
+}
+
+// readerSize returns how much is left to read from r, if it can
+// tell without reading it
+func readerSize(r io.Reader) (int64, bool) {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	cur, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := s.Seek(cur, io.SeekStart); err != nil {
+		return 0, false
+	}
+	return end - cur, true
+}
+
+// spool copies r into a temporary file, returning how much it
+// holds, or ErrStorageQuotaExceeded if that is more than limit
+// bytes. The file is ready to be read from the start.
+func spool(r io.Reader, limit int64) (*os.File, int64, error) {
+	tmp, err := ioutil.TempFile("", "image-")
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(tmp, io.LimitReader(r, limit+1))
+	if err == nil && n > limit {
+		err = ErrStorageQuotaExceeded
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+	return tmp, n, nil
+}
+
+// Copy checks the copies fit in the other gallery's owner's plan
+func (qis *quotaImageService) Copy(galleryID uint, filenames []string, toGalleryID uint) error {
+	images, err := qis.ByGalleryID(galleryID)
+	if err != nil {
+		return err
+	}
+	selected, err := SelectImages(images, filenames)
+	if err != nil {
+		return err
+	}
+	q, err := qis.us.GalleryQuota(toGalleryID)
+	if err != nil {
+		return err
+	}
+	defer qis.lock(q.UserID)()
+	if q, err = qis.us.GalleryQuota(toGalleryID); err != nil {
+		return err
+	}
+	var u Usage
+	u.add(selected)
+	if err := q.Check(u.Bytes, u.Images); err != nil {
+		return err
+	}
+	if err := qis.ImageService.Copy(galleryID, filenames, toGalleryID); err != nil {
+		return err
+	}
+	qis.recount(toGalleryID)
+	return nil
+}
+
+// Move recounts both galleries. Galleries can only swap images with
+// galleries of the same owner, so there is nothing to check.
+func (qis *quotaImageService) Move(galleryID uint, filenames []string, toGalleryID uint) error {
+	if err := qis.ImageService.Move(galleryID, filenames, toGalleryID); err != nil {
+		return err
+	}
+	qis.recount(galleryID)
+	qis.recount(toGalleryID)
+	return nil
+}
+
+// Purge recounts the image's gallery
+func (qis *quotaImageService) Purge(i *Image) error {
+	if err := qis.ImageService.Purge(i); err != nil {
+		return err
+	}
+	qis.recount(i.GalleryID)
+	return nil
+}
+
+// Discard counts the gallery's usage again, in case a failed
+// upload was counted
+func (qis *quotaImageService) Discard(i *Image) error {
+	if err := qis.ImageService.Discard(i); err != nil {
+		return err
+	}
+	qis.recount(i.GalleryID)
+	return nil
+}
+
+// DeleteAll forgets the gallery's usage along with its images
+func (qis *quotaImageService) DeleteAll(galleryID uint) error {
+	if err := qis.ImageService.DeleteAll(galleryID); err != nil {
+		return err
+	}
+	return qis.us.Delete(galleryID)
+}
+
+// recount recounts the usage of a gallery after its images have
+// changed. The change has already been made by then, so a failed
+// recount is logged and left to the next one, or to
+// UsageService.Reconcile.
+func (qis *quotaImageService) recount(galleryID uint) {
+	if _, err := qis.us.Recount(galleryID); err != nil {
+		log.Printf("recounting the usage of gallery %d: %v", galleryID, err)
+	}
+}
@@ -0,0 +1,383 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MaxSearchResults is the most galleries, and the most images, a
+// search returns
+const MaxSearchResults = 100
+
+// SearchQuery is what to look for among a user's galleries and
+// images. Everything that is set has to match.
+type SearchQuery struct {
+	UserID uint
+	// Text is looked for in the title, description and tags of
+	// galleries, and the caption, alt text, tags and camera of
+	// images
+	Text string
+	// Tag only finds galleries and images with this tag
+	Tag string
+	// Camera only finds images taken with this camera, and the
+	// galleries with one
+	Camera string
+	// From and To are the first and last days images were taken
+	// on, or galleries' event dates fall on. Images that don't say
+	// when they were taken go by their gallery's event date.
+	From *time.Time
+	To   *time.Time
+}
+
+// Empty reports whether there is nothing to search for
+func (q *SearchQuery) Empty() bool {
+	return q.Text == "" && q.Tag == "" && q.Camera == "" && q.From == nil && q.To == nil
+}
+
+// words returns the lower cased words of the text
+func (q *SearchQuery) words() []string {
+	return strings.Fields(strings.ToLower(q.Text))
+}
+
+// inRange reports whether date falls in the date range, dates
+// that aren't set never do when there is one
+func (q *SearchQuery) inRange(date *time.Time) bool {
+	if q.From == nil && q.To == nil {
+		return true
+	}
+	if date == nil {
+		return false
+	}
+	if q.From != nil && date.Before(*q.From) {
+		return false
+	}
+	return q.To == nil || date.Before(q.To.AddDate(0, 0, 1))
+}
+
+// hasWords reports whether text contains every word of the query
+func (q *SearchQuery) hasWords(text string) bool {
+	text = strings.ToLower(text)
+	for _, word := range q.words() {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTag reports whether tags include the tag searched for
+func (q *SearchQuery) hasTag(tags string) bool {
+	if q.Tag == "" {
+		return true
+	}
+	for _, tag := range SplitTags(tags) {
+		if tag == q.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGallery reports whether a gallery, with images, matches the
+// query. It's for SearchDBs that can't query a database, and finds
+// what the portable database search does.
+func (q *SearchQuery) MatchGallery(g *Gallery, images []Image) bool {
+	if !q.hasWords(galleryText(g)) || !q.hasTag(g.Tags) || !q.inRange(g.EventDate) {
+		return false
+	}
+	if q.Camera == "" {
+		return true
+	}
+	for _, img := range images {
+		if strings.EqualFold(img.Camera, q.Camera) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchImage reports whether an image in gallery g matches the
+// query, like MatchGallery
+func (q *SearchQuery) MatchImage(i *Image, g *Gallery) bool {
+	if q.Camera != "" && !strings.EqualFold(i.Camera, q.Camera) {
+		return false
+	}
+	date := i.TakenAt
+	if date == nil {
+		date = g.EventDate
+	}
+	return q.hasWords(imageText(i)) && q.hasTag(i.Tags) && q.inRange(date)
+}
+
+func galleryText(g *Gallery) string {
+	return strings.Join([]string{g.Title, g.Description, g.Tags}, " ")
+}
+
+func imageText(i *Image) string {
+	return strings.Join([]string{i.Caption, i.AltText, i.Tags, i.Camera}, " ")
+}
+
+// ImageResult is an image a search found, with what we show of its
+// gallery
+type ImageResult struct {
+	Image
+	GalleryTitle string
+	// Date is when the image was taken, or its gallery's event date
+	// if it doesn't say
+	Date *time.Time
+}
+
+// NewImageResult returns an image in gallery g as a search result
+func NewImageResult(i Image, g *Gallery) ImageResult {
+	ret := ImageResult{Image: i, GalleryTitle: g.Title, Date: i.TakenAt}
+	if ret.Date == nil {
+		ret.Date = g.EventDate
+	}
+	return ret
+}
+
+// Facet is a value results can be narrowed down to and how many
+// images have it
+type Facet struct {
+	Value string
+	Count int
+}
+
+// SearchResults are what a search found
+type SearchResults struct {
+	// Galleries are newest first
+	Galleries []Gallery
+	// Images are the most recently taken first
+	Images []ImageResult
+	// TotalGalleries and TotalImages are how many were found, even
+	// if there were too many to return
+	TotalGalleries int
+	TotalImages    int
+	// Cameras and Years count the images found by the camera that
+	// took them and the year they were taken, most common camera
+	// and latest year first
+	Cameras []Facet
+	Years   []Facet
+}
+
+// NewSearchResults sorts what a search found, counts the facets of
+// the images and returns at most MaxSearchResults of each. Every
+// SearchDB uses it so their results come out the same way.
+func NewSearchResults(galleries []Gallery, images []ImageResult) *SearchResults {
+	sort.SliceStable(galleries, func(i, j int) bool {
+		a, b := galleries[i], galleries[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if (a.Date == nil) != (b.Date == nil) {
+			return b.Date == nil
+		}
+		if a.Date != nil && !a.Date.Equal(*b.Date) {
+			return a.Date.After(*b.Date)
+		}
+		if a.GalleryID != b.GalleryID {
+			return a.GalleryID > b.GalleryID
+		}
+		return a.Position < b.Position
+	})
+	cameras := make(map[string]int)
+	years := make(map[string]int)
+	for _, img := range images {
+		if img.Camera != "" {
+			cameras[img.Camera]++
+		}
+		if img.Date != nil {
+			years[img.Date.Format("2006")]++
+		}
+	}
+	ret := &SearchResults{
+		Galleries:      galleries,
+		Images:         images,
+		TotalGalleries: len(galleries),
+		TotalImages:    len(images),
+		Cameras:        facets(cameras),
+		Years:          facets(years),
+	}
+	// years are listed latest first rather than by count
+	sort.Slice(ret.Years, func(i, j int) bool {
+		return ret.Years[i].Value > ret.Years[j].Value
+	})
+	if len(ret.Galleries) > MaxSearchResults {
+		ret.Galleries = ret.Galleries[:MaxSearchResults]
+	}
+	if len(ret.Images) > MaxSearchResults {
+		ret.Images = ret.Images[:MaxSearchResults]
+	}
+	return ret
+}
+
+// facets returns counts most common first
+func facets(counts map[string]int) []Facet {
+	ret := make([]Facet, 0, len(counts))
+	for value, count := range counts {
+		ret = append(ret, Facet{Value: value, Count: count})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Count != ret[j].Count {
+			return ret[i].Count > ret[j].Count
+		}
+		return ret[i].Value < ret[j].Value
+	})
+	return ret
+}
+
+// SearchDB looks through the galleries and images of a user
+type SearchDB interface {
+	Search(q *SearchQuery) (*SearchResults, error)
+}
+
+// SearchService finds galleries and images by their text, tags,
+// camera and date
+type SearchService interface {
+	SearchDB
+}
+
+// NewSearchService searches the database
+func NewSearchService(db *gorm.DB) SearchService {
+	return NewSearchServiceFromDB(&searchGorm{db})
+}
+
+// NewSearchServiceFromDB builds a SearchService on top of any
+// SearchDB implementation, wrapping it in our validation.
+func NewSearchServiceFromDB(sdb SearchDB) SearchService {
+	return &searchService{
+		SearchDB: &searchValidator{sdb},
+	}
+}
+
+type searchService struct {
+	SearchDB
+}
+
+type searchValidator struct {
+	SearchDB
+}
+
+// Search cleans up the query before searching. Only one tag can be
+// searched for at a time, and dates are whole days.
+func (sv *searchValidator) Search(q *SearchQuery) (*SearchResults, error) {
+	if q.UserID <= 0 {
+		return nil, ErrUserIDRequired
+	}
+	q.Text = strings.TrimSpace(q.Text)
+	q.Camera = strings.TrimSpace(q.Camera)
+	tags := SplitTags(q.Tag)
+	q.Tag = ""
+	if len(tags) > 0 {
+		q.Tag = tags[0]
+	}
+	for _, date := range []**time.Time{&q.From, &q.To} {
+		if *date != nil {
+			y, m, d := (*date).Date()
+			day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+			*date = &day
+		}
+	}
+	if q.From != nil && q.To != nil && q.From.After(*q.To) {
+		return nil, ErrSearchRangeInvalid
+	}
+	return sv.SearchDB.Search(q)
+}
+
+var _ SearchDB = &searchGorm{}
+
+// searchGorm uses full text search on Postgres. Anything else, like
+// SQLite, gets a portable search for every word of the text
+// anywhere, the same as SearchQuery.MatchGallery and MatchImage.
+type searchGorm struct {
+	db *gorm.DB
+}
+
+// The text searched in galleries and images. The columns can be
+// NULL in rows stored before they were added.
+const (
+	gallerySearchText = "coalesce(galleries.title, '') || ' ' || coalesce(galleries.description, '') || ' ' || coalesce(galleries.tags, '')"
+	imageSearchText   = "coalesce(image_details.caption, '') || ' ' || coalesce(image_details.alt_text, '') || ' ' || coalesce(image_details.tags, '') || ' ' || coalesce(image_details.camera, '')"
+)
+
+// imageRow is an image found along with its gallery
+type imageRow struct {
+	ImageDetails
+	GalleryTitle     string
+	GalleryEventDate *time.Time
+}
+
+func (sg *searchGorm) Search(q *SearchQuery) (*SearchResults, error) {
+	var galleries []Gallery
+	db := sg.where(sg.db.Where("galleries.user_id = ?", q.UserID), q, gallerySearchText, "galleries.tags")
+	if q.Camera != "" {
+		db = db.Where("EXISTS (SELECT 1 FROM image_details WHERE image_details.gallery_id = galleries.id AND image_details.deleted_at IS NULL AND lower(image_details.camera) = ?)", strings.ToLower(q.Camera))
+	}
+	db = sg.dateRange(db, q, "galleries.event_date")
+	if err := db.Find(&galleries).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []imageRow
+	db = sg.db.Table("image_details").
+		Select("image_details.*, galleries.title AS gallery_title, galleries.event_date AS gallery_event_date").
+		Joins("JOIN galleries ON galleries.id = image_details.gallery_id AND galleries.deleted_at IS NULL").
+		Where("image_details.deleted_at IS NULL AND galleries.user_id = ?", q.UserID)
+	db = sg.where(db, q, imageSearchText, "image_details.tags")
+	if q.Camera != "" {
+		db = db.Where("lower(image_details.camera) = ?", strings.ToLower(q.Camera))
+	}
+	db = sg.dateRange(db, q, "coalesce(image_details.taken_at, galleries.event_date)")
+	if err := db.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	images := make([]ImageResult, len(rows))
+	for i, row := range rows {
+		images[i] = NewImageResult(row.image(), &Gallery{Title: row.GalleryTitle, EventDate: row.GalleryEventDate})
+	}
+	return NewSearchResults(galleries, images), nil
+}
+
+// where narrows db down to rows whose text matches the query and
+// whose tags have the tag searched for
+func (sg *searchGorm) where(db *gorm.DB, q *SearchQuery, text, tags string) *gorm.DB {
+	if q.Text != "" {
+		if sg.db.Dialect().GetName() == "postgres" {
+			db = db.Where("to_tsvector('english', "+text+") @@ plainto_tsquery('english', ?)", q.Text)
+		} else {
+			for _, word := range q.words() {
+				db = db.Where("lower("+text+") LIKE ? ESCAPE '\\'", "%"+escapeLike(word)+"%")
+			}
+		}
+	}
+	if q.Tag != "" {
+		// tags are stored comma separated, so wrapping them in commas
+		// finds whole tags
+		db = db.Where("',' || coalesce("+tags+", '') || ',' LIKE ? ESCAPE '\\'", "%,"+escapeLike(q.Tag)+",%")
+	}
+	return db
+}
+
+func (sg *searchGorm) dateRange(db *gorm.DB, q *SearchQuery, date string) *gorm.DB {
+	if q.From != nil {
+		db = db.Where(date+" >= ?", *q.From)
+	}
+	if q.To != nil {
+		db = db.Where(date+" < ?", q.To.AddDate(0, 0, 1))
+	}
+	return db
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes the characters LIKE treats specially
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/imaging/imagingtest"
+)
+
+func TestSplitTags(t *testing.T) {
+	if got := JoinTags(SplitTags(" Wedding, new  York,,wedding , Lagos")); got != "wedding,new york,lagos" {
+		t.Errorf("Expected cleaned up tags, received %q", got)
+	}
+	gs := testingServices(t).Gallery
+	g := Gallery{UserID: 1, Title: "Wedding", Tags: strings.Repeat("a", MaxTagLength+1)}
+	if err := gs.Create(&g); err != ErrTagTooLong {
+		t.Errorf("Expected ErrTagTooLong, received %v", err)
+	}
+	var many []string
+	for i := 0; i <= MaxTags; i++ {
+		many = append(many, strings.Repeat("a", i+1))
+	}
+	g.Tags = strings.Join(many, ",")
+	if err := gs.Create(&g); err != ErrTooManyTags {
+		t.Errorf("Expected ErrTooManyTags, received %v", err)
+	}
+	// tags that come with an image are cut down quietly instead
+	if got := SplitTags(addTags("", many)); len(got) != MaxTags {
+		t.Errorf("Expected %d tags, received %d", MaxTags, len(got))
+	}
+}
+
+// inTempDir runs the rest of the test in a temporary directory, so
+// images stored by the ImageService are cleaned up
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// TestImageMetadata checks the camera, date and keywords of an
+// image are read when it is stored
+func TestImageMetadata(t *testing.T) {
+	is := testingServices(t).Image
+	inTempDir(t)
+	taken := time.Date(2019, 6, 1, 14, 30, 0, 0, time.UTC)
+	jpg := imagingtest.JPEG("Canon EOS 5D", taken, "Wedding", "Lagos")
+	if err := is.Create(1, bytes.NewReader(jpg), "cake.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	images, _ := is.ByGalleryID(1)
+	if i := images[0]; i.Camera != "Canon EOS 5D" || i.TakenAt == nil || !i.TakenAt.Equal(taken) || i.Tags != "wedding,lagos" {
+		t.Errorf("Expected the metadata to be read, received %+v", i)
+	}
+
+	// uploading the image again keeps the tags it was given, adds
+	// its keywords back and replaces what it says about the camera
+	if err := is.Update(&Image{GalleryID: 1, Filename: "cake.jpg", Caption: "Cake", Tags: "Wedding, cake"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Create(1, bytes.NewReader(imagingtest.JPEG("", time.Time{}, "Lagos")), "cake.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	images, _ = is.ByGalleryID(1)
+	if i := images[0]; i.Caption != "Cake" || i.Tags != "wedding,cake,lagos" || i.Camera != "" || i.TakenAt != nil {
+		t.Errorf("Expected the details to be kept and the metadata replaced, received %+v", i)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := testingServices(t)
+	inTempDir(t)
+	date := func(y, m, d int) *time.Time {
+		t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	wedding := Gallery{UserID: 1, Title: "Ade & Funke", Description: "Our wedding day", Tags: "party, lagos", EventDate: date(2019, 6, 1)}
+	holiday := Gallery{UserID: 1, Title: "Holiday", Tags: "beach"}
+	theirs := Gallery{UserID: 2, Title: "Wedding", Tags: "party"}
+	for _, g := range []*Gallery{&wedding, &holiday, &theirs} {
+		if err := s.Gallery.Create(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, img := range []struct {
+		gallery  uint
+		filename string
+		jpg      []byte
+		caption  string
+	}{
+		{wedding.ID, "cake.jpg", imagingtest.JPEG("Canon EOS 5D", *date(2019, 6, 1), "cake"), "The 100% wedding cake"},
+		{wedding.ID, "dance.jpg", imagingtest.JPEG("", time.Time{}), "First dance"},
+		{holiday.ID, "sea.jpg", imagingtest.JPEG("NIKON D750", *date(2021, 8, 10), "Beach"), ""},
+		{theirs.ID, "cake.jpg", imagingtest.JPEG("Canon EOS 5D", *date(2019, 6, 1), "cake"), "Wedding cake"},
+	} {
+		if err := s.Image.Create(img.gallery, bytes.NewReader(img.jpg), img.filename); err != nil {
+			t.Fatal(err)
+		}
+		if img.caption != "" {
+			images, _ := s.Image.ByGalleryID(img.gallery)
+			for _, i := range images {
+				if i.Filename == img.filename {
+					i.Caption = img.caption
+					if err := s.Image.Update(&i); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		q         SearchQuery
+		galleries string
+		images    string
+	}{
+		{"text", SearchQuery{Text: "WEDDING"}, "Ade & Funke", "cake.jpg"},
+		{"every word", SearchQuery{Text: "wedding dance"}, "", ""},
+		{"like characters", SearchQuery{Text: "100%"}, "", "cake.jpg"},
+		{"tag", SearchQuery{Tag: "Beach"}, "Holiday", "sea.jpg"},
+		{"whole tags", SearchQuery{Tag: "part"}, "", ""},
+		{"camera", SearchQuery{Camera: "canon eos 5d"}, "Ade & Funke", "cake.jpg"},
+		{"date range", SearchQuery{From: date(2019, 1, 1), To: date(2019, 6, 1)}, "Ade & Funke", "cake.jpg,dance.jpg"},
+		{"after", SearchQuery{From: date(2020, 1, 1)}, "", "sea.jpg"},
+	} {
+		tc.q.UserID = 1
+		res, err := s.Search.Search(&tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var galleries, images []string
+		for _, g := range res.Galleries {
+			galleries = append(galleries, g.Title)
+		}
+		for _, i := range res.Images {
+			images = append(images, i.Filename)
+		}
+		if got := strings.Join(galleries, ","); got != tc.galleries {
+			t.Errorf("%s: expected galleries %q, received %q", tc.name, tc.galleries, got)
+		}
+		if got := strings.Join(images, ","); got != tc.images {
+			t.Errorf("%s: expected images %q, received %q", tc.name, tc.images, got)
+		}
+	}
+
+	res, err := s.Search.Search(&SearchQuery{UserID: 1, From: date(2000, 1, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Images) != 3 || res.Images[0].Filename != "sea.jpg" || res.Images[0].GalleryTitle != "Holiday" {
+		t.Errorf("Expected the latest image first, received %+v", res.Images)
+	}
+	if len(res.Cameras) != 2 || res.Cameras[0] != (Facet{"Canon EOS 5D", 1}) || res.Cameras[1] != (Facet{"NIKON D750", 1}) {
+		t.Errorf("Expected a facet for each camera, received %v", res.Cameras)
+	}
+	if len(res.Years) != 2 || res.Years[0] != (Facet{"2021", 1}) || res.Years[1] != (Facet{"2019", 2}) {
+		t.Errorf("Expected a facet for each year, latest first, received %v", res.Years)
+	}
+
+	if _, err := s.Search.Search(&SearchQuery{UserID: 1, From: date(2020, 1, 1), To: date(2019, 1, 1)}); err != ErrSearchRangeInvalid {
+		t.Errorf("Expected ErrSearchRangeInvalid, received %v", err)
+	}
+}
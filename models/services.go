@@ -1,40 +1,187 @@
 package models
 
 import (
-	"os"
-
 	"github.com/jinzhu/gorm"
-	// we want to keep the postgres dialect even though we are not using it directly
+	// we want to keep the postgres and sqlite dialects even though we are not using them directly
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
-// NewServices func is responsible for making a connection to the database
-func NewServices(dbDriver, connectionInfo string) (*Services, error) {
-	db, err := gorm.Open(dbDriver, connectionInfo)
-	if err != nil {
-		return nil, err
+// ServicesConfig is used to configure the Services built by
+// NewServices. They are applied in order, so WithGorm needs to
+// come before any of the services that use the database.
+type ServicesConfig func(*Services) error
+
+// WithGorm opens a database connection using the provided gorm
+// dialect and connection string
+func WithGorm(dialect, connectionInfo string) ServicesConfig {
+	return func(s *Services) error {
+		db, err := gorm.Open(dialect, connectionInfo)
+		if err != nil {
+			return err
+		}
+		if dialect == "sqlite3" {
+			// sqlite only allows a single writer, and an in-memory database
+			// only lives as long as the connection that created it
+			db.DB().SetMaxOpenConns(1)
+		}
+		s.db = db
+		return nil
+	}
+}
+
+// WithLogMode turns logging of every SQL query on or off
+func WithLogMode(mode bool) ServicesConfig {
+	return func(s *Services) error {
+		s.db.LogMode(mode)
+		return nil
+	}
+}
+
+// WithUser sets up the UserService using the provided password
+// pepper and the key used to hash remember and reset tokens
+func WithUser(pepper, hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.User = NewUserService(s.db, pepper, hmacKey)
+		return nil
+	}
+}
+
+// WithUserIdentity sets up the UserIdentityService used to sign in
+// with external providers. It creates users through the
+// UserService, so WithUser needs to come first.
+func WithUserIdentity() ServicesConfig {
+	return func(s *Services) error {
+		s.UserIdentity = NewUserIdentityService(s.db, s.User)
+		return nil
+	}
+}
+
+// WithAPIToken sets up the APITokenService using the key used to
+// hash the tokens
+func WithAPIToken(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.APIToken = NewAPITokenService(s.db, hmacKey)
+		return nil
+	}
+}
+
+// WithOAuth sets up the OAuthService using the key used to hash
+// client secrets, codes and refresh tokens. It issues access
+// tokens through the APITokenService, so WithAPIToken needs to
+// come first.
+func WithOAuth(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.OAuth = NewOAuthService(s.db, s.APIToken, hmacKey)
+		return nil
+	}
+}
+
+// WithAudit sets up the AuditService used to record security
+// relevant and content events
+func WithAudit() ServicesConfig {
+	return func(s *Services) error {
+		s.Audit = NewAuditService(s.db)
+		return nil
+	}
+}
+
+// WithAccountDeletion sets up the AccountDeletionService used to
+// schedule deleting accounts
+func WithAccountDeletion() ServicesConfig {
+	return func(s *Services) error {
+		s.AccountDeletion = NewAccountDeletionService(s.db)
+		return nil
 	}
-	var logDB bool
-	if os.Getenv("APP_ENV") == "production" {
-		logDB = false
-	} else {
-		logDB = true
+}
+
+// WithExport sets up the ExportService using the key used to hash
+// download tokens
+func WithExport(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Export = NewExportService(s.db, hmacKey)
+		return nil
+	}
+}
+
+// WithUpload sets up the UploadService
+func WithUpload() ServicesConfig {
+	return func(s *Services) error {
+		s.Upload = NewUploadService(s.db)
+		return nil
+	}
+}
+
+// WithGallery sets up the GalleryService
+func WithGallery() ServicesConfig {
+	return func(s *Services) error {
+		s.Gallery = NewGalleryService(s.db)
+		return nil
+	}
+}
+
+// WithCollection sets up the CollectionService
+func WithCollection() ServicesConfig {
+	return func(s *Services) error {
+		s.Collection = NewCollectionService(s.db)
+		return nil
+	}
+}
+
+// WithImage sets up the ImageService
+func WithImage() ServicesConfig {
+	return func(s *Services) error {
+		s.Image = NewImageService(s.db)
+		return nil
+	}
+}
+
+// WithUsage sets up the UsageService, and wraps the ImageService
+// to keep it up to date and hold users to their plans. It needs
+// WithUser, WithGallery and WithImage to come first.
+func WithUsage() ServicesConfig {
+	return func(s *Services) error {
+		s.Usage = NewUsageService(s.db, s.User, s.Gallery, s.Image)
+		s.Image = NewQuotaImageService(s.Image, s.Usage)
+		return nil
+	}
+}
+
+// WithSearch sets up the SearchService
+func WithSearch() ServicesConfig {
+	return func(s *Services) error {
+		s.Search = NewSearchService(s.db)
+		return nil
+	}
+}
+
+// NewServices func is responsible for making a connection to the database
+func NewServices(cfgs ...ServicesConfig) (*Services, error) {
+	var s Services
+	for _, cfg := range cfgs {
+		if err := cfg(&s); err != nil {
+			return nil, err
+		}
 	}
-	db.LogMode(logDB)
-	return &Services{
-		User:    NewUserService(db),
-		Gallery: NewGalleryService(db),
-		Image:   NewImageService(),
-		db:      db,
-	}, nil
+	return &s, nil
 }
 
 // Services struct that encompasses all our services
 type Services struct {
-	Gallery GalleryService
-	User    UserService
-	Image   ImageService
-	db      *gorm.DB
+	Gallery         GalleryService
+	Collection      CollectionService
+	User            UserService
+	UserIdentity    UserIdentityService
+	Image           ImageService
+	APIToken        APITokenService
+	OAuth           OAuthService
+	Audit           AuditService
+	AccountDeletion AccountDeletionService
+	Export          ExportService
+	Upload          UploadService
+	Search          SearchService
+	Usage           UsageService
+	db              *gorm.DB
 }
 
 // Close closes the database connection
@@ -44,7 +191,7 @@ func (s *Services) Close() error {
 
 // DestructiveReset drops the tables and rebuilds it
 func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}, &Gallery{}, &pwReset{}).Error
+	err := s.db.DropTableIfExists(&User{}, &UserIdentity{}, &Gallery{}, &PwReset{}, &APIToken{}, &OAuthClient{}, &OAuthCode{}, &OAuthRefreshToken{}, &AuditEvent{}, &AccountDeletion{}, &Export{}, &Upload{}, &ImageDetails{}, &Collection{}, &GalleryUsage{}).Error
 	if err != nil {
 		return err
 	}
@@ -53,5 +200,5 @@ func (s *Services) DestructiveReset() error {
 
 // AutoMigrate will attempt to automatically migrate the tables
 func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}, &Gallery{}, &pwReset{}).Error
+	return s.db.AutoMigrate(&User{}, &UserIdentity{}, &Gallery{}, &PwReset{}, &APIToken{}, &OAuthClient{}, &OAuthCode{}, &OAuthRefreshToken{}, &AuditEvent{}, &AccountDeletion{}, &Export{}, &Upload{}, &ImageDetails{}, &Collection{}, &GalleryUsage{}).Error
 }
@@ -0,0 +1,36 @@
+package models
+
+import "testing"
+
+// testingServices returns a Services backed by a fresh in-memory
+// sqlite database so that the model tests don't need a running
+// postgres server. The database is closed when the test ends.
+func testingServices(t *testing.T) *Services {
+	t.Helper()
+	s, err := NewServices(
+		WithGorm("sqlite3", ":memory:"),
+		WithLogMode(false),
+		WithUser("test-pepper", "test-hmac-key"),
+		WithUserIdentity(),
+		WithAPIToken("test-hmac-key"),
+		WithOAuth("test-hmac-key"),
+		WithAudit(),
+		WithAccountDeletion(),
+		WithExport("test-hmac-key"),
+		WithUpload(),
+		WithGallery(),
+		WithCollection(),
+		WithImage(),
+		WithSearch(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+	})
+	return s
+}
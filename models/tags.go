@@ -0,0 +1,68 @@
+package models
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTags is the most tags a gallery or image can have
+	MaxTags = 50
+	// MaxTagLength is the most characters a tag can have
+	MaxTagLength = 50
+)
+
+// SplitTags splits a comma separated list of tags. Tags are lower
+// cased with their spaces collapsed, and empty and repeated tags
+// are dropped, keeping the rest in order.
+func SplitTags(s string) []string {
+	var ret []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		ret = append(ret, tag)
+	}
+	return ret
+}
+
+// JoinTags is how tags are stored, which SplitTags reads back
+func JoinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// normalizeTags cleans up a comma separated list of tags in place
+// and checks there aren't too many or too long ones
+func normalizeTags(tags *string) error {
+	split := SplitTags(*tags)
+	if len(split) > MaxTags {
+		return ErrTooManyTags
+	}
+	for _, tag := range split {
+		if utf8.RuneCountInString(tag) > MaxTagLength {
+			return ErrTagTooLong
+		}
+	}
+	*tags = JoinTags(split)
+	return nil
+}
+
+// addTags adds more tags to a comma separated list, quietly
+// dropping the ones that are too long or don't fit. It is for tags
+// the user didn't type, where an error would just be confusing.
+func addTags(tags string, more []string) string {
+	split := SplitTags(tags + "," + strings.Join(more, ","))
+	var ret []string
+	for _, tag := range split {
+		if len(ret) == MaxTags {
+			break
+		}
+		if utf8.RuneCountInString(tag) <= MaxTagLength {
+			ret = append(ret, tag)
+		}
+	}
+	return JoinTags(ret)
+}
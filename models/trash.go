@@ -0,0 +1,178 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultTrashRetention is how long deleted galleries and images
+// are kept in the trash before they are purged
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// trashPath is where the deleted images of a gallery are kept. It
+// is outside of images/ so they aren't served to everyone.
+func (is *imageService) trashPath(galleryID uint) string {
+	return fmt.Sprintf("trash/galleries/%v/", galleryID)
+}
+
+func (is *imageService) mkTrashPath(galleryID uint) (string, error) {
+	trashPath := is.trashPath(galleryID)
+	if err := os.MkdirAll(trashPath, 0755); err != nil {
+		return "", err
+	}
+	return trashPath, nil
+}
+
+// DeletedByGalleryID gets the details of the images in a gallery's
+// trash, with the size of their files
+func (is *imageService) DeletedByGalleryID(galleryID uint) ([]Image, error) {
+	var details []ImageDetails
+	err := is.db.Unscoped().
+		Where("gallery_id = ? AND deleted_at IS NOT NULL", galleryID).
+		Order("deleted_at DESC").
+		Find(&details).Error
+	if err != nil {
+		return nil, err
+	}
+	return is.deletedImages(details), nil
+}
+
+// DeletedBefore gets the images of every gallery deleted before t
+func (is *imageService) DeletedBefore(t time.Time) ([]Image, error) {
+	var details []ImageDetails
+	err := is.db.Unscoped().Where("deleted_at < ?", t).Find(&details).Error
+	if err != nil {
+		return nil, err
+	}
+	return is.deletedImages(details), nil
+}
+
+func (is *imageService) deletedImages(details []ImageDetails) []Image {
+	images := make([]Image, len(details))
+	for i, d := range details {
+		images[i] = d.image()
+		if info, err := os.Stat(is.trashPath(d.GalleryID) + d.Filename); err == nil {
+			images[i].Size = info.Size()
+		}
+	}
+	return images
+}
+
+// OpenDeleted opens an image file in the trash, returning
+// ErrNotFound if it isn't there
+func (is *imageService) OpenDeleted(i *Image) (io.ReadCloser, error) {
+	if err := ValidateImageFilename(i.Filename); err != nil {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(is.trashPath(i.GalleryID) + i.Filename)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Restore moves the file back into the gallery before undeleting
+// its details, moving it back to the trash if that fails
+func (is *imageService) Restore(i *Image) error {
+	var details ImageDetails
+	db := is.db.Unscoped().Where("gallery_id = ? AND filename = ? AND deleted_at IS NOT NULL", i.GalleryID, i.Filename)
+	if err := first(db, &details); err != nil {
+		return err
+	}
+	path, err := is.mkImagePath(i.GalleryID)
+	if err != nil {
+		return err
+	}
+	var b fileBatch
+	err = b.rename(is.trashPath(i.GalleryID)+i.Filename, path+i.Filename)
+	if err == ErrImageNameTaken {
+		return ErrRestoreNameTaken
+	}
+	if err != nil {
+		return err
+	}
+	err = is.db.Unscoped().Model(&details).UpdateColumn("deleted_at", nil).Error
+	if err != nil {
+		return b.rollback(err)
+	}
+	return nil
+}
+
+// Purge removes the image file from the gallery or its trash along
+// with the details, returning ErrNotFound if there was neither
+func (is *imageService) Purge(i *Image) error {
+	if err := ValidateImageFilename(i.Filename); err != nil {
+		return ErrNotFound
+	}
+	found := false
+	for _, path := range []string{i.RelativePath(), is.trashPath(i.GalleryID) + i.Filename} {
+		err := os.Remove(path)
+		if err == nil {
+			found = true
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	db := is.db.Unscoped().Where("gallery_id = ? AND filename = ?", i.GalleryID, i.Filename).
+		Delete(&ImageDetails{})
+	if db.Error != nil {
+		return db.Error
+	}
+	if !found && db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// purgeDeleted removes the images of a gallery named filenames from
+// its trash for good, if they are there
+func (is *imageService) purgeDeleted(galleryID uint, filenames []string) error {
+	err := is.db.Unscoped().
+		Where("gallery_id = ? AND filename IN (?) AND deleted_at IS NOT NULL", galleryID, filenames).
+		Delete(&ImageDetails{}).Error
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		if err := os.Remove(is.trashPath(galleryID) + filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore validates the ID before taking the gallery out of the
+// trash
+func (gv *galleryValidator) Restore(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return gv.GalleryDB.Restore(id)
+}
+
+// Restore clears the gallery's deleted_at, returning ErrNotFound if
+// it wasn't deleted
+func (gg *galleryGorm) Restore(id uint) error {
+	db := gg.db.Unscoped().Model(&Gallery{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		UpdateColumn("deleted_at", nil)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeletedBefore gets the galleries of every user deleted before t
+func (gg *galleryGorm) DeletedBefore(t time.Time) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Unscoped().Where("deleted_at < ?", t).Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
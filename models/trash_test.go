@@ -0,0 +1,143 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImageTrash(t *testing.T) {
+	is := testingServices(t).Image
+	inTempDir(t)
+	for _, filename := range []string{"a.jpg", "b.jpg"} {
+		if err := is.Create(1, strings.NewReader(filename), filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := is.Update(&Image{GalleryID: 1, Filename: "a.jpg", Caption: "Ay"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := is.Delete(&Image{GalleryID: 1, Filename: "a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("images/galleries/1/a.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to leave the gallery, received %v", err)
+	}
+	deleted, err := is.DeletedByGalleryID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Filename != "a.jpg" || deleted[0].DeletedAt == nil || deleted[0].Size != 5 {
+		t.Fatalf("Expected a.jpg in the trash, received %+v", deleted)
+	}
+	f, err := is.OpenDeleted(&deleted[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(b) != "a.jpg" {
+		t.Errorf("Expected to read the trashed file, received %q", b)
+	}
+
+	if err := is.Restore(&Image{GalleryID: 1, Filename: "a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	images, _ := is.ByGalleryID(1)
+	if len(images) != 2 || images[0].Filename != "a.jpg" || images[0].Caption != "Ay" {
+		t.Errorf("Expected a.jpg back with its details, received %+v", images)
+	}
+	if err := is.Restore(&Image{GalleryID: 1, Filename: "a.jpg"}); err != ErrNotFound {
+		t.Errorf("Expected restoring a live image to be ErrNotFound, received %v", err)
+	}
+
+	// uploading an image of the same name replaces the one in the
+	// trash
+	is.Delete(&Image{GalleryID: 1, Filename: "b.jpg"})
+	if err := is.Create(1, strings.NewReader("new b"), "b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, _ := is.DeletedByGalleryID(1); len(deleted) != 0 {
+		t.Errorf("Expected the trashed b.jpg to be replaced, received %+v", deleted)
+	}
+	if _, err := os.Stat(is.(*imageService).trashPath(1) + "b.jpg"); !os.IsNotExist(err) {
+		t.Errorf("Expected the trashed file to be removed, received %v", err)
+	}
+
+	is.Delete(&Image{GalleryID: 1, Filename: "a.jpg"})
+	if old, _ := is.DeletedBefore(time.Now().Add(-time.Hour)); len(old) != 0 {
+		t.Errorf("Expected nothing deleted an hour ago, received %+v", old)
+	}
+	if err := is.Purge(&Image{GalleryID: 1, Filename: "a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, _ := is.DeletedByGalleryID(1); len(deleted) != 0 {
+		t.Errorf("Expected the trash to be empty, received %+v", deleted)
+	}
+	if err := is.Purge(&Image{GalleryID: 1, Filename: "a.jpg"}); err != ErrNotFound {
+		t.Errorf("Expected purging twice to be ErrNotFound, received %v", err)
+	}
+}
+
+func TestGalleryRestore(t *testing.T) {
+	gs := testingServices(t).Gallery
+	gallery := Gallery{UserID: 1, Title: "Wedding"}
+	if err := gs.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Restore(gallery.ID); err != ErrNotFound {
+		t.Errorf("Expected restoring a live gallery to be ErrNotFound, received %v", err)
+	}
+	if err := gs.Delete(gallery.ID); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, _ := gs.DeletedBefore(time.Now().Add(time.Minute)); len(deleted) != 1 {
+		t.Errorf("Expected the deleted gallery, received %+v", deleted)
+	}
+	if err := gs.Restore(gallery.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gs.ByID(gallery.ID); err != nil {
+		t.Errorf("Expected the gallery to be back, received %v", err)
+	}
+	if deleted, _ := gs.DeletedByUserID(1); len(deleted) != 0 {
+		t.Errorf("Expected the trash to be empty, received %+v", deleted)
+	}
+}
+
+// TestImageDiscard checks discarding what a failed upload left
+// behind keeps the trashed image of the same name
+func TestImageDiscard(t *testing.T) {
+	is := testingServices(t).Image
+	inTempDir(t)
+	for _, filename := range []string{"a.jpg", "b.jpg"} {
+		if err := is.Create(1, strings.NewReader(filename), filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := is.Delete(&Image{GalleryID: 1, Filename: "a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	// half of a new a.jpg, which failed before its details were saved
+	if err := ioutil.WriteFile("images/galleries/1/a.jpg", []byte("a."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"a.jpg", "b.jpg"} {
+		if err := is.Discard(&Image{GalleryID: 1, Filename: filename}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if images, _ := is.ByGalleryID(1); len(images) != 0 {
+		t.Errorf("Expected the gallery to be empty, received %+v", images)
+	}
+	deleted, err := is.DeletedByGalleryID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Filename != "a.jpg" {
+		t.Errorf("Expected only the trashed a.jpg in the trash, received %+v", deleted)
+	}
+}
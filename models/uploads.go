@@ -0,0 +1,222 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// DefaultUploadExpiry is how long an unfinished upload is kept
+	// after the last chunk we received for it
+	DefaultUploadExpiry = 24 * time.Hour
+
+	// MaxUploadLength is the largest image that can be uploaded in
+	// chunks
+	MaxUploadLength = 200 << 20
+)
+
+// Upload is an image being uploaded to a gallery in chunks, which
+// are appended to a part file until Offset reaches Length. It is
+// then handed to the ImageService and removed.
+type Upload struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	GalleryID uint      `gorm:"not null"`
+	Filename  string    `gorm:"not null"`
+	Length    int64     `gorm:"not null"`
+	Offset    int64     `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// PartName is the name of the file the upload is written to
+func (u *Upload) PartName() string {
+	return fmt.Sprintf("upload-%d.part", u.ID)
+}
+
+// Complete reports whether every byte has been received
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// UploadDB is used to interact with the uploads table
+type UploadDB interface {
+	ByID(id uint) (*Upload, error)
+	// ByUserID gets the uploads the user hasn't finished or abandoned
+	ByUserID(userID uint) ([]Upload, error)
+	// Expired gets the uploads that expired before t
+	Expired(t time.Time) ([]Upload, error)
+
+	Create(upload *Upload) error
+	Update(upload *Upload) error
+	// Delete removes a finished or abandoned upload for good
+	Delete(id uint) error
+}
+
+// UploadService keeps track of chunked uploads
+type UploadService interface {
+	UploadDB
+}
+
+// NewUploadService handles DB connection
+func NewUploadService(db *gorm.DB) UploadService {
+	return NewUploadServiceFromDB(&uploadGorm{db})
+}
+
+// NewUploadServiceFromDB builds an UploadService on top of any
+// UploadDB implementation, wrapping it in our validation.
+func NewUploadServiceFromDB(udb UploadDB) UploadService {
+	return &uploadService{
+		UploadDB: &uploadValidator{udb},
+	}
+}
+
+type uploadService struct {
+	UploadDB
+}
+
+type uploadValFunc func(*Upload) error
+
+func runUploadValFuncs(upload *Upload, fns ...uploadValFunc) error {
+	for _, fn := range fns {
+		if err := fn(upload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * validators
+type uploadValidator struct {
+	UploadDB
+}
+
+// Create validator for uploads
+func (uv *uploadValidator) Create(upload *Upload) error {
+	err := runUploadValFuncs(upload,
+		uv.userIDRequired,
+		uv.galleryIDRequired,
+		uv.filenameValid,
+		uv.lengthValid,
+		uv.offsetValid,
+		uv.expiresAtRequired)
+	if err != nil {
+		return err
+	}
+	return uv.UploadDB.Create(upload)
+}
+
+// Update validator for uploads
+func (uv *uploadValidator) Update(upload *Upload) error {
+	err := runUploadValFuncs(upload,
+		uv.userIDRequired,
+		uv.galleryIDRequired,
+		uv.filenameValid,
+		uv.lengthValid,
+		uv.offsetValid,
+		uv.expiresAtRequired)
+	if err != nil {
+		return err
+	}
+	return uv.UploadDB.Update(upload)
+}
+
+// Delete validator for uploads
+func (uv *uploadValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return uv.UploadDB.Delete(id)
+}
+
+func (uv *uploadValidator) userIDRequired(u *Upload) error {
+	if u.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (uv *uploadValidator) galleryIDRequired(u *Upload) error {
+	if u.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+func (uv *uploadValidator) filenameValid(u *Upload) error {
+	return ValidateImageFilename(u.Filename)
+}
+
+func (uv *uploadValidator) lengthValid(u *Upload) error {
+	if u.Length <= 0 || u.Length > MaxUploadLength {
+		return ErrUploadLengthInvalid
+	}
+	return nil
+}
+
+func (uv *uploadValidator) offsetValid(u *Upload) error {
+	if u.Offset < 0 || u.Offset > u.Length {
+		return ErrUploadOffsetInvalid
+	}
+	return nil
+}
+
+func (uv *uploadValidator) expiresAtRequired(u *Upload) error {
+	if u.ExpiresAt.IsZero() {
+		return ErrExpiresAtRequired
+	}
+	return nil
+}
+
+var _ UploadDB = &uploadGorm{}
+
+type uploadGorm struct {
+	db *gorm.DB
+}
+
+// ByID gets an upload by its ID
+func (ug *uploadGorm) ByID(id uint) (*Upload, error) {
+	var upload Upload
+	err := first(ug.db.Where("id = ?", id), &upload)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// ByUserID gets the uploads the user hasn't finished or abandoned
+func (ug *uploadGorm) ByUserID(userID uint) ([]Upload, error) {
+	var uploads []Upload
+	err := ug.db.Where("user_id = ?", userID).Find(&uploads).Error
+	if err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// Expired gets the uploads that expired before t
+func (ug *uploadGorm) Expired(t time.Time) ([]Upload, error) {
+	var uploads []Upload
+	err := ug.db.Where("expires_at <= ?", t).Find(&uploads).Error
+	if err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// Create stores a new upload
+func (ug *uploadGorm) Create(upload *Upload) error {
+	return ug.db.Create(upload).Error
+}
+
+// Update saves the progress of an upload
+func (ug *uploadGorm) Update(upload *Upload) error {
+	return ug.db.Save(upload).Error
+}
+
+// Delete removes an upload for good
+func (ug *uploadGorm) Delete(id uint) error {
+	upload := Upload{Model: gorm.Model{ID: id}}
+	return ug.db.Unscoped().Delete(&upload).Error
+}
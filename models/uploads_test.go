@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// TestUploadValidation checks uploads are named like images, fit
+// in MaxUploadLength and can only be found until they expire
+func TestUploadValidation(t *testing.T) {
+	us := testingServices(t).Upload
+	now := time.Now()
+	upload := Upload{UserID: 1, GalleryID: 1, Filename: "cake.png", Length: 10, ExpiresAt: now.Add(time.Hour)}
+	if err := us.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		change func(u *Upload)
+		want   error
+	}{
+		{func(u *Upload) { u.Filename = "notes.txt" }, ErrImageTypeInvalid},
+		{func(u *Upload) { u.Length = MaxUploadLength + 1 }, ErrUploadLengthInvalid},
+		{func(u *Upload) { u.Length = 0 }, ErrUploadLengthInvalid},
+		{func(u *Upload) { u.Offset = 11 }, ErrUploadOffsetInvalid},
+		{func(u *Upload) { u.GalleryID = 0 }, ErrGalleryIDRequired},
+	} {
+		u := upload
+		tc.change(&u)
+		if err := us.Update(&u); err != tc.want {
+			t.Errorf("Expected %v, received %v", tc.want, err)
+		}
+	}
+
+	upload.Offset = 10
+	if err := us.Update(&upload); err != nil {
+		t.Fatal(err)
+	}
+	found, err := us.ByID(upload.ID)
+	if err != nil || !found.Complete() {
+		t.Errorf("Expected the complete upload, received %+v, %v", found, err)
+	}
+	expired, err := us.Expired(now.Add(2 * time.Hour))
+	if err != nil || len(expired) != 1 {
+		t.Errorf("Expected the upload to expire, received %v, %v", expired, err)
+	}
+	if err := us.Delete(upload.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByID(upload.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, received %v", err)
+	}
+}
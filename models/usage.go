@@ -0,0 +1,399 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Plans users can be on
+const (
+	PlanFree = "free"
+	PlanPro  = "pro"
+)
+
+// Plan limits how much a user can store. A limit of 0 means there
+// is none.
+type Plan struct {
+	Name      string
+	MaxBytes  int64
+	MaxImages int
+}
+
+// Plans lists every plan from smallest to largest
+var Plans = []Plan{
+	{Name: PlanFree, MaxBytes: 1 << 30, MaxImages: 1000},
+	{Name: PlanPro, MaxBytes: 100 << 30, MaxImages: 50000},
+}
+
+// PlanByName finds the plan called name, returning ErrPlanInvalid
+// if there isn't one
+func PlanByName(name string) (Plan, error) {
+	for _, plan := range Plans {
+		if plan.Name == name {
+			return plan, nil
+		}
+	}
+	return Plan{}, ErrPlanInvalid
+}
+
+// Usage is the storage some images take up
+type Usage struct {
+	Bytes  int64 `gorm:"not null"`
+	Images int   `gorm:"not null"`
+}
+
+// add adds the size of each image to u
+func (u *Usage) add(images []Image) {
+	for _, image := range images {
+		u.Bytes += image.Size
+		u.Images++
+	}
+}
+
+// GalleryUsage is the storage the images of a gallery take up,
+// those in the trash included, as last counted
+type GalleryUsage struct {
+	GalleryID uint `gorm:"primary_key;auto_increment:false"`
+	UserID    uint `gorm:"not null;index"`
+	Usage
+	UpdatedAt time.Time
+}
+
+// Quota is what a user's plan allows next to what they use
+type Quota struct {
+	UserID uint
+	Plan   Plan
+	Usage  Usage
+}
+
+// Check returns ErrStorageQuotaExceeded or ErrImageQuotaExceeded if
+// storing bytes more in images more would take the user over their
+// plan
+func (q *Quota) Check(bytes int64, images int) error {
+	if images > 0 && q.Plan.MaxImages > 0 && q.Usage.Images+images > q.Plan.MaxImages {
+		return ErrImageQuotaExceeded
+	}
+	if bytes > 0 && q.Plan.MaxBytes > 0 && q.Usage.Bytes+bytes > q.Plan.MaxBytes {
+		return ErrStorageQuotaExceeded
+	}
+	return nil
+}
+
+// RemainingBytes is how much more the user can store, or -1 if
+// their plan has no limit
+func (q *Quota) RemainingBytes() int64 {
+	if q.Plan.MaxBytes == 0 {
+		return -1
+	}
+	if q.Usage.Bytes >= q.Plan.MaxBytes {
+		return 0
+	}
+	return q.Plan.MaxBytes - q.Usage.Bytes
+}
+
+// Percent is how much of their plan the user has used, whichever of
+// storage or images is fuller, from 0 to 100
+func (q *Quota) Percent() int {
+	percent := 0
+	if q.Plan.MaxBytes > 0 {
+		percent = int(q.Usage.Bytes * 100 / q.Plan.MaxBytes)
+	}
+	if q.Plan.MaxImages > 0 {
+		if p := q.Usage.Images * 100 / q.Plan.MaxImages; p > percent {
+			percent = p
+		}
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// NearlyFull reports whether the user has used 90% or more of
+// their plan
+func (q *Quota) NearlyFull() bool {
+	return q.Percent() >= 90
+}
+
+// UsageChange is a gallery whose stored usage was wrong when it was
+// reconciled
+type UsageChange struct {
+	GalleryID uint
+	UserID    uint
+	From      Usage
+	To        Usage
+}
+
+// UsageReport is what reconciling usage with storage found
+type UsageReport struct {
+	// Galleries is how many galleries were recounted
+	Galleries int
+	Changed   []UsageChange
+	// Orphaned are galleries that no longer exist but still have
+	// images stored, which nobody is charged for
+	Orphaned []uint
+}
+
+// UsageDB stores the usage of each gallery
+type UsageDB interface {
+	// ByUserID gets the usage of every gallery of a user, those in
+	// the trash included, the largest first
+	ByUserID(userID uint) ([]GalleryUsage, error)
+	// ByGalleryID gets the usage of a gallery
+	ByGalleryID(galleryID uint) (*GalleryUsage, error)
+	// All gets the usage of every gallery
+	All() ([]GalleryUsage, error)
+	// Set stores the usage of a gallery, replacing what was there
+	Set(usage *GalleryUsage) error
+	// Delete forgets the usage of a gallery
+	Delete(galleryID uint) error
+}
+
+// UsageService keeps track of how much each user stores, see
+// NewQuotaImageService
+type UsageService interface {
+	UsageDB
+	// Quota gets a user's plan and what they use of it
+	Quota(user *User) (*Quota, error)
+	// GalleryQuota gets the Quota of the user a gallery belongs to
+	GalleryQuota(galleryID uint) (*Quota, error)
+	// ImageQuota is the GalleryQuota left for storing filename in a
+	// gallery, not counting the image of that name it would replace
+	ImageQuota(galleryID uint, filename string) (*Quota, error)
+	// Recount works out the usage of a gallery from its images,
+	// those in the trash included, and stores it
+	Recount(galleryID uint) (*GalleryUsage, error)
+	// Reconcile recounts every gallery with images in storage or
+	// usage stored, fixing usage that has drifted
+	Reconcile() (*UsageReport, error)
+}
+
+// NewUsageService stores usage in db. It counts the images of is,
+// which should be the ImageService before it is wrapped by
+// NewQuotaImageService.
+func NewUsageService(db *gorm.DB, us UserService, gs GalleryService, is ImageService) UsageService {
+	return NewUsageServiceFromDB(&usageGorm{db}, us, gs, is)
+}
+
+// NewUsageServiceFromDB builds a UsageService on top of any
+// UsageDB implementation
+func NewUsageServiceFromDB(udb UsageDB, us UserService, gs GalleryService, is ImageService) UsageService {
+	return &usageService{
+		UsageDB: udb,
+		us:      us,
+		gs:      gs,
+		is:      is,
+	}
+}
+
+type usageService struct {
+	UsageDB
+	us UserService
+	gs GalleryService
+	is ImageService
+}
+
+// Quota adds up the usage of the user's galleries
+func (us *usageService) Quota(user *User) (*Quota, error) {
+	plan, err := PlanByName(user.Plan)
+	if err != nil {
+		return nil, err
+	}
+	galleries, err := us.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	q := Quota{UserID: user.ID, Plan: plan}
+	for _, g := range galleries {
+		q.Usage.Bytes += g.Bytes
+		q.Usage.Images += g.Images
+	}
+	return &q, nil
+}
+
+// GalleryQuota looks up the owner of the gallery
+func (us *usageService) GalleryQuota(galleryID uint) (*Quota, error) {
+	gallery, err := us.gs.ByID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.us.ByID(gallery.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return us.Quota(user)
+}
+
+// ImageQuota takes away the images of the same name in the gallery,
+// whether they are there or in the trash, since creating the image
+// replaces both
+func (us *usageService) ImageQuota(galleryID uint, filename string) (*Quota, error) {
+	q, err := us.GalleryQuota(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	images, err := us.is.ByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := us.is.DeletedByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range append(images, deleted...) {
+		if image.Filename == filename {
+			q.Usage.Bytes -= image.Size
+			q.Usage.Images--
+		}
+	}
+	return q, nil
+}
+
+// Recount charges the gallery to the user it was charged to
+// before, or its owner if it is new
+func (us *usageService) Recount(galleryID uint) (*GalleryUsage, error) {
+	var userID uint
+	stored, err := us.ByGalleryID(galleryID)
+	switch err {
+	case nil:
+		userID = stored.UserID
+	case ErrNotFound:
+		gallery, err := us.gs.ByID(galleryID)
+		if err != nil {
+			return nil, err
+		}
+		userID = gallery.UserID
+	default:
+		return nil, err
+	}
+	return us.recount(galleryID, userID)
+}
+
+// recount counts the images of a gallery, forgetting its usage when
+// there are none so purged galleries don't leave any behind
+func (us *usageService) recount(galleryID, userID uint) (*GalleryUsage, error) {
+	usage := GalleryUsage{GalleryID: galleryID, UserID: userID}
+	images, err := us.is.ByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	usage.add(images)
+	deleted, err := us.is.DeletedByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	usage.add(deleted)
+	if usage.Images == 0 {
+		return &usage, us.Delete(galleryID)
+	}
+	return &usage, us.Set(&usage)
+}
+
+// Reconcile finds the owner of each gallery, including those in the
+// trash, before recounting it
+func (us *usageService) Reconcile() (*UsageReport, error) {
+	stored, err := us.All()
+	if err != nil {
+		return nil, err
+	}
+	before := make(map[uint]GalleryUsage, len(stored))
+	for _, u := range stored {
+		before[u.GalleryID] = u
+	}
+	ids, err := us.is.GalleryIDs()
+	if err != nil {
+		return nil, err
+	}
+	for id := range before {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	trashed, err := us.gs.DeletedBefore(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[uint]uint, len(trashed))
+	for _, g := range trashed {
+		owners[g.ID] = g.UserID
+	}
+
+	var report UsageReport
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+		userID, ok := owners[id]
+		if !ok {
+			gallery, err := us.gs.ByID(id)
+			switch err {
+			case nil:
+				userID = gallery.UserID
+			case ErrNotFound:
+				report.Orphaned = append(report.Orphaned, id)
+				if _, ok := before[id]; ok {
+					if err := us.Delete(id); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			default:
+				return nil, err
+			}
+		}
+		after, err := us.recount(id, userID)
+		if err != nil {
+			return nil, err
+		}
+		report.Galleries++
+		if b := before[id]; b.Usage != after.Usage || b.UserID != after.UserID {
+			report.Changed = append(report.Changed, UsageChange{
+				GalleryID: id,
+				UserID:    userID,
+				From:      b.Usage,
+				To:        after.Usage,
+			})
+		}
+	}
+	return &report, nil
+}
+
+var _ UsageDB = &usageGorm{}
+
+type usageGorm struct {
+	db *gorm.DB
+}
+
+func (ug *usageGorm) ByUserID(userID uint) ([]GalleryUsage, error) {
+	var usage []GalleryUsage
+	err := ug.db.Where("user_id = ?", userID).Order("bytes DESC").Order("gallery_id").Find(&usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (ug *usageGorm) ByGalleryID(galleryID uint) (*GalleryUsage, error) {
+	var usage GalleryUsage
+	if err := first(ug.db.Where("gallery_id = ?", galleryID), &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+func (ug *usageGorm) All() ([]GalleryUsage, error) {
+	var usage []GalleryUsage
+	if err := ug.db.Order("gallery_id").Find(&usage).Error; err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (ug *usageGorm) Set(usage *GalleryUsage) error {
+	return ug.db.Save(usage).Error
+}
+
+func (ug *usageGorm) Delete(galleryID uint) error {
+	return ug.db.Where("gallery_id = ?", galleryID).Delete(&GalleryUsage{}).Error
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	q := Quota{
+		Plan:  Plan{Name: PlanFree, MaxBytes: 100, MaxImages: 2},
+		Usage: Usage{Bytes: 90, Images: 1},
+	}
+	if err := q.Check(10, 1); err != nil {
+		t.Errorf("Expected an image that just fits to be allowed, received %v", err)
+	}
+	if err := q.Check(11, 1); err != ErrStorageQuotaExceeded {
+		t.Errorf("Expected ErrStorageQuotaExceeded, received %v", err)
+	}
+	if err := q.Check(1, 2); err != ErrImageQuotaExceeded {
+		t.Errorf("Expected ErrImageQuotaExceeded, received %v", err)
+	}
+	if q.RemainingBytes() != 10 || q.Percent() != 90 || !q.NearlyFull() {
+		t.Errorf("Expected 10 bytes left at 90%%, received %d at %d%%", q.RemainingBytes(), q.Percent())
+	}
+	unlimited := Quota{Plan: Plan{Name: "unlimited"}, Usage: Usage{Bytes: 1 << 40, Images: 1 << 20}}
+	if err := unlimited.Check(1<<40, 1); err != nil || unlimited.RemainingBytes() != -1 || unlimited.Percent() != 0 {
+		t.Errorf("Expected a plan without limits to allow anything, received %v", err)
+	}
+}
+
+// usageServices returns testingServices with a user on a plan of
+// 10 bytes and 2 images, who owns the returned gallery
+func usageServices(t *testing.T) (*Services, *Gallery) {
+	t.Helper()
+	plans := Plans
+	Plans = []Plan{{Name: PlanFree, MaxBytes: 10, MaxImages: 2}}
+	t.Cleanup(func() { Plans = plans })
+	s := testingServices(t)
+	if err := WithUsage()(s); err != nil {
+		t.Fatal(err)
+	}
+	inTempDir(t)
+	user := User{Name: "Gary", Email: "gary@test.dev", Password: "secret-password"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Plan != PlanFree {
+		t.Errorf("Expected new users to be on the free plan, received %q", user.Plan)
+	}
+	gallery := Gallery{UserID: user.ID, Title: "Wedding"}
+	if err := s.Gallery.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+	return s, &gallery
+}
+
+// unsized hides the size of a reader, like an upload being
+// streamed
+type unsized struct{ r io.Reader }
+
+func (u unsized) Read(p []byte) (int, error) { return u.r.Read(p) }
+
+// slow is a reader of a known size that takes its time, so
+// uploads overlap
+type slow struct{ *strings.Reader }
+
+func (s slow) Read(p []byte) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	return s.Reader.Read(p)
+}
+
+func TestQuotaImageService(t *testing.T) {
+	s, gallery := usageServices(t)
+	is := s.Image
+	usage := func() Usage {
+		t.Helper()
+		u, err := s.Usage.ByGalleryID(gallery.ID)
+		if err == ErrNotFound {
+			return Usage{}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Usage
+	}
+
+	if err := is.Create(gallery.ID, strings.NewReader("aaaa"), "a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if u := usage(); u != (Usage{Bytes: 4, Images: 1}) {
+		t.Errorf("Expected the upload to be counted, received %+v", u)
+	}
+	if err := is.Create(gallery.ID, unsized{strings.NewReader("bbbbbbb")}, "b.jpg"); err != ErrStorageQuotaExceeded {
+		t.Errorf("Expected ErrStorageQuotaExceeded, received %v", err)
+	}
+	if images, _ := is.ByGalleryID(gallery.ID); len(images) != 1 {
+		t.Errorf("Expected the image over quota not to be stored, received %+v", images)
+	}
+	if err := is.Create(gallery.ID, unsized{strings.NewReader("bbbbbb")}, "b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Create(gallery.ID, strings.NewReader("c"), "c.jpg"); err != ErrImageQuotaExceeded {
+		t.Errorf("Expected ErrImageQuotaExceeded, received %v", err)
+	}
+	// replacing an image only needs room for the difference
+	if err := is.Create(gallery.ID, strings.NewReader("AAAA"), "a.jpg"); err != nil {
+		t.Errorf("Expected replacing an image to fit, received %v", err)
+	}
+
+	// the trash still takes up room until it is purged
+	if err := is.Delete(&Image{GalleryID: gallery.ID, Filename: "a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if u := usage(); u != (Usage{Bytes: 10, Images: 2}) {
+		t.Errorf("Expected the trashed image to still be counted, received %+v", u)
+	}
+	if err := is.Purge(&Image{GalleryID: gallery.ID, Filename: "a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if u := usage(); u != (Usage{Bytes: 6, Images: 1}) {
+		t.Errorf("Expected the purged image to be uncounted, received %+v", u)
+	}
+
+	other := Gallery{UserID: gallery.UserID, Title: "Reception"}
+	if err := s.Gallery.Create(&other); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Copy(gallery.ID, []string{"b.jpg"}, other.ID); err != ErrStorageQuotaExceeded {
+		t.Errorf("Expected the copy not to fit, received %v", err)
+	}
+	if err := is.Move(gallery.ID, []string{"b.jpg"}, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := s.User.ByID(gallery.UserID)
+	q, err := s.Usage.Quota(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := usage(); u != (Usage{}) || q.Usage != (Usage{Bytes: 6, Images: 1}) {
+		t.Errorf("Expected the usage to move with the image, received %+v and %+v", u, q.Usage)
+	}
+	if err := is.DeleteAll(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := s.Usage.ByUserID(user.ID); len(found) != 0 {
+		t.Errorf("Expected the usage to go with the images, received %+v", found)
+	}
+}
+
+func TestQuotaImageServiceConcurrent(t *testing.T) {
+	s, gallery := usageServices(t)
+	// the plan has room for two of these, however many are uploaded
+	// at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- s.Image.Create(gallery.ID, slow{strings.NewReader("aaaa")}, fmt.Sprintf("%d.jpg", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	stored := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			stored++
+		case ErrStorageQuotaExceeded, ErrImageQuotaExceeded:
+		default:
+			t.Errorf("Expected the quota to be exceeded, received %v", err)
+		}
+	}
+	images, _ := s.Image.ByGalleryID(gallery.ID)
+	if stored != 2 || len(images) != 2 {
+		t.Errorf("Expected two images to fit, received %d stored and %+v", stored, images)
+	}
+}
+
+func TestUsageReconcile(t *testing.T) {
+	s, gallery := usageServices(t)
+	if err := s.Image.Create(gallery.ID, strings.NewReader("aaaa"), "a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	trashed := Gallery{UserID: gallery.UserID, Title: "Trashed"}
+	if err := s.Gallery.Create(&trashed); err != nil {
+		t.Fatal(err)
+	}
+	// images stored without going through the usage, as they were
+	// before it was tracked
+	raw := NewImageService(s.db)
+	raw.Create(gallery.ID, strings.NewReader("bb"), "b.jpg")
+	raw.Create(trashed.ID, strings.NewReader("ccc"), "c.jpg")
+	raw.Create(999, strings.NewReader("d"), "d.jpg")
+	s.Gallery.Delete(trashed.ID)
+
+	report, err := s.Usage.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Galleries != 2 || len(report.Changed) != 2 || len(report.Orphaned) != 1 || report.Orphaned[0] != 999 {
+		t.Fatalf("Expected both galleries to be fixed and the missing one orphaned, received %+v", report)
+	}
+	if c := report.Changed[0]; c.GalleryID != gallery.ID || c.From != (Usage{Bytes: 4, Images: 1}) || c.To != (Usage{Bytes: 6, Images: 2}) {
+		t.Errorf("Expected the gallery to be recounted, received %+v", c)
+	}
+	if c := report.Changed[1]; c.GalleryID != trashed.ID || c.UserID != gallery.UserID || c.To != (Usage{Bytes: 3, Images: 1}) {
+		t.Errorf("Expected the trashed gallery to be charged to its owner, received %+v", c)
+	}
+	if report, _ := s.Usage.Reconcile(); len(report.Changed) != 0 {
+		t.Errorf("Expected nothing left to fix, received %+v", report.Changed)
+	}
+}
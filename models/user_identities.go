@@ -0,0 +1,273 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// UserIdentity links a user to their account with an external
+// OpenID Connect provider. Subject is the provider's stable ID for
+// that account, the email is only kept for display.
+type UserIdentity struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	Provider string `gorm:"not null;unique_index:idx_user_identities_provider_subject"`
+	Subject  string `gorm:"not null;unique_index:idx_user_identities_provider_subject"`
+	Email    string
+}
+
+// ExternalIdentity is what an external provider told us about the
+// account a user signed in with
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// UserIdentityDB is used to interact with the user_identities
+// table
+type UserIdentityDB interface {
+	ByID(id uint) (*UserIdentity, error)
+	ByProviderSubject(provider, subject string) (*UserIdentity, error)
+	ByUserID(userID uint) ([]UserIdentity, error)
+
+	Create(identity *UserIdentity) error
+	Delete(id uint) error
+}
+
+// UserIdentityService is a set of methods used to sign users in
+// with external providers and manage their linked accounts
+type UserIdentityService interface {
+	// SignIn returns the user an external identity belongs to. An
+	// identity we haven't seen before is linked to the user with the
+	// same verified email address, or to a new user without a
+	// password if there is none. ErrEmailNotVerified is returned if
+	// we'd need the email but the provider didn't verify it, and
+	// ErrAccountDisabled if the user is disabled.
+	SignIn(ext ExternalIdentity) (*User, error)
+	// Link connects an external identity to an existing user,
+	// returning ErrIdentityTaken if another user already has it.
+	Link(userID uint, ext ExternalIdentity) error
+	// Unlink removes one of a user's identities. Users without a
+	// password can't remove the last one, since they would have no
+	// way left to sign in.
+	Unlink(userID, identityID uint) error
+	UserIdentityDB
+}
+
+// NewUserIdentityService handles DB connection. Users are looked up
+// and created through us.
+func NewUserIdentityService(db *gorm.DB, us UserService) UserIdentityService {
+	return NewUserIdentityServiceFromDB(&userIdentityGorm{db}, us)
+}
+
+// NewUserIdentityServiceFromDB builds a UserIdentityService on top
+// of any UserIdentityDB implementation, wrapping it in our
+// validation.
+func NewUserIdentityServiceFromDB(uidb UserIdentityDB, us UserService) UserIdentityService {
+	return &userIdentityService{
+		UserIdentityDB: &userIdentityValidator{uidb},
+		us:             us,
+	}
+}
+
+var _ UserIdentityService = &userIdentityService{}
+
+type userIdentityService struct {
+	UserIdentityDB
+	us UserService
+}
+
+func (uis *userIdentityService) SignIn(ext ExternalIdentity) (*User, error) {
+	identity, err := uis.ByProviderSubject(ext.Provider, ext.Subject)
+	switch err {
+	case nil:
+		user, err := uis.us.ByID(identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user.Disabled {
+			return nil, ErrAccountDisabled
+		}
+		return user, nil
+	case ErrNotFound:
+	default:
+		return nil, err
+	}
+	if !ext.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	user, err := uis.us.ByEmail(ext.Email)
+	switch err {
+	case nil:
+		if user.Disabled {
+			return nil, ErrAccountDisabled
+		}
+	case ErrNotFound:
+		user = &User{
+			Name:       ext.Name,
+			Email:      ext.Email,
+			NoPassword: true,
+		}
+		if err := uis.us.Create(user); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	if err := uis.Link(user.ID, ext); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (uis *userIdentityService) Link(userID uint, ext ExternalIdentity) error {
+	identity, err := uis.ByProviderSubject(ext.Provider, ext.Subject)
+	switch err {
+	case nil:
+		if identity.UserID != userID {
+			return ErrIdentityTaken
+		}
+		return nil
+	case ErrNotFound:
+	default:
+		return err
+	}
+	return uis.Create(&UserIdentity{
+		UserID:   userID,
+		Provider: ext.Provider,
+		Subject:  ext.Subject,
+		Email:    ext.Email,
+	})
+}
+
+func (uis *userIdentityService) Unlink(userID, identityID uint) error {
+	identity, err := uis.ByID(identityID)
+	if err != nil {
+		return err
+	}
+	if identity.UserID != userID {
+		return ErrNotFound
+	}
+	user, err := uis.us.ByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.NoPassword {
+		identities, err := uis.ByUserID(userID)
+		if err != nil {
+			return err
+		}
+		if len(identities) <= 1 {
+			return ErrLastSignInMethod
+		}
+	}
+	return uis.Delete(identity.ID)
+}
+
+type userIdentityValFunc func(*UserIdentity) error
+
+func runUserIdentityValFuncs(identity *UserIdentity, fns ...userIdentityValFunc) error {
+	for _, fn := range fns {
+		if err := fn(identity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * validators
+type userIdentityValidator struct {
+	UserIdentityDB
+}
+
+// Create validator for user identities
+func (uiv *userIdentityValidator) Create(identity *UserIdentity) error {
+	err := runUserIdentityValFuncs(identity,
+		uiv.userIDRequired,
+		uiv.providerSubjectRequired,
+		uiv.normalizeEmail)
+	if err != nil {
+		return err
+	}
+	return uiv.UserIdentityDB.Create(identity)
+}
+
+// Delete will delete the identity with the provided ID
+func (uiv *userIdentityValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return uiv.UserIdentityDB.Delete(id)
+}
+
+func (uiv *userIdentityValidator) userIDRequired(ui *UserIdentity) error {
+	if ui.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (uiv *userIdentityValidator) providerSubjectRequired(ui *UserIdentity) error {
+	if ui.Provider == "" || ui.Subject == "" {
+		return ErrIdentityRequired
+	}
+	return nil
+}
+
+func (uiv *userIdentityValidator) normalizeEmail(ui *UserIdentity) error {
+	ui.Email = strings.ToLower(strings.TrimSpace(ui.Email))
+	return nil
+}
+
+var _ UserIdentityDB = &userIdentityGorm{}
+
+type userIdentityGorm struct {
+	db *gorm.DB
+}
+
+// ByID gets an identity by its ID
+func (uig *userIdentityGorm) ByID(id uint) (*UserIdentity, error) {
+	var identity UserIdentity
+	err := first(uig.db.Where("id = ?", id), &identity)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// ByProviderSubject gets the identity a provider knows by subject
+func (uig *userIdentityGorm) ByProviderSubject(provider, subject string) (*UserIdentity, error) {
+	var identity UserIdentity
+	err := first(uig.db.Where("provider = ? AND subject = ?", provider, subject), &identity)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// ByUserID gets every identity linked to a user
+func (uig *userIdentityGorm) ByUserID(userID uint) ([]UserIdentity, error) {
+	var identities []UserIdentity
+	err := uig.db.Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	if err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// Create stores a new identity
+func (uig *userIdentityGorm) Create(identity *UserIdentity) error {
+	return uig.db.Create(identity).Error
+}
+
+// Delete removes an identity for good, so the same external
+// account can be linked again later
+func (uig *userIdentityGorm) Delete(id uint) error {
+	identity := UserIdentity{Model: gorm.Model{ID: id}}
+	return uig.db.Unscoped().Delete(&identity).Error
+}
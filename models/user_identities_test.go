@@ -0,0 +1,79 @@
+package models
+
+import "testing"
+
+func TestUserIdentitySignIn(t *testing.T) {
+	s := testingServices(t)
+	existing := User{Name: "Gary", Email: "gary@test.dev", Password: "password1"}
+	if err := s.User.Create(&existing); err != nil {
+		t.Fatal(err)
+	}
+
+	ext := ExternalIdentity{Provider: "mock", Subject: "1", Email: "Gary@test.dev", Name: "Gary"}
+	if _, err := s.UserIdentity.SignIn(ext); err != ErrEmailNotVerified {
+		t.Errorf("Expected an unverified email to be rejected, received %v", err)
+	}
+
+	ext.EmailVerified = true
+	user, err := s.UserIdentity.SignIn(ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != existing.ID {
+		t.Errorf("Expected to be linked to user %d by email, received user %d", existing.ID, user.ID)
+	}
+
+	// Once linked, the identity signs in even if the email changed
+	ext.Email = "gary@elsewhere.dev"
+	ext.EmailVerified = false
+	if user, err = s.UserIdentity.SignIn(ext); err != nil || user.ID != existing.ID {
+		t.Errorf("Expected the linked identity to sign in as user %d, received %v, %v", existing.ID, user, err)
+	}
+
+	newcomer, err := s.UserIdentity.SignIn(ExternalIdentity{Provider: "mock", Subject: "2", Email: "ann@test.dev", EmailVerified: true, Name: "Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newcomer.NoPassword || newcomer.Name != "Ann" {
+		t.Errorf("Expected a new user without a password, received %+v", newcomer)
+	}
+	if _, err := s.User.Authenticate("ann@test.dev", ""); err != ErrPasswordNotSet {
+		t.Errorf("Expected users without a password to be told so, received %v", err)
+	}
+}
+
+func TestUserIdentityUnlink(t *testing.T) {
+	s := testingServices(t)
+	user, err := s.UserIdentity.SignIn(ExternalIdentity{Provider: "mock", Subject: "1", Email: "ann@test.dev", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities, err := s.UserIdentity.ByUserID(user.ID)
+	if err != nil || len(identities) != 1 {
+		t.Fatalf("Expected one identity, received %v, %v", identities, err)
+	}
+
+	if err := s.UserIdentity.Link(user.ID+1, ExternalIdentity{Provider: "mock", Subject: "1"}); err != ErrIdentityTaken {
+		t.Errorf("Expected another user's identity to be taken, received %v", err)
+	}
+	if err := s.UserIdentity.Unlink(user.ID+1, identities[0].ID); err != ErrNotFound {
+		t.Errorf("Expected other users not to unlink the identity, received %v", err)
+	}
+	if err := s.UserIdentity.Unlink(user.ID, identities[0].ID); err != ErrLastSignInMethod {
+		t.Errorf("Expected the last sign in method to be kept, received %v", err)
+	}
+
+	user.Password = "password1"
+	if err := s.User.Update(user); err != nil {
+		t.Fatal(err)
+	}
+	if user.NoPassword {
+		t.Error("Expected setting a password to clear NoPassword")
+	}
+	if err := s.UserIdentity.Unlink(user.ID, identities[0].ID); err != nil {
+		t.Errorf("Expected users with a password to unlink their identity, received %v", err)
+	}
+	if _, err := s.User.Authenticate("ann@test.dev", "password1"); err != nil {
+		t.Errorf("Expected the new password to work, received %v", err)
+	}
+}
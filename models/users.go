@@ -1,7 +1,6 @@
 package models
 
 import (
-	"os"
 	"regexp"
 	"strings"
 	"time"
@@ -12,9 +11,6 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var userPwPepper = os.Getenv("USER_PASSWORD_PEPPER")
-var hmacSecretKey = os.Getenv("HMAC_SECRET_KEY")
-
 // User represents the user model stored in our database
 // This is used for user accounts, storing both an email
 // address and a password so users can log in and gain
@@ -27,6 +23,47 @@ type User struct {
 	PasswordHash string `gorm:"not null"`
 	Remember     string `gorm:"-"`
 	RememberHash string `gorm:"not null;unique_index"`
+	// NoPassword is set for users who signed up through an external
+	// provider, until they set a password of their own
+	NoPassword bool
+	// Role is one of RoleUser, RoleModerator or RoleAdmin
+	Role string `gorm:"not null;default:'user'"`
+	// Disabled users can't log in, and any session or token they
+	// still have is ignored
+	Disabled bool
+	// MustResetPassword is set when an admin forces a password
+	// reset, until the user sets a new password
+	MustResetPassword bool
+	// Plan is the name of one of Plans, which limits how much the
+	// user can store
+	Plan string `gorm:"not null;default:'free'"`
+}
+
+// Roles a user can have. Every role can do everything the roles
+// before it can.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists every role from least to most privileged
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// HasRole reports whether the user has role or a more privileged
+// one
+func (u *User) HasRole(role string) bool {
+	rank := roleRank(role)
+	return rank >= 0 && roleRank(u.Role) >= rank
 }
 
 // UserDB is used to interact with the users database.
@@ -45,11 +82,17 @@ type UserDB interface {
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
 	ByRemember(token string) (*User, error)
+	// Search returns up to limit users whose name or email contains
+	// query, ignoring case, most recent first
+	Search(query string, limit int) ([]User, error)
 
 	// Methods for altering users
 	Create(user *User) error
 	Update(user *User) error
 	Delete(id uint) error
+	// Purge removes a user for good, unlike Delete which only
+	// marks them as deleted
+	Purge(id uint) error
 }
 
 // UserService is a set of methods used to manipulate and
@@ -67,17 +110,27 @@ type UserService interface {
 	// provided email address.
 	InitiateReset(email string) (string, error)
 	CompleteReset(token, newPw string) (*User, error)
+	// ForcePasswordReset logs the user out everywhere and requires
+	// them to reset their password before they can log in again.
+	// It returns a reset token to send them.
+	ForcePasswordReset(id uint) (string, error)
 	UserDB
 }
 
 // NewUserService handles DB connection
-func NewUserService(db *gorm.DB) UserService {
-	ug := &userGorm{db}
-	hmac := hash.NewHMAC(hmacSecretKey)
-	uv := newUserValidator(ug, hmac)
+func NewUserService(db *gorm.DB, pepper, hmacKey string) UserService {
+	return NewUserServiceFromDB(&userGorm{db}, &pwResetGorm{db}, pepper, hmacKey)
+}
+
+// NewUserServiceFromDB builds a UserService on top of any
+// UserDB and PwResetDB implementation, wrapping them in the
+// same validation used for our database backed service.
+func NewUserServiceFromDB(udb UserDB, pwrdb PwResetDB, pepper, hmacKey string) UserService {
+	hmac := hash.NewHMAC(hmacKey)
 	return &userService{
-		UserDB:    uv,
-		pwResetDB: newPwResetValidator(&pwResetGorm{db}, hmac),
+		UserDB:    newUserValidator(udb, hmac, pepper),
+		pwResetDB: newPwResetValidator(pwrdb, hmac),
+		pepper:    pepper,
 	}
 }
 
@@ -85,7 +138,8 @@ var _ UserService = &userService{}
 
 type userService struct {
 	UserDB
-	pwResetDB pwResetDB
+	pwResetDB PwResetDB
+	pepper    string
 }
 
 // Authenticate can be used to authenticate a user with the
@@ -103,8 +157,11 @@ func (us *userService) Authenticate(email, password string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	if foundUser.NoPassword {
+		return nil, ErrPasswordNotSet
+	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+userPwPepper))
+	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+us.pepper))
 	if err != nil {
 		switch err {
 		case bcrypt.ErrMismatchedHashAndPassword:
@@ -113,6 +170,14 @@ func (us *userService) Authenticate(email, password string) (*User, error) {
 			return nil, err
 		}
 	}
+	// Only tell people who know the password about the state of the
+	// account
+	if foundUser.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	if foundUser.MustResetPassword {
+		return nil, ErrPasswordResetRequired
+	}
 
 	return foundUser, nil
 }
@@ -122,7 +187,7 @@ func (us *userService) InitiateReset(email string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	pwr := pwReset{
+	pwr := PwReset{
 		UserID: user.ID,
 	}
 	if err := us.pwResetDB.Create(&pwr); err != nil {
@@ -156,6 +221,37 @@ func (us *userService) CompleteReset(token, newPw string) (*User, error) {
 	return user, nil
 }
 
+// Purge removes the user's reset tokens before the user
+func (us *userService) Purge(id uint) error {
+	if err := us.pwResetDB.DeleteByUserID(id); err != nil {
+		return err
+	}
+	return us.UserDB.Purge(id)
+}
+
+func (us *userService) ForcePasswordReset(id uint) (string, error) {
+	user, err := us.ByID(id)
+	if err != nil {
+		return "", err
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return "", err
+	}
+	user.MustResetPassword = true
+	user.Remember = token
+	if err := us.Update(user); err != nil {
+		return "", err
+	}
+	pwr := PwReset{
+		UserID: user.ID,
+	}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		return "", err
+	}
+	return pwr.Token, nil
+}
+
 type userValFunc func(*User) error
 
 func runUserValFuncs(user *User, fns ...userValFunc) error {
@@ -172,10 +268,11 @@ var _ UserDB = &userValidator{}
 // * Validators
 
 // newUserValidator function
-func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
+func newUserValidator(udb UserDB, hmac hash.HMAC, pepper string) *userValidator {
 	return &userValidator{
 		UserDB:     udb,
 		hmac:       hmac,
+		pepper:     pepper,
 		emailRegex: regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
 	}
 }
@@ -183,6 +280,7 @@ func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
 type userValidator struct {
 	UserDB
 	hmac       hash.HMAC
+	pepper     string
 	emailRegex *regexp.Regexp
 }
 
@@ -216,6 +314,11 @@ func (uv *userValidator) Create(user *User) error {
 	// todo the order of function calls matter especially setRemember before hmacRemember. Read & understand Adams
 	err := runUserValFuncs(
 		user,
+		uv.randomPasswordIfNoPassword,
+		uv.defaultRole,
+		uv.roleValid,
+		uv.defaultPlan,
+		uv.planValid,
 		uv.passwordRequired,
 		uv.passwordMinLength,
 		uv.bcryptPassword,
@@ -238,7 +341,11 @@ func (uv *userValidator) Create(user *User) error {
 func (uv *userValidator) Update(user *User) error {
 	err := runUserValFuncs(
 		user,
+		uv.roleValid,
+		uv.defaultPlan,
+		uv.planValid,
 		uv.passwordMinLength,
+		uv.passwordSetClearsFlags,
 		uv.bcryptPassword,
 		uv.passwordHashRequired,
 		uv.rememberMinBytes,
@@ -265,14 +372,25 @@ func (uv *userValidator) Delete(id uint) error {
 	return uv.UserDB.Delete(id)
 }
 
+// Purge validates the ID before removing the user for good
+func (uv *userValidator) Purge(id uint) error {
+	var user User
+	user.ID = id
+	err := runUserValFuncs(&user, uv.idGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return uv.UserDB.Purge(id)
+}
+
 // bcryptPassword will hash a user's password with a
-// predefined pepper (userPwPepper) and bcrypt if the
+// predefined pepper (uv.pepper) and bcrypt if the
 // Password field is not the empty string
 func (uv *userValidator) bcryptPassword(user *User) error {
 	if user.Password == "" {
 		return nil
 	}
-	pwBytes := []byte(user.Password + userPwPepper)
+	pwBytes := []byte(user.Password + uv.pepper)
 	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
 	if err != nil {
 		return err
@@ -282,6 +400,61 @@ func (uv *userValidator) bcryptPassword(user *User) error {
 	return nil
 }
 
+// randomPasswordIfNoPassword gives users created without a
+// password a random one nobody knows, so the password hash is
+// never empty
+func (uv *userValidator) randomPasswordIfNoPassword(user *User) error {
+	if !user.NoPassword || user.Password != "" {
+		return nil
+	}
+	pw, err := rand.String(32)
+	if err != nil {
+		return err
+	}
+	user.Password = pw
+	return nil
+}
+
+// passwordSetClearsFlags marks users who set a password as able
+// to log in with it, which also completes a forced reset
+func (uv *userValidator) passwordSetClearsFlags(user *User) error {
+	if user.Password != "" {
+		user.NoPassword = false
+		user.MustResetPassword = false
+	}
+	return nil
+}
+
+// defaultRole makes new users regular users unless told otherwise
+func (uv *userValidator) defaultRole(user *User) error {
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	return nil
+}
+
+// roleValid checks the role is one we know
+func (uv *userValidator) roleValid(user *User) error {
+	if roleRank(user.Role) < 0 {
+		return ErrRoleInvalid
+	}
+	return nil
+}
+
+// defaultPlan puts users on the free plan unless told otherwise
+func (uv *userValidator) defaultPlan(user *User) error {
+	if user.Plan == "" {
+		user.Plan = PlanFree
+	}
+	return nil
+}
+
+// planValid checks the plan is one we offer
+func (uv *userValidator) planValid(user *User) error {
+	_, err := PlanByName(user.Plan)
+	return err
+}
+
 // hmacRemember to remember our token
 func (uv *userValidator) hmacRemember(user *User) error {
 	if user.Remember == "" {
@@ -461,6 +634,18 @@ func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
 	return &user, nil
 }
 
+// Search looks for users by name or email
+func (ug *userGorm) Search(query string, limit int) ([]User, error) {
+	var users []User
+	like := "%" + strings.ToLower(query) + "%"
+	err := ug.db.Where("LOWER(name) LIKE ? OR LOWER(email) LIKE ?", like, like).
+		Order("id desc").Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // Create will create the provided user and backfill data
 // like the ID, CreatedAt, and UpdatedAt fields.
 func (ug *userGorm) Create(user *User) error {
@@ -479,6 +664,12 @@ func (ug *userGorm) Delete(id uint) error {
 	return ug.db.Delete(&user).Error
 }
 
+// Purge deletes the user row, including soft deleted ones
+func (ug *userGorm) Purge(id uint) error {
+	user := User{Model: gorm.Model{ID: id}}
+	return ug.db.Unscoped().Delete(&user).Error
+}
+
 // first will query using the provided gorm.DB and it will
 // get the first item returned and place it into dst. If
 // nothing is found in the query, it will return ErrNotFound
@@ -1,42 +1,19 @@
 package models
 
 import (
-	"fmt"
 	"testing"
 	"time"
 )
 
-func testingUserService() (*UserService, error) {
-	const (
-		host     = "localhost"
-		port     = 5432
-		user     = ""
-		password = ""
-		dbname   = "gophotos_test"
-	)
-
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	us, err := NewUserService("postgres", psqlInfo)
-	if err != nil {
-		return nil, err
-	}
-	us.db.LogMode(false)
-	// clear the users table between tests
-	us.DestructiveReset()
-	return us, nil
-}
-
 // TestCreateUser function to test user creation
 func TestCreateUser(t *testing.T) {
-	us, err := testingUserService()
-	if err != nil {
-		t.Fatal(err)
-	}
+	us := testingServices(t).User
 	user := User{
-		Name:  "Gary Oldman",
-		Email: "gary@test.dev",
+		Name:     "Gary Oldman",
+		Email:    "gary@test.dev",
+		Password: "secret-password",
 	}
-	err = us.Create(&user)
+	err := us.Create(&user)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -50,3 +27,103 @@ func TestCreateUser(t *testing.T) {
 		t.Errorf("Expected UpdatedAt to be recent, Received %s", user.UpdatedAt)
 	}
 }
+
+// TestAuthenticate checks both a correct and an incorrect password
+func TestAuthenticate(t *testing.T) {
+	us := testingServices(t).User
+	user := User{
+		Name:     "Gary Oldman",
+		Email:    "gary@test.dev",
+		Password: "secret-password",
+	}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	found, err := us.Authenticate("Gary@Test.dev ", "secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != user.ID {
+		t.Errorf("Expected user %d, received %d", user.ID, found.ID)
+	}
+	if _, err := us.Authenticate("gary@test.dev", "wrong-password"); err != ErrPasswordIncorrect {
+		t.Errorf("Expected ErrPasswordIncorrect, received %v", err)
+	}
+	if _, err := us.Authenticate("nobody@test.dev", "secret-password"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, received %v", err)
+	}
+}
+
+// TestUserRoles checks new users default to the user role and that
+// unknown roles are rejected
+func TestUserRoles(t *testing.T) {
+	us := testingServices(t).User
+	user := User{
+		Name:     "Gary Oldman",
+		Email:    "gary@test.dev",
+		Password: "secret-password",
+	}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != RoleUser || user.HasRole(RoleModerator) {
+		t.Errorf("Expected a plain user, received role %q", user.Role)
+	}
+	user.Role = "superuser"
+	if err := us.Update(&user); err != ErrRoleInvalid {
+		t.Errorf("Expected ErrRoleInvalid, received %v", err)
+	}
+	user.Role = RoleAdmin
+	if err := us.Update(&user); err != nil {
+		t.Fatal(err)
+	}
+	if !user.HasRole(RoleModerator) || !user.HasRole(RoleAdmin) {
+		t.Errorf("Expected admins to have every role")
+	}
+	found, err := us.Search("OLDMAN", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Role != RoleAdmin {
+		t.Errorf("Expected to find the admin, received %+v", found)
+	}
+}
+
+// TestAuthenticateBlockedUsers checks disabled users and users who
+// have to reset their password can't log in
+func TestAuthenticateBlockedUsers(t *testing.T) {
+	us := testingServices(t).User
+	user := User{
+		Name:     "Gary Oldman",
+		Email:    "gary@test.dev",
+		Password: "secret-password",
+	}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	user.Disabled = true
+	if err := us.Update(&user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate("gary@test.dev", "secret-password"); err != ErrAccountDisabled {
+		t.Errorf("Expected ErrAccountDisabled, received %v", err)
+	}
+	user.Disabled = false
+	if err := us.Update(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := us.ForcePasswordReset(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate("gary@test.dev", "secret-password"); err != ErrPasswordResetRequired {
+		t.Errorf("Expected ErrPasswordResetRequired, received %v", err)
+	}
+	if _, err := us.CompleteReset(token, "brand-new-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate("gary@test.dev", "brand-new-password"); err != nil {
+		t.Errorf("Expected the reset to clear the requirement, received %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+package oidc
+
+import "context"
+
+// Verify exposes ID token verification to the tests
+func Verify(p *Provider, idToken, nonce string) (*Claims, error) {
+	return p.verify(context.Background(), idToken, nonce)
+}
@@ -0,0 +1,84 @@
+package oidc_test
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/oidc"
+	"github.com/sajicode/go-photo/oidc/oidctest"
+)
+
+func TestProviderExchange(t *testing.T) {
+	iss := oidctest.NewIssuer("shutters", "shutters-secret")
+	defer iss.Close()
+	p := oidc.NewProvider(oidc.Config{
+		Name:         "mock",
+		Issuer:       iss.URL + "/",
+		ClientID:     "shutters",
+		ClientSecret: "shutters-secret",
+		RedirectURL:  "http://localhost:3000/auth/mock/callback",
+	}, nil)
+	ctx := context.Background()
+	verifier := strings.Repeat("v", 43)
+
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := iss.Login(authURL, oidctest.User{Subject: "123", Email: "gary@test.dev", EmailVerified: true, Name: "Gary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(callback)
+	if u.Query().Get("state") != "state" {
+		t.Errorf("Expected the state to be sent back, received %s", callback)
+	}
+
+	if _, err := p.Exchange(ctx, u.Query().Get("code"), verifier, "other-nonce"); err != oidc.ErrInvalidIDToken {
+		t.Errorf("Expected a nonce mismatch to be rejected, received %v", err)
+	}
+
+	callback, _ = iss.Login(authURL, oidctest.User{Subject: "123", Email: "gary@test.dev", EmailVerified: true, Name: "Gary"})
+	u, _ = url.Parse(callback)
+	if _, err := p.Exchange(ctx, u.Query().Get("code"), strings.Repeat("x", 43), "nonce"); err == nil {
+		t.Errorf("Expected a wrong PKCE verifier to be rejected")
+	}
+
+	callback, _ = iss.Login(authURL, oidctest.User{Subject: "123", Email: "gary@test.dev", EmailVerified: true, Name: "Gary"})
+	u, _ = url.Parse(callback)
+	claims, err := p.Exchange(ctx, u.Query().Get("code"), verifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "123" || claims.Email != "gary@test.dev" || !claims.EmailVerified || claims.Name != "Gary" {
+		t.Errorf("Expected Gary's claims, received %+v", claims)
+	}
+}
+
+func TestProviderRejectsForeignTokens(t *testing.T) {
+	iss := oidctest.NewIssuer("shutters", "shutters-secret")
+	defer iss.Close()
+	other := oidctest.NewIssuer("shutters", "shutters-secret")
+	defer other.Close()
+	p := oidc.NewProvider(oidc.Config{
+		Name:         "mock",
+		Issuer:       iss.URL,
+		ClientID:     "shutters",
+		ClientSecret: "shutters-secret",
+	}, nil)
+
+	for name, token := range map[string]string{
+		"other audience": iss.IDToken("someone-else", "nonce", oidctest.User{Subject: "1"}),
+		"other issuer":   other.IDToken("shutters", "nonce", oidctest.User{Subject: "1"}),
+		"tampered":       iss.IDToken("shutters", "nonce", oidctest.User{Subject: "1"}) + "x",
+	} {
+		if _, err := oidc.Verify(p, token, "nonce"); err == nil {
+			t.Errorf("Expected a token with %s to be rejected", name)
+		}
+	}
+	if _, err := oidc.Verify(p, iss.IDToken("shutters", "nonce", oidctest.User{Subject: "1"}), "nonce"); err != nil {
+		t.Errorf("Expected a valid token to be accepted, received %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+func TestAccountDeletion(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	user, err := app.users.ByEmail("gary@test.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := models.APIToken{UserID: user.ID, Name: "cli", Scope: models.ScopeRead}
+	if err := app.tokens.Create(&token); err != nil {
+		t.Fatal(err)
+	}
+	refresh := models.OAuthRefreshToken{OAuthClientID: 1, UserID: user.ID, Scope: models.ScopeRead, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := app.oauth.CreateRefreshToken(&refresh); err != nil {
+		t.Fatal(err)
+	}
+
+	res := c.postForm("/settings/account", "/settings/delete", url.Values{"password": {"wrong-password"}})
+	if body := expectStatus(t, res, 200); !strings.Contains(body, "Incorrect password provided") {
+		t.Errorf("Expected an incorrect password alert, received %s", body)
+	}
+	if _, err := app.deletions.ByUserID(user.ID); err != models.ErrNotFound {
+		t.Fatalf("Expected no deletion without the right password, received %v", err)
+	}
+
+	res = c.postForm("/settings/account", "/settings/delete", url.Values{"password": {"secret-password"}})
+	expectRedirect(t, res, "/")
+	if _, err := app.deletions.ByUserID(user.ID); err != nil {
+		t.Fatalf("Expected the deletion to be scheduled, received %v", err)
+	}
+	expectRedirect(t, c.get("/galleries"), "/login")
+	// tokens stop working too, rather than until the purge
+	if _, err := app.tokens.Authenticate(token.Token); err != models.ErrTokenInvalid {
+		t.Errorf("Expected the API token to be revoked, received %v", err)
+	}
+	if tokens, _ := app.oauth.RefreshTokensByUserID(user.ID); len(tokens) != 0 {
+		t.Errorf("Expected the app authorizations to be revoked, received %+v", tokens)
+	}
+
+	// logging back in reminds users their account is going away
+	c = app.client(t)
+	res = c.postForm("/login", "/login", url.Values{
+		"email":    {"gary@test.dev"},
+		"password": {"secret-password"},
+	})
+	expectRedirect(t, res, "/settings/account")
+	if body := readBody(t, c.get("/settings/account")); !strings.Contains(body, "Keep my account") {
+		t.Errorf("Expected the account page to offer to cancel the deletion, received %s", body)
+	}
+
+	res = c.postForm("/settings/account", "/settings/delete/cancel", url.Values{})
+	expectRedirect(t, res, "/settings/account")
+	if _, err := app.deletions.ByUserID(user.ID); err != models.ErrNotFound {
+		t.Errorf("Expected the deletion to be cancelled, received %v", err)
+	}
+	expectStatus(t, c.postForm("/settings/account", "/settings/delete/cancel", url.Values{}), 404)
+
+	events, err := app.audit.Find(models.AuditFilter{UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range events {
+		if e.Action == models.AuditDeletionScheduled || e.Action == models.AuditDeletionCancelled {
+			actions = append(actions, e.Action)
+		}
+	}
+	if len(actions) != 2 || actions[0] != models.AuditDeletionCancelled || actions[1] != models.AuditDeletionScheduled {
+		t.Errorf("Expected the deletion to be scheduled then cancelled, received %v", actions)
+	}
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/oidc/oidctest"
+)
+
+// promote gives the user with email role
+func (app *testApp) promote(t *testing.T, email, role string) *models.User {
+	t.Helper()
+	user, err := app.users.ByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Role = role
+	if err := app.users.Update(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestAdminRequiresRole(t *testing.T) {
+	app := newTestApp(t)
+	expectRedirect(t, app.client(t).get("/admin/users"), "/login")
+
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	expectStatus(t, c.get("/admin/users"), http.StatusForbidden)
+
+	app.promote(t, "gary@test.dev", models.RoleModerator)
+	body := expectStatus(t, c.get("/admin/users?q=OLDMAN"), http.StatusOK)
+	if !strings.Contains(body, "gary@test.dev") {
+		t.Errorf("Expected the search to find gary, received %s", body)
+	}
+	jon := app.signup(t, "Jon Snow", "jon@test.dev")
+	user, _ := app.users.ByEmail("jon@test.dev")
+	path := fmt.Sprintf("/admin/users/%d", user.ID)
+	expectStatus(t, c.postForm(path, path+"/disable", url.Values{}), http.StatusForbidden)
+	expectStatus(t, jon.get("/galleries"), http.StatusOK)
+}
+
+func TestAdminDisableUser(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.signup(t, "Gary Oldman", "gary@test.dev")
+	app.promote(t, "gary@test.dev", models.RoleAdmin)
+	c := app.signup(t, "Jon Snow", "jon@test.dev")
+	user, _ := app.users.ByEmail("jon@test.dev")
+	path := fmt.Sprintf("/admin/users/%d", user.ID)
+
+	expectRedirect(t, admin.postForm(path, path+"/disable", url.Values{}), path)
+	expectRedirect(t, c.get("/galleries"), "/login")
+	res := c.postForm("/login", "/login", url.Values{
+		"email":    {"jon@test.dev"},
+		"password": {"secret-password"},
+	})
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, "This account has been disabled") {
+		t.Errorf("Expected a disabled alert, received %s", body)
+	}
+
+	expectRedirect(t, admin.postForm(path, path+"/enable", url.Values{}), path)
+	res = c.postForm("/login", "/login", url.Values{
+		"email":    {"jon@test.dev"},
+		"password": {"secret-password"},
+	})
+	expectRedirect(t, res, "/galleries")
+
+	self, _ := app.users.ByEmail("gary@test.dev")
+	selfPath := fmt.Sprintf("/admin/users/%d", self.ID)
+	body := expectStatus(t, admin.postForm(selfPath, selfPath+"/disable", url.Values{}), http.StatusOK)
+	if !strings.Contains(body, "You can&#39;t do that to your own account") {
+		t.Errorf("Expected admins to be kept from disabling themselves, received %s", body)
+	}
+
+	for _, action := range []string{models.AuditUserDisabled, models.AuditUserEnabled} {
+		events, err := app.audit.Find(models.AuditFilter{UserID: user.ID, Action: action})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].ActorID != self.ID {
+			t.Errorf("Expected one %s event by the admin, received %+v", action, events)
+		}
+	}
+}
+
+func TestAdminForcePasswordReset(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.signup(t, "Gary Oldman", "gary@test.dev")
+	app.promote(t, "gary@test.dev", models.RoleAdmin)
+	c := app.signup(t, "Jon Snow", "jon@test.dev")
+	user, _ := app.users.ByEmail("jon@test.dev")
+	path := fmt.Sprintf("/admin/users/%d", user.ID)
+
+	expectRedirect(t, admin.postForm(path, path+"/reset", url.Values{}), path)
+	if sent := app.mail.last(); sent.to != "jon@test.dev" {
+		t.Errorf("Expected a reset email to jon, received %+v", sent)
+	}
+	expectRedirect(t, c.get("/galleries"), "/login")
+	res := c.postForm("/login", "/login", url.Values{
+		"email":    {"jon@test.dev"},
+		"password": {"secret-password"},
+	})
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, "You need to reset your password") {
+		t.Errorf("Expected a reset required alert, received %s", body)
+	}
+}
+
+func TestAdminImpersonation(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.signup(t, "Gary Oldman", "gary@test.dev")
+	app.promote(t, "gary@test.dev", models.RoleAdmin)
+	jon := app.signup(t, "Jon Snow", "jon@test.dev")
+	app.createGallery(t, jon, "Winterfell")
+	user, _ := app.users.ByEmail("jon@test.dev")
+	path := fmt.Sprintf("/admin/users/%d", user.ID)
+
+	expectRedirect(t, admin.postForm(path, path+"/impersonate", url.Values{}), "/galleries")
+	body := expectStatus(t, admin.get("/galleries"), http.StatusOK)
+	if !strings.Contains(body, "Winterfell") || !strings.Contains(body, "you are acting as Jon Snow") {
+		t.Errorf("Expected jon's galleries and the impersonation banner, received %s", body)
+	}
+	expectStatus(t, admin.get("/admin/users"), http.StatusForbidden)
+
+	expectRedirect(t, admin.postForm("/galleries", "/admin/impersonate/stop", url.Values{}), path)
+	if body := expectStatus(t, admin.get("/galleries"), http.StatusOK); strings.Contains(body, "Winterfell") {
+		t.Errorf("Expected the admin to act as themselves again, received %s", body)
+	}
+
+	self, _ := app.users.ByEmail("gary@test.dev")
+	for _, action := range []string{models.AuditImpersonationStarted, models.AuditImpersonationFinished} {
+		events, _ := app.audit.Find(models.AuditFilter{UserID: user.ID, Action: action})
+		if len(events) != 1 || events[0].ActorID != self.ID || events[0].ImpersonatorID != 0 {
+			t.Errorf("Expected one %s event by the admin, received %+v", action, events)
+		}
+	}
+}
+
+// TestAdminImpersonationCantGrantAccess checks an admin acting as a
+// user can't create API tokens or authorize apps for them
+func TestAdminImpersonationCantGrantAccess(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.signup(t, "Gary Oldman", "gary@test.dev")
+	app.promote(t, "gary@test.dev", models.RoleAdmin)
+	clientID := admin.registerApp("Darkroom")
+	app.signup(t, "Jon Snow", "jon@test.dev")
+	user, _ := app.users.ByEmail("jon@test.dev")
+	path := fmt.Sprintf("/admin/users/%d", user.ID)
+	expectRedirect(t, admin.postForm(path, path+"/impersonate", url.Values{}), "/galleries")
+
+	body := expectStatus(t, admin.postForm("/settings/tokens", "/settings/tokens", url.Values{
+		"name":  {"cli"},
+		"scope": {models.ScopeRead},
+	}), http.StatusOK)
+	if !strings.Contains(body, "can&#39;t be created while acting as a user") {
+		t.Errorf("Expected the token to be refused, received %s", body)
+	}
+	if tokens, _ := app.tokens.ByUserID(user.ID); len(tokens) != 0 {
+		t.Errorf("Expected no token to be created, received %+v", tokens)
+	}
+
+	authorize := "/oauth/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {strings.Repeat("a", 43)},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+	form, _ := url.ParseQuery(strings.SplitN(authorize, "?", 2)[1])
+	form.Set("decision", "approve")
+	expectStatus(t, admin.postForm(authorize, "/oauth/authorize", form), http.StatusForbidden)
+
+	self, _ := app.users.ByEmail("gary@test.dev")
+	events, _ := app.audit.Find(models.AuditFilter{UserID: user.ID, Action: models.AuditImpersonationBlocked})
+	if len(events) != 2 {
+		t.Fatalf("Expected both attempts to be audited, received %+v", events)
+	}
+	for _, e := range events {
+		if e.ActorID != user.ID || e.ImpersonatorID != self.ID {
+			t.Errorf("Expected the attempt to name the admin, received %+v", e)
+		}
+	}
+}
+
+// TestAdminImpersonationCantTakeOverAccount checks an admin acting
+// as a user can't set their password, connect or disconnect sign in
+// providers, or delete the account
+func TestAdminImpersonationCantTakeOverAccount(t *testing.T) {
+	app := newTestApp(t)
+	iss := withMockProvider(t, app)
+	admin := app.signup(t, "Gary Oldman", "gary@test.dev")
+	app.promote(t, "gary@test.dev", models.RoleAdmin)
+	ann := oidctest.User{Subject: "ann-1", Email: "ann@test.dev", EmailVerified: true, Name: "Ann"}
+	expectRedirect(t, oidcLogin(t, app.client(t), iss, ann), "/galleries")
+	user, _ := app.users.ByEmail("ann@test.dev")
+	identities, _ := app.identities.ByUserID(user.ID)
+	if len(identities) != 1 {
+		t.Fatalf("Expected one connected account, received %+v", identities)
+	}
+	path := fmt.Sprintf("/admin/users/%d", user.ID)
+	expectRedirect(t, admin.postForm(path, path+"/impersonate", url.Values{}), "/galleries")
+
+	refused := "can&#39;t be changed while acting as a user"
+	body := expectStatus(t, admin.postForm("/settings/account", "/settings/password", url.Values{"password": {"admin-password"}}), http.StatusOK)
+	if !strings.Contains(body, refused) {
+		t.Errorf("Expected setting the password to be refused, received %s", body)
+	}
+	if user, _ := app.users.ByEmail("ann@test.dev"); !user.NoPassword {
+		t.Error("Expected the user to still have no password")
+	}
+	unlink := fmt.Sprintf("/settings/identities/%d/delete", identities[0].ID)
+	if body := expectStatus(t, admin.postForm("/settings/account", unlink, url.Values{}), http.StatusOK); !strings.Contains(body, refused) {
+		t.Errorf("Expected disconnecting to be refused, received %s", body)
+	}
+	if body := expectStatus(t, admin.postForm("/settings/account", "/settings/delete", url.Values{}), http.StatusOK); !strings.Contains(body, refused) {
+		t.Errorf("Expected deleting the account to be refused, received %s", body)
+	}
+	if _, err := app.deletions.ByUserID(user.ID); err != models.ErrNotFound {
+		t.Errorf("Expected no deletion to be scheduled, received %v", err)
+	}
+	res := oidcLogin(t, admin, iss, oidctest.User{Subject: "gary-1", Email: "gary@test.dev"})
+	expectRedirect(t, res, "/settings/account")
+	if identities, _ := app.identities.ByUserID(user.ID); len(identities) != 1 {
+		t.Errorf("Expected the admin's account not to be connected, received %+v", identities)
+	}
+
+	events, _ := app.audit.Find(models.AuditFilter{UserID: user.ID, Action: models.AuditImpersonationBlocked})
+	if len(events) != 4 {
+		t.Errorf("Expected every attempt to be audited, received %+v", events)
+	}
+}
+
+func TestAdminDeleteContent(t *testing.T) {
+	app := newTestApp(t)
+	mod := app.signup(t, "Gary Oldman", "gary@test.dev")
+	app.promote(t, "gary@test.dev", models.RoleModerator)
+	jon := app.signup(t, "Jon Snow", "jon@test.dev")
+	gallery := app.createGallery(t, jon, "Winterfell")
+	if err := app.images.Create(gallery.ID, strings.NewReader("abuse"), "abuse.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := app.users.ByEmail("jon@test.dev")
+	userPath := fmt.Sprintf("/admin/users/%d", user.ID)
+	path := fmt.Sprintf("/admin/galleries/%d", gallery.ID)
+
+	body := expectStatus(t, mod.get(userPath), http.StatusOK)
+	if !strings.Contains(body, "Winterfell") || !strings.Contains(body, "abuse.jpg") {
+		t.Errorf("Expected the user's galleries and images, received %s", body)
+	}
+	expectRedirect(t, mod.postForm(userPath, path+"/images/abuse.jpg/delete", url.Values{}), userPath)
+	if images, _ := app.images.ByGalleryID(gallery.ID); len(images) != 0 {
+		t.Errorf("Expected the image to be deleted, received %+v", images)
+	}
+	// the owner can't restore what moderators remove
+	if trashed, _ := app.images.DeletedByGalleryID(gallery.ID); len(trashed) != 0 {
+		t.Errorf("Expected the image to skip the trash, received %+v", trashed)
+	}
+	expectRedirect(t, mod.postForm(userPath, path+"/delete", url.Values{}), userPath)
+	if _, err := app.galleries.ByID(gallery.ID); err != models.ErrNotFound {
+		t.Errorf("Expected the gallery to be deleted, received %v", err)
+	}
+	if trashed, _ := app.galleries.DeletedByUserID(user.ID); len(trashed) != 0 {
+		t.Errorf("Expected the gallery to skip the trash, received %+v", trashed)
+	}
+}
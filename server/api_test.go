@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/controllers"
+	"github.com/sajicode/go-photo/middleware"
+	"github.com/sajicode/go-photo/unpack"
+)
+
+// doJSON sends an API request, adding the csrf token for anything
+// but a GET.
+func (c *testClient) doJSON(method, path, contentType string, body io.Reader) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if method != http.MethodGet {
+		req.Header.Set("X-CSRF-Token", c.csrfToken("/galleries/new"))
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res
+}
+
+type apiResponse struct {
+	Data       json.RawMessage          `json:"data"`
+	Pagination controllers.Pagination   `json:"pagination"`
+	Error      controllers.APIErrorBody `json:"error"`
+}
+
+func decodeAPI(t *testing.T, res *http.Response, status int) apiResponse {
+	t.Helper()
+	body := expectStatus(t, res, status)
+	var ret apiResponse
+	if err := json.Unmarshal([]byte(body), &ret); err != nil {
+		t.Fatalf("Expected a JSON body, received %s", body)
+	}
+	return ret
+}
+
+func TestAPIRequiresUser(t *testing.T) {
+	app := newTestApp(t)
+	res := app.client(t).doJSON(http.MethodGet, "/api/v1/galleries", "", nil)
+	if got := decodeAPI(t, res, http.StatusUnauthorized); got.Error.Status != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 error body, received %+v", got.Error)
+	}
+}
+
+func TestAPIGalleries(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+
+	var created controllers.APIGallery
+	for _, title := range []string{"Wedding", "Holiday", "Birthday"} {
+		res := c.doJSON(http.MethodPost, "/api/v1/galleries", "application/json", strings.NewReader(`{"title":"`+title+`"}`))
+		json.Unmarshal(decodeAPI(t, res, http.StatusCreated).Data, &created)
+		if created.ID == 0 || created.Title != title {
+			t.Fatalf("Expected the created gallery, received %+v", created)
+		}
+	}
+
+	got := decodeAPI(t, c.doJSON(http.MethodGet, "/api/v1/galleries?sort=title&per_page=2", "", nil), http.StatusOK)
+	var page []controllers.APIGallery
+	json.Unmarshal(got.Data, &page)
+	if len(page) != 2 || page[0].Title != "Birthday" || page[1].Title != "Holiday" || got.Pagination.Total != 3 || got.Pagination.NextCursor == "" {
+		t.Fatalf("Expected the first two galleries by title, received %+v %+v", page, got.Pagination)
+	}
+	cursor := got.Pagination.NextCursor
+	got = decodeAPI(t, c.doJSON(http.MethodGet, "/api/v1/galleries?sort=title&per_page=2&cursor="+cursor, "", nil), http.StatusOK)
+	json.Unmarshal(got.Data, &page)
+	if len(page) != 1 || page[0].Title != "Wedding" || got.Pagination.NextCursor != "" {
+		t.Errorf("Expected the second page to hold the last gallery, received %+v %+v", page, got.Pagination)
+	}
+	// a cursor only works with the sort it came from
+	res := c.doJSON(http.MethodGet, "/api/v1/galleries?sort=images&cursor="+cursor, "", nil)
+	decodeAPI(t, res, http.StatusUnprocessableEntity)
+	got = decodeAPI(t, c.doJSON(http.MethodGet, "/api/v1/galleries?title=DAY", "", nil), http.StatusOK)
+	json.Unmarshal(got.Data, &page)
+	if len(page) != 2 || page[0].Title != "Birthday" || page[1].Title != "Holiday" {
+		t.Errorf("Expected the newest galleries with day in their title, received %+v", page)
+	}
+
+	path := fmt.Sprintf("/api/v1/galleries/%d", created.ID)
+	res = c.doJSON(http.MethodPatch, path, "application/json", strings.NewReader(`{"title":""}`))
+	if got := decodeAPI(t, res, http.StatusUnprocessableEntity); got.Error.Message != "Gallery title is required" {
+		t.Errorf("Expected the public model error, received %+v", got.Error)
+	}
+	res = c.doJSON(http.MethodPatch, path, "application/json", strings.NewReader(`not json`))
+	decodeAPI(t, res, http.StatusBadRequest)
+	res = c.doJSON(http.MethodPatch, path, "application/json", strings.NewReader(`{"title":"Party"}`))
+	var updated controllers.APIGallery
+	json.Unmarshal(decodeAPI(t, res, http.StatusOK).Data, &updated)
+	if updated.Title != "Party" {
+		t.Errorf("Expected the updated title, received %+v", updated)
+	}
+	res = c.doJSON(http.MethodPatch, path, "application/json", strings.NewReader(`{"title":"Party","description":"*fun*","event_date":"2019-06-01","location":"Lagos","tags":["Wedding"," lagos"]}`))
+	json.Unmarshal(decodeAPI(t, res, http.StatusOK).Data, &updated)
+	if updated.Description != "*fun*" || updated.EventDate == nil || *updated.EventDate != "2019-06-01" || updated.Location != "Lagos" || strings.Join(updated.Tags, ",") != "wedding,lagos" {
+		t.Errorf("Expected the updated details, received %+v", updated)
+	}
+	// fields that aren't sent are left alone
+	res = c.doJSON(http.MethodPatch, path, "application/json", strings.NewReader(`{"title":"Party!"}`))
+	json.Unmarshal(decodeAPI(t, res, http.StatusOK).Data, &updated)
+	if updated.Title != "Party!" || updated.Location != "Lagos" || updated.EventDate == nil || len(updated.Tags) != 2 {
+		t.Errorf("Expected only the title to change, received %+v", updated)
+	}
+	res = c.doJSON(http.MethodPatch, path, "application/json", strings.NewReader(`{"title":"Party","cover":"missing.jpg"}`))
+	decodeAPI(t, res, http.StatusUnprocessableEntity)
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	decodeAPI(t, other.doJSON(http.MethodGet, path, "", nil), http.StatusNotFound)
+	decodeAPI(t, other.doJSON(http.MethodDelete, path, "", nil), http.StatusNotFound)
+
+	res = c.doJSON(http.MethodDelete, path, "", nil)
+	expectStatus(t, res, http.StatusNoContent)
+	if got := decodeAPI(t, c.doJSON(http.MethodGet, path, "", nil), http.StatusNotFound); got.Error.Message != "Resource not found" {
+		t.Errorf("Expected a not found error, received %+v", got.Error)
+	}
+}
+
+func TestAPIImages(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	path := fmt.Sprintf("/api/v1/galleries/%d/images", gallery.ID)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("images", "cake.png")
+	fw.Write([]byte("cake"))
+	mw.Close()
+	var uploaded []controllers.APIImage
+	json.Unmarshal(decodeAPI(t, c.doJSON(http.MethodPost, path, mw.FormDataContentType(), &buf), http.StatusCreated).Data, &uploaded)
+	if len(uploaded) != 1 || uploaded[0].URL != fmt.Sprintf("/images/galleries/%d/cake.png", gallery.ID) {
+		t.Errorf("Expected the uploaded image, received %+v", uploaded)
+	}
+
+	got := decodeAPI(t, c.doJSON(http.MethodGet, path, "", nil), http.StatusOK)
+	if got.Pagination.Total != 1 {
+		t.Errorf("Expected 1 image, received %+v", got.Pagination)
+	}
+
+	expectStatus(t, c.doJSON(http.MethodDelete, path+"/cake.png", "", nil), http.StatusNoContent)
+	decodeAPI(t, c.doJSON(http.MethodDelete, path+"/cake.png", "", nil), http.StatusNotFound)
+}
+
+func TestAPIImageUploadLimit(t *testing.T) {
+	limits := unpack.DefaultLimits
+	unpack.DefaultLimits.MaxTotalSize = 512
+	t.Cleanup(func() { unpack.DefaultLimits = limits })
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("images", "cake.png")
+	fw.Write(bytes.Repeat([]byte("cake"), 256))
+	mw.Close()
+	path := fmt.Sprintf("/api/v1/galleries/%d/images", gallery.ID)
+	decodeAPI(t, c.doJSON(http.MethodPost, path, mw.FormDataContentType(), &buf), http.StatusBadRequest)
+	if images, _ := app.images.ByGalleryID(gallery.ID); len(images) != 0 {
+		t.Errorf("Expected nothing to be stored, received %+v", images)
+	}
+}
+
+// TestOpenAPIMatchesRoutes makes sure every API route is
+// documented and every documented operation has a route.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	b, err := ioutil.ReadFile("controllers/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	documented := map[string]bool{}
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	r := mux.NewRouter()
+	registerAPI(r.PathPrefix("/api/v1").Subrouter(), middleware.RequireAPIUser{}, controllers.NewAPIGalleries(nil, nil, nil))
+	patterns := regexp.MustCompile(`\{(\w+):[^}]+\}`)
+	routed := map[string]bool{}
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path := patterns.ReplaceAllString(strings.TrimPrefix(tpl, "/api/v1"), "{$1}")
+		if path == "/openapi.json" {
+			return nil
+		}
+		for _, m := range methods {
+			routed[m+" "+path] = true
+		}
+		return nil
+	})
+
+	for op := range routed {
+		if !documented[op] {
+			t.Errorf("%s is routed but not documented in openapi.json", op)
+		}
+	}
+	for op := range documented {
+		if !routed[op] {
+			t.Errorf("%s is documented in openapi.json but not routed", op)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var createdTokenRegex = regexp.MustCompile(`readonly value="([^"]+)"`)
+
+// createToken creates an API token through the settings page and
+// returns the raw token that is only shown once.
+func (c *testClient) createToken(name, scope string) string {
+	c.t.Helper()
+	res := c.postForm("/settings/tokens", "/settings/tokens", url.Values{
+		"name":       {name},
+		"scope":      {scope},
+		"expires_in": {"30"},
+	})
+	body := expectStatus(c.t, res, http.StatusOK)
+	m := createdTokenRegex.FindStringSubmatch(body)
+	if m == nil {
+		c.t.Fatalf("Expected the new token to be displayed, received %s", body)
+	}
+	return m[1]
+}
+
+// bearer sends an API request authenticated only by token, without
+// any cookies or csrf token.
+func bearer(t *testing.T, base, token, method, path string, body io.Reader) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, base+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	readOnly := c.createToken("backup script", "read")
+	readWrite := c.createToken("mobile app", "read-write")
+
+	res := bearer(t, app.srv.URL, readWrite, http.MethodPost, "/api/v1/galleries", strings.NewReader(`{"title":"Wedding"}`))
+	decodeAPI(t, res, http.StatusCreated)
+
+	res = bearer(t, app.srv.URL, readOnly, http.MethodGet, "/api/v1/galleries", nil)
+	if got := decodeAPI(t, res, http.StatusOK); got.Pagination.Total != 1 {
+		t.Errorf("Expected the read token to list 1 gallery, received %+v", got.Pagination)
+	}
+	res = bearer(t, app.srv.URL, readOnly, http.MethodPost, "/api/v1/galleries", strings.NewReader(`{"title":"Holiday"}`))
+	decodeAPI(t, res, http.StatusForbidden)
+
+	body := expectStatus(t, c.get("/settings/tokens"), http.StatusOK)
+	if strings.Contains(body, readOnly) || strings.Contains(body, readWrite) {
+		t.Errorf("Expected raw tokens to only be displayed once")
+	}
+
+	user, _ := app.users.ByEmail("gary@test.dev")
+	tokens, err := app.tokens.ByUserID(user.ID)
+	if err != nil || len(tokens) != 2 {
+		t.Fatalf("Expected 2 tokens, received %v, %v", tokens, err)
+	}
+	for _, token := range tokens {
+		if token.LastUsedAt == nil {
+			t.Errorf("Expected %s to have been used", token.Name)
+		}
+	}
+}
+
+func TestAPITokenRevoke(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	token := c.createToken("backup script", "read")
+
+	res := bearer(t, app.srv.URL, "not-a-real-token", http.MethodGet, "/api/v1/galleries", nil)
+	decodeAPI(t, res, http.StatusUnauthorized)
+
+	user, _ := app.users.ByEmail("gary@test.dev")
+	tokens, _ := app.tokens.ByUserID(user.ID)
+	path := fmt.Sprintf("/settings/tokens/%d/revoke", tokens[0].ID)
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	expectStatus(t, other.postForm("/settings/tokens", path, url.Values{}), http.StatusNotFound)
+	decodeAPI(t, bearer(t, app.srv.URL, token, http.MethodGet, "/api/v1/galleries", nil), http.StatusOK)
+
+	expectRedirect(t, c.postForm("/settings/tokens", path, url.Values{}), "/settings/tokens")
+	res = bearer(t, app.srv.URL, token, http.MethodGet, "/api/v1/galleries", nil)
+	decodeAPI(t, res, http.StatusUnauthorized)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+func TestSecurityActivity(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	res := app.client(t).postForm("/login", "/login", url.Values{
+		"email":    {"gary@test.dev"},
+		"password": {"wrong-password"},
+	})
+	expectStatus(t, res, http.StatusOK)
+
+	body := expectStatus(t, c.get("/settings/activity"), http.StatusOK)
+	for _, want := range []string{"Signed up", "Failed to log in", "127.0.0.1"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the activity page to include %q, received %s", want, body)
+		}
+	}
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	if body := expectStatus(t, other.get("/settings/activity"), http.StatusOK); strings.Contains(body, "Failed to log in") {
+		t.Errorf("Expected jon not to see gary's activity, received %s", body)
+	}
+}
+
+func TestAuditContentDeletes(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	if err := app.images.Create(gallery.ID, strings.NewReader("cake"), "cake.png"); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+	expectRedirect(t, c.postForm(path+"/edit", path+"/images/cake.png/delete", url.Values{}), path+"/edit")
+	expectRedirect(t, c.postForm(path+"/delete", path+"/delete", url.Values{"confirm": {"true"}}), "/galleries")
+
+	events, err := app.audit.Find(models.AuditFilter{Action: models.AuditImageDeleted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].TargetID != fmt.Sprintf("%d/cake.png", gallery.ID) || !strings.Contains(events[0].Details, `"filename":"cake.png"`) {
+		t.Errorf("Expected the deleted file to be recorded, received %+v", events)
+	}
+	events, _ = app.audit.Find(models.AuditFilter{Action: models.AuditGalleryDeleted})
+	if len(events) != 1 || events[0].UserID != gallery.UserID || !strings.Contains(events[0].Details, "Wedding") {
+		t.Errorf("Expected the deleted gallery to be recorded, received %+v", events)
+	}
+}
+
+func TestAdminAuditLog(t *testing.T) {
+	app := newTestApp(t)
+	admin := app.signup(t, "Gary Oldman", "gary@test.dev")
+	app.promote(t, "gary@test.dev", models.RoleModerator)
+	expectStatus(t, admin.get("/admin/audit"), http.StatusForbidden)
+	app.promote(t, "gary@test.dev", models.RoleAdmin)
+	app.signup(t, "Jon Snow", "jon@test.dev")
+	app.client(t).postForm("/login", "/login", url.Values{
+		"email":    {"jon@test.dev"},
+		"password": {"wrong-password"},
+	}).Body.Close()
+
+	body := expectStatus(t, admin.get("/admin/audit?user=jon@test.dev&action=user.login_failed"), http.StatusOK)
+	if !strings.Contains(body, "user.login_failed") || strings.Contains(body, "user.signup</td>") {
+		t.Errorf("Expected only jon's failed login, received %s", body)
+	}
+	body = expectStatus(t, admin.get("/admin/audit?since=yesterday"), http.StatusOK)
+	if !strings.Contains(body, "yesterday isn&#39;t a valid date") {
+		t.Errorf("Expected an invalid date alert, received %s", body)
+	}
+
+	res := admin.get("/admin/audit.csv?user=jon@test.dev")
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Expected a CSV, received %s", ct)
+	}
+	rows, err := csv.NewReader(strings.NewReader(expectStatus(t, res, http.StatusOK))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][2] != "action" || rows[1][2] != models.AuditLoginFailed || rows[2][2] != models.AuditSignup {
+		t.Errorf("Expected a header and jon's two events, received %v", rows)
+	}
+	expectStatus(t, admin.get("/admin/audit.csv?user=nobody@test.dev"), http.StatusBadRequest)
+
+	// what clients send can't be run as a formula by a spreadsheet
+	jon, err := app.users.ByEmail("jon@test.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.audit.Create(&models.AuditEvent{
+		Action:    models.AuditLogout,
+		UserID:    jon.ID,
+		TargetID:  "-1",
+		IP:        "10.0.0.1",
+		UserAgent: `=HYPERLINK("http://evil.test","update")`,
+	})
+	res = admin.get("/admin/audit.csv?user=jon@test.dev&action=user.logout")
+	rows, err = csv.NewReader(strings.NewReader(expectStatus(t, res, http.StatusOK))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][7] != "'-1" || rows[1][8] != "10.0.0.1" || rows[1][9] != `'=HYPERLINK("http://evil.test","update")` {
+		t.Errorf("Expected formulas to be escaped, received %v", rows)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// createCollection creates a collection as the client's user and
+// returns it
+func (app *testApp) createCollection(t *testing.T, c *testClient, title string, parent uint) *models.Collection {
+	t.Helper()
+	res := c.postForm("/collections/new", "/collections", url.Values{
+		"title":  {title},
+		"parent": {fmt.Sprint(parent)},
+	})
+	user, err := app.users.ByEmail(c.email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collections, err := app.collections.ByUserID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, collection := range collections {
+		if collection.Title == title {
+			expectRedirect(t, res, fmt.Sprintf("/collections/%d/edit", collection.ID))
+			return &collection
+		}
+	}
+	t.Fatalf("Expected a collection titled %q, received %s", title, readBody(t, res))
+	return nil
+}
+
+func TestCollections(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Reception")
+	app.images.Create(gallery.ID, strings.NewReader("cake"), "cake.png")
+	family := app.createCollection(t, c, "Family", 0)
+	weddings := app.createCollection(t, c, "Weddings", family.ID)
+	familyPath := fmt.Sprintf("/collections/%d", family.ID)
+	weddingsPath := fmt.Sprintf("/collections/%d", weddings.ID)
+	galleryPath := fmt.Sprintf("/galleries/%d", gallery.ID)
+
+	res := c.postForm("/galleries", galleryPath+"/collection", url.Values{
+		"collection": {fmt.Sprint(weddings.ID)},
+		"next":       {weddingsPath + "/edit"},
+	})
+	expectRedirect(t, res, weddingsPath+"/edit")
+	body := expectStatus(t, c.get(weddingsPath+"/edit"), http.StatusOK)
+	if !strings.Contains(body, "Moved Reception to Weddings") || !strings.Contains(body, "cake.png") {
+		t.Errorf("Expected the gallery in the collection, received %s", body)
+	}
+	body = expectStatus(t, c.get("/galleries"), http.StatusOK)
+	if !strings.Contains(body, "Family") || strings.Contains(body, "Reception") {
+		t.Errorf("Expected only the top level collection on the galleries page, received %s", body)
+	}
+	body = expectStatus(t, c.get(galleryPath), http.StatusOK)
+	if !strings.Contains(body, `<a href="`+familyPath+`">Family</a>`) || !strings.Contains(body, `<a href="`+weddingsPath+`">Weddings</a>`) {
+		t.Errorf("Expected breadcrumbs on the gallery page, received %s", body)
+	}
+
+	// collections are private until they are made public, and
+	// collections and galleries in them are only visible if they
+	// are public too
+	visitor := app.client(t)
+	expectStatus(t, visitor.get(familyPath), http.StatusNotFound)
+	res = c.postForm(familyPath+"/edit", familyPath+"/update", url.Values{
+		"title":      {"Family"},
+		"visibility": {"public"},
+		"sort":       {"title"},
+	})
+	expectRedirect(t, res, familyPath+"/edit")
+	if body := expectStatus(t, visitor.get(familyPath), http.StatusOK); strings.Contains(body, "Weddings") {
+		t.Errorf("Expected the private collection to be hidden, received %s", body)
+	}
+	expectStatus(t, visitor.get(weddingsPath), http.StatusNotFound)
+	expectStatus(t, visitor.get(galleryPath), http.StatusNotFound)
+
+	res = c.postForm(familyPath+"/edit", familyPath+"/update", url.Values{
+		"title":      {"Family"},
+		"parent":     {fmt.Sprint(weddings.ID)},
+		"visibility": {"public"},
+		"sort":       {"title"},
+	})
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, html.EscapeString("can't be moved into itself")) {
+		t.Errorf("Expected an error moving a collection into its own collection, received %s", body)
+	}
+
+	// the gallery and the collection both have to be the user's
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	theirs := app.createCollection(t, other, "Mine", 0)
+	expectStatus(t, other.postForm("/galleries", galleryPath+"/collection", url.Values{
+		"collection": {fmt.Sprint(theirs.ID)},
+	}), http.StatusNotFound)
+	expectStatus(t, c.postForm("/galleries", galleryPath+"/collection", url.Values{
+		"collection": {fmt.Sprint(theirs.ID)},
+	}), http.StatusNotFound)
+	expectStatus(t, other.get(weddingsPath+"/edit"), http.StatusNotFound)
+	expectStatus(t, other.postForm("/galleries", weddingsPath+"/delete", url.Values{}), http.StatusNotFound)
+
+	// deleting a collection moves what is in it up a level
+	expectRedirect(t, c.postForm(weddingsPath+"/edit", weddingsPath+"/delete", url.Values{}), familyPath+"/edit")
+	found, _ := app.galleries.ByID(gallery.ID)
+	if found.CollectionID != family.ID {
+		t.Errorf("Expected the gallery to move up to Family, received %d", found.CollectionID)
+	}
+	if _, err := app.collections.ByID(weddings.ID); err != models.ErrNotFound {
+		t.Errorf("Expected the collection to be deleted, received %v", err)
+	}
+}
+
+func TestCollectionOrder(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	var ids []string
+	for _, title := range []string{"Alpha", "Bravo", "Charlie"} {
+		ids = append(ids, fmt.Sprint(app.createCollection(t, c, title, 0).ID))
+	}
+	res := c.postForm("/galleries", "/collections/order", url.Values{
+		"parent": {"0"},
+		"ids":    {ids[2], ids[0], ids[1]},
+	})
+	expectRedirect(t, res, "/galleries")
+	body := expectStatus(t, c.get("/galleries"), http.StatusOK)
+	if !(strings.Index(body, "Charlie") < strings.Index(body, "Alpha") && strings.Index(body, "Alpha") < strings.Index(body, "Bravo")) {
+		t.Errorf("Expected the collections in their new order, received %s", body)
+	}
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	res = other.postForm("/galleries", "/collections/order", url.Values{
+		"parent": {ids[0]},
+		"ids":    {},
+	})
+	expectStatus(t, res, http.StatusNotFound)
+}
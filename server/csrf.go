@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/securecookie"
+	"github.com/sajicode/go-photo/context"
+)
+
+// csrfCookieName is the gorilla/csrf default, which we need to
+// know to find out which key signed a request's cookie.
+const csrfCookieName = "_gorilla_csrf"
+
+// csrfMaxAge matches the gorilla/csrf default of 12 hours. Old
+// keys can be dropped once they were rotated out this long ago.
+const csrfMaxAge = 12 * 60 * 60
+
+// newCSRF returns middleware protecting against csrf using the
+// first key for new visitors. Visitors whose csrf cookie was
+// signed by one of the older keys keep being checked against
+// that key, so rotating keys doesn't break forms that are already
+// open. Requests authenticated with an API token and OAuth token
+// requests are skipped, so the user middleware has to run first.
+func newCSRF(keys [][]byte, secure bool, failure http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		protectors := make([]http.Handler, len(keys))
+		cookies := make([]*securecookie.SecureCookie, len(keys))
+		for i, key := range keys {
+			protectors[i] = csrf.Protect(key,
+				csrf.Secure(secure),
+				csrf.MaxAge(csrfMaxAge),
+				csrf.ErrorHandler(failure),
+			)(next)
+			cookies[i] = securecookie.New(key, nil)
+			cookies[i].SetSerializer(securecookie.JSONEncoder{})
+			cookies[i].MaxAge(csrfMaxAge)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// API tokens are sent in a header browsers never add on
+			// their own, so those requests can't be forged. The token
+			// endpoint authenticates the app instead of a user.
+			if context.APIToken(r.Context()) != nil || r.URL.Path == oauthTokenPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+			protectors[signingKey(r, cookies)].ServeHTTP(w, r)
+		})
+	}
+}
+
+// signingKey returns the index of the key that signed the csrf
+// cookie, defaulting to the current key.
+func signingKey(r *http.Request, cookies []*securecookie.SecureCookie) int {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil {
+		return 0
+	}
+	for i, sc := range cookies {
+		var token []byte
+		if sc.Decode(csrfCookieName, cookie.Value, &token) == nil {
+			return i
+		}
+	}
+	return 0
+}
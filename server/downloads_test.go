@@ -0,0 +1,199 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/imaging"
+)
+
+// readZip returns the contents of every file in a ZIP response,
+// keyed by name
+func readZip(t *testing.T, res *http.Response) map[string][]byte {
+	t.Helper()
+	body := expectStatus(t, res, http.StatusOK)
+	if ct := res.Header.Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("Expected a ZIP archive, received %s", ct)
+	}
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = ioutil.ReadAll(r)
+		r.Close()
+	}
+	return files
+}
+
+func TestGalleryDownload(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	var big bytes.Buffer
+	if err := jpeg.Encode(&big, image.NewGray(image.Rect(0, 0, imaging.WebMaxSize*2, 10)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// images are kept in upload order, which decides which of the
+	// clashing names gets numbered
+	for _, upload := range []struct {
+		name string
+		b    []byte
+	}{
+		{"Cake.jpg", []byte("cake")},
+		{"cake.JPG", []byte("other cake")},
+		{"dance.jpg", big.Bytes()},
+	} {
+		if err := app.images.Create(gallery.ID, bytes.NewReader(upload.b), upload.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := fmt.Sprintf("/galleries/%d/download", gallery.ID)
+
+	// anyone who can see the gallery can download it
+	res := app.client(t).get(path)
+	if cd := res.Header.Get("Content-Disposition"); cd != `attachment; filename="Wedding.zip"` {
+		t.Errorf("Expected the archive to be named after the gallery, received %s", cd)
+	}
+	files := readZip(t, res)
+	if len(files) != 3 || !bytes.Equal(files["dance.jpg"], big.Bytes()) {
+		t.Errorf("Expected every original, received %d files", len(files))
+	}
+	if _, ok := files["cake (2).JPG"]; !ok {
+		t.Errorf("Expected names differing by case to be numbered, received %v", files)
+	}
+
+	files = readZip(t, c.get(path+"?files=dance.jpg&size=web"))
+	if len(files) != 1 {
+		t.Fatalf("Expected only the selected image, received %d files", len(files))
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(files["dance.jpg"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != imaging.WebMaxSize {
+		t.Errorf("Expected a web sized image, received %dx%d", cfg.Width, cfg.Height)
+	}
+
+	expectStatus(t, c.get(path+"?files=missing.jpg"), http.StatusNotFound)
+	expectStatus(t, c.get(path+"?size=huge"), http.StatusBadRequest)
+	expectStatus(t, c.get("/galleries/999/download"), http.StatusNotFound)
+}
+
+func TestGalleryDownloadAccess(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	if err := app.images.Create(gallery.ID, strings.NewReader("cake"), "cake.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+	visitor := app.client(t)
+
+	// private galleries are hidden from everyone but their owner
+	res := c.postForm(path+"/edit", path+"/sharing", url.Values{"visibility": {"private"}})
+	expectRedirect(t, res, path+"/edit")
+	expectStatus(t, visitor.get(path), http.StatusNotFound)
+	expectStatus(t, visitor.get(path+"/download"), http.StatusNotFound)
+	readZip(t, c.get(path+"/download"))
+
+	// the share link shows the gallery, but only allows downloads
+	// if the owner says so
+	res = c.postForm(path+"/edit", path+"/sharing", url.Values{"visibility": {"private"}, "shared": {"true"}})
+	expectRedirect(t, res, path+"/edit")
+	shared, _ := app.galleries.ByID(gallery.ID)
+	if shared.ShareToken == "" {
+		t.Fatal("Expected the gallery to have a share token")
+	}
+	share := "?share=" + url.QueryEscape(shared.ShareToken)
+	if body := expectStatus(t, visitor.get(path+share), http.StatusOK); strings.Contains(body, "download-form") {
+		t.Errorf("Expected no download form without download permission, received %s", body)
+	}
+	expectStatus(t, visitor.get(path+"/download"+share), http.StatusForbidden)
+	expectStatus(t, visitor.get(path+"/download?share=wrong"), http.StatusNotFound)
+
+	res = c.postForm(path+"/edit", path+"/sharing", url.Values{"visibility": {"private"}, "shared": {"true"}, "download": {"true"}})
+	expectRedirect(t, res, path+"/edit")
+	if body := expectStatus(t, visitor.get(path+share), http.StatusOK); !strings.Contains(body, `name="share"`) {
+		t.Errorf("Expected the download form to keep the share token, received %s", body)
+	}
+	if files := readZip(t, visitor.get(path+"/download"+share+"&files=cake.jpg")); string(files["cake.jpg"]) != "cake" {
+		t.Errorf("Expected the image, received %v", files)
+	}
+
+	// a new link stops the old one working
+	res = c.postForm(path+"/edit", path+"/sharing", url.Values{"visibility": {"private"}, "shared": {"true"}, "new_link": {"true"}})
+	expectRedirect(t, res, path+"/edit")
+	expectStatus(t, visitor.get(path+share), http.StatusNotFound)
+
+	// public galleries in a private collection are hidden too
+	family := app.createCollection(t, c, "Family", 0)
+	expectRedirect(t, c.postForm("/galleries", path+"/collection", url.Values{"collection": {fmt.Sprint(family.ID)}}), "/galleries")
+	res = c.postForm(path+"/edit", path+"/sharing", url.Values{"visibility": {"public"}})
+	expectRedirect(t, res, path+"/edit")
+	expectStatus(t, visitor.get(path+"/download"), http.StatusNotFound)
+	body := expectStatus(t, c.postForm(path+"/edit", path+"/sharing", url.Values{"visibility": {"hidden"}}), http.StatusOK)
+	if !strings.Contains(body, "Visibility must be public or private") {
+		t.Errorf("Expected an unknown visibility to be refused, received %s", body)
+	}
+}
+
+// slowWriter takes its time over every write, like a client on a
+// slow connection
+type slowWriter struct {
+	http.ResponseWriter
+}
+
+func (sw slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(20 * time.Millisecond)
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw slowWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func TestGalleryDownloadOutlastsWriteTimeout(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	for i := 0; i < 10; i++ {
+		// big enough for the ZIP to be written in many pieces
+		name := fmt.Sprintf("%d.jpg", i)
+		if err := app.images.Create(gallery.ID, strings.NewReader(strings.Repeat(name, 4096)), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := app.srv.Config.Handler
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(slowWriter{w}, r)
+	}))
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	// the download takes longer than the write timeout, which would
+	// cut it off if the deadline weren't pushed back
+	res, err := http.Get(srv.URL + fmt.Sprintf("/galleries/%d/download", gallery.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := readZip(t, res); len(files) != 10 {
+		t.Errorf("Expected every image, received %d files", len(files))
+	}
+}
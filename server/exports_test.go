@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/email"
+	"github.com/sajicode/go-photo/jobs"
+)
+
+func TestDataExport(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	app.createGallery(t, c, "Holiday")
+
+	expectRedirect(t, c.postForm("/settings/export", "/settings/export", url.Values{}), "/settings/export")
+	if body := readBody(t, c.get("/settings/export")); !strings.Contains(body, "Preparing") {
+		t.Errorf("Expected the export to be listed as pending, received %s", body)
+	}
+
+	exports := &jobs.Exports{
+		Exports:     app.exports,
+		Users:       app.users,
+		Galleries:   app.galleries,
+		Collections: app.collections,
+		Images:      app.images,
+		Emailer:     email.NewClient(email.WithTransport(app.mail)),
+		Dir:         t.TempDir(),
+	}
+	if err := exports.Run(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	text := app.mail.last().text
+	i := strings.Index(text, "/settings/export/download?")
+	if i < 0 {
+		t.Fatalf("Expected a download link, received %s", text)
+	}
+	link := strings.Fields(text[i:])[0]
+
+	// someone else can't use the link
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	expectStatus(t, other.get(link), 404)
+
+	// logging in comes back to the link
+	anon := app.client(t)
+	expectRedirect(t, anon.get(link), "/login?next="+url.QueryEscape(link))
+
+	res := c.get(link)
+	body := expectStatus(t, res, 200)
+	if ct := res.Header.Get("Content-Type"); ct != "application/zip" || !strings.HasPrefix(body, "PK") {
+		t.Errorf("Expected a ZIP archive, received %s", ct)
+	}
+}
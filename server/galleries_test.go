@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+func TestGalleryIndex(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	for _, title := range []string{"Wedding", "Holiday", "Birthday"} {
+		app.createGallery(t, c, title)
+	}
+	family := app.createCollection(t, c, "Family", 0)
+	birthday := app.createGallery(t, c, "Grandma's birthday")
+	path := fmt.Sprintf("/galleries/%d", birthday.ID)
+	res := c.postForm("/galleries", path+"/collection", url.Values{"collection": {fmt.Sprint(family.ID)}})
+	expectRedirect(t, res, "/galleries")
+
+	body := expectStatus(t, c.get("/galleries?sort=title&per_page=2"), http.StatusOK)
+	if !strings.Contains(body, "<h2>Collections</h2>") || !strings.Contains(body, "Birthday") || !strings.Contains(body, "Holiday") || strings.Contains(body, "Wedding") {
+		t.Errorf("Expected the collection and the first page of galleries, received %s", body)
+	}
+	start := strings.Index(body, `<li class="next"><a href="`)
+	if start < 0 {
+		t.Fatalf("Expected a link to the next page, received %s", body)
+	}
+	next := body[start+len(`<li class="next"><a href="`):]
+	next = html.UnescapeString(next[:strings.Index(next, `"`)])
+	body = expectStatus(t, c.get(next), http.StatusOK)
+	if !strings.Contains(body, "Wedding") || strings.Contains(body, "Holiday") || strings.Contains(body, "<h2>Collections</h2>") || strings.Contains(body, `class="next"`) {
+		t.Errorf("Expected just the last gallery, received %s", body)
+	}
+
+	// filtering looks in collections too
+	body = expectStatus(t, c.get("/galleries?title=birthday"), http.StatusOK)
+	if !strings.Contains(body, html.EscapeString("Grandma's birthday")) || !strings.Contains(body, "2 found") || strings.Contains(body, "Wedding") {
+		t.Errorf("Expected both birthdays, received %s", body)
+	}
+	if body := expectStatus(t, c.get("/galleries?sort=size"), http.StatusOK); !strings.Contains(body, "Galleries can be sorted by created, updated, title or images") {
+		t.Errorf("Expected an error for the sort, received %s", body)
+	}
+	if body := expectStatus(t, c.get("/galleries?sort=images&cursor=nope"), http.StatusOK); !strings.Contains(body, "That page link is no longer valid") {
+		t.Errorf("Expected an error for the cursor, received %s", body)
+	}
+}
+
+func TestImageSelection(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	wedding := app.createGallery(t, c, "Wedding")
+	holiday := app.createGallery(t, c, "Holiday")
+	for _, name := range []string{"cake.png", "dance.png", "sea.png"} {
+		app.images.Create(wedding.ID, strings.NewReader(name), name)
+	}
+	path := fmt.Sprintf("/galleries/%d", wedding.ID)
+	selection := func(action string, gallery uint, filenames ...string) *http.Response {
+		return c.postForm(path+"/edit", path+"/images/selection", url.Values{
+			"action":    {action},
+			"gallery":   {fmt.Sprint(gallery)},
+			"filenames": filenames,
+		})
+	}
+
+	body := expectStatus(t, c.get(path+"/edit"), http.StatusOK)
+	if !strings.Contains(body, fmt.Sprintf(`<option value="%d">Holiday</option>`, holiday.ID)) {
+		t.Errorf("Expected the other gallery to be a target, received %s", body)
+	}
+	expectRedirect(t, selection("cover", 0, "dance.png"), path+"/edit")
+	if g, _ := app.galleries.ByID(wedding.ID); g.CoverFilename != "dance.png" {
+		t.Errorf("Expected dance.png to be the cover, received %q", g.CoverFilename)
+	}
+	expectRedirect(t, selection("move", holiday.ID, "dance.png", "sea.png"), path+"/edit")
+	if body := expectStatus(t, c.get(path+"/edit"), http.StatusOK); !strings.Contains(body, "Moved 2 images to Holiday") {
+		t.Errorf("Expected a success message, received %s", body)
+	}
+	if g, _ := app.galleries.ByID(wedding.ID); g.CoverFilename != "" {
+		t.Errorf("Expected the cover to be dropped once moved, received %q", g.CoverFilename)
+	}
+	expectRedirect(t, selection("copy", holiday.ID, "cake.png"), path+"/edit")
+	images, _ := app.images.ByGalleryID(holiday.ID)
+	if len(images) != 3 || images[0].Filename != "dance.png" || images[2].Filename != "cake.png" {
+		t.Errorf("Expected the moved and copied images, received %+v", images)
+	}
+
+	for _, tc := range []struct {
+		name string
+		res  *http.Response
+		want string
+	}{
+		{"cover of two", selection("cover", 0, "cake.png", "sea.png"), "Pick a single image to use as the cover"},
+		{"name taken", selection("copy", holiday.ID, "cake.png"), "The other gallery already has an image with the same name"},
+		{"nothing selected", selection("delete", 0), "Select at least one image first"},
+	} {
+		if body := expectStatus(t, tc.res, http.StatusOK); !strings.Contains(body, tc.want) {
+			t.Errorf("%s: expected %q, received %s", tc.name, tc.want, body)
+		}
+	}
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	theirs := app.createGallery(t, other, "Winterfell")
+	if body := expectStatus(t, selection("move", theirs.ID, "cake.png"), http.StatusOK); !strings.Contains(body, "Resource not found") {
+		t.Errorf("Expected someone else's gallery to be out of reach, received %s", body)
+	}
+	expectRedirect(t, selection("delete", 0, "cake.png"), path+"/edit")
+	if images, _ := app.images.ByGalleryID(wedding.ID); len(images) != 0 {
+		t.Errorf("Expected the gallery to be empty, received %+v", images)
+	}
+}
+
+// TestGalleryImageAccess checks the images of a private gallery are
+// only served to those who can see the gallery
+func TestGalleryImageAccess(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	if err := app.images.Create(gallery.ID, strings.NewReader("cake"), "cake.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+	image := fmt.Sprintf("/images/galleries/%d/cake.jpg", gallery.ID)
+	visitor := app.client(t)
+	expectStatus(t, visitor.get(image), http.StatusOK)
+
+	res := c.postForm(path+"/edit", path+"/sharing", url.Values{"visibility": {"private"}, "shared": {"true"}})
+	expectRedirect(t, res, path+"/edit")
+	expectStatus(t, visitor.get(image), http.StatusNotFound)
+	if body := expectStatus(t, c.get(image), http.StatusOK); body != "cake" {
+		t.Errorf("Expected the owner to see the image, received %q", body)
+	}
+	mod := app.signup(t, "Jon Snow", "jon@test.dev")
+	app.promote(t, "jon@test.dev", models.RoleModerator)
+	expectStatus(t, mod.get(image), http.StatusOK)
+
+	// the share link is kept on the images of the gallery
+	shared, _ := app.galleries.ByID(gallery.ID)
+	share := "?share=" + url.QueryEscape(shared.ShareToken)
+	if body := expectStatus(t, visitor.get(path+share), http.StatusOK); !strings.Contains(body, image+"?share=") {
+		t.Errorf("Expected the image URLs to carry the share token, received %s", body)
+	}
+	expectStatus(t, visitor.get(image+share), http.StatusOK)
+	expectStatus(t, visitor.get(image+"?share=wrong"), http.StatusNotFound)
+}
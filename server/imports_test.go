@@ -0,0 +1,138 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/controllers"
+	"github.com/sajicode/go-photo/models"
+)
+
+// archiveForm returns a multipart body with a ZIP of files in its
+// archive field
+func archiveForm(t *testing.T, files ...string) (string, string) {
+	t.Helper()
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for _, name := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("data for " + name))
+	}
+	zw.Close()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("archive", "photos.zip")
+	fw.Write(zbuf.Bytes())
+	mw.Close()
+	return mw.FormDataContentType(), buf.String()
+}
+
+func TestImportArchive(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	app.images.Create(gallery.ID, strings.NewReader("old cake"), "cake.png")
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+
+	contentType, body := archiveForm(t,
+		"shoot/cake.png",
+		"shoot/first dance.JPG",
+		"../../etc/evil.jpg",
+		"notes.txt",
+		"__MACOSX/shoot/._cake.png",
+	)
+	res := c.post(path+"/edit", path+"/archive", contentType, strings.NewReader(body))
+	page := expectStatus(t, res, http.StatusOK)
+	if !strings.Contains(page, "Imported 2 of 5 files") {
+		t.Errorf("Expected a summary, received %s", page)
+	}
+
+	images, _ := app.images.ByGalleryID(gallery.ID)
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Filename)
+	}
+	if strings.Join(names, ",") != "cake.png,cake (2).png,first dance.JPG" {
+		t.Errorf("Expected the images to be added after cake.png, received %v", names)
+	}
+	b, _ := app.images.Bytes(&models.Image{GalleryID: gallery.ID, Filename: "cake.png"})
+	if string(b) != "old cake" {
+		t.Errorf("Expected the existing image to be left alone, received %q", b)
+	}
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	contentType, body = archiveForm(t, "sneaky.jpg")
+	expectStatus(t, other.post("/galleries/new", path+"/archive", contentType, strings.NewReader(body)), http.StatusNotFound)
+}
+
+// TestImportKeepsTrash checks an image that fails to import doesn't
+// take a trashed image of the same name with it
+func TestImportKeepsTrash(t *testing.T) {
+	plans := models.Plans
+	models.Plans = []models.Plan{
+		{Name: models.PlanFree, MaxBytes: 5},
+		{Name: models.PlanPro},
+	}
+	t.Cleanup(func() { models.Plans = plans })
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	if err := app.images.Create(gallery.ID, strings.NewReader("cake"), "a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.images.Delete(&models.Image{GalleryID: gallery.ID, Filename: "a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+
+	// the new a.jpg is over the quota
+	contentType, body := archiveForm(t, "a.jpg")
+	page := expectStatus(t, c.post(path+"/edit", path+"/archive", contentType, strings.NewReader(body)), http.StatusOK)
+	if !strings.Contains(page, "Imported 0 of 1 files") {
+		t.Errorf("Expected the import to fail, received %s", page)
+	}
+	if images, _ := app.images.ByGalleryID(gallery.ID); len(images) != 0 {
+		t.Errorf("Expected nothing to be imported, received %+v", images)
+	}
+	trashed, _ := app.images.DeletedByGalleryID(gallery.ID)
+	if len(trashed) != 1 || trashed[0].Filename != "a.jpg" {
+		t.Errorf("Expected the trashed image to be kept, received %+v", trashed)
+	}
+}
+
+func TestAPIImportArchive(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	path := fmt.Sprintf("/api/v1/galleries/%d/archive", gallery.ID)
+
+	contentType, body := archiveForm(t, "cake.png", "notes.txt")
+	var summary controllers.ImportSummary
+	json.Unmarshal(decodeAPI(t, c.doJSON(http.MethodPost, path, contentType, strings.NewReader(body)), http.StatusOK).Data, &summary)
+	want := []controllers.ImportResult{
+		{Path: "cake.png", Filename: "cake.png", Status: controllers.ImportImported},
+		{Path: "notes.txt", Status: controllers.ImportSkipped, Reason: models.ErrImageTypeInvalid.Public()},
+	}
+	if summary.Imported != 1 || summary.Skipped != 1 || len(summary.Results) != 2 || summary.Results[0] != want[0] || summary.Results[1] != want[1] {
+		t.Errorf("Expected %+v, received %+v", want, summary)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("archive", "photos.rar")
+	fw.Write([]byte("Rar!"))
+	mw.Close()
+	got := decodeAPI(t, c.doJSON(http.MethodPost, path, mw.FormDataContentType(), &buf), http.StatusUnprocessableEntity)
+	if !strings.Contains(got.Error.Message, "zip, tar") {
+		t.Errorf("Expected an unknown format error, received %+v", got.Error)
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/controllers"
+)
+
+const testRedirectURI = "http://127.0.0.1:9000/callback"
+
+var clientIDRegex = regexp.MustCompile(`client-id" readonly value="([^"]+)"`)
+
+// registerApp registers a public app through the settings page and
+// returns its client ID.
+func (c *testClient) registerApp(name string) string {
+	c.t.Helper()
+	res := c.postForm("/settings/apps", "/settings/apps", url.Values{
+		"name":          {name},
+		"redirect_uris": {testRedirectURI},
+		"public":        {"true"},
+	})
+	body := expectStatus(c.t, res, http.StatusOK)
+	m := clientIDRegex.FindStringSubmatch(body)
+	if m == nil {
+		c.t.Fatalf("Expected the client ID to be displayed, received %s", body)
+	}
+	if strings.Contains(body, "client-secret") {
+		c.t.Errorf("Expected a public app not to get a secret")
+	}
+	return m[1]
+}
+
+// callback parses the redirect back to the app
+func callback(t *testing.T, res *http.Response) url.Values {
+	t.Helper()
+	res.Body.Close()
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusFound || err != nil {
+		t.Fatalf("Expected a redirect, received %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+	if got := loc.Scheme + "://" + loc.Host + loc.Path; got != testRedirectURI {
+		t.Fatalf("Expected a redirect to the app, received %s", loc)
+	}
+	return loc.Query()
+}
+
+// requestToken calls the token endpoint the way an app would, with
+// no cookies or csrf token.
+func requestToken(t *testing.T, base string, params url.Values, status int) controllers.TokenResponse {
+	t.Helper()
+	res, err := http.PostForm(base+oauthTokenPath, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := expectStatus(t, res, status)
+	var ret controllers.TokenResponse
+	if err := json.Unmarshal([]byte(body), &ret); err != nil {
+		t.Fatalf("Expected a JSON body, received %s", body)
+	}
+	return ret
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	app := newTestApp(t)
+	clientID := app.signup(t, "Gary Oldman", "gary@test.dev").registerApp("Darkroom")
+	app.signup(t, "Jon Snow", "jon@test.dev")
+
+	verifier := strings.Repeat("verifier", 6)
+	sum := sha256.Sum256([]byte(verifier))
+	authorize := "/oauth/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+
+	c := app.client(t)
+	expectRedirect(t, c.get(authorize), "/login?next="+url.QueryEscape(authorize))
+	res := c.postForm("/login?next="+url.QueryEscape(authorize), "/login", url.Values{
+		"email":    {"jon@test.dev"},
+		"password": {"secret-password"},
+		"next":     {authorize},
+	})
+	expectRedirect(t, res, authorize)
+	res = c.get(authorize)
+	if res.Header.Get("X-Frame-Options") != "DENY" || res.Header.Get("Content-Security-Policy") != "frame-ancestors 'none'" {
+		t.Errorf("Expected the consent screen not to be framed, received %v", res.Header)
+	}
+	body := expectStatus(t, res, http.StatusOK)
+	if !strings.Contains(body, "Darkroom") || !strings.Contains(body, "See your galleries and images") {
+		t.Errorf("Expected the consent screen, received %s", body)
+	}
+
+	form, _ := url.ParseQuery(strings.SplitN(authorize, "?", 2)[1])
+	form.Set("decision", "deny")
+	if got := callback(t, c.postForm(authorize, "/oauth/authorize", form)); got.Get("error") != "access_denied" || got.Get("state") != "xyz" {
+		t.Errorf("Expected access_denied, received %v", got)
+	}
+	form.Set("decision", "approve")
+	got := callback(t, c.postForm(authorize, "/oauth/authorize", form))
+	if got.Get("code") == "" || got.Get("state") != "xyz" {
+		t.Fatalf("Expected a code, received %v", got)
+	}
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientID},
+		"code":          {got.Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {strings.Repeat("x", 48)},
+	}
+	requestToken(t, app.srv.URL, exchange, http.StatusBadRequest)
+	exchange.Set("code_verifier", verifier)
+	requestToken(t, app.srv.URL, exchange, http.StatusBadRequest)
+
+	res = c.postForm(authorize, "/oauth/authorize", form)
+	exchange.Set("code", callback(t, res).Get("code"))
+	token := requestToken(t, app.srv.URL, exchange, http.StatusOK)
+	if token.AccessToken == "" || token.RefreshToken == "" || token.TokenType != "Bearer" || token.Scope != "read" {
+		t.Fatalf("Expected a read only token pair, received %+v", token)
+	}
+
+	decodeAPI(t, bearer(t, app.srv.URL, token.AccessToken, http.MethodGet, "/api/v1/galleries", nil), http.StatusOK)
+	res = bearer(t, app.srv.URL, token.AccessToken, http.MethodPost, "/api/v1/galleries", strings.NewReader(`{"title":"Mine"}`))
+	decodeAPI(t, res, http.StatusForbidden)
+
+	refresh := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {clientID},
+		"refresh_token": {token.RefreshToken},
+	}
+	refreshed := requestToken(t, app.srv.URL, refresh, http.StatusOK)
+	if refreshed.AccessToken == token.AccessToken || refreshed.RefreshToken == token.RefreshToken {
+		t.Errorf("Expected new tokens, received %+v", refreshed)
+	}
+	requestToken(t, app.srv.URL, refresh, http.StatusBadRequest)
+	decodeAPI(t, bearer(t, app.srv.URL, refreshed.AccessToken, http.MethodGet, "/api/v1/galleries", nil), http.StatusOK)
+
+	if body := expectStatus(t, c.get("/settings/tokens"), http.StatusOK); strings.Contains(body, "Darkroom") {
+		t.Errorf("Expected app tokens not to be listed as personal tokens")
+	}
+
+	// the user can disconnect the app, which revokes its tokens
+	body = expectStatus(t, c.get("/settings/apps"), http.StatusOK)
+	m := regexp.MustCompile(`/settings/apps/authorized/(\d+)/revoke`).FindStringSubmatch(body)
+	if m == nil || !strings.Contains(body, "Darkroom") {
+		t.Fatalf("Expected the app to be listed as authorized, received %s", body)
+	}
+	revoke := "/settings/apps/authorized/" + m[1] + "/revoke"
+	// only the user who authorized it can disconnect it
+	ann := app.signup(t, "Ann", "ann@test.dev")
+	expectStatus(t, ann.postForm("/settings/apps", revoke, url.Values{}), http.StatusNotFound)
+	expectRedirect(t, c.postForm("/settings/apps", revoke, url.Values{}), "/settings/apps")
+	decodeAPI(t, bearer(t, app.srv.URL, refreshed.AccessToken, http.MethodGet, "/api/v1/galleries", nil), http.StatusUnauthorized)
+	refresh.Set("refresh_token", refreshed.RefreshToken)
+	requestToken(t, app.srv.URL, refresh, http.StatusBadRequest)
+	if body := expectStatus(t, c.get("/settings/apps"), http.StatusOK); !strings.Contains(body, "haven't authorized any apps") {
+		t.Errorf("Expected no authorized apps, received %s", body)
+	}
+	expectStatus(t, c.postForm("/settings/apps", revoke, url.Values{}), http.StatusNotFound)
+}
+
+func TestOAuthAuthorizeErrors(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	clientID := c.registerApp("Darkroom")
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {"http://127.0.0.1:9000/elsewhere"},
+	}
+	expectStatus(t, c.get("/oauth/authorize?"+params.Encode()), http.StatusBadRequest)
+
+	params.Set("redirect_uri", testRedirectURI)
+	if got := callback(t, c.get("/oauth/authorize?"+params.Encode())); got.Get("error") != "invalid_request" {
+		t.Errorf("Expected PKCE to be required, received %v", got)
+	}
+	params.Set("code_challenge", "challenge")
+	params.Set("code_challenge_method", "S256")
+	params.Set("scope", "admin")
+	if got := callback(t, c.get("/oauth/authorize?"+params.Encode())); got.Get("error") != "invalid_scope" {
+		t.Errorf("Expected an invalid scope, received %v", got)
+	}
+
+	requestToken(t, app.srv.URL, url.Values{"grant_type": {"password"}, "client_id": {clientID}}, http.StatusBadRequest)
+	requestToken(t, app.srv.URL, url.Values{"grant_type": {"refresh_token"}, "client_id": {"unknown"}}, http.StatusUnauthorized)
+}
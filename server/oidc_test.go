@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/oidc"
+	"github.com/sajicode/go-photo/oidc/oidctest"
+)
+
+// withMockProvider starts a mock issuer and restarts the app with a
+// provider named mock that signs in with it
+func withMockProvider(t *testing.T, app *testApp) *oidctest.Issuer {
+	t.Helper()
+	iss := oidctest.NewIssuer("shutters", "shutters-secret")
+	t.Cleanup(iss.Close)
+	app.providers = []*oidc.Provider{oidc.NewProvider(oidc.Config{
+		Name:         "mock",
+		DisplayName:  "Mock",
+		Issuer:       iss.URL,
+		ClientID:     "shutters",
+		ClientSecret: "shutters-secret",
+		RedirectURL:  "http://shutters.test/auth/mock/callback",
+	}, nil)}
+	app.srv = app.serve(t, testConfig)
+	return iss
+}
+
+// oidcLogin signs in at the mock issuer as user and returns our
+// response to the callback
+func oidcLogin(t *testing.T, c *testClient, iss *oidctest.Issuer, user oidctest.User) *http.Response {
+	t.Helper()
+	res := c.get("/auth/mock")
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("Expected a redirect to the provider, received %d", res.StatusCode)
+	}
+	callback, err := iss.Login(res.Header.Get("Location"), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.get(u.RequestURI())
+}
+
+func TestOIDCSignUpThenSetPassword(t *testing.T) {
+	app := newTestApp(t)
+	iss := withMockProvider(t, app)
+	c := app.client(t)
+	if body := readBody(t, c.get("/login")); !strings.Contains(body, "Sign in with Mock") {
+		t.Error("Expected the login page to offer signing in with Mock")
+	}
+
+	res := oidcLogin(t, c, iss, oidctest.User{Subject: "ann-1", Email: "ann@test.dev", EmailVerified: true, Name: "Ann"})
+	expectRedirect(t, res, "/galleries")
+	user, err := app.users.ByEmail("ann@test.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.NoPassword {
+		t.Error("Expected the new user not to have a password")
+	}
+	body := expectStatus(t, c.get("/settings/account"), http.StatusOK)
+	if !strings.Contains(body, "Set a password") {
+		t.Error("Expected the account page to offer setting a password")
+	}
+	identities, _ := app.identities.ByUserID(user.ID)
+	if len(identities) != 1 {
+		t.Fatalf("Expected one connected account, received %d", len(identities))
+	}
+	unlink := fmt.Sprintf("/settings/identities/%d/delete", identities[0].ID)
+
+	body = expectStatus(t, c.postForm("/settings/account", unlink, url.Values{}), http.StatusOK)
+	if !strings.Contains(body, "Set a password before disconnecting") {
+		t.Error("Expected the last sign in method to be kept")
+	}
+
+	res = c.postForm("/settings/account", "/settings/password", url.Values{"password": {"new-password"}})
+	expectRedirect(t, res, "/settings/account")
+	res = c.postForm("/settings/account", unlink, url.Values{})
+	expectRedirect(t, res, "/settings/account")
+
+	other := app.client(t)
+	res = other.postForm("/login", "/login", url.Values{"email": {"ann@test.dev"}, "password": {"new-password"}})
+	expectRedirect(t, res, "/galleries")
+}
+
+func TestOIDCLinksExistingUsers(t *testing.T) {
+	app := newTestApp(t)
+	iss := withMockProvider(t, app)
+	gary := app.signup(t, "Gary", "gary@test.dev")
+	user, err := app.users.ByEmail("gary@test.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Logged in users connect accounts whatever their email
+	res := oidcLogin(t, gary, iss, oidctest.User{Subject: "gary-work", Email: "gary@work.dev"})
+	expectRedirect(t, res, "/settings/account")
+	if body := readBody(t, gary.get("/settings/account")); !strings.Contains(body, "gary@work.dev") {
+		t.Error("Expected the connected account to be listed")
+	}
+	linked, _ := app.audit.Find(models.AuditFilter{UserID: user.ID, Action: models.AuditIdentityLinked})
+	if len(linked) != 1 || !strings.Contains(linked[0].Details, `"subject":"gary-work"`) {
+		t.Errorf("Expected the connection to be audited, received %+v", linked)
+	}
+
+	// Everyone else is linked by verified email
+	c := app.client(t)
+	res = oidcLogin(t, c, iss, oidctest.User{Subject: "gary-home", Email: "gary@test.dev", EmailVerified: true})
+	expectRedirect(t, res, "/galleries")
+	identities, _ := app.identities.ByUserID(user.ID)
+	if len(identities) != 2 {
+		t.Errorf("Expected two connected accounts, received %d", len(identities))
+	}
+
+	res = oidcLogin(t, app.client(t), iss, oidctest.User{Subject: "someone", Email: "gary@test.dev"})
+	expectRedirect(t, res, "/login")
+
+	// Another user can't take an account that is already connected
+	ann := app.signup(t, "Ann", "ann@test.dev")
+	res = oidcLogin(t, ann, iss, oidctest.User{Subject: "gary-home"})
+	expectRedirect(t, res, "/settings/account")
+	if body := readBody(t, ann.get("/settings/account")); !strings.Contains(body, "already connected to another user") {
+		t.Error("Expected Ann to be told the account is taken")
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	app := newTestApp(t)
+	withMockProvider(t, app)
+	c := app.client(t)
+	expectRedirect(t, c.get("/auth/mock/callback?code=abc&state=forged"), "/login")
+	expectStatus(t, c.get("/auth/unknown"), http.StatusNotFound)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Default timeouts used when they are left unset in the Config.
+// Reads and writes are generous so that large image uploads on
+// slow connections still make it through.
+const (
+	DefaultReadTimeout     = 5 * time.Minute
+	DefaultWriteTimeout    = 5 * time.Minute
+	DefaultIdleTimeout     = 2 * time.Minute
+	DefaultShutdownTimeout = 30 * time.Second
+
+	readHeaderTimeout = 10 * time.Second
+)
+
+// Run serves handler on cfg.Addr until the process receives
+// SIGINT or SIGTERM. It then stops accepting new connections and
+// waits up to cfg.ShutdownTimeout for in-flight requests, such as
+// uploads, to finish before returning.
+func Run(cfg Config, handler http.Handler) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return serve(ctx, newHTTPServer(cfg, handler), shutdownTimeout(cfg))
+}
+
+func newHTTPServer(cfg Config, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	if srv.ReadTimeout == 0 {
+		srv.ReadTimeout = DefaultReadTimeout
+	}
+	if srv.WriteTimeout == 0 {
+		srv.WriteTimeout = DefaultWriteTimeout
+	}
+	if srv.IdleTimeout == 0 {
+		srv.IdleTimeout = DefaultIdleTimeout
+	}
+	return srv
+}
+
+func shutdownTimeout(cfg Config) time.Duration {
+	if cfg.ShutdownTimeout == 0 {
+		return DefaultShutdownTimeout
+	}
+	return cfg.ShutdownTimeout
+}
+
+// serve runs srv until ctx is done and then shuts it down
+// gracefully. Errors from starting the server are returned right
+// away.
+func serve(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
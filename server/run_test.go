@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestServeDrainsInFlightRequests makes sure a request that is
+// still running when we are told to stop gets to finish.
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, newHTTPServer(Config{Addr: addr}, handler), time.Second)
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		var res *http.Response
+		var err error
+		// the server may not be listening yet
+		for i := 0; i < 50; i++ {
+			res, err = http.Get("http://" + addr)
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	cancel()
+	if got := <-body; got != "done" {
+		t.Errorf("Expected the in-flight request to finish, received %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected a clean shutdown, received %v", err)
+	}
+}
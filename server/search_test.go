@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sajicode/go-photo/imaging/imagingtest"
+)
+
+func TestSearch(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Ade & Funke")
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+	res := c.postForm(path+"/edit", path+"/update", url.Values{
+		"title":      {"Ade & Funke"},
+		"event_date": {"2019-06-01"},
+		"tags":       {"Wedding, Lagos"},
+	})
+	expectStatus(t, res, http.StatusOK)
+
+	// keywords are imported when the image is uploaded
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("images", "cake.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(imagingtest.JPEG("Canon EOS 5D", time.Date(2019, 6, 1, 14, 0, 0, 0, time.UTC), "Cake"))
+	mw.Close()
+	res = c.post(path+"/edit", path+"/images", mw.FormDataContentType(), strings.NewReader(buf.String()))
+	expectRedirect(t, res, path+"/edit")
+	app.images.Create(gallery.ID, strings.NewReader("dance"), "dance.png")
+	res = c.postForm(path+"/edit", path+"/images/dance.png/update", url.Values{
+		"caption": {"First dance"},
+		"tags":    {"dance, Lagos"},
+	})
+	expectRedirect(t, res, path+"/edit")
+	images, _ := app.images.ByGalleryID(gallery.ID)
+	if images[0].Tags != "cake" || images[1].Tags != "dance,lagos" {
+		t.Errorf("Expected the images to be tagged, received %+v", images)
+	}
+
+	body := expectStatus(t, c.get("/search?tag=lagos"), http.StatusOK)
+	if !strings.Contains(body, html.EscapeString("Ade & Funke")) || !strings.Contains(body, "dance.png") || !strings.Contains(body, "Images <small>1 found") {
+		t.Errorf("Expected the gallery and image tagged lagos, received %s", body)
+	}
+	body = expectStatus(t, c.get("/search?q=cake"), http.StatusOK)
+	if !strings.Contains(body, "cake.jpg") || !strings.Contains(body, "No galleries match your search.") {
+		t.Errorf("Expected just the image, received %s", body)
+	}
+	// both images are dated 2019, the cake by its camera and the
+	// dance by the gallery's event date
+	body = expectStatus(t, c.get("/search?q=a"), http.StatusOK)
+	cameraURL := "/search?camera=Canon&#43;EOS&#43;5D&amp;q=a"
+	yearURL := "/search?from=2019-01-01&amp;q=a&amp;to=2019-12-31"
+	for _, want := range []string{cameraURL, "Canon EOS 5D <span class=\"badge\">1</span>", yearURL, "2019 <span class=\"badge\">2</span>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the facets to include %s, received %s", want, body)
+		}
+	}
+	body = expectStatus(t, c.get(html.UnescapeString(cameraURL)), http.StatusOK)
+	if !strings.Contains(body, "Images <small>1 found") || strings.Contains(body, "dance.png") {
+		t.Errorf("Expected just the image taken with the camera, received %s", body)
+	}
+
+	if body := expectStatus(t, c.get("/search?from=June"), http.StatusOK); !strings.Contains(body, "Dates must be written as YYYY-MM-DD") {
+		t.Errorf("Expected an error for the date, received %s", body)
+	}
+	if body := expectStatus(t, c.get("/search?from=2020-01-01&to=2019-01-01"), http.StatusOK); !strings.Contains(body, "The start of the date range must be before its end") {
+		t.Errorf("Expected an error for the date range, received %s", body)
+	}
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	if body := expectStatus(t, other.get("/search?tag=lagos"), http.StatusOK); strings.Contains(body, "dance.png") {
+		t.Errorf("Expected someone else's images to be left out, received %s", body)
+	}
+}
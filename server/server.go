@@ -0,0 +1,314 @@
+// Package server wires our controllers and middleware into a
+// single http.Handler and knows how to serve it.
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sajicode/go-photo/audit"
+	"github.com/sajicode/go-photo/controllers"
+	"github.com/sajicode/go-photo/email"
+	"github.com/sajicode/go-photo/middleware"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/oidc"
+	"github.com/sajicode/go-photo/views"
+)
+
+// Config holds the settings used to build and run the server.
+// Any timeout left as zero falls back to its default.
+type Config struct {
+	// Addr is the TCP address to listen on, e.g. ":3000"
+	Addr string
+	// CSRFKeys are the 32 byte keys used to sign csrf tokens. The
+	// first one is used for new tokens, the rest are old keys that
+	// are still accepted.
+	CSRFKeys [][]byte
+	// Secure marks the csrf cookie as https only
+	Secure bool
+	// FlashSecret is used to sign and encrypt the flash alerts we
+	// persist across redirects
+	FlashSecret string
+	// ImpersonationSecret is used to sign the cookie admins use to
+	// act as another user
+	ImpersonationSecret string
+	// AccountDeletionGrace is how long users have to cancel deleting
+	// their account, models.DefaultDeletionGrace when zero
+	AccountDeletionGrace time.Duration
+	// UploadDir is where chunked uploads are stored until they are
+	// complete or expire, UploadExpiry after their last chunk or
+	// models.DefaultUploadExpiry when zero
+	UploadDir    string
+	UploadExpiry time.Duration
+	// TrashRetention is how long deleted galleries and images are
+	// kept, models.DefaultTrashRetention when zero. It is only
+	// shown to users, the jobs.Trash purges them.
+	TrashRetention time.Duration
+
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// Deps are the services the controllers are built on
+type Deps struct {
+	User         models.UserService
+	UserIdentity models.UserIdentityService
+	Gallery      models.GalleryService
+	Collection   models.CollectionService
+	Image        models.ImageService
+	Search       models.SearchService
+	APIToken     models.APITokenService
+	OAuth        models.OAuthService
+	Audit        models.AuditService
+	// AccountDeletion schedules accounts to be purged by the
+	// jobs.AccountPurge job
+	AccountDeletion models.AccountDeletionService
+	// Export requests archives built by the jobs.Exports job
+	Export models.ExportService
+	// Upload tracks chunked uploads, abandoned ones are removed by
+	// the jobs.Uploads job
+	Upload models.UploadService
+	// Usage is how much each user stores. Image should be wrapped
+	// with models.NewQuotaImageService to keep it up to date.
+	Usage   models.UsageService
+	Emailer *email.Client
+	// OIDCProviders are the external providers users can sign in
+	// with
+	OIDCProviders []*oidc.Provider
+}
+
+// oauthTokenPath is where OAuth apps exchange codes for tokens.
+// Apps call it directly rather than through a browser, so it is
+// exempt from csrf checks.
+const oauthTokenPath = "/oauth/token"
+
+// New builds our router with every route registered and wraps it
+// in the csrf and user middleware.
+func New(cfg Config, deps Deps) http.Handler {
+	r := mux.NewRouter()
+	auditLog := audit.New(deps.Audit)
+	staticC := controllers.NewStatic()
+	usersC := controllers.NewUsers(deps.User, deps.AccountDeletion, *deps.Emailer, deps.OIDCProviders, auditLog)
+	oidcC := controllers.NewOIDC(deps.User, deps.UserIdentity, deps.AccountDeletion, deps.OIDCProviders, auditLog)
+	grace := cfg.AccountDeletionGrace
+	if grace == 0 {
+		grace = models.DefaultDeletionGrace
+	}
+	accountC := controllers.NewAccount(deps.User, deps.UserIdentity, deps.AccountDeletion, deps.Gallery, deps.Usage, deps.APIToken, deps.OAuth, grace, deps.OIDCProviders, auditLog)
+	retention := cfg.TrashRetention
+	if retention == 0 {
+		retention = models.DefaultTrashRetention
+	}
+	galleriesC := controllers.NewGalleries(deps.Gallery, deps.Collection, deps.Image, retention, auditLog, r)
+	collectionsC := controllers.NewCollections(deps.Collection, deps.Gallery, deps.Image, r)
+	searchC := controllers.NewSearch(deps.Search, deps.Image)
+	trashC := controllers.NewTrash(deps.Gallery, deps.Collection, deps.Image, retention)
+	uploadExpiry := cfg.UploadExpiry
+	if uploadExpiry == 0 {
+		uploadExpiry = models.DefaultUploadExpiry
+	}
+	uploadsC := controllers.NewUploads(deps.Gallery, deps.Image, deps.Upload, deps.Usage, cfg.UploadDir, uploadExpiry)
+	apiGalleriesC := controllers.NewAPIGalleries(deps.Gallery, deps.Image, auditLog)
+	apiTokensC := controllers.NewAPITokens(deps.APIToken, auditLog)
+	exportsC := controllers.NewExports(deps.Export, auditLog)
+	oauthC := controllers.NewOAuth(deps.OAuth, auditLog)
+	impersonation := middleware.NewImpersonation(cfg.ImpersonationSecret)
+	adminC := controllers.NewAdmin(deps.User, deps.Gallery, deps.Image, deps.Usage, auditLog, *deps.Emailer, impersonation)
+
+	csrfMw := newCSRF(cfg.CSRFKeys, cfg.Secure, http.HandlerFunc(staticC.CSRFFailure))
+	userMw := middleware.User{
+		UserService:   deps.User,
+		APITokens:     deps.APIToken,
+		Impersonation: impersonation,
+		Usage:         deps.Usage,
+	}
+
+	// user middleware
+	requireUserMw := middleware.RequireUser{
+		User: userMw,
+	}
+	requireAPIUserMw := middleware.RequireAPIUser{
+		User: userMw,
+	}
+	requireModeratorMw := middleware.RequireRole{
+		User: userMw,
+		Role: models.RoleModerator,
+	}
+	requireAdminMw := middleware.RequireRole{
+		User: userMw,
+		Role: models.RoleAdmin,
+	}
+
+	r.NotFoundHandler = http.HandlerFunc(notFound)
+	r.Handle("/", staticC.Home).Methods("GET")
+	r.Handle("/contact", staticC.Contact).Methods("GET")
+
+	// User routes
+	r.HandleFunc("/signup", usersC.New).Methods("GET")
+	r.HandleFunc("/signup", usersC.Create).Methods("POST")
+	r.HandleFunc("/cookietest", usersC.CookieTest).Methods("GET")
+	r.HandleFunc("/login", usersC.LoginPage).Methods("GET")
+	r.HandleFunc("/login", usersC.Login).Methods("POST")
+	r.HandleFunc("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST")
+	r.Handle("/forgot", usersC.ForgotPwView).Methods("GET")
+	r.HandleFunc("/forgot", usersC.InitiateReset).Methods("POST")
+	r.HandleFunc("/reset", usersC.ResetPw).Methods("GET")
+	r.HandleFunc("/reset", usersC.CompleteReset).Methods("POST")
+
+	// External sign in routes. The callback also connects accounts
+	// for users who are already logged in.
+	r.HandleFunc("/auth/{provider}", oidcC.Start).Methods("GET")
+	r.HandleFunc("/auth/{provider}/callback", oidcC.Callback).Methods("GET")
+
+	r.HandleFunc("/faq", faq).Methods("GET")
+
+	// Settings routes
+	r.HandleFunc("/settings/account", requireUserMw.ApplyFn(accountC.Show)).Methods("GET")
+	r.HandleFunc("/settings/password", requireUserMw.ApplyFn(accountC.UpdatePassword)).Methods("POST")
+	r.HandleFunc("/settings/identities/{id:[0-9]+}/delete", requireUserMw.ApplyFn(accountC.Unlink)).Methods("POST")
+	r.HandleFunc("/settings/activity", requireUserMw.ApplyFn(accountC.Activity)).Methods("GET")
+	r.HandleFunc("/settings/delete", requireUserMw.ApplyFn(accountC.Delete)).Methods("POST")
+	r.HandleFunc("/settings/delete/cancel", requireUserMw.ApplyFn(accountC.CancelDelete)).Methods("POST")
+	r.HandleFunc("/settings/export", requireUserMw.ApplyFn(exportsC.Index)).Methods("GET")
+	r.HandleFunc("/settings/export", requireUserMw.ApplyFn(exportsC.Create)).Methods("POST")
+	// the download sends users who aren't logged in back to the
+	// link after they log in
+	r.HandleFunc("/settings/export/download", exportsC.Download).Methods("GET")
+	r.HandleFunc("/settings/tokens", requireUserMw.ApplyFn(apiTokensC.Index)).Methods("GET")
+	r.HandleFunc("/settings/tokens", requireUserMw.ApplyFn(apiTokensC.Create)).Methods("POST")
+	r.HandleFunc("/settings/tokens/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(apiTokensC.Revoke)).Methods("POST")
+	r.HandleFunc("/settings/apps", requireUserMw.ApplyFn(oauthC.Apps)).Methods("GET")
+	r.HandleFunc("/settings/apps", requireUserMw.ApplyFn(oauthC.CreateApp)).Methods("POST")
+	r.HandleFunc("/settings/apps/{id:[0-9]+}/delete", requireUserMw.ApplyFn(oauthC.DeleteApp)).Methods("POST")
+	r.HandleFunc("/settings/apps/authorized/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(oauthC.RevokeApp)).Methods("POST")
+
+	// OAuth2 authorization server routes. Authorize sends users that
+	// aren't logged in to the login page itself, so it can bring
+	// them back afterwards.
+	r.HandleFunc("/oauth/authorize", oauthC.Authorize).Methods("GET")
+	r.HandleFunc("/oauth/authorize", requireUserMw.ApplyFn(oauthC.Approve)).Methods("POST")
+	r.HandleFunc(oauthTokenPath, oauthC.Token).Methods("POST")
+
+	// Admin routes. Moderators can find users and remove abusive
+	// content, managing accounts is left to admins.
+	r.Handle("/admin", requireModeratorMw.Apply(http.RedirectHandler("/admin/users", http.StatusFound))).Methods("GET")
+	r.HandleFunc("/admin/users", requireModeratorMw.ApplyFn(adminC.Users)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}", requireModeratorMw.ApplyFn(adminC.User)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/disable", requireAdminMw.ApplyFn(adminC.Disable)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/enable", requireAdminMw.ApplyFn(adminC.Enable)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/role", requireAdminMw.ApplyFn(adminC.SetRole)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/plan", requireAdminMw.ApplyFn(adminC.SetPlan)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/reset", requireAdminMw.ApplyFn(adminC.ForceReset)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/impersonate", requireAdminMw.ApplyFn(adminC.Impersonate)).Methods("POST")
+	r.HandleFunc("/admin/impersonate/stop", requireUserMw.ApplyFn(adminC.StopImpersonating)).Methods("POST")
+	r.HandleFunc("/admin/galleries/{id:[0-9]+}/delete", requireModeratorMw.ApplyFn(adminC.DeleteGallery)).Methods("POST")
+	r.HandleFunc("/admin/galleries/{id:[0-9]+}/images/{filename}/delete", requireModeratorMw.ApplyFn(adminC.DeleteImage)).Methods("POST")
+	r.HandleFunc("/admin/audit", requireAdminMw.ApplyFn(adminC.Audit)).Methods("GET")
+	r.HandleFunc("/admin/audit.csv", requireAdminMw.ApplyFn(adminC.AuditCSV)).Methods("GET")
+
+	// Assets
+	assetHandler := http.FileServer(http.Dir("./assets/"))
+	assetHandler = http.StripPrefix("/assets/", assetHandler)
+	r.PathPrefix("/assets/").Handler(assetHandler)
+
+	// Image routes
+	r.HandleFunc("/images/galleries/{id:[0-9]+}/{filename}", galleriesC.ImageFile).Methods("GET", "HEAD")
+
+	// * named routes are useful for when we want to redirect to a particular route after an action
+	// Gallery routes
+	r.Handle("/galleries", requireUserMw.ApplyFn(galleriesC.Index)).Methods("GET")
+	r.Handle("/galleries/new", requireUserMw.Apply(galleriesC.New)).Methods("GET")
+	r.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Create)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Edit)).Methods("GET").Name(controllers.EditGallery)
+	r.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleriesC.Update)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/sharing", requireUserMw.ApplyFn(galleriesC.Sharing)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.ConfirmDelete)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.Delete)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST")
+	// tus resumable uploads, see controllers.Uploads
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads", uploadsC.Options).Methods("OPTIONS")
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads", requireUserMw.ApplyFn(uploadsC.Create)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads/{upload:[0-9]+}", requireUserMw.ApplyFn(uploadsC.Head)).Methods("HEAD")
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads/{upload:[0-9]+}", requireUserMw.ApplyFn(uploadsC.Patch)).Methods("PATCH")
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads/{upload:[0-9]+}", requireUserMw.ApplyFn(uploadsC.Delete)).Methods("DELETE")
+	r.HandleFunc("/galleries/{id:[0-9]+}/archive", requireUserMw.ApplyFn(galleriesC.ImportArchive)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/order", requireUserMw.ApplyFn(galleriesC.ImageReorder)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/selection", requireUserMw.ApplyFn(galleriesC.ImageSelection)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/update", requireUserMw.ApplyFn(galleriesC.ImageUpdate)).Methods("POST")
+	// POST /galleries/:id/images/:filename/delete
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", requireUserMw.ApplyFn(galleriesC.ImageDelete)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).Methods("GET").Name(controllers.ShowGallery)
+	// Show and Download check the visibility and share link
+	// themselves, since visible galleries can be seen without
+	// logging in
+	r.HandleFunc("/galleries/{id:[0-9]+}/download", galleriesC.Download).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/collection", requireUserMw.ApplyFn(collectionsC.MoveGallery)).Methods("POST")
+
+	// Collection routes. Show checks the visibility itself, since
+	// public collections can be browsed without logging in.
+	r.HandleFunc("/collections/new", requireUserMw.ApplyFn(collectionsC.New)).Methods("GET")
+	r.HandleFunc("/collections", requireUserMw.ApplyFn(collectionsC.Create)).Methods("POST")
+	r.HandleFunc("/collections/order", requireUserMw.ApplyFn(collectionsC.Order)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}", collectionsC.Show).Methods("GET").Name(controllers.ShowCollection)
+	r.HandleFunc("/collections/{id:[0-9]+}/edit", requireUserMw.ApplyFn(collectionsC.Edit)).Methods("GET").Name(controllers.EditCollection)
+	r.HandleFunc("/collections/{id:[0-9]+}/update", requireUserMw.ApplyFn(collectionsC.Update)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}/delete", requireUserMw.ApplyFn(collectionsC.Delete)).Methods("POST")
+
+	// Search routes
+	r.HandleFunc("/search", requireUserMw.ApplyFn(searchC.Index)).Methods("GET")
+
+	// Trash routes
+	r.HandleFunc("/trash", requireUserMw.ApplyFn(trashC.Index)).Methods("GET")
+	r.HandleFunc("/trash/galleries/{id:[0-9]+}/restore", requireUserMw.ApplyFn(trashC.RestoreGallery)).Methods("POST")
+	r.HandleFunc("/trash/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(trashC.DeleteGallery)).Methods("POST")
+	r.HandleFunc("/trash/galleries/{id:[0-9]+}/images/{filename}", requireUserMw.ApplyFn(trashC.ShowImage)).Methods("GET")
+	r.HandleFunc("/trash/galleries/{id:[0-9]+}/images/{filename}/restore", requireUserMw.ApplyFn(trashC.RestoreImage)).Methods("POST")
+	r.HandleFunc("/trash/galleries/{id:[0-9]+}/images/{filename}/delete", requireUserMw.ApplyFn(trashC.DeleteImage)).Methods("POST")
+
+	// JSON API routes, documented in controllers/openapi.json
+	registerAPI(r.PathPrefix("/api/v1").Subrouter(), requireAPIUserMw, apiGalleriesC)
+
+	// apply middleware on all routes. The user is looked up first so
+	// that it is available when rendering csrf failures.
+	flashes := views.NewFlashes(cfg.FlashSecret)
+	return flashes.Apply(userMw.Apply(csrfMw(r)))
+}
+
+// registerAPI adds every JSON API route to api. Keep this in sync
+// with controllers/openapi.json, which the tests check.
+func registerAPI(api *mux.Router, requireAPIUserMw middleware.RequireAPIUser, apiGalleriesC *controllers.APIGalleries) {
+	api.HandleFunc("/openapi.json", controllers.OpenAPI).Methods("GET")
+	read := func(h http.HandlerFunc) http.HandlerFunc {
+		return requireAPIUserMw.ApplyScopeFn(models.ScopeRead, h)
+	}
+	write := func(h http.HandlerFunc) http.HandlerFunc {
+		return requireAPIUserMw.ApplyScopeFn(models.ScopeReadWrite, h)
+	}
+	upload := func(h http.HandlerFunc) http.HandlerFunc {
+		return requireAPIUserMw.ApplyScopeFn(models.ScopeUpload, h)
+	}
+	api.HandleFunc("/galleries", read(apiGalleriesC.Index)).Methods("GET")
+	api.HandleFunc("/galleries", write(apiGalleriesC.Create)).Methods("POST")
+	api.HandleFunc("/galleries/{id:[0-9]+}", read(apiGalleriesC.Show)).Methods("GET")
+	api.HandleFunc("/galleries/{id:[0-9]+}", write(apiGalleriesC.Update)).Methods("PATCH")
+	api.HandleFunc("/galleries/{id:[0-9]+}", write(apiGalleriesC.Delete)).Methods("DELETE")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images", read(apiGalleriesC.ImageIndex)).Methods("GET")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images", upload(apiGalleriesC.ImageUpload)).Methods("POST")
+	api.HandleFunc("/galleries/{id:[0-9]+}/archive", upload(apiGalleriesC.ImageImport)).Methods("POST")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}", write(apiGalleriesC.ImageDelete)).Methods("DELETE")
+}
+
+func faq(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, "What questions do you have? Share them here and we would do our best to answer. :)")
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, "Sorry, we couldn't get the page you requested")
+}
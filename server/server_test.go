@@ -0,0 +1,594 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sajicode/go-photo/email"
+	"github.com/sajicode/go-photo/models"
+	"github.com/sajicode/go-photo/models/memstore"
+	"github.com/sajicode/go-photo/oidc"
+)
+
+// TestMain runs the tests from the repository root since the
+// views, assets and images are all loaded relative to it.
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// testApp serves our real handler backed by the memstore services
+// and records every email that would have been sent.
+type testApp struct {
+	srv         *httptest.Server
+	users       models.UserService
+	identities  models.UserIdentityService
+	galleries   models.GalleryService
+	collections models.CollectionService
+	images      *memstore.ImageService
+	search      models.SearchService
+	tokens      models.APITokenService
+	oauth       models.OAuthService
+	audit       models.AuditService
+	deletions   models.AccountDeletionService
+	exports     models.ExportService
+	uploads     models.UploadService
+	usage       models.UsageService
+	mail        *mailRecorder
+	// providers are passed to servers started after they are set
+	providers []*oidc.Provider
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	app := &testApp{
+		users:  memstore.NewUserService("test-pepper", "test-hmac-key"),
+		images: memstore.NewImageService(),
+		tokens: memstore.NewAPITokenService("test-hmac-key"),
+		mail:   &mailRecorder{},
+	}
+	app.galleries = memstore.NewGalleryService(app.images)
+	app.identities = memstore.NewUserIdentityService(app.users)
+	app.oauth = memstore.NewOAuthService(app.tokens, "test-hmac-key")
+	app.audit = memstore.NewAuditService()
+	app.deletions = memstore.NewAccountDeletionService()
+	app.exports = memstore.NewExportService("test-hmac-key")
+	app.uploads = memstore.NewUploadService()
+	app.collections = memstore.NewCollectionService()
+	app.search = memstore.NewSearchService(app.galleries, app.images)
+	app.usage = memstore.NewUsageService(app.users, app.galleries, app.images)
+	app.srv = app.serve(t, testConfig)
+	return app
+}
+
+var testCSRFKey = bytes.Repeat([]byte("k"), 32)
+
+var testConfig = Config{CSRFKeys: [][]byte{testCSRFKey}, FlashSecret: "test-flash-secret"}
+
+// serve starts another server using cfg on top of the app's
+// services.
+func (app *testApp) serve(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	if cfg.UploadDir == "" {
+		cfg.UploadDir = t.TempDir()
+	}
+	handler := New(cfg, Deps{
+		User:            app.users,
+		UserIdentity:    app.identities,
+		Gallery:         app.galleries,
+		Collection:      app.collections,
+		Image:           models.NewQuotaImageService(app.images, app.usage),
+		Search:          app.search,
+		APIToken:        app.tokens,
+		OAuth:           app.oauth,
+		Audit:           app.audit,
+		AccountDeletion: app.deletions,
+		Export:          app.exports,
+		Upload:          app.uploads,
+		Usage:           app.usage,
+		Emailer:         email.NewClient(email.WithTransport(app.mail)),
+		OIDCProviders:   app.providers,
+	})
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// signup creates a new user through the signup form and returns
+// a client that is logged in as that user.
+func (app *testApp) signup(t *testing.T, name, emailAddr string) *testClient {
+	t.Helper()
+	c := app.client(t)
+	res := c.postForm("/signup", "/signup", url.Values{
+		"name":     {name},
+		"email":    {emailAddr},
+		"password": {"secret-password"},
+	})
+	expectRedirect(t, res, "/galleries")
+	return c
+}
+
+// createGallery creates a gallery as the client's user and
+// returns it.
+func (app *testApp) createGallery(t *testing.T, c *testClient, title string) *models.Gallery {
+	t.Helper()
+	res := c.postForm("/galleries/new", "/galleries", url.Values{"title": {title}})
+	user, err := app.users.ByEmail(c.email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	galleries, err := app.galleries.ByUserID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gallery := galleries[len(galleries)-1]
+	if gallery.Title != title {
+		t.Fatalf("Expected a gallery titled %q, received %q", title, gallery.Title)
+	}
+	expectRedirect(t, res, fmt.Sprintf("/galleries/%d/edit", gallery.ID))
+	return &gallery
+}
+
+// testClient keeps its own cookies and does not follow redirects
+// so tests can assert where they would have gone.
+type testClient struct {
+	t     *testing.T
+	base  string
+	email string
+	http  *http.Client
+}
+
+func (app *testApp) client(t *testing.T) *testClient {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{
+		t:    t,
+		base: app.srv.URL,
+		http: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (c *testClient) get(path string) *http.Response {
+	c.t.Helper()
+	res, err := c.http.Get(c.base + path)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res
+}
+
+var csrfTokenRegex = regexp.MustCompile(`name="gorilla.csrf.Token" value="([^"]+)"`)
+
+// csrfToken loads a page containing a form and returns the csrf
+// token rendered in it.
+func (c *testClient) csrfToken(page string) string {
+	c.t.Helper()
+	body := readBody(c.t, c.get(page))
+	m := csrfTokenRegex.FindStringSubmatch(body)
+	if m == nil {
+		c.t.Fatalf("No csrf token found on %s", page)
+	}
+	return m[1]
+}
+
+// postForm submits values to action using the csrf token from the
+// form rendered on page.
+func (c *testClient) postForm(page, action string, values url.Values) *http.Response {
+	c.t.Helper()
+	if e := values.Get("email"); e != "" {
+		c.email = e
+	}
+	return c.post(page, action, "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+}
+
+func (c *testClient) post(page, action, contentType string, body *strings.Reader) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, c.base+action, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-CSRF-Token", c.csrfToken(page))
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res
+}
+
+type sentEmail struct {
+	to, subject, text string
+}
+
+type mailRecorder struct {
+	mu   sync.Mutex
+	sent []sentEmail
+}
+
+func (mr *mailRecorder) Send(from, to, subject, text, html string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.sent = append(mr.sent, sentEmail{to: to, subject: subject, text: text})
+	return nil
+}
+
+func (mr *mailRecorder) last() sentEmail {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if len(mr.sent) == 0 {
+		return sentEmail{}
+	}
+	return mr.sent[len(mr.sent)-1]
+}
+
+func readBody(t *testing.T, res *http.Response) string {
+	t.Helper()
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func expectRedirect(t *testing.T, res *http.Response, location string) {
+	t.Helper()
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("Expected status %d, received %d", http.StatusFound, res.StatusCode)
+	}
+	if got := res.Header.Get("Location"); got != location {
+		t.Fatalf("Expected redirect to %s, received %s", location, got)
+	}
+}
+
+func expectStatus(t *testing.T, res *http.Response, status int) string {
+	t.Helper()
+	body := readBody(t, res)
+	if res.StatusCode != status {
+		t.Fatalf("Expected status %d, received %d: %s", status, res.StatusCode, body)
+	}
+	return body
+}
+
+func TestSignupLoginLogout(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	if sent := app.mail.last(); sent.to != "Gary Oldman <gary@test.dev>" {
+		t.Errorf("Expected a welcome email to gary, received %+v", sent)
+	}
+	expectStatus(t, c.get("/galleries"), http.StatusOK)
+
+	expectRedirect(t, c.postForm("/galleries", "/logout", url.Values{}), "/")
+	expectRedirect(t, c.get("/galleries"), "/login")
+
+	res := c.postForm("/login", "/login", url.Values{
+		"email":    {"gary@test.dev"},
+		"password": {"wrong-password"},
+	})
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, "Incorrect password provided") {
+		t.Errorf("Expected an incorrect password alert, received %s", body)
+	}
+
+	res = c.postForm("/login", "/login", url.Values{
+		"email":    {"gary@test.dev"},
+		"password": {"secret-password"},
+	})
+	expectRedirect(t, res, "/galleries")
+	expectStatus(t, c.get("/galleries"), http.StatusOK)
+}
+
+func TestSignupValidation(t *testing.T) {
+	app := newTestApp(t)
+	app.signup(t, "Gary Oldman", "gary@test.dev")
+	c := app.client(t)
+	res := c.postForm("/signup", "/signup", url.Values{
+		"name":     {"Gary Again"},
+		"email":    {"GARY@test.dev"},
+		"password": {"secret-password"},
+	})
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, "Email address is already taken") {
+		t.Errorf("Expected an email taken alert, received %s", body)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApp(t)
+	app.signup(t, "Gary Oldman", "gary@test.dev")
+
+	c := app.client(t)
+	res := c.postForm("/forgot", "/forgot", url.Values{"email": {"gary@test.dev"}})
+	expectRedirect(t, res, "/reset")
+	sent := app.mail.last()
+	m := regexp.MustCompile(`following value:\s+(\S+)`).FindStringSubmatch(sent.text)
+	if sent.to != "gary@test.dev" || m == nil {
+		t.Fatalf("Expected a reset email with a token, received %+v", sent)
+	}
+
+	res = c.postForm("/reset", "/reset", url.Values{
+		"token":    {"not-a-real-token"},
+		"password": {"brand-new-password"},
+	})
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, "Token provided is not valid") {
+		t.Errorf("Expected an invalid token alert, received %s", body)
+	}
+
+	res = c.postForm("/reset", "/reset", url.Values{
+		"token":    {m[1]},
+		"password": {"brand-new-password"},
+	})
+	expectRedirect(t, res, "/galleries")
+	body := expectStatus(t, c.get("/galleries"), http.StatusOK)
+	if !strings.Contains(body, "Your password has been reset") {
+		t.Errorf("Expected the flash alert to be displayed, received %s", body)
+	}
+	body = expectStatus(t, c.get("/galleries"), http.StatusOK)
+	if strings.Contains(body, "Your password has been reset") {
+		t.Errorf("Expected the flash alert to only be displayed once")
+	}
+	if _, err := app.users.Authenticate("gary@test.dev", "brand-new-password"); err != nil {
+		t.Errorf("Expected the new password to work, received %v", err)
+	}
+}
+
+func TestGalleryCRUD(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+
+	if body := expectStatus(t, c.get("/galleries"), http.StatusOK); !strings.Contains(body, "Wedding") {
+		t.Errorf("Expected the index to list the gallery, received %s", body)
+	}
+
+	res := c.postForm(path+"/edit", path+"/update", url.Values{"title": {""}})
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, "Gallery title is required") {
+		t.Errorf("Expected a title required alert, received %s", body)
+	}
+	res = c.postForm(path+"/edit", path+"/update", url.Values{"title": {"Reception"}})
+	expectStatus(t, res, http.StatusOK)
+	if body := expectStatus(t, app.client(t).get(path), http.StatusOK); !strings.Contains(body, "Reception") {
+		t.Errorf("Expected the updated title on the show page, received %s", body)
+	}
+
+	expectRedirect(t, c.postForm(path+"/delete", path+"/delete", url.Values{"confirm": {"true"}}), "/galleries")
+	if _, err := app.galleries.ByID(gallery.ID); err != models.ErrNotFound {
+		t.Errorf("Expected the gallery to be deleted, received %v", err)
+	}
+	expectStatus(t, c.get(path), http.StatusNotFound)
+}
+
+func TestGalleryOwnership(t *testing.T) {
+	app := newTestApp(t)
+	owner := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, owner, "Wedding")
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+
+	expectRedirect(t, app.client(t).get(path+"/edit"), "/login")
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	expectStatus(t, other.get(path+"/edit"), http.StatusNotFound)
+	expectStatus(t, other.postForm("/galleries/new", path+"/update", url.Values{"title": {"Mine"}}), http.StatusNotFound)
+	expectStatus(t, other.postForm("/galleries/new", path+"/delete", url.Values{}), http.StatusNotFound)
+	expectStatus(t, other.postForm("/galleries/new", path+"/images/a.jpg/delete", url.Values{}), http.StatusNotFound)
+
+	found, err := app.galleries.ByID(gallery.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Title != "Wedding" {
+		t.Errorf("Expected the title to be unchanged, received %s", found.Title)
+	}
+}
+
+func TestImageUploadAndDelete(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, name := range []string{"first dance.jpg", "cake.png"} {
+		fw, err := mw.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte("image data for " + name))
+	}
+	mw.Close()
+	res := c.post(path+"/edit", path+"/images", mw.FormDataContentType(), strings.NewReader(buf.String()))
+	expectRedirect(t, res, path+"/edit")
+
+	images, err := app.images.ByGalleryID(gallery.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("Expected 2 images, received %d", len(images))
+	}
+	b, err := app.images.Bytes(&models.Image{GalleryID: gallery.ID, Filename: "cake.png"})
+	if err != nil || string(b) != "image data for cake.png" {
+		t.Errorf("Expected the uploaded contents, received %q, %v", b, err)
+	}
+	if body := expectStatus(t, c.get(path), http.StatusOK); !strings.Contains(body, "first%20dance.jpg") {
+		t.Errorf("Expected the show page to include the image, received %s", body)
+	}
+
+	res = c.postForm(path+"/edit", path+"/images/"+url.PathEscape("first dance.jpg")+"/delete", url.Values{})
+	expectRedirect(t, res, path+"/edit")
+	images, _ = app.images.ByGalleryID(gallery.ID)
+	if len(images) != 1 || images[0].Filename != "cake.png" {
+		t.Errorf("Expected only cake.png to remain, received %v", images)
+	}
+}
+
+func TestImageDetailsAndOrder(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+	for _, name := range []string{"cake.png", "first dance.jpg", "vows.jpg"} {
+		app.images.Create(gallery.ID, strings.NewReader(name), name)
+	}
+	if body := expectStatus(t, c.get(path+"/edit"), http.StatusOK); !strings.Contains(body, "3 images have no alt text") {
+		t.Errorf("Expected a warning about missing alt text, received %s", body)
+	}
+
+	res := c.postForm(path+"/edit", path+"/images/"+url.PathEscape("first dance.jpg")+"/update", url.Values{
+		"alt":     {"The couple dancing"},
+		"caption": {"Our first dance"},
+	})
+	expectRedirect(t, res, path+"/edit")
+	body := expectStatus(t, c.get(path), http.StatusOK)
+	if !strings.Contains(body, `alt="The couple dancing"`) || !strings.Contains(body, "<figcaption class=\"caption\">Our first dance</figcaption>") {
+		t.Errorf("Expected the alt text and caption on the show page, received %s", body)
+	}
+	res = c.postForm(path+"/edit", path+"/images/"+url.PathEscape("first dance.jpg")+"/update", url.Values{
+		"alt": {strings.Repeat("a", models.MaxAltTextLength+1)},
+	})
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, "Alt text can be at most") {
+		t.Errorf("Expected an error about long alt text, received %s", body)
+	}
+
+	res = c.postForm(path+"/edit", path+"/images/order", url.Values{
+		"filenames": {"vows.jpg", "cake.png", "first dance.jpg"},
+	})
+	expectRedirect(t, res, path+"/edit")
+	images, _ := app.images.ByGalleryID(gallery.ID)
+	if images[0].Filename != "vows.jpg" || images[1].Filename != "cake.png" || images[2].Filename != "first dance.jpg" {
+		t.Errorf("Expected the new order, received %v", images)
+	}
+	res = c.postForm(path+"/edit", path+"/images/order", url.Values{
+		"filenames": {"vows.jpg"},
+	})
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, "changed while you were reordering") {
+		t.Errorf("Expected an error about the incomplete order, received %s", body)
+	}
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	res = other.postForm("/galleries/new", path+"/images/order", url.Values{
+		"filenames": {"cake.png", "first dance.jpg", "vows.jpg"},
+	})
+	expectStatus(t, res, http.StatusNotFound)
+}
+
+func TestGalleryDetails(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+	for _, name := range []string{"cake.png", "vows.jpg"} {
+		app.images.Create(gallery.ID, strings.NewReader(name), name)
+	}
+
+	res := c.postForm(path+"/edit", path+"/update", url.Values{
+		"title":       {"Wedding"},
+		"description": {"The **big** day <script>alert(1)</script> [map](javascript:alert(1))"},
+		"event_date":  {"2019-06-01"},
+		"location":    {"Lagos"},
+		"cover":       {"vows.jpg"},
+	})
+	expectStatus(t, res, http.StatusOK)
+	body := expectStatus(t, app.client(t).get(path), http.StatusOK)
+	for _, want := range []string{"<strong>big</strong>", "June 1, 2019", "Lagos"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the show page to include %s, received %s", want, body)
+		}
+	}
+	if strings.Contains(body, "<script>alert") || strings.Contains(body, `href="javascript:`) {
+		t.Errorf("Expected unsafe markdown to be dropped, received %s", body)
+	}
+	body = expectStatus(t, c.get("/galleries"), http.StatusOK)
+	if !strings.Contains(body, "vows.jpg") || !strings.Contains(body, "2 images") {
+		t.Errorf("Expected the card to show the cover and image count, received %s", body)
+	}
+
+	for values, alert := range map[string]string{
+		"event_date=yesterday": "Event date must be a real date",
+		"cover=missing.jpg":    "The cover must be one of the gallery's images",
+	} {
+		form, _ := url.ParseQuery(values)
+		form.Set("title", "Wedding")
+		res = c.postForm(path+"/edit", path+"/update", form)
+		if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, html.EscapeString(alert)) {
+			t.Errorf("Expected %q for %s, received %s", alert, values, body)
+		}
+	}
+	found, _ := app.galleries.ByID(gallery.ID)
+	if found.CoverFilename != "vows.jpg" {
+		t.Errorf("Expected the cover to be unchanged, received %s", found.CoverFilename)
+	}
+}
+
+func TestCSRFKeyRotation(t *testing.T) {
+	app := newTestApp(t)
+	c := app.client(t)
+	// loads the form and signs the csrf cookie with the old key
+	token := c.csrfToken("/signup")
+
+	newKey := bytes.Repeat([]byte("n"), 32)
+	rotated := app.serve(t, Config{CSRFKeys: [][]byte{newKey, testCSRFKey}})
+	c.base = rotated.URL
+	values := url.Values{
+		"name":     {"Gary Oldman"},
+		"email":    {"gary@test.dev"},
+		"password": {"secret-password"},
+	}
+	req, _ := http.NewRequest(http.MethodPost, c.base+"/signup", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-CSRF-Token", token)
+	res, err := c.http.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRedirect(t, res, "/galleries")
+
+	dropped := app.serve(t, Config{CSRFKeys: [][]byte{newKey}})
+	c.base = dropped.URL
+	req, _ = http.NewRequest(http.MethodPost, c.base+"/logout", nil)
+	req.Header.Set("X-CSRF-Token", token)
+	res, err = c.http.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := expectStatus(t, res, http.StatusForbidden); !strings.Contains(body, "This form has expired") {
+		t.Errorf("Expected the csrf error page, received %s", body)
+	}
+}
+
+func TestCSRFFailureJSON(t *testing.T) {
+	app := newTestApp(t)
+	req, _ := http.NewRequest(http.MethodPost, app.srv.URL+"/galleries", strings.NewReader(`{"title":"Wedding"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := expectStatus(t, res, http.StatusForbidden)
+	if res.Header.Get("Content-Type") != "application/json" || !strings.Contains(body, `"error"`) {
+		t.Errorf("Expected a JSON error, received %s", body)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+func TestTrash(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	family := app.createCollection(t, c, "Family", 0)
+	wedding := app.createGallery(t, c, "Wedding")
+	path := fmt.Sprintf("/galleries/%d", wedding.ID)
+	expectRedirect(t, c.postForm("/galleries", path+"/collection", url.Values{"collection": {fmt.Sprint(family.ID)}}), "/galleries")
+	reception := app.createGallery(t, c, "Reception")
+	if err := app.images.Create(reception.ID, strings.NewReader("cake"), "cake.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	receptionImage := fmt.Sprintf("/images/galleries/%d/cake.png", reception.ID)
+	if body := expectStatus(t, app.client(t).get(receptionImage), http.StatusOK); body != "cake" {
+		t.Errorf("Expected the image to be served, received %q", body)
+	}
+
+	// deleting asks first, and says how long the trash keeps things
+	if body := expectStatus(t, c.get(path+"/delete"), http.StatusOK); !strings.Contains(body, "restore them from there for 30 days") {
+		t.Errorf("Expected to be asked to confirm, received %s", body)
+	}
+	if body := expectStatus(t, c.postForm(path+"/delete", path+"/delete", url.Values{}), http.StatusOK); !strings.Contains(body, "Move to trash") {
+		t.Errorf("Expected deleting without confirming to ask first, received %s", body)
+	}
+	if _, err := app.galleries.ByID(wedding.ID); err != nil {
+		t.Fatalf("Expected the gallery to be kept until confirmed, received %v", err)
+	}
+	expectRedirect(t, c.postForm(path+"/delete", path+"/delete", url.Values{"confirm": {"true"}}), "/galleries")
+	receptionPath := fmt.Sprintf("/galleries/%d", reception.ID)
+	expectRedirect(t, c.postForm(receptionPath+"/edit", receptionPath+"/images/cake.png/delete", url.Values{}), receptionPath+"/edit")
+
+	body := expectStatus(t, c.get("/trash"), http.StatusOK)
+	if !strings.Contains(body, "Wedding") || !strings.Contains(body, "cake.png") {
+		t.Errorf("Expected the gallery and image in the trash, received %s", body)
+	}
+	imagePath := fmt.Sprintf("/trash/galleries/%d/images/cake.png", reception.ID)
+	if body := expectStatus(t, c.get(imagePath), http.StatusOK); body != "cake" {
+		t.Errorf("Expected the trashed image to be served to its owner, received %q", body)
+	}
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	if body := expectStatus(t, other.get("/trash"), http.StatusOK); strings.Contains(body, "Wedding") {
+		t.Errorf("Expected someone else's trash to be left out, received %s", body)
+	}
+	expectStatus(t, other.get(imagePath), http.StatusNotFound)
+	expectStatus(t, other.postForm("/trash", fmt.Sprintf("/trash/galleries/%d/restore", wedding.ID), url.Values{}), http.StatusNotFound)
+	expectStatus(t, other.postForm("/trash", imagePath+"/delete", url.Values{}), http.StatusNotFound)
+
+	// the collection went while the gallery was in the trash
+	expectRedirect(t, c.postForm(fmt.Sprintf("/collections/%d/edit", family.ID), fmt.Sprintf("/collections/%d/delete", family.ID), url.Values{}), "/galleries")
+	expectRedirect(t, c.postForm("/trash", fmt.Sprintf("/trash/galleries/%d/restore", wedding.ID), url.Values{}), "/trash")
+	restored, err := app.galleries.ByID(wedding.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.CollectionID != 0 {
+		t.Errorf("Expected the gallery to leave the deleted collection, received %d", restored.CollectionID)
+	}
+	expectRedirect(t, c.postForm("/trash", imagePath+"/restore", url.Values{}), "/trash")
+	if images, _ := app.images.ByGalleryID(reception.ID); len(images) != 1 {
+		t.Errorf("Expected the image to be restored, received %+v", images)
+	}
+
+	expectRedirect(t, c.postForm(receptionPath+"/delete", receptionPath+"/delete", url.Values{"confirm": {"true"}}), "/galleries")
+	// the images of a gallery in the trash aren't served, until
+	// it is restored
+	expectStatus(t, app.client(t).get(receptionImage), http.StatusNotFound)
+	expectRedirect(t, c.postForm("/trash", fmt.Sprintf("/trash/galleries/%d/restore", reception.ID), url.Values{}), "/trash")
+	expectStatus(t, app.client(t).get(receptionImage), http.StatusOK)
+	expectRedirect(t, c.postForm(receptionPath+"/delete", receptionPath+"/delete", url.Values{"confirm": {"true"}}), "/galleries")
+	expectRedirect(t, c.postForm("/trash", fmt.Sprintf("/trash/galleries/%d/delete", reception.ID), url.Values{}), "/trash")
+	if deleted, _ := app.galleries.DeletedByUserID(reception.UserID); len(deleted) != 0 {
+		t.Errorf("Expected the gallery to be deleted for good, received %+v", deleted)
+	}
+	if images, _ := app.images.ByGalleryID(reception.ID); len(images) != 0 {
+		t.Errorf("Expected its images to go with it, received %+v", images)
+	}
+	if _, err := app.galleries.ByID(reception.ID); err != models.ErrNotFound {
+		t.Errorf("Expected the gallery to be gone, received %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// tus sends a tus request with the headers every request needs
+func (c *testClient) tus(method, path string, headers map[string]string, body io.Reader) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	if method != http.MethodHead {
+		req.Header.Set("X-CSRF-Token", c.csrfToken("/galleries/new"))
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res
+}
+
+// createUpload starts an upload and returns its URL
+func (c *testClient) createUpload(galleryID uint, filename string, length int) string {
+	c.t.Helper()
+	res := c.tus(http.MethodPost, fmt.Sprintf("/galleries/%d/uploads", galleryID), map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+	}, nil)
+	expectStatus(c.t, res, http.StatusCreated)
+	if res.Header.Get("Upload-Expires") == "" {
+		c.t.Error("Expected the upload to say when it expires")
+	}
+	return res.Header.Get("Location")
+}
+
+func (c *testClient) patchUpload(url string, offset int, chunk string) *http.Response {
+	c.t.Helper()
+	return c.tus(http.MethodPatch, url, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	}, strings.NewReader(chunk))
+}
+
+func TestTusUpload(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+
+	res := c.tus(http.MethodOptions, fmt.Sprintf("/galleries/%d/uploads", gallery.ID), nil, nil)
+	expectStatus(t, res, http.StatusNoContent)
+	if res.Header.Get("Tus-Version") != "1.0.0" || !strings.Contains(res.Header.Get("Tus-Extension"), "termination") {
+		t.Errorf("Expected the supported version and extensions, received %v", res.Header)
+	}
+
+	url := c.createUpload(gallery.ID, "first dance.jpg", 10)
+	expectStatus(t, c.patchUpload(url, 0, "hello "), http.StatusNoContent)
+	expectStatus(t, c.patchUpload(url, 0, "hello "), http.StatusConflict)
+
+	res = c.tus(http.MethodHead, url, nil, nil)
+	expectStatus(t, res, http.StatusOK)
+	if res.Header.Get("Upload-Offset") != "6" || res.Header.Get("Upload-Length") != "10" {
+		t.Errorf("Expected to resume from 6 of 10, received %v", res.Header)
+	}
+	if images, _ := app.images.ByGalleryID(gallery.ID); len(images) != 0 {
+		t.Errorf("Expected nothing to be added before the upload completes, received %v", images)
+	}
+
+	res = c.patchUpload(url, 6, "world and more")
+	expectStatus(t, res, http.StatusNoContent)
+	if res.Header.Get("Upload-Offset") != "10" {
+		t.Errorf("Expected the upload to stop at its length, received %v", res.Header)
+	}
+	b, err := app.images.Bytes(&models.Image{GalleryID: gallery.ID, Filename: "first dance.jpg"})
+	if err != nil || string(b) != "hello worl" {
+		t.Errorf("Expected the completed image, received %q, %v", b, err)
+	}
+	expectStatus(t, c.tus(http.MethodHead, url, nil, nil), http.StatusNotFound)
+}
+
+func TestTusUploadRules(t *testing.T) {
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	path := fmt.Sprintf("/galleries/%d/uploads", gallery.ID)
+
+	res := c.tus(http.MethodPost, path, map[string]string{
+		"Tus-Resumable": "0.2.2",
+		"Upload-Length": "10",
+	}, nil)
+	expectStatus(t, res, http.StatusPreconditionFailed)
+
+	res = c.tus(http.MethodPost, path, map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("notes.txt")),
+	}, nil)
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+
+	res = c.tus(http.MethodPost, path, map[string]string{
+		"Upload-Length":   strconv.Itoa(models.MaxUploadLength + 1),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("huge.jpg")),
+	}, nil)
+	expectStatus(t, res, http.StatusRequestEntityTooLarge)
+
+	url := c.createUpload(gallery.ID, "cake.png", 10)
+	res = c.tus(http.MethodPatch, url, map[string]string{"Upload-Offset": "0"}, strings.NewReader("cake"))
+	expectStatus(t, res, http.StatusUnsupportedMediaType)
+
+	other := app.signup(t, "Jon Snow", "jon@test.dev")
+	expectStatus(t, other.tus(http.MethodHead, url, nil, nil), http.StatusNotFound)
+	expectStatus(t, other.patchUpload(url, 0, "evil"), http.StatusNotFound)
+
+	expectStatus(t, c.tus(http.MethodDelete, url, nil, nil), http.StatusNoContent)
+	expectStatus(t, c.tus(http.MethodHead, url, nil, nil), http.StatusNotFound)
+}
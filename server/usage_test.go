@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sajicode/go-photo/models"
+)
+
+// uploadForm returns a multipart body uploading each file to a
+// gallery
+func uploadForm(t *testing.T, files map[string]string) (string, *strings.Reader) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, content := range files {
+		fw, err := mw.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+	return mw.FormDataContentType(), strings.NewReader(buf.String())
+}
+
+func TestStorageQuota(t *testing.T) {
+	plans := models.Plans
+	models.Plans = []models.Plan{
+		{Name: models.PlanFree, MaxBytes: 10, MaxImages: 2},
+		{Name: models.PlanPro},
+	}
+	t.Cleanup(func() { models.Plans = plans })
+	app := newTestApp(t)
+	c := app.signup(t, "Gary Oldman", "gary@test.dev")
+	gallery := app.createGallery(t, c, "Wedding")
+	path := fmt.Sprintf("/galleries/%d", gallery.ID)
+
+	contentType, upload := uploadForm(t, map[string]string{"cake.png": "cake"})
+	expectRedirect(t, c.post(path+"/edit", path+"/images", contentType, upload), path+"/edit")
+	contentType, upload = uploadForm(t, map[string]string{"dance.png": "first dance"})
+	res := c.post(path+"/edit", path+"/images", contentType, upload)
+	if body := expectStatus(t, res, http.StatusOK); !strings.Contains(body, "over the storage your plan allows") {
+		t.Errorf("Expected the upload over quota to be refused, received %s", body)
+	}
+	if images, _ := app.images.ByGalleryID(gallery.ID); len(images) != 1 {
+		t.Errorf("Expected only the image that fit to be stored, received %+v", images)
+	}
+
+	// tus uploads are refused before any bytes are sent
+	res = c.tus(http.MethodPost, path+"/uploads", map[string]string{
+		"Upload-Length":   "7",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("dance.png")),
+	}, nil)
+	expectStatus(t, res, http.StatusRequestEntityTooLarge)
+
+	// and so are ones that would only fit if the uploads still open
+	// never finished
+	open := c.createUpload(gallery.ID, "speech.png", 5)
+	res = c.tus(http.MethodPost, path+"/uploads", map[string]string{
+		"Upload-Length":   "1",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("toast.png")),
+	}, nil)
+	expectStatus(t, res, http.StatusRequestEntityTooLarge)
+	expectStatus(t, c.tus(http.MethodDelete, open, nil, nil), http.StatusNoContent)
+	expectStatus(t, c.tus(http.MethodDelete, c.createUpload(gallery.ID, "toast.png", 1), nil, nil), http.StatusNoContent)
+
+	body := expectStatus(t, c.get("/galleries"), http.StatusOK)
+	if !strings.Contains(body, "4 B of 10 B") {
+		t.Errorf("Expected the usage meter in the navbar, received %s", body)
+	}
+	body = expectStatus(t, c.get("/settings/account"), http.StatusOK)
+	if !strings.Contains(body, "free plan") || !strings.Contains(body, "1 of 2 images") || !strings.Contains(body, "Wedding") {
+		t.Errorf("Expected the usage of each gallery on the account page, received %s", body)
+	}
+
+	admin := app.signup(t, "Jon Snow", "jon@test.dev")
+	app.promote(t, "jon@test.dev", models.RoleAdmin)
+	userPath := fmt.Sprintf("/admin/users/%d", gallery.UserID)
+	body = expectStatus(t, admin.postForm(userPath, userPath+"/plan", url.Values{"plan": {"gold"}}), http.StatusOK)
+	if !strings.Contains(body, "Plan is not valid") {
+		t.Errorf("Expected an unknown plan to be refused, received %s", body)
+	}
+	expectRedirect(t, admin.postForm(userPath, userPath+"/plan", url.Values{"plan": {models.PlanPro}}), userPath)
+	if events, _ := app.audit.Find(models.AuditFilter{UserID: gallery.UserID, Action: models.AuditPlanChanged}); len(events) != 1 {
+		t.Errorf("Expected the plan change to be audited, received %+v", events)
+	}
+	contentType, upload = uploadForm(t, map[string]string{"dance.png": "first dance"})
+	expectRedirect(t, c.post(path+"/edit", path+"/images", contentType, upload), path+"/edit")
+}
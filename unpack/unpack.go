@@ -0,0 +1,220 @@
+// Package unpack reads the files out of zip and tar archives that
+// users upload, refusing anything that could escape the archive or
+// blow up once extracted.
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// Limits bound how much reading an archive can cost us
+type Limits struct {
+	// MaxEntries is the most files an archive can hold
+	MaxEntries int
+	// MaxEntrySize is the most bytes a single file can hold once
+	// extracted
+	MaxEntrySize int64
+	// MaxTotalSize is the most bytes all the files can hold once
+	// extracted
+	MaxTotalSize int64
+}
+
+// DefaultLimits are generous enough for a photo shoot
+var DefaultLimits = Limits{
+	MaxEntries:   1000,
+	MaxEntrySize: 50 << 20,
+	MaxTotalSize: 2 << 30,
+}
+
+// Errors stopping the whole archive from being read
+var (
+	ErrUnknownFormat  = errors.New("unpack: not a zip or tar archive")
+	ErrTooManyEntries = errors.New("unpack: archive holds too many files")
+	ErrTooLarge       = errors.New("unpack: archive is too large once extracted")
+)
+
+// Errors passed to WalkFunc for files that are skipped
+var (
+	ErrUnsafePath    = errors.New("unpack: file path points outside the archive")
+	ErrEntryTooLarge = errors.New("unpack: file is too large")
+	ErrNotRegular    = errors.New("unpack: not a regular file")
+)
+
+// WalkFunc is called with the path of every file in an archive,
+// and either a reader over its contents or the reason it can't be
+// read. Returning an error stops the walk.
+type WalkFunc func(name string, r io.Reader, err error) error
+
+// Walk calls fn for every file in the zip, tar or gzipped tar
+// archive in ra, in the order they are stored. Directories are
+// left out. Sizes are checked against lim before anything is read,
+// so an archive over MaxTotalSize stops with ErrTooLarge part way.
+func Walk(ra io.ReaderAt, size int64, lim Limits, fn WalkFunc) error {
+	magic := make([]byte, 512)
+	n, err := ra.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return walkZip(ra, size, lim, fn)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(io.NewSectionReader(ra, 0, size))
+		if err != nil {
+			return ErrUnknownFormat
+		}
+		defer gz.Close()
+		return walkTar(tar.NewReader(gz), lim, fn)
+	case len(magic) > 262 && string(magic[257:262]) == "ustar":
+		return walkTar(tar.NewReader(io.NewSectionReader(ra, 0, size)), lim, fn)
+	}
+	return ErrUnknownFormat
+}
+
+func walkZip(ra io.ReaderAt, size int64, lim Limits, fn WalkFunc) error {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return ErrUnknownFormat
+	}
+	var files []*zip.File
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+	if len(files) > lim.MaxEntries {
+		return ErrTooManyEntries
+	}
+	var total int64
+	for _, f := range files {
+		name, err := cleanPath(f.Name)
+		if err != nil {
+			if err := fn(f.Name, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			if err := fn(name, nil, ErrNotRegular); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.UncompressedSize64 > uint64(lim.MaxEntrySize) {
+			if err := fn(name, nil, ErrEntryTooLarge); err != nil {
+				return err
+			}
+			continue
+		}
+		total += int64(f.UncompressedSize64)
+		if total > lim.MaxTotalSize {
+			return ErrTooLarge
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(name, limitReader(rc, lim.MaxEntrySize), nil)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(tr *tar.Reader, lim Limits, fn WalkFunc) error {
+	var count int
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if count == 0 {
+				return ErrUnknownFormat
+			}
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		count++
+		if count > lim.MaxEntries {
+			return ErrTooManyEntries
+		}
+		name, err := cleanPath(hdr.Name)
+		if err != nil {
+			if err := fn(hdr.Name, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			if err := fn(name, nil, ErrNotRegular); err != nil {
+				return err
+			}
+			continue
+		}
+		if hdr.Size > lim.MaxEntrySize {
+			if err := fn(name, nil, ErrEntryTooLarge); err != nil {
+				return err
+			}
+			continue
+		}
+		total += hdr.Size
+		if total > lim.MaxTotalSize {
+			return ErrTooLarge
+		}
+		if err := fn(name, limitReader(tr, lim.MaxEntrySize), nil); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanPath returns name as a clean relative path, or
+// ErrUnsafePath if it is absolute or climbs out of the archive
+// with "..". Nothing we extract is written under its path, but an
+// archive built to do that isn't one we want anything from.
+func cleanPath(name string) (string, error) {
+	name = strings.Replace(name, `\`, "/", -1)
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", ErrUnsafePath
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", ErrUnsafePath
+		}
+	}
+	return path.Clean(name), nil
+}
+
+// limitReader fails reads past n bytes, in case the archive lied
+// about how big a file is
+func limitReader(r io.Reader, n int64) io.Reader {
+	return &limitedReader{r: io.LimitReader(r, n+1), n: n}
+}
+
+type limitedReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.read += int64(n)
+	if lr.read > lr.n {
+		return n, ErrEntryTooLarge
+	}
+	return n, err
+}
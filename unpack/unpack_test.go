@@ -0,0 +1,151 @@
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+type file struct {
+	name, body string
+}
+
+func zipOf(t *testing.T, files ...file) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, files ...file) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}
+		if f.body == "->" {
+			hdr = &tar.Header{Name: f.name, Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(f.body))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipOf(b []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(b)
+	gw.Close()
+	return buf.Bytes()
+}
+
+type walked struct {
+	name, body string
+	err        error
+}
+
+func walk(b []byte, lim Limits) ([]walked, error) {
+	var ret []walked
+	err := Walk(bytes.NewReader(b), int64(len(b)), lim, func(name string, r io.Reader, err error) error {
+		w := walked{name: name, err: err}
+		if r != nil {
+			body, err := ioutil.ReadAll(r)
+			w.body = string(body)
+			w.err = err
+		}
+		ret = append(ret, w)
+		return nil
+	})
+	return ret, err
+}
+
+func TestWalkFormats(t *testing.T) {
+	files := []file{{"a.jpg", "aaa"}, {"dir/b.png", "bb"}}
+	want := []walked{{name: "a.jpg", body: "aaa"}, {name: "dir/b.png", body: "bb"}}
+	for name, b := range map[string][]byte{
+		"zip":    zipOf(t, files...),
+		"tar":    tarOf(t, files...),
+		"tar.gz": gzipOf(tarOf(t, files...)),
+	} {
+		got, err := walk(b, DefaultLimits)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Expected %v, received %v", name, want, got)
+		}
+	}
+	if _, err := walk([]byte("just some text"), DefaultLimits); err != ErrUnknownFormat {
+		t.Errorf("Expected ErrUnknownFormat, received %v", err)
+	}
+}
+
+func TestWalkUnsafe(t *testing.T) {
+	got, err := walk(zipOf(t,
+		file{"../evil.jpg", "x"},
+		file{"a/../../evil.jpg", "x"},
+		file{"/etc/evil.jpg", "x"},
+		file{`..\evil.jpg`, "x"},
+		file{"C:/evil.jpg", "x"},
+		file{"ok/./fine.jpg", "x"},
+	), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range got[:5] {
+		if w.err != ErrUnsafePath {
+			t.Errorf("Expected %q to be unsafe, received %v", w.name, w.err)
+		}
+	}
+	if got[5].name != "ok/fine.jpg" || got[5].err != nil {
+		t.Errorf("Expected the safe file to be cleaned, received %+v", got[5])
+	}
+
+	got, err = walk(tarOf(t, file{"link.jpg", "->"}), DefaultLimits)
+	if err != nil || len(got) != 1 || got[0].err != ErrNotRegular {
+		t.Errorf("Expected the symlink to be skipped, received %+v, %v", got, err)
+	}
+}
+
+func TestWalkLimits(t *testing.T) {
+	files := []file{{"a.jpg", "aaaa"}, {"b.jpg", "bbbb"}, {"c.jpg", "cc"}}
+	for name, b := range map[string][]byte{
+		"zip": zipOf(t, files...),
+		"tar": tarOf(t, files...),
+	} {
+		if _, err := walk(b, Limits{MaxEntries: 2, MaxEntrySize: 10, MaxTotalSize: 100}); err != ErrTooManyEntries {
+			t.Errorf("%s: Expected ErrTooManyEntries, received %v", name, err)
+		}
+		if _, err := walk(b, Limits{MaxEntries: 10, MaxEntrySize: 10, MaxTotalSize: 7}); err != ErrTooLarge {
+			t.Errorf("%s: Expected ErrTooLarge, received %v", name, err)
+		}
+		got, err := walk(b, Limits{MaxEntries: 10, MaxEntrySize: 3, MaxTotalSize: 100})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got[0].err != ErrEntryTooLarge || got[1].err != ErrEntryTooLarge || got[2].body != "cc" {
+			t.Errorf("%s: Expected the large files to be skipped, received %+v", name, got)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package views
 import (
 	"log"
 	"net/http"
-	"time"
 
 	"github.com/sajicode/go-photo/models"
 )
@@ -31,8 +30,14 @@ type Alert struct {
 
 // Data struct encompasses bootstrap alert and extra info
 type Data struct {
-	Alert *Alert // * by using a pointer, Alert can be nil
-	User  *models.User
+	Alert   *Alert // * by using a pointer, Alert can be nil
+	Flashes []Alert
+	User    *models.User
+	// Impersonator is the admin acting as User, if any
+	Impersonator *models.User
+	// Quota is User's plan and what they use of it, for the usage
+	// meter
+	Quota *models.Quota
 	Yield interface{}
 }
 
@@ -66,62 +71,15 @@ type PublicError interface {
 	Public() string
 }
 
-func persistAlert(w http.ResponseWriter, alert Alert) {
-	expiresAt := time.Now().Add(5 * time.Minute)
-	lvl := http.Cookie{
-		Name:     "alert_level",
-		Value:    alert.Level,
-		Expires:  expiresAt,
-		HttpOnly: true,
-	}
-	msg := http.Cookie{
-		Name:     "alert_message",
-		Value:    alert.Message,
-		Expires:  expiresAt,
-		HttpOnly: true,
-	}
-	http.SetCookie(w, &lvl)
-	http.SetCookie(w, &msg)
-}
-
-func clearAlert(w http.ResponseWriter) {
-	lvl := http.Cookie{
-		Name:     "alert_level",
-		Value:    "",
-		Expires:  time.Now(),
-		HttpOnly: true,
-	}
-	msg := http.Cookie{
-		Name:     "alert_message",
-		Value:    "",
-		Expires:  time.Now(),
-		HttpOnly: true,
-	}
-	http.SetCookie(w, &lvl)
-	http.SetCookie(w, &msg)
-}
-
-func getAlert(r *http.Request) *Alert {
-	lvl, err := r.Cookie("alert_level")
-	if err != nil {
-		return nil
-	}
-	msg, err := r.Cookie("alert_message")
-	if err != nil {
-		return nil
-	}
-	alert := Alert{
-		Level:   lvl.Value,
-		Message: msg.Value,
-	}
-	return &alert
-}
-
 // RedirectAlert accepts all the normal params for an
 // http.Redirect and performs a redirect, but only after
 // persisting the provided alert in a cookie so that it can
 // be displayed when the new page is loaded.
 func RedirectAlert(w http.ResponseWriter, r *http.Request, urlStr string, code int, alert Alert) {
-	persistAlert(w, alert)
+	if f := flashesFrom(r); f != nil {
+		f.add(w, r, alert)
+	} else {
+		log.Printf("views: no flashes configured, dropping alert %q", alert.Message)
+	}
 	http.Redirect(w, r, urlStr, code)
 }
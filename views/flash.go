@@ -0,0 +1,129 @@
+package views
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	flashCookieName = "flash"
+	flashMaxAge     = 5 * time.Minute
+	// flashMaxQueued keeps the cookie well below the browser size
+	// limit if alerts keep piling up without being displayed.
+	flashMaxQueued = 5
+)
+
+type flashKey string
+
+const flashesKey flashKey = "flashes"
+
+// Flashes persists alerts between requests, typically across a
+// redirect, in a single cookie that is signed and encrypted so
+// that it can't be forged or read by the client.
+type Flashes struct {
+	sc *securecookie.SecureCookie
+}
+
+// NewFlashes derives the signing and encryption keys for the
+// flash cookie from secret.
+func NewFlashes(secret string) *Flashes {
+	sc := securecookie.New(deriveKey(secret, "flash-hash"), deriveKey(secret, "flash-block"))
+	sc.SetSerializer(securecookie.JSONEncoder{})
+	sc.MaxAge(int(flashMaxAge.Seconds()))
+	return &Flashes{sc: sc}
+}
+
+func deriveKey(secret, label string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(label))
+	return h.Sum(nil)
+}
+
+// Apply makes the flashes available to RedirectAlert and Render
+// for every request handled by next.
+func (f *Flashes) Apply(next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), flashesKey, f)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func flashesFrom(r *http.Request) *Flashes {
+	f, _ := r.Context().Value(flashesKey).(*Flashes)
+	return f
+}
+
+// add queues alert behind any alerts the request came in with
+// that have not been displayed yet.
+func (f *Flashes) add(w http.ResponseWriter, r *http.Request, alert Alert) {
+	if !validAlertLevel(alert.Level) {
+		alert.Level = AlertLvlInfo
+	}
+	alerts := append(f.read(r), alert)
+	if len(alerts) > flashMaxQueued {
+		alerts = alerts[len(alerts)-flashMaxQueued:]
+	}
+	encoded, err := f.sc.Encode(flashCookieName, alerts)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookieName,
+		Value:    encoded,
+		Path:     "/",
+		Expires:  time.Now().Add(flashMaxAge),
+		HttpOnly: true,
+	})
+}
+
+// pop returns every queued alert and clears the cookie holding
+// them in a single step.
+func (f *Flashes) pop(w http.ResponseWriter, r *http.Request) []Alert {
+	if _, err := r.Cookie(flashCookieName); err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return f.read(r)
+}
+
+// read decodes the alerts in the request's cookie, ignoring a
+// cookie that was tampered with and any alert with a level we
+// don't know about.
+func (f *Flashes) read(r *http.Request) []Alert {
+	cookie, err := r.Cookie(flashCookieName)
+	if err != nil {
+		return nil
+	}
+	var alerts []Alert
+	if err := f.sc.Decode(flashCookieName, cookie.Value, &alerts); err != nil {
+		return nil
+	}
+	ret := alerts[:0]
+	for _, alert := range alerts {
+		if validAlertLevel(alert.Level) {
+			ret = append(ret, alert)
+		}
+	}
+	return ret
+}
+
+func validAlertLevel(lvl string) bool {
+	switch lvl {
+	case AlertLvlError, AlertLvlWarning, AlertLvlInfo, AlertLvlSuccess:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package views
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withCookies returns a request carrying every cookie set on rec
+func withCookies(rec *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestFlashesQueueAndPop(t *testing.T) {
+	f := NewFlashes("secret")
+	rec := httptest.NewRecorder()
+	f.add(rec, httptest.NewRequest(http.MethodGet, "/", nil), Alert{Level: AlertLvlSuccess, Message: "Saved"})
+	r := withCookies(rec)
+
+	// a second alert queues behind the first one
+	rec = httptest.NewRecorder()
+	f.add(rec, r, Alert{Level: AlertLvlWarning, Message: "Almost full"})
+	r = withCookies(rec)
+
+	rec = httptest.NewRecorder()
+	alerts := f.pop(rec, r)
+	if len(alerts) != 2 || alerts[0].Message != "Saved" || alerts[1].Message != "Almost full" {
+		t.Fatalf("Expected both alerts in order, received %+v", alerts)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != flashCookieName || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected the flash cookie to be cleared, received %+v", cookies)
+	}
+}
+
+func TestFlashesRejectForgedCookies(t *testing.T) {
+	f := NewFlashes("secret")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: flashCookieName, Value: `[{"Level":"success","Message":"You won!"}]`})
+	if alerts := f.read(r); len(alerts) != 0 {
+		t.Errorf("Expected a forged cookie to be ignored, received %+v", alerts)
+	}
+
+	rec := httptest.NewRecorder()
+	NewFlashes("another secret").add(rec, httptest.NewRequest(http.MethodGet, "/", nil), Alert{Level: AlertLvlSuccess, Message: "Hi"})
+	if alerts := f.read(withCookies(rec)); len(alerts) != 0 {
+		t.Errorf("Expected a cookie signed with another secret to be ignored, received %+v", alerts)
+	}
+}
+
+func TestFlashesWhitelistLevels(t *testing.T) {
+	f := NewFlashes("secret")
+	rec := httptest.NewRecorder()
+	f.add(rec, httptest.NewRequest(http.MethodGet, "/", nil), Alert{Level: `danger" onclick="alert(1)`, Message: "Hi"})
+	alerts := f.read(withCookies(rec))
+	if len(alerts) != 1 || alerts[0].Level != AlertLvlInfo {
+		t.Errorf("Expected an unknown level to become info, received %+v", alerts)
+	}
+}
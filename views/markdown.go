@@ -0,0 +1,29 @@
+package views
+
+import (
+	"html/template"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// markdownFlags drop raw HTML and images, and only keep links
+// with safe protocols, so whatever users write can't run scripts
+// or load anything when the page is viewed
+const markdownFlags = blackfriday.SkipHTML |
+	blackfriday.SkipImages |
+	blackfriday.Safelink |
+	blackfriday.NofollowLinks |
+	blackfriday.NoreferrerLinks |
+	blackfriday.HrefTargetBlank
+
+// Markdown renders user written Markdown as HTML that is safe to
+// include in a page
+func Markdown(s string) template.HTML {
+	renderer := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+		Flags: markdownFlags,
+	})
+	out := blackfriday.Run([]byte(s),
+		blackfriday.WithRenderer(renderer),
+		blackfriday.WithExtensions(blackfriday.CommonExtensions&^blackfriday.HeadingIDs))
+	return template.HTML(out)
+}
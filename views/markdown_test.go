@@ -0,0 +1,23 @@
+package views
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	for in, want := range map[string]string{
+		"**Big** day":                       "<p><strong>Big</strong> day</p>",
+		"[venue](https://example.com)":      `<a href="https://example.com" target="_blank" rel="nofollow noreferrer">venue</a>`,
+		"<script>alert(1)</script>":         "",
+		"<img src=x onerror=alert(1)>":      "",
+		"[click](javascript:alert(1))":      "<tt>click</tt>",
+		"![tracker](https://example.com/x)": "",
+		"a < b & c":                         "<p>a &lt; b &amp; c</p>",
+	} {
+		got := strings.TrimSpace(string(Markdown(in)))
+		if !strings.Contains(got, want) || strings.Contains(got, "<script") || strings.Contains(got, "javascript:") || strings.Contains(got, "onerror") {
+			t.Errorf("%q: Expected %q, received %q", in, want, got)
+		}
+	}
+}
@@ -30,6 +30,8 @@ func NewView(layout string, files ...string) *View {
 			"csrfField": func() (template.HTML, error) {
 				return "", errors.New("csrfField is not implemented")
 			},
+			"markdown": Markdown,
+			"bytes":    FormatBytes,
 		},
 	).ParseFiles(files...)
 	if err != nil {
@@ -66,13 +68,14 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 			Yield: data,
 		}
 	}
-	if alert := getAlert(r); alert != nil {
-		vd.Alert = alert
-		clearAlert(w)
+	if f := flashesFrom(r); f != nil {
+		vd.Flashes = f.pop(w, r)
 	}
 
 	// set user from context on view data
 	vd.User = context.User(r.Context())
+	vd.Impersonator = context.Impersonator(r.Context())
+	vd.Quota = context.Quota(r.Context())
 	var buf bytes.Buffer
 	csrfField := csrf.TemplateField(r)
 	tpl := v.Template.Funcs(template.FuncMap{